@@ -0,0 +1,901 @@
+package flim
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/l-donovan/flim/common"
+	flimexpr "github.com/l-donovan/flim/expressions"
+	"hash/crc32"
+	"math"
+	"math/big"
+	"sort"
+	"time"
+)
+
+// BinaryVersion is the version of the binary encoding written by
+// EncodeExpression and EncodeValue. Decoders reject any other version.
+const BinaryVersion = 1
+
+// Every encoding starts with binaryMagic, the version and one of these kinds,
+// and ends with a CRC-32 (IEEE) checksum of everything before it.
+const (
+	binaryMagic          = "FLIM"
+	binaryKindExpression = 'E'
+	binaryKindValue      = 'V'
+	binaryHeaderSize     = len(binaryMagic) + 2
+	binaryChecksumSize   = 4
+)
+
+// Node codes. The literal codes are shared by expressions and values.
+const (
+	binaryNull byte = iota
+	binaryFalse
+	binaryTrue
+	binaryInteger
+	binaryFloat
+	binaryString
+	binaryBigInteger
+	binaryBigFloat
+	binaryDuration
+	binarySize
+	binaryTimestamp
+	binaryCustom
+	binaryList
+	binaryMap
+	binaryPair
+	binaryExpanding
+	binaryTagged
+	binaryReference
+	binaryTransformer
+	binaryMappedTransformer
+	binaryFile
+)
+
+// ErrBinaryChecksum is returned when encoded data fails its checksum.
+var ErrBinaryChecksum = errors.New("binary: checksum mismatch")
+
+// EncodeExpression writes an expression tree, including its tags,
+// references, transformers and expansions, in the binary encoding. Only the
+// names of references are written, so it makes no difference whether they
+// have been resolved.
+func EncodeExpression(expr common.Expression) ([]byte, error) {
+	encoder := newBinaryEncoder(binaryKindExpression)
+
+	if err := encoder.writeExpression(expr); err != nil {
+		return nil, err
+	}
+
+	return encoder.finish(), nil
+}
+
+// DecodeExpression reads an expression tree written by EncodeExpression. As
+// with FromYAML, references are left unresolved, so the result should be
+// passed to ResolveReferences before it is evaluated. Custom literals are
+// rebuilt with the kinds given by WithLiterals.
+func DecodeExpression(data []byte, options ...ParserOption) (common.Expression, error) {
+	decoder, err := newBinaryDecoder(data, binaryKindExpression)
+
+	if err != nil {
+		return nil, err
+	}
+
+	decoder.literals = NewParser(options...).literals
+
+	expr, err := decoder.readExpression()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := decoder.end(); err != nil {
+		return nil, err
+	}
+
+	return expr, nil
+}
+
+// EncodeValue writes an evaluated value in the binary encoding. Values may be
+// made of nil, booleans, integers, floats, strings, *big.Int, *big.Float,
+// time.Duration, time.Time, []interface{} and map[string]interface{}. Map
+// keys are written in sorted order, so equal values encode identically.
+func EncodeValue(val interface{}) ([]byte, error) {
+	encoder := newBinaryEncoder(binaryKindValue)
+
+	if err := encoder.writeValue(val); err != nil {
+		return nil, err
+	}
+
+	return encoder.finish(), nil
+}
+
+// DecodeValue reads a value written by EncodeValue. Integers are decoded as
+// int64, whatever their type when they were encoded.
+func DecodeValue(data []byte) (interface{}, error) {
+	decoder, err := newBinaryDecoder(data, binaryKindValue)
+
+	if err != nil {
+		return nil, err
+	}
+
+	val, err := decoder.readValue()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := decoder.end(); err != nil {
+		return nil, err
+	}
+
+	return val, nil
+}
+
+// binaryEncoder writes strings once, after which they are referred to by
+// their position in the string table. Map keys and tag names repeat often in
+// large documents.
+type binaryEncoder struct {
+	buf     []byte
+	strings map[string]uint64
+}
+
+func newBinaryEncoder(kind byte) *binaryEncoder {
+	buf := make([]byte, 0, 256)
+	buf = append(buf, binaryMagic...)
+	buf = append(buf, BinaryVersion, kind)
+
+	return &binaryEncoder{buf: buf, strings: map[string]uint64{}}
+}
+
+func (e *binaryEncoder) finish() []byte {
+	return binary.BigEndian.AppendUint32(e.buf, crc32.ChecksumIEEE(e.buf))
+}
+
+func (e *binaryEncoder) writeUvarint(val uint64) {
+	e.buf = binary.AppendUvarint(e.buf, val)
+}
+
+func (e *binaryEncoder) writeVarint(val int64) {
+	e.buf = binary.AppendVarint(e.buf, val)
+}
+
+func (e *binaryEncoder) writeBytes(val []byte) {
+	e.writeUvarint(uint64(len(val)))
+	e.buf = append(e.buf, val...)
+}
+
+// writeString writes 0 followed by the string the first time it is seen, and
+// its index in the string table plus one after that.
+func (e *binaryEncoder) writeString(val string) {
+	if index, exists := e.strings[val]; exists {
+		e.writeUvarint(index + 1)
+		return
+	}
+
+	e.strings[val] = uint64(len(e.strings))
+	e.writeUvarint(0)
+	e.writeBytes([]byte(val))
+}
+
+func (e *binaryEncoder) writeFloat(val float64) {
+	e.buf = binary.BigEndian.AppendUint64(e.buf, math.Float64bits(val))
+}
+
+func (e *binaryEncoder) writeBigInteger(val *big.Int) error {
+	encoded, err := val.GobEncode()
+
+	if err != nil {
+		return err
+	}
+
+	e.writeBytes(encoded)
+	return nil
+}
+
+func (e *binaryEncoder) writeBigFloat(val *big.Float) error {
+	encoded, err := val.GobEncode()
+
+	if err != nil {
+		return err
+	}
+
+	e.writeBytes(encoded)
+	return nil
+}
+
+func (e *binaryEncoder) writeTimestamp(val time.Time) error {
+	encoded, err := val.MarshalBinary()
+
+	if err != nil {
+		return err
+	}
+
+	e.writeBytes(encoded)
+	return nil
+}
+
+func (e *binaryEncoder) writeExpressions(exprs []common.Expression) error {
+	e.writeUvarint(uint64(len(exprs)))
+
+	for _, expr := range exprs {
+		if err := e.writeExpression(expr); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *binaryEncoder) writeExpression(expr common.Expression) error {
+	switch expr := expr.(type) {
+	case flimexpr.NullLiteralExpression:
+		e.buf = append(e.buf, binaryNull)
+	case flimexpr.BooleanLiteralExpression:
+		if expr.Value() {
+			e.buf = append(e.buf, binaryTrue)
+		} else {
+			e.buf = append(e.buf, binaryFalse)
+		}
+	case flimexpr.IntegerLiteralExpression:
+		e.buf = append(e.buf, binaryInteger)
+		e.writeVarint(expr.Value())
+		e.writeString(expr.Text())
+	case flimexpr.FloatLiteralExpression:
+		e.buf = append(e.buf, binaryFloat)
+		e.writeFloat(expr.Value())
+	case flimexpr.StringLiteralExpression:
+		e.buf = append(e.buf, binaryString)
+		e.writeString(expr.Value())
+	case flimexpr.BigIntegerLiteralExpression:
+		e.buf = append(e.buf, binaryBigInteger)
+		e.writeString(expr.Text())
+		return e.writeBigInteger(expr.Value())
+	case flimexpr.BigFloatLiteralExpression:
+		e.buf = append(e.buf, binaryBigFloat)
+		return e.writeBigFloat(expr.Value())
+	case flimexpr.DurationLiteralExpression:
+		e.buf = append(e.buf, binaryDuration)
+		e.writeVarint(int64(expr.Value()))
+	case flimexpr.SizeLiteralExpression:
+		e.buf = append(e.buf, binarySize)
+		e.writeVarint(expr.Value())
+	case flimexpr.TimestampLiteralExpression:
+		e.buf = append(e.buf, binaryTimestamp)
+		return e.writeTimestamp(expr.Value())
+	case flimexpr.CustomLiteralExpression:
+		// Custom values are opaque, so they are stored as their source text
+		// and parsed again when decoded
+		text, err := expr.Serialize(common.NewSerializerConfig(), 0)
+
+		if err != nil {
+			return err
+		}
+
+		e.buf = append(e.buf, binaryCustom)
+		e.writeString(expr.Kind())
+		e.writeString(text)
+	case flimexpr.ListExpression:
+		e.buf = append(e.buf, binaryList)
+		return e.writeExpressions(expr.Items())
+	case flimexpr.MapExpression:
+		e.buf = append(e.buf, binaryMap)
+		return e.writeExpressions(expr.Pairs())
+	case flimexpr.PairExpression:
+		e.buf = append(e.buf, binaryPair)
+		e.writeString(expr.Key())
+		return e.writeExpression(expr.Value())
+	case flimexpr.ExpandingExpression:
+		e.buf = append(e.buf, binaryExpanding)
+		return e.writeExpression(expr.Expression())
+	case flimexpr.TaggedExpression:
+		e.buf = append(e.buf, binaryTagged)
+		e.writeString(expr.Tag())
+		return e.writeExpression(expr.Expression())
+	case flimexpr.ReferenceExpression:
+		e.buf = append(e.buf, binaryReference)
+		e.writeString(expr.Name())
+	case flimexpr.TransformerExpression:
+		e.buf = append(e.buf, binaryTransformer)
+		e.writeString(expr.Name())
+		return e.writeExpression(expr.Expression())
+	case flimexpr.MappedTransformerExpression:
+		e.buf = append(e.buf, binaryMappedTransformer)
+		e.writeString(expr.Name())
+
+		if expr.WithKeys() {
+			e.buf = append(e.buf, 1)
+		} else {
+			e.buf = append(e.buf, 0)
+		}
+
+		return e.writeExpression(expr.Expression())
+	case flimexpr.FileExpression:
+		e.buf = append(e.buf, binaryFile)
+		return e.writeExpressions(expr.Expressions())
+	default:
+		return fmt.Errorf("binary: cannot encode expression of type %T", expr)
+	}
+
+	return nil
+}
+
+func (e *binaryEncoder) writeValue(val interface{}) error {
+	switch val := val.(type) {
+	case nil:
+		e.buf = append(e.buf, binaryNull)
+	case bool:
+		if val {
+			e.buf = append(e.buf, binaryTrue)
+		} else {
+			e.buf = append(e.buf, binaryFalse)
+		}
+	case int:
+		e.buf = append(e.buf, binaryInteger)
+		e.writeVarint(int64(val))
+	case int8:
+		e.buf = append(e.buf, binaryInteger)
+		e.writeVarint(int64(val))
+	case int16:
+		e.buf = append(e.buf, binaryInteger)
+		e.writeVarint(int64(val))
+	case int32:
+		e.buf = append(e.buf, binaryInteger)
+		e.writeVarint(int64(val))
+	case int64:
+		e.buf = append(e.buf, binaryInteger)
+		e.writeVarint(val)
+	case uint:
+		return e.writeValue(uint64(val))
+	case uint8:
+		return e.writeValue(int64(val))
+	case uint16:
+		return e.writeValue(int64(val))
+	case uint32:
+		return e.writeValue(int64(val))
+	case uint64:
+		if val > math.MaxInt64 {
+			return e.writeValue(new(big.Int).SetUint64(val))
+		}
+
+		return e.writeValue(int64(val))
+	case float32:
+		return e.writeValue(float64(val))
+	case float64:
+		e.buf = append(e.buf, binaryFloat)
+		e.writeFloat(val)
+	case string:
+		e.buf = append(e.buf, binaryString)
+		e.writeString(val)
+	case *big.Int:
+		e.buf = append(e.buf, binaryBigInteger)
+		return e.writeBigInteger(val)
+	case *big.Float:
+		e.buf = append(e.buf, binaryBigFloat)
+		return e.writeBigFloat(val)
+	case time.Duration:
+		e.buf = append(e.buf, binaryDuration)
+		e.writeVarint(int64(val))
+	case time.Time:
+		e.buf = append(e.buf, binaryTimestamp)
+		return e.writeTimestamp(val)
+	case []interface{}:
+		e.buf = append(e.buf, binaryList)
+		e.writeUvarint(uint64(len(val)))
+
+		for _, listItem := range val {
+			if err := e.writeValue(listItem); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(val))
+
+		for key := range val {
+			keys = append(keys, key)
+		}
+
+		sort.Strings(keys)
+
+		e.buf = append(e.buf, binaryMap)
+		e.writeUvarint(uint64(len(keys)))
+
+		for _, key := range keys {
+			e.writeString(key)
+
+			if err := e.writeValue(val[key]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("binary: cannot encode value of type %T", val)
+	}
+
+	return nil
+}
+
+type binaryDecoder struct {
+	data    []byte
+	pos     int
+	strings []string
+
+	// literals holds the kinds custom literals are rebuilt with
+	literals *Literals
+}
+
+func newBinaryDecoder(data []byte, kind byte) (*binaryDecoder, error) {
+	if len(data) < binaryHeaderSize+binaryChecksumSize || string(data[:len(binaryMagic)]) != binaryMagic {
+		return nil, fmt.Errorf("binary: not a flim binary encoding")
+	}
+
+	if version := data[len(binaryMagic)]; version != BinaryVersion {
+		return nil, fmt.Errorf("binary: unsupported version %d (expected %d)", version, BinaryVersion)
+	}
+
+	body := data[:len(data)-binaryChecksumSize]
+
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(data[len(body):]) {
+		return nil, ErrBinaryChecksum
+	}
+
+	if found := data[len(binaryMagic)+1]; found != kind {
+		if kind == binaryKindExpression {
+			return nil, fmt.Errorf("binary: data holds a value, not an expression")
+		}
+
+		return nil, fmt.Errorf("binary: data holds an expression, not a value")
+	}
+
+	return &binaryDecoder{data: body, pos: binaryHeaderSize}, nil
+}
+
+var errBinaryTruncated = errors.New("binary: data is truncated")
+
+func (d *binaryDecoder) end() error {
+	if d.pos != len(d.data) {
+		return fmt.Errorf("binary: unexpected data after the encoded document")
+	}
+
+	return nil
+}
+
+func (d *binaryDecoder) readByte() (byte, error) {
+	if d.pos >= len(d.data) {
+		return 0, errBinaryTruncated
+	}
+
+	d.pos++
+	return d.data[d.pos-1], nil
+}
+
+func (d *binaryDecoder) readUvarint() (uint64, error) {
+	val, n := binary.Uvarint(d.data[d.pos:])
+
+	if n <= 0 {
+		return 0, errBinaryTruncated
+	}
+
+	d.pos += n
+	return val, nil
+}
+
+func (d *binaryDecoder) readVarint() (int64, error) {
+	val, n := binary.Varint(d.data[d.pos:])
+
+	if n <= 0 {
+		return 0, errBinaryTruncated
+	}
+
+	d.pos += n
+	return val, nil
+}
+
+// readCount reads a collection length, checking that it is plausible so that
+// corrupt data cannot cause a huge allocation.
+func (d *binaryDecoder) readCount() (int, error) {
+	count, err := d.readUvarint()
+
+	if err != nil {
+		return 0, err
+	}
+
+	if count > uint64(len(d.data)-d.pos) {
+		return 0, errBinaryTruncated
+	}
+
+	return int(count), nil
+}
+
+func (d *binaryDecoder) readBytes() ([]byte, error) {
+	length, err := d.readCount()
+
+	if err != nil {
+		return nil, err
+	}
+
+	d.pos += length
+	return d.data[d.pos-length : d.pos], nil
+}
+
+func (d *binaryDecoder) readString() (string, error) {
+	index, err := d.readUvarint()
+
+	if err != nil {
+		return "", err
+	}
+
+	if index > 0 {
+		if index > uint64(len(d.strings)) {
+			return "", fmt.Errorf("binary: invalid string reference %d", index)
+		}
+
+		return d.strings[index-1], nil
+	}
+
+	val, err := d.readBytes()
+
+	if err != nil {
+		return "", err
+	}
+
+	d.strings = append(d.strings, string(val))
+	return string(val), nil
+}
+
+func (d *binaryDecoder) readFloat() (float64, error) {
+	if len(d.data)-d.pos < 8 {
+		return 0, errBinaryTruncated
+	}
+
+	d.pos += 8
+	return math.Float64frombits(binary.BigEndian.Uint64(d.data[d.pos-8:])), nil
+}
+
+func (d *binaryDecoder) readBigInteger() (*big.Int, error) {
+	encoded, err := d.readBytes()
+
+	if err != nil {
+		return nil, err
+	}
+
+	val := new(big.Int)
+
+	if err := val.GobDecode(encoded); err != nil {
+		return nil, err
+	}
+
+	return val, nil
+}
+
+func (d *binaryDecoder) readBigFloat() (*big.Float, error) {
+	encoded, err := d.readBytes()
+
+	if err != nil {
+		return nil, err
+	}
+
+	val := new(big.Float)
+
+	if err := val.GobDecode(encoded); err != nil {
+		return nil, err
+	}
+
+	return val, nil
+}
+
+func (d *binaryDecoder) readTimestamp() (time.Time, error) {
+	encoded, err := d.readBytes()
+
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var val time.Time
+
+	if err := val.UnmarshalBinary(encoded); err != nil {
+		return time.Time{}, err
+	}
+
+	return val, nil
+}
+
+func (d *binaryDecoder) readExpressions() ([]common.Expression, error) {
+	count, err := d.readCount()
+
+	if err != nil {
+		return nil, err
+	}
+
+	exprs := make([]common.Expression, count)
+
+	for i := range exprs {
+		if exprs[i], err = d.readExpression(); err != nil {
+			return nil, err
+		}
+	}
+
+	return exprs, nil
+}
+
+// readNamed reads a name followed by an expression, as used by pairs, tags
+// and transformers.
+func (d *binaryDecoder) readNamed() (string, common.Expression, error) {
+	name, err := d.readString()
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	expr, err := d.readExpression()
+	return name, expr, err
+}
+
+func (d *binaryDecoder) readExpression() (common.Expression, error) {
+	code, err := d.readByte()
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch code {
+	case binaryNull:
+		return flimexpr.NewNullLiteralExpression()
+	case binaryFalse, binaryTrue:
+		return flimexpr.NewBooleanLiteralExpression(code == binaryTrue)
+	case binaryInteger:
+		val, err := d.readVarint()
+
+		if err != nil {
+			return nil, err
+		}
+
+		text, err := d.readString()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return flimexpr.NewIntegerLiteralExpressionWithText(val, text)
+	case binaryFloat:
+		val, err := d.readFloat()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return flimexpr.NewFloatLiteralExpression(val)
+	case binaryString:
+		val, err := d.readString()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return flimexpr.NewStringLiteralExpression(val)
+	case binaryBigInteger:
+		text, err := d.readString()
+
+		if err != nil {
+			return nil, err
+		}
+
+		val, err := d.readBigInteger()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return flimexpr.NewBigIntegerLiteralExpressionWithText(val, text)
+	case binaryBigFloat:
+		val, err := d.readBigFloat()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return flimexpr.NewBigFloatLiteralExpression(val)
+	case binaryDuration:
+		val, err := d.readVarint()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return flimexpr.NewDurationLiteralExpression(time.Duration(val))
+	case binarySize:
+		val, err := d.readVarint()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return flimexpr.NewSizeLiteralExpression(val)
+	case binaryTimestamp:
+		val, err := d.readTimestamp()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return flimexpr.NewTimestampLiteralExpression(val)
+	case binaryCustom:
+		kindName, err := d.readString()
+
+		if err != nil {
+			return nil, err
+		}
+
+		text, err := d.readString()
+
+		if err != nil {
+			return nil, err
+		}
+
+		kind, exists := d.literals.lookup(kindName)
+
+		if !exists {
+			return nil, fmt.Errorf("binary: unknown literal kind `%s'", kindName)
+		}
+
+		return parseCustomLiteral(kind, text)
+	case binaryList:
+		listItems, err := d.readExpressions()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return flimexpr.NewListExpression(listItems)
+	case binaryMap:
+		pairs, err := d.readExpressions()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return flimexpr.NewMapExpression(pairs)
+	case binaryPair:
+		key, val, err := d.readNamed()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return flimexpr.NewPairExpression(key, val)
+	case binaryExpanding:
+		expr, err := d.readExpression()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return flimexpr.NewExpandingExpression(expr)
+	case binaryTagged:
+		tag, expr, err := d.readNamed()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return flimexpr.NewTaggedExpression(tag, expr)
+	case binaryReference:
+		name, err := d.readString()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return flimexpr.NewReferenceExpression(name)
+	case binaryTransformer:
+		name, expr, err := d.readNamed()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return flimexpr.NewTransformerExpression(name, expr)
+	case binaryMappedTransformer:
+		name, err := d.readString()
+
+		if err != nil {
+			return nil, err
+		}
+
+		withKeys, err := d.readByte()
+
+		if err != nil {
+			return nil, err
+		}
+
+		expr, err := d.readExpression()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if withKeys != 0 {
+			return flimexpr.NewKeyedMappedTransformerExpression(name, expr)
+		}
+
+		return flimexpr.NewMappedTransformerExpression(name, expr)
+	case binaryFile:
+		exprs, err := d.readExpressions()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return flimexpr.NewFileExpression(exprs)
+	default:
+		return nil, fmt.Errorf("binary: unknown expression code %d", code)
+	}
+}
+
+func (d *binaryDecoder) readValue() (interface{}, error) {
+	code, err := d.readByte()
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch code {
+	case binaryNull:
+		return nil, nil
+	case binaryFalse, binaryTrue:
+		return code == binaryTrue, nil
+	case binaryInteger:
+		return d.readVarint()
+	case binaryFloat:
+		return d.readFloat()
+	case binaryString:
+		return d.readString()
+	case binaryBigInteger:
+		return d.readBigInteger()
+	case binaryBigFloat:
+		return d.readBigFloat()
+	case binaryDuration:
+		val, err := d.readVarint()
+		return time.Duration(val), err
+	case binaryTimestamp:
+		return d.readTimestamp()
+	case binaryList:
+		count, err := d.readCount()
+
+		if err != nil {
+			return nil, err
+		}
+
+		listItems := make([]interface{}, count)
+
+		for i := range listItems {
+			if listItems[i], err = d.readValue(); err != nil {
+				return nil, err
+			}
+		}
+
+		return listItems, nil
+	case binaryMap:
+		count, err := d.readCount()
+
+		if err != nil {
+			return nil, err
+		}
+
+		val := make(map[string]interface{}, count)
+
+		for i := 0; i < count; i++ {
+			key, err := d.readString()
+
+			if err != nil {
+				return nil, err
+			}
+
+			if val[key], err = d.readValue(); err != nil {
+				return nil, err
+			}
+		}
+
+		return val, nil
+	default:
+		return nil, fmt.Errorf("binary: unknown value code %d", code)
+	}
+}
@@ -0,0 +1,292 @@
+package flim
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/l-donovan/flim/common"
+	"hash/crc32"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var binaryDocuments = []string{
+	`{ name "flim" port 8080 ratio 0.5 enabled true nothing null }`,
+	`{ hex 0x1F big 123456789012345678901234567890 precise 0.1000000000000000000001 }`,
+	`{ timeout 1h30m size 4KiB at 2024-01-02T03:04:05Z }`,
+	`[ 1 [ 2 [ 3 ] ] {} [] "" ]`,
+	"#base { port 80 }\n{ a &base b { *&base host \"b\" } l [ *[ 1 2 ] 3 ] }",
+	`{ upper upper "x" each @trim [ " a " ] keyed @@pair { a 1 } }`,
+	`{ "quoted key" 1 "true" 2 same "text" again "text" }`,
+}
+
+func TestBinaryExpressionRoundTrip(t *testing.T) {
+	for _, text := range binaryDocuments {
+		t.Run(text, func(t *testing.T) {
+			expr, err := ParseString(text, WithBigNumbers())
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := EncodeExpression(expr)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoded, err := DecodeExpression(data)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if decoded, err = ResolveReferences(decoded); err != nil {
+				t.Fatal(err)
+			}
+
+			want, err := common.SerializeWith(expr)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := common.SerializeWith(decoded)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != want {
+				t.Errorf("decoded as\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestBinaryValueRoundTrip(t *testing.T) {
+	bigInt, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+
+	for _, val := range []interface{}{
+		nil,
+		true,
+		int64(-42),
+		3.25,
+		"text",
+		bigInt,
+		big.NewFloat(0.5),
+		90 * time.Minute,
+		time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC),
+		[]interface{}{int64(1), "two", []interface{}{}},
+		map[string]interface{}{"a": int64(1), "b": map[string]interface{}{"c": nil}, "text": "text"},
+	} {
+		data, err := EncodeValue(val)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decoded, err := DecodeValue(data)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		switch want := val.(type) {
+		case *big.Int:
+			if got, ok := decoded.(*big.Int); !ok || got.Cmp(want) != 0 {
+				t.Errorf("%v decoded as %#v", val, decoded)
+			}
+		case *big.Float:
+			if got, ok := decoded.(*big.Float); !ok || got.Cmp(want) != 0 {
+				t.Errorf("%v decoded as %#v", val, decoded)
+			}
+		case time.Time:
+			if got, ok := decoded.(time.Time); !ok || !got.Equal(want) {
+				t.Errorf("%v decoded as %#v", val, decoded)
+			}
+		default:
+			if !reflect.DeepEqual(decoded, val) {
+				t.Errorf("%#v decoded as %#v", val, decoded)
+			}
+		}
+	}
+}
+
+// Integers of any type decode as int64, and equal maps encode identically
+// whatever order their keys were added in.
+func TestBinaryValueNormalization(t *testing.T) {
+	data, err := EncodeValue(map[string]interface{}{"a": 1, "b": int32(2), "c": uint8(3)})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := DecodeValue(data)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]interface{}{"a": int64(1), "b": int64(2), "c": int64(3)}
+
+	if !reflect.DeepEqual(decoded, want) {
+		t.Errorf("decoded as %#v, want %#v", decoded, want)
+	}
+
+	again, err := EncodeValue(want)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(again) != string(data) {
+		t.Errorf("equal values encoded differently")
+	}
+}
+
+// withChecksum replaces the checksum at the end of data with a correct one,
+// so that decoding gets past it to the corrupt data.
+func withChecksum(data []byte) []byte {
+	body := data[:len(data)-4]
+	fixed := append([]byte{}, body...)
+	return binary.BigEndian.AppendUint32(fixed, crc32.ChecksumIEEE(body))
+}
+
+func encodeTestDocument(t *testing.T) []byte {
+	t.Helper()
+	expr, err := ParseString(binaryDocuments[4])
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := EncodeExpression(expr)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func TestBinaryDecodeErrors(t *testing.T) {
+	data := encodeTestDocument(t)
+	value, err := EncodeValue(map[string]interface{}{"a": int64(1)})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	corrupt := func(at int, b byte) []byte {
+		changed := append([]byte{}, data...)
+		changed[at] = b
+		return changed
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{"empty", nil, "not a flim binary encoding"},
+		{"not flim", []byte("{ a 1 }\n"), "not a flim binary encoding"},
+		{"version", withChecksum(corrupt(4, 99)), "unsupported version 99"},
+		{"checksum", corrupt(len(data)-10, data[len(data)-10]^0xFF), ErrBinaryChecksum.Error()},
+		{"value as expression", value, "data holds a value"},
+		{"unknown code", withChecksum(corrupt(6, 0xEE)), "unknown expression code"},
+		{"trailing data", withChecksum(append(append([]byte{}, data[:len(data)-4]...), 0, 0, 0, 0, 0)), "unexpected data after"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := DecodeExpression(test.data)
+
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("got error %v, want one containing `%s'", err, test.err)
+			}
+		})
+	}
+
+	if _, err := DecodeValue(data); err == nil || !strings.Contains(err.Error(), "data holds an expression") {
+		t.Errorf("decoding an expression as a value gave %v", err)
+	}
+
+	if _, err := DecodeExpression(corrupt(len(data)-10, data[len(data)-10]^0xFF)); !errors.Is(err, ErrBinaryChecksum) {
+		t.Errorf("got %v, want ErrBinaryChecksum", err)
+	}
+}
+
+// Truncated data, and data corrupted past its checksum, gives an error
+// rather than a panic or a huge allocation, whichever byte is affected.
+func TestBinaryDecodeCorrupt(t *testing.T) {
+	data := encodeTestDocument(t)
+
+	for length := 0; length < len(data); length++ {
+		if _, err := DecodeExpression(data[:length]); err == nil {
+			t.Errorf("decoded the first %d of %d bytes without an error", length, len(data))
+		}
+	}
+
+	// The same, but with a checksum that matches what is left
+	for length := binaryHeaderSize; length < len(data)-4; length++ {
+		truncated := withChecksum(append(append([]byte{}, data[:length]...), 0, 0, 0, 0))
+
+		if _, err := DecodeExpression(truncated); err == nil {
+			t.Errorf("decoded the first %d of %d bytes without an error", length, len(data)-4)
+		}
+	}
+
+	for at := binaryHeaderSize; at < len(data)-4; at++ {
+		for _, b := range []byte{0x00, 0x7F, 0x80, 0xFF} {
+			changed := append([]byte{}, data...)
+			changed[at] = b
+
+			// Either error is fine; what matters is that decoding returns
+			DecodeExpression(withChecksum(changed))
+		}
+	}
+}
+
+func TestBinaryCustomLiterals(t *testing.T) {
+	literals := NewLiterals()
+
+	err := literals.Register(LiteralKind{
+		Name:      "Version",
+		Pattern:   `v\d+\.\d+\.\d+`,
+		Parse:     func(text string) (interface{}, error) { return text, nil },
+		Serialize: func(val interface{}) (string, error) { return val.(string), nil },
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expr, err := ParseString("{ version v1.2.3 }", WithLiterals(literals))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := EncodeExpression(expr)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := DecodeExpression(data); err == nil || !strings.Contains(err.Error(), "unknown literal kind `Version'") {
+		t.Errorf("decoding without the literal kind gave %v", err)
+	}
+
+	decoded, err := DecodeExpression(data, WithLiterals(literals))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := evaluateDocument(t, decoded); !reflect.DeepEqual(got, map[string]interface{}{"version": "v1.2.3"}) {
+		t.Errorf("decoded as %#v", got)
+	}
+}
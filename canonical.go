@@ -0,0 +1,302 @@
+package flim
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/l-donovan/flim/common"
+	flimexpr "github.com/l-donovan/flim/expressions"
+	"sort"
+)
+
+type canonicalConfig struct {
+	unresolved bool
+}
+
+type CanonicalOption func(*canonicalConfig)
+
+// WithUnresolvedReferences keeps tags and references in the canonical form
+// instead of replacing references with what they refer to. Two documents then
+// only match if they share the same tag names.
+func WithUnresolvedReferences() CanonicalOption {
+	return func(c *canonicalConfig) {
+		c.unresolved = true
+	}
+}
+
+// Canonicalize rewrites expr into a canonical form that is the same for any
+// two documents that differ only in formatting, key order, overridden keys or
+// the way their literals are written. Integers lose their base and digit
+// separators, timestamps are moved to UTC, and expansions of literal maps and
+// lists are merged into their surroundings.
+//
+// By default references are replaced by their targets, which requires expr to
+// have had its references resolved, and only the value of the document is
+// kept. With WithUnresolvedReferences, tags and references are kept by name
+// and top-level tagged definitions are sorted by tag.
+func Canonicalize(expr common.Expression, options ...CanonicalOption) (common.Expression, error) {
+	config := &canonicalConfig{}
+
+	for _, option := range options {
+		option(config)
+	}
+
+	c := &canonicalizer{config: config, inlining: map[string]bool{}}
+
+	if fileExpr, ok := expr.(flimexpr.FileExpression); ok {
+		return c.file(fileExpr)
+	}
+
+	canonicalExpr, err := c.expression(expr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return flimexpr.NewFileExpression([]common.Expression{canonicalExpr})
+}
+
+// CanonicalString writes the canonical form of expr on a single line.
+func CanonicalString(expr common.Expression, options ...CanonicalOption) (string, error) {
+	canonicalExpr, err := Canonicalize(expr, options...)
+
+	if err != nil {
+		return "", err
+	}
+
+	return common.SerializeWith(canonicalExpr, common.Minified(), common.WithSortedKeys(), common.WithQuoteStyle(common.DoubleQuotes))
+}
+
+// Hash returns the hex-encoded SHA-256 of the canonical form of expr, so
+// documents that are semantically identical have the same hash.
+func Hash(expr common.Expression, options ...CanonicalOption) (string, error) {
+	canonical, err := CanonicalString(expr, options...)
+
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(canonical))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+type canonicalizer struct {
+	config *canonicalConfig
+
+	// inlining holds the references being replaced by their targets, to
+	// catch references that contain themselves
+	inlining map[string]bool
+}
+
+func (c *canonicalizer) file(fileExpr flimexpr.FileExpression) (common.Expression, error) {
+	exprs := fileExpr.Expressions()
+
+	if len(exprs) == 0 {
+		return fileExpr, nil
+	}
+
+	if !c.config.unresolved {
+		// Earlier expressions only matter through the references to them
+		last, err := c.expression(exprs[len(exprs)-1])
+
+		if err != nil {
+			return nil, err
+		}
+
+		return flimexpr.NewFileExpression([]common.Expression{last})
+	}
+
+	canonicalExprs := make([]common.Expression, len(exprs))
+
+	for i, expr := range exprs {
+		canonicalExpr, err := c.expression(expr)
+
+		if err != nil {
+			return nil, err
+		}
+
+		canonicalExprs[i] = canonicalExpr
+	}
+
+	// Definitions can be given in any order, but untagged expressions are
+	// evaluated in order and so are left alone
+	definitions := canonicalExprs[:len(canonicalExprs)-1]
+
+	for _, definition := range definitions {
+		if _, ok := definition.(flimexpr.TaggedExpression); !ok {
+			return flimexpr.NewFileExpression(canonicalExprs)
+		}
+	}
+
+	sort.Slice(definitions, func(a, b int) bool {
+		return definitions[a].(flimexpr.TaggedExpression).Tag() < definitions[b].(flimexpr.TaggedExpression).Tag()
+	})
+
+	return flimexpr.NewFileExpression(canonicalExprs)
+}
+
+func (c *canonicalizer) expressions(exprs []common.Expression) ([]common.Expression, error) {
+	canonicalExprs := make([]common.Expression, len(exprs))
+
+	for i, expr := range exprs {
+		canonicalExpr, err := c.expression(expr)
+
+		if err != nil {
+			return nil, err
+		}
+
+		canonicalExprs[i] = canonicalExpr
+	}
+
+	return canonicalExprs, nil
+}
+
+func (c *canonicalizer) expression(expr common.Expression) (common.Expression, error) {
+	switch e := expr.(type) {
+	case flimexpr.IntegerLiteralExpression:
+		return flimexpr.NewIntegerLiteralExpression(e.Value())
+	case flimexpr.BigIntegerLiteralExpression:
+		return flimexpr.NewBigIntegerLiteralExpression(e.Value())
+	case flimexpr.TimestampLiteralExpression:
+		return flimexpr.NewTimestampLiteralExpression(e.Value().UTC())
+	case flimexpr.TaggedExpression:
+		inner, err := c.expression(e.Expression())
+
+		if err != nil || !c.config.unresolved {
+			return inner, err
+		}
+
+		return flimexpr.NewTaggedExpression(e.Tag(), inner)
+	case flimexpr.ReferenceExpression:
+		if c.config.unresolved {
+			return flimexpr.NewReferenceExpression(e.Name())
+		}
+
+		target, resolved := e.Target()
+
+		if !resolved {
+			return nil, fmt.Errorf("reference `%s' is not resolved", e.Name())
+		}
+
+		if c.inlining[e.Name()] {
+			return nil, fmt.Errorf("reference `%s' refers to itself", e.Name())
+		}
+
+		c.inlining[e.Name()] = true
+		defer delete(c.inlining, e.Name())
+
+		return c.expression(target)
+	case flimexpr.TransformerExpression:
+		inner, err := c.expression(e.Expression())
+
+		if err != nil {
+			return nil, err
+		}
+
+		return flimexpr.NewTransformerExpression(e.Name(), inner)
+	case flimexpr.MappedTransformerExpression:
+		inner, err := c.expression(e.Expression())
+
+		if err != nil {
+			return nil, err
+		}
+
+		if e.WithKeys() {
+			return flimexpr.NewKeyedMappedTransformerExpression(e.Name(), inner)
+		}
+
+		return flimexpr.NewMappedTransformerExpression(e.Name(), inner)
+	case flimexpr.ExpandingExpression:
+		inner, err := c.expression(e.Expression())
+
+		if err != nil {
+			return nil, err
+		}
+
+		return flimexpr.NewExpandingExpression(inner)
+	case flimexpr.PairExpression:
+		val, err := c.expression(e.Value())
+
+		if err != nil {
+			return nil, err
+		}
+
+		return flimexpr.NewPairExpression(e.Key(), val)
+	case flimexpr.ListExpression:
+		return c.list(e)
+	case flimexpr.MapExpression:
+		return c.mapExpression(e)
+	case flimexpr.FileExpression:
+		return c.file(e)
+	default:
+		// The remaining literals already have a single way of being written
+		return expr, nil
+	}
+}
+
+func (c *canonicalizer) list(e flimexpr.ListExpression) (common.Expression, error) {
+	listItems, err := c.expressions(e.Items())
+
+	if err != nil {
+		return nil, err
+	}
+
+	spliced := []common.Expression{}
+
+	for _, listItem := range listItems {
+		if expansion, ok := listItem.(flimexpr.ExpandingExpression); ok {
+			if expandedList, ok := expansion.Expression().(flimexpr.ListExpression); ok {
+				spliced = append(spliced, expandedList.Items()...)
+				continue
+			}
+		}
+
+		spliced = append(spliced, listItem)
+	}
+
+	return flimexpr.NewListExpression(spliced)
+}
+
+// mapExpression merges in expansions of literal maps and drops pairs that are
+// overridden by a later pair with the same key. Expansions of anything else
+// are kept in place, since pairs cannot be moved across them.
+func (c *canonicalizer) mapExpression(e flimexpr.MapExpression) (common.Expression, error) {
+	pairs, err := c.expressions(e.Pairs())
+
+	if err != nil {
+		return nil, err
+	}
+
+	merged := []common.Expression{}
+
+	for _, pairExpr := range pairs {
+		if expansion, ok := pairExpr.(flimexpr.ExpandingExpression); ok {
+			if expandedMap, ok := expansion.Expression().(flimexpr.MapExpression); ok {
+				merged = append(merged, expandedMap.Pairs()...)
+				continue
+			}
+		}
+
+		merged = append(merged, pairExpr)
+	}
+
+	last := map[string]int{}
+
+	for i, pairExpr := range merged {
+		if pair, ok := pairExpr.(flimexpr.PairExpression); ok {
+			last[pair.Key()] = i
+		}
+	}
+
+	kept := []common.Expression{}
+
+	for i, pairExpr := range merged {
+		if pair, ok := pairExpr.(flimexpr.PairExpression); ok && last[pair.Key()] != i {
+			continue
+		}
+
+		kept = append(kept, pairExpr)
+	}
+
+	return flimexpr.NewMapExpression(kept)
+}
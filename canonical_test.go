@@ -0,0 +1,162 @@
+package flim
+
+import (
+	"strings"
+	"testing"
+)
+
+func hashOf(t *testing.T, text string, options ...CanonicalOption) string {
+	t.Helper()
+	expr, err := ParseString(text)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := Hash(expr, options...)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return hash
+}
+
+func TestHashIgnoresFormatting(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+	}{
+		{"whitespace and comments", "{ a 1 b [ 1 2 ] }", "{\n    // comment\n    a 1\n    b [\n        1\n        2\n    ]\n}"},
+		{"key order", "{ a 1 b 2 }", "{ b 2 a 1 }"},
+		{"overridden keys", "{ a 1 b 2 a 3 }", "{ b 2 a 3 }"},
+		{"quote style", `{ a "text" }`, `{ a 'text' }`},
+		{"integer bases", "{ a 16 b 1000 }", "{ a 0x10 b 1_000 }"},
+		{"timestamp zones", "{ at 2024-01-02T03:04:05Z }", "{ at 2024-01-02T04:04:05+01:00 }"},
+		{"expanded maps", "{ a 1 *{ b 2 } }", "{ a 1 b 2 }"},
+		{"expanded lists", "[ 1 *[ 2 3 ] ]", "[ 1 2 3 ]"},
+		{"references", "#base { port 80 }\n{ a &base }", "{ a { port 80 } }"},
+		{"tags", "{ a #t 1 b &t }", "{ a 1 b 1 }"},
+		{"earlier file expressions", "{ ignored 1 }\n{ a 1 }", "{ a 1 }"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if a, b := hashOf(t, test.a), hashOf(t, test.b); a != b {
+				t.Errorf("`%s' and `%s' hash differently", test.a, test.b)
+			}
+		})
+	}
+}
+
+func TestHashDistinguishesValues(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+	}{
+		{"values", "{ a 1 }", "{ a 2 }"},
+		{"types", `{ a 1 }`, `{ a "1" }`},
+		{"integers and floats", "{ a 1 }", "{ a 1.0 }"},
+		{"list order", "[ 1 2 ]", "[ 2 1 ]"},
+		{"keys", "{ a 1 }", "{ b 1 }"},
+		{"transformers", `{ a upper "x" }`, `{ a lower "x" }`},
+		{"mapped transformers", `{ a @upper [ "x" ] }`, `{ a @@upper [ "x" ] }`},
+		{"nesting", "[ [ 1 ] 2 ]", "[ 1 [ 2 ] ]"},
+		{"expansion of a transformer", "{ a 1 *env {} }", "{ *env {} a 1 }"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if a, b := hashOf(t, test.a), hashOf(t, test.b); a == b {
+				t.Errorf("`%s' and `%s' hash the same", test.a, test.b)
+			}
+		})
+	}
+}
+
+func TestCanonicalString(t *testing.T) {
+	tests := []struct {
+		text    string
+		options []CanonicalOption
+		want    string
+	}{
+		{"{ b 0x10 a 'x' *{ c [ 1 *[ 2 ] ] } }", nil, `{a "x" b 16 c [1 2]}`},
+		{"#base { port 80 }\n{ a &base }", nil, "{a {port 80}}"},
+		{"#z 1\n#a 2\n{ x &z y &a }", []CanonicalOption{WithUnresolvedReferences()}, "#a 2 #z 1 {x &z y &a}"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			expr, err := ParseString(test.text)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := CanonicalString(expr, test.options...)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != test.want {
+				t.Errorf("got `%s', want `%s'", got, test.want)
+			}
+		})
+	}
+}
+
+// With WithUnresolvedReferences, documents only match if they use the same
+// tag names.
+func TestHashWithUnresolvedReferences(t *testing.T) {
+	a := "#x 1\n{ a &x }"
+	b := "#y 1\n{ a &y }"
+
+	if hashOf(t, a) != hashOf(t, b) {
+		t.Errorf("`%s' and `%s' hash differently", a, b)
+	}
+
+	if hashOf(t, a, WithUnresolvedReferences()) == hashOf(t, b, WithUnresolvedReferences()) {
+		t.Errorf("`%s' and `%s' hash the same with unresolved references", a, b)
+	}
+
+	reordered := "#y 2\n#x 1\n{ a &x }"
+	sorted := "#x 1\n#y 2\n{ a &x }"
+
+	if hashOf(t, reordered, WithUnresolvedReferences()) != hashOf(t, sorted, WithUnresolvedReferences()) {
+		t.Errorf("the order of definitions changed the hash")
+	}
+}
+
+func TestCanonicalizeErrors(t *testing.T) {
+	parser := NewParser()
+	tokens, err := parser.Lex("#a 1\n{ x &a }")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unresolved, err := parser.Parse(tokens)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Hash(unresolved); err == nil || !strings.Contains(err.Error(), "reference `a' is not resolved") {
+		t.Errorf("hashing an unresolved document gave %v", err)
+	}
+
+	if _, err := Hash(unresolved, WithUnresolvedReferences()); err != nil {
+		t.Errorf("hashing an unresolved document with unresolved references gave %v", err)
+	}
+
+	cyclic, err := ParseString("#a [ 1 &a ]")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Hash(cyclic); err == nil || !strings.Contains(err.Error(), "refers to itself") {
+		t.Errorf("hashing a tag that refers to itself gave %v", err)
+	}
+}
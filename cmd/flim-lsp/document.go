@@ -0,0 +1,369 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/l-donovan/flim"
+	"github.com/l-donovan/flim/common"
+	flimexpr "github.com/l-donovan/flim/expressions"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// document is an open flim document and what was learned from parsing it.
+type document struct {
+	uri     string
+	version int
+	text    string
+
+	// expr is the parsed document, with its references left unresolved, and
+	// sources where its expressions were written. Both are nil if the
+	// document could not be parsed.
+	expr    common.Expression
+	sources *flim.SourceMap
+
+	diagnostics []diagnostic
+}
+
+func newDocument(uri string, version int, text string) *document {
+	d := &document{uri: uri, version: version, text: text, diagnostics: []diagnostic{}}
+	sourceMap := &flim.SourceMap{}
+	tokens, err := flim.Lex(text)
+
+	if err == nil {
+		d.expr, err = flim.NewParser(flim.WithSourceMap(sourceMap)).Parse(tokens)
+	}
+
+	if err != nil {
+		d.expr = nil
+		offset := 0
+		var syntaxErr *flim.SyntaxError
+
+		if errors.As(err, &syntaxErr) {
+			offset = syntaxErr.Offset
+		}
+
+		d.report(offset, d.wordEnd(offset), err.Error())
+		return d
+	}
+
+	d.sources = sourceMap
+	unresolved := false
+
+	for _, ref := range sourceMap.References {
+		if _, exists := sourceMap.Tags[ref.Name]; !exists {
+			d.report(ref.Span.Start-1, ref.Span.End, fmt.Sprintf("could not find tag `%s'", ref.Name))
+			unresolved = true
+		}
+	}
+
+	if unresolved {
+		return d
+	}
+
+	// Resolving references changes the expressions in place, so it is done to
+	// a copy to leave d.expr as it was written
+	expr, err := flim.NewParser().Parse(tokens)
+
+	if err == nil {
+		_, err = flim.ResolveReferences(expr)
+	}
+
+	if err != nil {
+		d.report(0, 0, err.Error())
+	}
+
+	return d
+}
+
+func (d *document) report(start, end int, message string) {
+	d.diagnostics = append(d.diagnostics, diagnostic{
+		Range:    d.textRange(start, end),
+		Severity: severityError,
+		Source:   "flim",
+		Message:  message,
+	})
+}
+
+// wordEnd returns the offset of the end of the word starting at offset.
+func (d *document) wordEnd(offset int) int {
+	end := offset
+
+	for end < len(d.text) {
+		r, size := utf8.DecodeRuneInString(d.text[end:])
+
+		if unicode.IsSpace(r) {
+			break
+		}
+
+		end += size
+	}
+
+	return end
+}
+
+// position converts a byte offset into a position, whose character counts
+// UTF-16 code units as the protocol does.
+func (d *document) position(offset int) position {
+	offset = min(max(offset, 0), len(d.text))
+	lineStart := strings.LastIndexByte(d.text[:offset], '\n') + 1
+	line := strings.Count(d.text[:lineStart], "\n")
+	return position{line, len(utf16.Encode([]rune(d.text[lineStart:offset])))}
+}
+
+// offset converts a position into a byte offset.
+func (d *document) offset(pos position) int {
+	offset := 0
+
+	for line := 0; line < pos.Line; line++ {
+		next := strings.IndexByte(d.text[offset:], '\n')
+
+		if next < 0 {
+			return len(d.text)
+		}
+
+		offset += next + 1
+	}
+
+	for units := 0; units < pos.Character && offset < len(d.text); {
+		r, size := utf8.DecodeRuneInString(d.text[offset:])
+
+		if r == '\n' {
+			break
+		}
+
+		if r >= 0x10000 {
+			// Encoded as a surrogate pair
+			units += 2
+		} else {
+			units++
+		}
+
+		offset += size
+	}
+
+	return offset
+}
+
+func (d *document) textRange(start, end int) textRange {
+	return textRange{d.position(start), d.position(end)}
+}
+
+func (d *document) spanRange(span flim.Span) textRange {
+	return d.textRange(span.Start, span.End)
+}
+
+// tagAt returns the name of the tag defined or referred to at an offset,
+// along with the span of the name there.
+func (d *document) tagAt(offset int) (string, flim.Span, bool) {
+	if d.sources == nil {
+		return "", flim.Span{}, false
+	}
+
+	// The pound sign or ampersand before a name counts as part of it
+	for name, span := range d.sources.Tags {
+		if span.Start-1 <= offset && offset <= span.End {
+			return name, span, true
+		}
+	}
+
+	for _, ref := range d.sources.References {
+		if ref.Span.Start-1 <= offset && offset <= ref.Span.End {
+			return ref.Name, ref.Span, true
+		}
+	}
+
+	return "", flim.Span{}, false
+}
+
+// references returns the spans of the names of every reference to a tag.
+func (d *document) references(name string) []flim.Span {
+	spans := []flim.Span{}
+
+	for _, ref := range d.sources.References {
+		if ref.Name == name {
+			spans = append(spans, ref.Span)
+		}
+	}
+
+	return spans
+}
+
+// symbols returns the document's tagged top-level expressions and the keys
+// of its value, along with the keys inside them.
+func (d *document) symbols() []documentSymbol {
+	symbols := []documentSymbol{}
+	fileExpr, ok := d.expr.(flimexpr.FileExpression)
+
+	if !ok {
+		return symbols
+	}
+
+	exprs := fileExpr.Expressions()
+
+	for i, expr := range exprs {
+		tagged, isTagged := expr.(flimexpr.TaggedExpression)
+
+		if !isTagged {
+			// Only the last untagged expression, the document's value, is
+			// kept in the source map
+			if i == len(exprs)-1 {
+				symbols = append(symbols, d.childSymbols(expr, flim.Path{}, d.sources.Lookup)...)
+			}
+
+			continue
+		}
+
+		tag := tagged.Tag()
+
+		lookup := func(path flim.Path) (flim.SourceNode, bool) {
+			return d.sources.LookupTag(tag, path)
+		}
+
+		node, exists := lookup(flim.Path{})
+
+		if !exists {
+			continue
+		}
+
+		nameSpan := d.sources.Tags[tag]
+
+		symbols = append(symbols, documentSymbol{
+			Name:           "#" + tag,
+			Kind:           symbolKind(tagged.Expression()),
+			Range:          d.textRange(nameSpan.Start-1, node.Value.End),
+			SelectionRange: d.spanRange(nameSpan),
+			Children:       d.childSymbols(tagged.Expression(), flim.Path{}, lookup),
+		})
+	}
+
+	return symbols
+}
+
+// childSymbols returns the symbols for the keys of a map, or the maps and
+// lists in a list.
+func (d *document) childSymbols(expr common.Expression, path flim.Path, lookup func(path flim.Path) (flim.SourceNode, bool)) []documentSymbol {
+	symbols := []documentSymbol{}
+
+	switch e := symbolTarget(expr).(type) {
+	case flimexpr.MapExpression:
+		for _, pairExpr := range e.Pairs() {
+			pair, ok := pairExpr.(flimexpr.PairExpression)
+
+			if !ok {
+				continue
+			}
+
+			childPath := path.Child(pair.Key())
+			node, exists := lookup(childPath)
+
+			if !exists {
+				continue
+			}
+
+			symbols = append(symbols, documentSymbol{
+				Name:           pair.Key(),
+				Detail:         symbolDetail(pair.Value()),
+				Kind:           symbolKind(pair.Value()),
+				Range:          d.spanRange(node.Entry),
+				SelectionRange: d.spanRange(node.Key),
+				Children:       d.childSymbols(pair.Value(), childPath, lookup),
+			})
+		}
+	case flimexpr.ListExpression:
+		for i, listItem := range e.Items() {
+			switch symbolTarget(listItem).(type) {
+			case flimexpr.MapExpression, flimexpr.ListExpression:
+			default:
+				continue
+			}
+
+			childPath := path.Child(i)
+			node, exists := lookup(childPath)
+
+			if !exists {
+				continue
+			}
+
+			symbols = append(symbols, documentSymbol{
+				Name:           fmt.Sprintf("[%d]", i),
+				Detail:         symbolDetail(listItem),
+				Kind:           symbolKind(listItem),
+				Range:          d.spanRange(node.Entry),
+				SelectionRange: d.spanRange(node.Entry),
+				Children:       d.childSymbols(listItem, childPath, lookup),
+			})
+		}
+	}
+
+	return symbols
+}
+
+// symbolTarget looks through tags and transformers, which keep the path of
+// what they are applied to.
+func symbolTarget(expr common.Expression) common.Expression {
+	for {
+		switch e := expr.(type) {
+		case flimexpr.TaggedExpression:
+			expr = e.Expression()
+		case flimexpr.TransformerExpression:
+			expr = e.Expression()
+		case flimexpr.MappedTransformerExpression:
+			expr = e.Expression()
+		default:
+			return expr
+		}
+	}
+}
+
+func symbolKind(expr common.Expression) int {
+	switch expr.(type) {
+	case flimexpr.TransformerExpression, flimexpr.MappedTransformerExpression:
+		return symbolFunction
+	}
+
+	switch symbolTarget(expr).(type) {
+	case flimexpr.MapExpression:
+		return symbolObject
+	case flimexpr.ListExpression:
+		return symbolArray
+	case flimexpr.StringLiteralExpression:
+		return symbolString
+	case flimexpr.IntegerLiteralExpression, flimexpr.BigIntegerLiteralExpression, flimexpr.FloatLiteralExpression, flimexpr.BigFloatLiteralExpression, flimexpr.SizeLiteralExpression:
+		return symbolNumber
+	case flimexpr.BooleanLiteralExpression:
+		return symbolBoolean
+	case flimexpr.NullLiteralExpression:
+		return symbolNull
+	default:
+		return symbolVariable
+	}
+}
+
+// symbolDetail names the tag and transformers a value was written with.
+func symbolDetail(expr common.Expression) string {
+	details := []string{}
+
+	for {
+		switch e := expr.(type) {
+		case flimexpr.TaggedExpression:
+			details = append(details, "#"+e.Tag())
+			expr = e.Expression()
+			continue
+		case flimexpr.TransformerExpression:
+			details = append(details, e.Name())
+			expr = e.Expression()
+			continue
+		case flimexpr.MappedTransformerExpression:
+			details = append(details, "@"+e.Name())
+			expr = e.Expression()
+			continue
+		case flimexpr.ReferenceExpression:
+			details = append(details, "&"+e.Name())
+		}
+
+		return strings.Join(details, " ")
+	}
+}
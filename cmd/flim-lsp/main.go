@@ -0,0 +1,45 @@
+// Command flim-lsp is a language server for flim documents. It speaks the
+// Language Server Protocol over standard input and output, and offers
+// diagnostics, going to the definition of a tag, finding and renaming its
+// references, hovering over a tag to see what it holds, document symbols for
+// map keys, and formatting.
+//
+// Everything but diagnostics needs the document to parse. While it has a
+// syntax error, the error is published and requests find nothing rather
+// than answering from an earlier version whose offsets no longer match the
+// text. Formatting rewrites the whole document with the serializer, which
+// cannot write comments, so a document with comments is left as it is and
+// the request fails saying so.
+package main
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"os"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("flim-lsp: ")
+
+	reader := bufio.NewReader(os.Stdin)
+	s := newServer(os.Stdout)
+
+	for {
+		body, err := readMessage(reader)
+
+		if err == io.EOF {
+			// The client went away without asking the server to exit
+			os.Exit(1)
+		}
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if exit, code := s.handle(body); exit {
+			os.Exit(code)
+		}
+	}
+}
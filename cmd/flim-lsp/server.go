@@ -0,0 +1,377 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/l-donovan/flim"
+	"github.com/l-donovan/flim/common"
+	flimexpr "github.com/l-donovan/flim/expressions"
+	"io"
+	"log"
+)
+
+type server struct {
+	out       io.Writer
+	documents map[string]*document
+	shutdown  bool
+}
+
+func newServer(out io.Writer) *server {
+	return &server{out: out, documents: map[string]*document{}}
+}
+
+// handle answers a request, or acts on a notification. It reports whether
+// the server should exit, along with the code to exit with.
+func (s *server) handle(body []byte) (bool, int) {
+	var req request
+
+	if err := json.Unmarshal(body, &req); err != nil {
+		s.respond(nil, nil, &responseError{codeParseError, err.Error()})
+		return false, 0
+	}
+
+	if req.Method == "exit" {
+		if s.shutdown {
+			return true, 0
+		}
+
+		return true, 1
+	}
+
+	result, err := s.dispatch(req)
+
+	// Notifications are never answered
+	if len(req.ID) == 0 {
+		if err != nil {
+			log.Printf("%s: %s", req.Method, err)
+		}
+
+		return false, 0
+	}
+
+	s.respond(req.ID, result, err)
+	return false, 0
+}
+
+func (s *server) respond(id json.RawMessage, result interface{}, err error) {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+
+	var message interface{} = response{"2.0", id, result}
+
+	if err != nil {
+		respErr, ok := err.(*responseError)
+
+		if !ok {
+			respErr = &responseError{codeRequestFailed, err.Error()}
+		}
+
+		message = errorResponse{"2.0", id, respErr}
+	}
+
+	if err := writeMessage(s.out, message); err != nil {
+		log.Print(err)
+	}
+}
+
+func (s *server) notify(method string, params interface{}) {
+	if err := writeMessage(s.out, notification{"2.0", method, params}); err != nil {
+		log.Print(err)
+	}
+}
+
+func (s *server) dispatch(req request) (interface{}, error) {
+	switch req.Method {
+	case "initialize":
+		return s.initialize()
+	case "initialized", "$/cancelRequest", "$/setTrace", "workspace/didChangeConfiguration", "textDocument/didSave":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var params didOpenParams
+
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+
+		s.open(params.TextDocument.URI, params.TextDocument.Version, params.TextDocument.Text)
+		return nil, nil
+	case "textDocument/didChange":
+		var params didChangeParams
+
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+
+		if n := len(params.ContentChanges); n > 0 {
+			s.open(params.TextDocument.URI, params.TextDocument.Version, params.ContentChanges[n-1].Text)
+		}
+
+		return nil, nil
+	case "textDocument/didClose":
+		var params textDocumentParams
+
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+
+		delete(s.documents, params.TextDocument.URI)
+		s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: params.TextDocument.URI, Diagnostics: []diagnostic{}})
+		return nil, nil
+	case "textDocument/definition":
+		var params positionParams
+
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+
+		return s.definition(params)
+	case "textDocument/references":
+		var params referenceParams
+
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+
+		return s.references(params)
+	case "textDocument/prepareRename":
+		var params positionParams
+
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+
+		return s.prepareRename(params)
+	case "textDocument/rename":
+		var params renameParams
+
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+
+		return s.rename(params)
+	case "textDocument/hover":
+		var params positionParams
+
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+
+		return s.hover(params)
+	case "textDocument/documentSymbol":
+		var params textDocumentParams
+
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+
+		return s.documentSymbols(params)
+	case "textDocument/formatting":
+		var params formattingParams
+
+		if err := unmarshalParams(req, &params); err != nil {
+			return nil, err
+		}
+
+		return s.format(params)
+	default:
+		return nil, &responseError{codeMethodNotFound, fmt.Sprintf("unknown method `%s'", req.Method)}
+	}
+}
+
+func unmarshalParams(req request, params interface{}) error {
+	if err := json.Unmarshal(req.Params, params); err != nil {
+		return &responseError{codeInvalidParams, err.Error()}
+	}
+
+	return nil
+}
+
+func (s *server) initialize() (interface{}, error) {
+	return map[string]interface{}{
+		"capabilities": map[string]interface{}{
+			"textDocumentSync": map[string]interface{}{
+				"openClose": true,
+				"change":    1,
+			},
+			"definitionProvider":         true,
+			"referencesProvider":         true,
+			"renameProvider":             map[string]interface{}{"prepareProvider": true},
+			"hoverProvider":              true,
+			"documentSymbolProvider":     true,
+			"documentFormattingProvider": true,
+		},
+		"serverInfo": map[string]interface{}{"name": "flim-lsp"},
+	}, nil
+}
+
+// open parses the new text of a document and publishes its diagnostics.
+func (s *server) open(uri string, version int, text string) {
+	d := newDocument(uri, version, text)
+	s.documents[uri] = d
+	s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: uri, Version: version, Diagnostics: d.diagnostics})
+}
+
+// tagAt returns the document and the tag at the position in the request,
+// if there is one.
+func (s *server) tagAt(params positionParams) (*document, string, flim.Span, bool) {
+	d, exists := s.documents[params.TextDocument.URI]
+
+	if !exists {
+		return nil, "", flim.Span{}, false
+	}
+
+	name, span, found := d.tagAt(d.offset(params.Position))
+	return d, name, span, found
+}
+
+func (s *server) definition(params positionParams) (interface{}, error) {
+	d, name, _, found := s.tagAt(params)
+
+	if !found {
+		return nil, nil
+	}
+
+	span, defined := d.sources.Tags[name]
+
+	if !defined {
+		return nil, nil
+	}
+
+	return location{d.uri, d.spanRange(span)}, nil
+}
+
+func (s *server) references(params referenceParams) (interface{}, error) {
+	d, name, _, found := s.tagAt(params.positionParams)
+
+	if !found {
+		return nil, nil
+	}
+
+	locations := []location{}
+
+	if span, defined := d.sources.Tags[name]; defined && params.Context.IncludeDeclaration {
+		locations = append(locations, location{d.uri, d.spanRange(span)})
+	}
+
+	for _, span := range d.references(name) {
+		locations = append(locations, location{d.uri, d.spanRange(span)})
+	}
+
+	return locations, nil
+}
+
+func (s *server) prepareRename(params positionParams) (interface{}, error) {
+	d, name, span, found := s.tagAt(params)
+
+	if !found {
+		return nil, nil
+	}
+
+	return prepareRenameResult{d.spanRange(span), name}, nil
+}
+
+func (s *server) rename(params renameParams) (interface{}, error) {
+	d, name, _, found := s.tagAt(params.positionParams)
+
+	if !found {
+		return nil, &responseError{codeRequestFailed, "there is no tag to rename here"}
+	}
+
+	// The new name must read back as a single keyword
+	tokens, err := flim.Lex(params.NewName)
+
+	if err != nil || len(tokens) != 1 || !tokens[0].IsOfType("Keyword") || tokens[0].Contents != params.NewName {
+		return nil, &responseError{codeInvalidParams, fmt.Sprintf("`%s' is not a valid tag name", params.NewName)}
+	}
+
+	if _, exists := d.sources.Tags[params.NewName]; exists && params.NewName != name {
+		return nil, &responseError{codeRequestFailed, fmt.Sprintf("tag `%s' already exists", params.NewName)}
+	}
+
+	edits := []textEdit{}
+
+	if span, defined := d.sources.Tags[name]; defined {
+		edits = append(edits, textEdit{d.spanRange(span), params.NewName})
+	}
+
+	for _, span := range d.references(name) {
+		edits = append(edits, textEdit{d.spanRange(span), params.NewName})
+	}
+
+	return workspaceEdit{map[string][]textEdit{d.uri: edits}}, nil
+}
+
+func (s *server) hover(params positionParams) (interface{}, error) {
+	d, name, span, found := s.tagAt(params)
+
+	if !found {
+		return nil, nil
+	}
+
+	tagged, defined := d.expr.GetTags()[name]
+
+	if !defined {
+		return nil, nil
+	}
+
+	fileExpr, err := flimexpr.NewFileExpression([]common.Expression{tagged})
+
+	if err != nil {
+		return nil, err
+	}
+
+	text, err := common.SerializeWith(fileExpr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return hover{markupContent{"markdown", "```flim\n" + text + "\n```"}, d.spanRange(span)}, nil
+}
+
+func (s *server) documentSymbols(params textDocumentParams) (interface{}, error) {
+	d, exists := s.documents[params.TextDocument.URI]
+
+	if !exists || d.expr == nil {
+		return []documentSymbol{}, nil
+	}
+
+	return d.symbols(), nil
+}
+
+func (s *server) format(params formattingParams) (interface{}, error) {
+	d, exists := s.documents[params.TextDocument.URI]
+
+	if !exists || d.expr == nil {
+		return nil, nil
+	}
+
+	// The serializer cannot write comments, so formatting would lose them
+	if comments, err := flim.Comments(d.text); err != nil || len(comments) > 0 {
+		return nil, &responseError{codeRequestFailed, "cannot format a document with comments without losing them"}
+	}
+
+	options := []common.SerializerOption{common.WithTrailingNewline()}
+
+	if !params.Options.InsertSpaces {
+		options = append(options, common.WithTabs())
+	} else if params.Options.TabSize > 0 {
+		options = append(options, common.WithIndentSize(params.Options.TabSize))
+	}
+
+	text, err := common.SerializeWith(d.expr, options...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if text == d.text {
+		return []textEdit{}, nil
+	}
+
+	return []textEdit{{d.textRange(0, len(d.text)), text}}, nil
+}
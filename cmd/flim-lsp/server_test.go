@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testURI = "file:///config.flim"
+
+const testDocument = `#base { port 80 }
+{
+    a &base
+    b { *&base host "b" }
+}
+`
+
+// testClient sends messages to a server and reads what it writes back.
+type testClient struct {
+	t      *testing.T
+	server *server
+	out    *bytes.Buffer
+	nextID int
+}
+
+func newTestClient(t *testing.T) *testClient {
+	out := &bytes.Buffer{}
+	return &testClient{t: t, server: newServer(out), out: out}
+}
+
+// messages returns the messages written since it was last called.
+func (c *testClient) messages() []map[string]json.RawMessage {
+	c.t.Helper()
+	reader := bufio.NewReader(c.out)
+	messages := []map[string]json.RawMessage{}
+
+	for reader.Buffered() > 0 || c.out.Len() > 0 {
+		body, err := readMessage(reader)
+
+		if err != nil {
+			c.t.Fatal(err)
+		}
+
+		var message map[string]json.RawMessage
+
+		if err := json.Unmarshal(body, &message); err != nil {
+			c.t.Fatal(err)
+		}
+
+		messages = append(messages, message)
+	}
+
+	return messages
+}
+
+func (c *testClient) send(id interface{}, method string, params interface{}) {
+	c.t.Helper()
+	message := map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params}
+
+	if id != nil {
+		message["id"] = id
+	}
+
+	body, err := json.Marshal(message)
+
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	if exit, _ := c.server.handle(body); exit {
+		c.t.Fatalf("%s made the server exit", method)
+	}
+}
+
+// notify sends a notification and returns the diagnostics published for it.
+func (c *testClient) notify(method string, params interface{}) []diagnostic {
+	c.t.Helper()
+	c.send(nil, method, params)
+	diagnostics := []diagnostic{}
+
+	for _, message := range c.messages() {
+		var published publishDiagnosticsParams
+
+		if err := json.Unmarshal(message["params"], &published); err != nil {
+			c.t.Fatal(err)
+		}
+
+		diagnostics = append(diagnostics, published.Diagnostics...)
+	}
+
+	return diagnostics
+}
+
+// request sends a request and decodes its result into result, returning the
+// error it was answered with, if any.
+func (c *testClient) request(method string, params interface{}, result interface{}) *responseError {
+	c.t.Helper()
+	c.nextID++
+	c.send(c.nextID, method, params)
+	messages := c.messages()
+
+	if len(messages) != 1 {
+		c.t.Fatalf("%s was answered with %d messages", method, len(messages))
+	}
+
+	if errJSON, failed := messages[0]["error"]; failed {
+		var respErr responseError
+
+		if err := json.Unmarshal(errJSON, &respErr); err != nil {
+			c.t.Fatal(err)
+		}
+
+		return &respErr
+	}
+
+	if err := json.Unmarshal(messages[0]["result"], result); err != nil {
+		c.t.Fatal(err)
+	}
+
+	return nil
+}
+
+func (c *testClient) open(text string) []diagnostic {
+	c.t.Helper()
+	return c.notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": testURI, "version": 1, "text": text},
+	})
+}
+
+func at(line, character int) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": testURI},
+		"position":     position{line, character},
+	}
+}
+
+func span(line, start, end int) textRange {
+	return textRange{position{line, start}, position{line, end}}
+}
+
+func TestDiagnostics(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []diagnostic
+	}{
+		{"valid", testDocument, []diagnostic{}},
+		{
+			"lex error",
+			"{\n    a $oops " + strings.Repeat("x", 100) + "\n}\n",
+			[]diagnostic{{span(1, 6, 11), severityError, "flim", "could not find token matching \"$oops\""}},
+		},
+		{
+			"parse error",
+			"{ a 1\n",
+			[]diagnostic{{span(0, 5, 5), severityError, "flim", ""}},
+		},
+		{
+			"missing tag",
+			"{ a &missing }\n",
+			[]diagnostic{{span(0, 4, 12), severityError, "flim", "could not find tag `missing'"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := newTestClient(t).open(test.text)
+
+			if len(got) != len(test.want) {
+				t.Fatalf("got %+v, want %+v", got, test.want)
+			}
+
+			for i := range got {
+				// Only the start of errors that cannot be placed exactly is
+				// checked, and only the message of those that can
+				if test.want[i].Message == "" {
+					got[i].Message = ""
+					test.want[i].Range.End = got[i].Range.End
+				}
+
+				if !reflect.DeepEqual(got[i], test.want[i]) {
+					t.Errorf("got %+v, want %+v", got[i], test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDefinitionAndReferences(t *testing.T) {
+	c := newTestClient(t)
+	c.open(testDocument)
+
+	var definition location
+
+	if err := c.request("textDocument/definition", at(2, 8), &definition); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := (location{testURI, span(0, 1, 5)}); definition != want {
+		t.Errorf("got definition %+v, want %+v", definition, want)
+	}
+
+	var references []location
+	params := at(0, 0)
+	params["context"] = map[string]interface{}{"includeDeclaration": true}
+
+	if err := c.request("textDocument/references", params, &references); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []location{{testURI, span(0, 1, 5)}, {testURI, span(2, 7, 11)}, {testURI, span(3, 10, 14)}}
+
+	if !reflect.DeepEqual(references, want) {
+		t.Errorf("got references %+v, want %+v", references, want)
+	}
+
+	var nothing *location
+
+	if err := c.request("textDocument/definition", at(1, 0), &nothing); err != nil || nothing != nil {
+		t.Errorf("got %+v, %v away from any tag", nothing, err)
+	}
+}
+
+func TestRename(t *testing.T) {
+	c := newTestClient(t)
+	c.open(testDocument)
+
+	params := at(3, 12)
+	params["newName"] = "defaults"
+	var edit workspaceEdit
+
+	if err := c.request("textDocument/rename", params, &edit); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []textEdit{{span(0, 1, 5), "defaults"}, {span(2, 7, 11), "defaults"}, {span(3, 10, 14), "defaults"}}
+
+	if !reflect.DeepEqual(edit.Changes[testURI], want) {
+		t.Errorf("got edits %+v, want %+v", edit.Changes[testURI], want)
+	}
+
+	for _, newName := range []string{"two words", "true", "#x", ""} {
+		params["newName"] = newName
+
+		if err := c.request("textDocument/rename", params, &edit); err == nil || err.Code != codeInvalidParams {
+			t.Errorf("renaming to `%s' gave %v", newName, err)
+		}
+	}
+
+	c.open("#a 1\n#b 2\n{ x &a y &b }\n")
+	params = at(0, 1)
+	params["newName"] = "b"
+
+	if err := c.request("textDocument/rename", params, &edit); err == nil || !strings.Contains(err.Message, "tag `b' already exists") {
+		t.Errorf("renaming onto an existing tag gave %v", err)
+	}
+}
+
+func TestHover(t *testing.T) {
+	c := newTestClient(t)
+	c.open(testDocument)
+
+	var got hover
+
+	if err := c.request("textDocument/hover", at(2, 9), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	want := hover{markupContent{"markdown", "```flim\n#base {\n    port 80\n}\n```"}, span(2, 7, 11)}
+
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestDocumentSymbols(t *testing.T) {
+	c := newTestClient(t)
+	c.open(testDocument)
+
+	var symbols []documentSymbol
+
+	if err := c.request("textDocument/documentSymbol", map[string]interface{}{"textDocument": map[string]interface{}{"uri": testURI}}, &symbols); err != nil {
+		t.Fatal(err)
+	}
+
+	var describe func(symbols []documentSymbol) []string
+
+	describe = func(symbols []documentSymbol) []string {
+		names := []string{}
+
+		for _, symbol := range symbols {
+			names = append(names, symbol.Name+" "+symbol.Detail)
+
+			for _, child := range describe(symbol.Children) {
+				names = append(names, symbol.Name+"."+child)
+			}
+		}
+
+		return names
+	}
+
+	want := []string{"#base ", "#base.port ", "a &base", "b ", "b.host "}
+
+	if got := describe(symbols); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFormatting(t *testing.T) {
+	c := newTestClient(t)
+	c.open("{ a 1 b [ 1 2 ] }")
+
+	params := map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": testURI},
+		"options":      map[string]interface{}{"tabSize": 2, "insertSpaces": true},
+	}
+
+	var edits []textEdit
+
+	if err := c.request("textDocument/formatting", params, &edits); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []textEdit{{span(0, 0, 17), "{\n  a 1\n  b [\n    1\n    2\n  ]\n}\n"}}
+
+	if !reflect.DeepEqual(edits, want) {
+		t.Errorf("got %+v, want %+v", edits, want)
+	}
+
+	c.open(want[0].NewText)
+
+	if err := c.request("textDocument/formatting", params, &edits); err != nil || len(edits) != 0 {
+		t.Errorf("formatting a formatted document gave %+v, %v", edits, err)
+	}
+
+	c.open("// comment\n{ a 1 }")
+
+	if err := c.request("textDocument/formatting", params, &edits); err == nil || !strings.Contains(err.Message, "comments") {
+		t.Errorf("formatting a document with comments gave %v", err)
+	}
+}
+
+// Until a document with a syntax error is fixed, requests about it find
+// nothing.
+func TestRequestsOnBrokenDocument(t *testing.T) {
+	c := newTestClient(t)
+	c.open(testDocument)
+
+	diagnostics := c.notify("textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]interface{}{"uri": testURI, "version": 2},
+		"contentChanges": []map[string]interface{}{{"text": strings.Replace(testDocument, "host", "$host", 1)}},
+	})
+
+	if len(diagnostics) != 1 {
+		t.Fatalf("got diagnostics %+v", diagnostics)
+	}
+
+	var definition *location
+
+	if err := c.request("textDocument/definition", at(2, 8), &definition); err != nil || definition != nil {
+		t.Errorf("got definition %+v, %v", definition, err)
+	}
+
+	var symbols []documentSymbol
+
+	if err := c.request("textDocument/documentSymbol", map[string]interface{}{"textDocument": map[string]interface{}{"uri": testURI}}, &symbols); err != nil || len(symbols) != 0 {
+		t.Errorf("got symbols %+v, %v", symbols, err)
+	}
+
+	if diagnostics := c.notify("textDocument/didClose", map[string]interface{}{"textDocument": map[string]interface{}{"uri": testURI}}); len(diagnostics) != 0 {
+		t.Errorf("closing the document published %+v", diagnostics)
+	}
+}
+
+func TestProtocolErrors(t *testing.T) {
+	c := newTestClient(t)
+
+	if exit, _ := c.server.handle([]byte("{")); exit {
+		t.Fatal("a message that is not JSON made the server exit")
+	}
+
+	if messages := c.messages(); len(messages) != 1 || !strings.Contains(string(messages[0]["error"]), "-32700") {
+		t.Errorf("a message that is not JSON was answered with %q", messages)
+	}
+
+	var result interface{}
+
+	if err := c.request("textDocument/unknown", map[string]interface{}{}, &result); err == nil || err.Code != codeMethodNotFound {
+		t.Errorf("an unknown method gave %v", err)
+	}
+
+	if err := c.request("textDocument/hover", []int{1}, &result); err == nil || err.Code != codeInvalidParams {
+		t.Errorf("invalid params gave %v", err)
+	}
+
+	c.request("shutdown", nil, &result)
+
+	if exit, code := c.server.handle([]byte(`{"jsonrpc": "2.0", "method": "exit"}`)); !exit || code != 0 {
+		t.Errorf("exit after shutdown gave %v, %d", exit, code)
+	}
+
+	if exit, code := newServer(&bytes.Buffer{}).handle([]byte(`{"jsonrpc": "2.0", "method": "exit"}`)); !exit || code != 1 {
+		t.Errorf("exit without shutdown gave %v, %d", exit, code)
+	}
+}
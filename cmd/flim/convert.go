@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/l-donovan/flim"
+	"github.com/l-donovan/flim/common"
+	"os"
+)
+
+func runConvert(args []string) error {
+	flags := flag.NewFlagSet("convert", flag.ContinueOnError)
+	from := flags.String("from", "", "input format, one of flim, json, yaml, toml or binary (default: guessed from the file name)")
+	to := flags.String("to", "", "output format, one of flim, json, yaml, toml or binary (default: json for flim input, otherwise flim)")
+	compact := flags.Bool("compact", false, "write output without indentation")
+	parsing := addParsingFlags(flags)
+
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: flim convert [-from format] [-to format] [-compact] [-big] [-literal name=pattern]... [file]")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	filename := flags.Arg(0)
+
+	if *from == "" {
+		*from = formatOf(filename)
+	}
+
+	if *to == "" {
+		*to = "flim"
+
+		if *from == "flim" {
+			*to = "json"
+		}
+	}
+
+	input, err := readInput(filename)
+
+	if err != nil {
+		return err
+	}
+
+	expr, err := parseDocument(input, *from, parsing.options())
+
+	if err != nil {
+		return err
+	}
+
+	var output []byte
+
+	switch *to {
+	case "flim":
+		var serialized string
+
+		if *compact {
+			serialized, err = common.SerializeWith(expr, common.Minified(), common.WithTrailingNewline())
+		} else {
+			serialized, err = common.SerializeWith(expr, common.WithTrailingNewline())
+		}
+
+		output = []byte(serialized)
+	case "json":
+		output, err = toJSON(expr, *compact)
+	case "yaml":
+		output, err = flim.ToYAML(expr)
+	case "toml":
+		if expr, err = flim.ResolveReferences(expr); err == nil {
+			output, err = flim.ToTOML(expr)
+		}
+	case "binary":
+		output, err = flim.EncodeExpression(expr)
+	default:
+		return fmt.Errorf("unknown output format `%s'", *to)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	_, err = os.Stdout.Write(output)
+	return err
+}
+
+// toJSON evaluates expr without any handlers and encodes the result.
+func toJSON(expr common.Expression, compact bool) ([]byte, error) {
+	expr, err := flim.ResolveReferences(expr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	val, err := common.Evaluate(expr, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := flim.ToJSON(val)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if compact {
+		return append(output, '\n'), nil
+	}
+
+	var indented bytes.Buffer
+
+	if err := json.Indent(&indented, output, "", "  "); err != nil {
+		return nil, err
+	}
+
+	indented.WriteByte('\n')
+	return indented.Bytes(), nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/l-donovan/flim"
+	"github.com/l-donovan/flim/common"
+	"os"
+)
+
+func runDiff(args []string) error {
+	flags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	from := flags.String("from", "", "input format, one of flim, json, yaml, toml or binary (default: guessed from each file name)")
+	values := flags.Bool("values", false, "compare evaluated values, without any transformers, instead of documents")
+	format := flags.String("format", "text", "output format, text or patch")
+	parsing := addParsingFlags(flags)
+
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: flim diff [-from format] [-values] [-format text|patch] [-big] [-literal name=pattern]... old new")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return fmt.Errorf("expected two files")
+	}
+
+	docs := make([]common.Expression, 2)
+
+	for i, filename := range flags.Args() {
+		docFormat := *from
+
+		if docFormat == "" {
+			docFormat = formatOf(filename)
+		}
+
+		input, err := readInput(filename)
+
+		if err != nil {
+			return err
+		}
+
+		expr, err := parseDocument(input, docFormat, parsing.options())
+
+		if err == nil {
+			expr, err = flim.ResolveReferences(expr)
+		}
+
+		if err != nil {
+			return fmt.Errorf("%s: %w", filename, err)
+		}
+
+		docs[i] = expr
+	}
+
+	var options []flim.DiffOption
+
+	if *values {
+		options = append(options, flim.CompareValues(nil))
+	}
+
+	changes, err := flim.Diff(docs[0], docs[1], options...)
+
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case "text":
+		fmt.Print(flim.FormatChanges(changes))
+	case "patch":
+		patch, err := flim.PatchFromChanges(changes)
+
+		if err != nil {
+			return err
+		}
+
+		output, err := common.SerializeWith(patch, common.WithTrailingNewline())
+
+		if err != nil {
+			return err
+		}
+
+		fmt.Print(output)
+	default:
+		return fmt.Errorf("unknown output format `%s'", *format)
+	}
+
+	if len(changes) > 0 {
+		// Like diff(1), exit with status 1 when the documents differ
+		os.Exit(1)
+	}
+
+	return nil
+}
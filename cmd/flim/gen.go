@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/l-donovan/flim"
+	"os"
+)
+
+func runGen(args []string) error {
+	flags := flag.NewFlagSet("gen", flag.ContinueOnError)
+	schemaFile := flags.String("schema", "", "schema, written in flim or as a JSON Schema (default: inferred from the sample files)")
+	typeName := flags.String("type", "Config", "name of the type to generate for the document")
+	packageName := flags.String("package", os.Getenv("GOPACKAGE"), "package of the generated file (default: $GOPACKAGE, as set by go generate, or main)")
+	output := flags.String("o", "", "file to write to (default: standard output)")
+	parsing := addParsingFlags(flags)
+
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: flim gen [-schema schema] [-type name] [-package name] [-o file] [-big] [-literal name=pattern]... [sample...]")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *packageName == "" {
+		*packageName = "main"
+	}
+
+	schema, err := schemaOrSamples(*schemaFile, flags.Args(), parsing.options())
+
+	if err != nil {
+		if err == errSchemaAndSamples {
+			flags.Usage()
+		}
+
+		return err
+	}
+
+	source, err := flim.GoTypes(schema, *packageName, *typeName)
+
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		_, err = os.Stdout.Write(source)
+		return err
+	}
+
+	return os.WriteFile(*output, source, 0o644)
+}
+
+var errSchemaAndSamples = errors.New("expected a schema or sample files, not both")
+
+// schemaOrSamples loads a schema, or infers one from sample flim documents
+// if there is none.
+func schemaOrSamples(schemaFile string, filenames []string, options []flim.ParserOption) (*flim.Schema, error) {
+	if schemaFile != "" {
+		if len(filenames) > 0 {
+			return nil, errSchemaAndSamples
+		}
+
+		schema, err := loadSchema(schemaFile, options)
+
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", schemaFile, err)
+		}
+
+		return schema, nil
+	}
+
+	if len(filenames) == 0 {
+		filenames = []string{"-"}
+	}
+
+	docs := make([]*flim.Document, len(filenames))
+
+	for i, filename := range filenames {
+		input, err := readInput(filename)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if docs[i], err = flim.ParseDocument(string(input), options...); err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+	}
+
+	return flim.InferSchema(docs...), nil
+}
+
+// loadSchema reads a schema written in flim, or a JSON Schema if the file
+// name ends in .json.
+func loadSchema(filename string, options []flim.ParserOption) (*flim.Schema, error) {
+	if formatOf(filename) != "json" {
+		return flim.LoadSchema(filename, options...)
+	}
+
+	input, err := os.ReadFile(filename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return flim.ParseJSONSchema(input, options...)
+}
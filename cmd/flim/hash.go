@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/l-donovan/flim"
+)
+
+func runHash(args []string) error {
+	flags := flag.NewFlagSet("hash", flag.ContinueOnError)
+	from := flags.String("from", "", "input format, one of flim, json, yaml, toml or binary (default: guessed from each file name)")
+	unresolved := flags.Bool("unresolved", false, "hash tags and references by name instead of by what they refer to")
+	canonical := flags.Bool("canonical", false, "print the canonical form instead of its hash")
+	parsing := addParsingFlags(flags)
+
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: flim hash [-from format] [-unresolved] [-canonical] [-big] [-literal name=pattern]... [file...]")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	filenames := flags.Args()
+
+	if len(filenames) == 0 {
+		filenames = []string{"-"}
+	}
+
+	var options []flim.CanonicalOption
+
+	if *unresolved {
+		options = append(options, flim.WithUnresolvedReferences())
+	}
+
+	for _, filename := range filenames {
+		format := *from
+
+		if format == "" {
+			format = formatOf(filename)
+		}
+
+		input, err := readInput(filename)
+
+		if err != nil {
+			return err
+		}
+
+		expr, err := parseDocument(input, format, parsing.options())
+
+		if err == nil {
+			expr, err = flim.ResolveReferences(expr)
+		}
+
+		if err != nil {
+			return fmt.Errorf("%s: %w", filename, err)
+		}
+
+		if *canonical {
+			canonicalString, err := flim.CanonicalString(expr, options...)
+
+			if err != nil {
+				return fmt.Errorf("%s: %w", filename, err)
+			}
+
+			fmt.Println(canonicalString)
+			continue
+		}
+
+		hash, err := flim.Hash(expr, options...)
+
+		if err != nil {
+			return fmt.Errorf("%s: %w", filename, err)
+		}
+
+		fmt.Printf("%s  %s\n", hash, filename)
+	}
+
+	return nil
+}
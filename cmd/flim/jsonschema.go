@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+func runJSONSchema(args []string) error {
+	flags := flag.NewFlagSet("jsonschema", flag.ContinueOnError)
+	schemaFile := flags.String("schema", "", "schema, written in flim or as a JSON Schema (default: inferred from the sample files)")
+	output := flags.String("o", "", "file to write to (default: standard output)")
+	parsing := addParsingFlags(flags)
+
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: flim jsonschema [-schema schema] [-o file] [-big] [-literal name=pattern]... [sample...]")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	schema, err := schemaOrSamples(*schemaFile, flags.Args(), parsing.options())
+
+	if err != nil {
+		if err == errSchemaAndSamples {
+			flags.Usage()
+		}
+
+		return err
+	}
+
+	out, err := schema.JSONSchema()
+
+	if err != nil {
+		return err
+	}
+
+	out = append(out, '\n')
+
+	if *output == "" {
+		_, err = os.Stdout.Write(out)
+		return err
+	}
+
+	return os.WriteFile(*output, out, 0o644)
+}
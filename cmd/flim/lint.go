@@ -0,0 +1,122 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/l-donovan/flim/common"
+	"github.com/l-donovan/flim/lint"
+	"os"
+	"strings"
+)
+
+func runLint(args []string) error {
+	flags := flag.NewFlagSet("lint", flag.ContinueOnError)
+	disable := flags.String("disable", "", "comma-separated rules not to check")
+	transformers := flags.String("transformers", "", "comma-separated names of the transformers documents are evaluated with; any others are reported")
+	parsing := addParsingFlags(flags)
+
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: flim lint [-disable rules] [-transformers names] [-big] [-literal name=pattern]... [file...]")
+		flags.PrintDefaults()
+		fmt.Fprintf(flags.Output(), "rules: %s\n", strings.Join(ruleNames(), ", "))
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	rules := lint.DefaultRules()
+
+	// Transformers can only be checked against a known set of them
+	if *transformers != "" {
+		handlers := map[string]common.HandlerFunc{}
+
+		for _, name := range strings.Split(*transformers, ",") {
+			handlers[name] = nil
+		}
+
+		rules = append(rules, lint.UnknownTransformers(handlers))
+	}
+
+	rules, err := enabledRules(rules, *disable)
+
+	if err != nil {
+		flags.Usage()
+		return err
+	}
+
+	filenames := flags.Args()
+
+	if len(filenames) == 0 {
+		filenames = []string{"-"}
+	}
+
+	clean := true
+
+	for _, filename := range filenames {
+		input, err := readInput(filename)
+
+		if err != nil {
+			return err
+		}
+
+		problems, err := lint.Lint(string(input), rules, parsing.options()...)
+
+		for _, problem := range problems {
+			clean = false
+			fmt.Printf("%s:%s\n", filename, problem)
+		}
+
+		if err != nil {
+			return fmt.Errorf("%s: %w", filename, err)
+		}
+	}
+
+	if !clean {
+		os.Exit(1)
+	}
+
+	return nil
+}
+
+// ruleNames returns the name of every built-in rule.
+func ruleNames() []string {
+	names := []string{}
+
+	for _, rule := range append(lint.DefaultRules(), lint.UnknownTransformers(nil)) {
+		names = append(names, rule.Name())
+	}
+
+	return names
+}
+
+// enabledRules removes the rules named in disable, a comma-separated list.
+func enabledRules(rules []lint.Rule, disable string) ([]lint.Rule, error) {
+	known := map[string]bool{}
+
+	for _, name := range ruleNames() {
+		known[name] = true
+	}
+
+	disabled := map[string]bool{}
+
+	if disable != "" {
+		for _, name := range strings.Split(disable, ",") {
+			if !known[name] {
+				return nil, fmt.Errorf("unknown rule `%s'", name)
+			}
+
+			disabled[name] = true
+		}
+	}
+
+	enabled := []lint.Rule{}
+
+	for _, rule := range rules {
+		if !disabled[rule.Name()] {
+			enabled = append(enabled, rule)
+		}
+	}
+
+	return enabled, nil
+}
@@ -0,0 +1,175 @@
+// Command flim works with flim documents from the command line.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/l-donovan/flim"
+	"github.com/l-donovan/flim/common"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type command struct {
+	summary string
+	run     func(args []string) error
+}
+
+var commands = map[string]command{
+	"convert":    {"convert documents between flim, JSON, YAML, TOML and binary", runConvert},
+	"diff":       {"report the differences between two documents by path", runDiff},
+	"gen":        {"generate Go types with flim tags from a schema or sample documents", runGen},
+	"hash":       {"print a hash of each document that ignores formatting and key order", runHash},
+	"jsonschema": {"write a JSON Schema for evaluated documents, from a flim schema or samples", runJSONSchema},
+	"lint":       {"report likely mistakes in flim documents, like unused tags and shadowed keys", runLint},
+	"patch":      {"apply a patch document to a flim file, keeping its comments and formatting", runPatch},
+	"query":      {"print the values a query selects, with their positions in flim files", runQuery},
+	"validate":   {"check documents against a schema, reporting every violation", runValidate},
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+
+	for name := range commands {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "usage: flim <command> [arguments]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "commands:")
+
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].summary)
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	name := os.Args[1]
+	cmd, exists := commands[name]
+
+	if !exists {
+		fmt.Fprintf(os.Stderr, "flim: unknown command `%s'\n", name)
+		usage()
+		os.Exit(2)
+	}
+
+	err := cmd.run(os.Args[2:])
+
+	// Asking for help with -h has already printed the usage
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "flim %s: %s\n", name, err)
+		os.Exit(1)
+	}
+}
+
+// readInput reads the named file, or standard input if the name is empty or -.
+func readInput(filename string) ([]byte, error) {
+	if filename == "" || filename == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+
+	return os.ReadFile(filename)
+}
+
+// formatOf guesses the format of a file from its extension.
+func formatOf(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		return "json"
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".toml":
+		return "toml"
+	case ".flimb":
+		return "binary"
+	default:
+		return "flim"
+	}
+}
+
+// parseDocument parses input in the given format. References are left
+// unresolved so that tags and references survive conversion to formats that
+// have them.
+func parseDocument(input []byte, format string, options []flim.ParserOption) (common.Expression, error) {
+	switch format {
+	case "flim":
+		parser := flim.NewParser(options...)
+		tokens, err := parser.Lex(string(input))
+
+		if err != nil {
+			return nil, err
+		}
+
+		return parser.Parse(tokens)
+	case "json":
+		return flim.FromJSON(input)
+	case "yaml":
+		return flim.FromYAML(input)
+	case "toml":
+		return flim.FromTOML(input)
+	case "binary":
+		return flim.DecodeExpression(input, options...)
+	default:
+		return nil, fmt.Errorf("unknown input format `%s'", format)
+	}
+}
+
+// parsing holds the flags of the commands that parse flim documents.
+type parsing struct {
+	bigNumbers bool
+	literals   *flim.Literals
+}
+
+// addParsingFlags adds -big, and -literal for documents with custom literals.
+// As the command line cannot say how to parse a custom literal, they are read
+// as strings.
+func addParsingFlags(flags *flag.FlagSet) *parsing {
+	p := &parsing{literals: flim.NewLiterals()}
+	flags.BoolVar(&p.bigNumbers, "big", false, "parse flim numbers with arbitrary precision")
+
+	flags.Func("literal", "read text matching `name=pattern` as a custom literal named name; may be repeated", func(value string) error {
+		name, pattern, found := strings.Cut(value, "=")
+
+		if !found {
+			return fmt.Errorf("expected name=pattern")
+		}
+
+		return p.literals.Register(flim.LiteralKind{
+			Name:    name,
+			Pattern: pattern,
+			Parse: func(text string) (interface{}, error) {
+				return text, nil
+			},
+			Serialize: func(val interface{}) (string, error) {
+				return val.(string), nil
+			},
+		})
+	})
+
+	return p
+}
+
+func (p *parsing) options() []flim.ParserOption {
+	options := []flim.ParserOption{flim.WithLiterals(p.literals)}
+
+	if p.bigNumbers {
+		options = append(options, flim.WithBigNumbers())
+	}
+
+	return options
+}
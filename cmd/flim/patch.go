@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/l-donovan/flim"
+	"os"
+)
+
+func runPatch(args []string) error {
+	flags := flag.NewFlagSet("patch", flag.ContinueOnError)
+	patchFile := flags.String("p", "", "patch document, in flim or JSON (required)")
+	write := flags.Bool("w", false, "write the result back to the file instead of standard output")
+	parsing := addParsingFlags(flags)
+
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: flim patch -p patch [-w] [-big] [-literal name=pattern]... file")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *patchFile == "" || flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("expected a patch and a file")
+	}
+
+	patchInput, err := readInput(*patchFile)
+
+	if err != nil {
+		return err
+	}
+
+	patchExpr, err := parseDocument(patchInput, formatOf(*patchFile), parsing.options())
+
+	if err != nil {
+		return fmt.Errorf("%s: %w", *patchFile, err)
+	}
+
+	patch, err := flim.ParsePatch(patchExpr)
+
+	if err != nil {
+		return fmt.Errorf("%s: %w", *patchFile, err)
+	}
+
+	filename := flags.Arg(0)
+	input, err := readInput(filename)
+
+	if err != nil {
+		return err
+	}
+
+	// Patching the source rather than the parsed document keeps comments
+	// and formatting
+	output, err := patch.ApplyToSource(string(input), parsing.options()...)
+
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+
+	if *write {
+		return os.WriteFile(filename, []byte(output), 0o644)
+	}
+
+	fmt.Print(output)
+	return nil
+}
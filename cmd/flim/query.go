@@ -0,0 +1,129 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/l-donovan/flim"
+	"github.com/l-donovan/flim/common"
+	flimexpr "github.com/l-donovan/flim/expressions"
+	"os"
+)
+
+func runQuery(args []string) error {
+	flags := flag.NewFlagSet("query", flag.ContinueOnError)
+	from := flags.String("from", "", "input format, one of flim, json, yaml, toml or binary (default: guessed from each file name)")
+	values := flags.Bool("values", false, "query the evaluated value, without any transformers, instead of the document")
+	parsing := addParsingFlags(flags)
+
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: flim query [-from format] [-values] [-big] [-literal name=pattern]... query [file...]")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() < 1 {
+		flags.Usage()
+		return fmt.Errorf("expected a query")
+	}
+
+	query, err := flim.ParseQuery(flags.Arg(0))
+
+	if err != nil {
+		return err
+	}
+
+	filenames := flags.Args()[1:]
+
+	if len(filenames) == 0 {
+		filenames = []string{"-"}
+	}
+
+	matched := false
+
+	for _, filename := range filenames {
+		format := *from
+
+		if format == "" {
+			format = formatOf(filename)
+		}
+
+		input, err := readInput(filename)
+
+		if err != nil {
+			return err
+		}
+
+		var queryOptions []flim.QueryOption
+		var expr common.Expression
+
+		if format == "flim" {
+			// Only flim sources can give the positions of results
+			sourceMap := &flim.SourceMap{}
+			parser := flim.NewParser(append(parsing.options(), flim.WithSourceMap(sourceMap))...)
+			tokens, err := parser.Lex(string(input))
+
+			if err == nil {
+				expr, err = parser.Parse(tokens)
+			}
+
+			if err != nil {
+				return fmt.Errorf("%s: %w", filename, err)
+			}
+
+			queryOptions = append(queryOptions, flim.WithPositions(string(input), sourceMap))
+		} else if expr, err = parseDocument(input, format, parsing.options()); err != nil {
+			return fmt.Errorf("%s: %w", filename, err)
+		}
+
+		if expr, err = flim.ResolveReferences(expr); err != nil {
+			return fmt.Errorf("%s: %w", filename, err)
+		}
+
+		var results []flim.QueryResult
+
+		if *values {
+			val, err := common.Evaluate(expr, nil)
+
+			if err != nil {
+				return fmt.Errorf("%s: %w", filename, err)
+			}
+
+			results = query.Values(val, flim.WithDocumentOrder(expr))
+		} else {
+			results = query.Expressions(expr, queryOptions...)
+		}
+
+		for _, result := range results {
+			matched = true
+			resultExpr := result.Expression
+
+			if resultExpr == nil {
+				if resultExpr, err = flimexpr.NewExpressionFromValue(result.Value); err != nil {
+					return err
+				}
+			}
+
+			text, err := common.SerializeWith(resultExpr, common.Minified())
+
+			if err != nil {
+				return err
+			}
+
+			if result.Position.IsValid() {
+				fmt.Printf("%s:%s: ", filename, result.Position)
+			}
+
+			fmt.Printf("%s: %s\n", result.Path, text)
+		}
+	}
+
+	if !matched {
+		// Like grep(1), exit with status 1 when nothing is found
+		os.Exit(1)
+	}
+
+	return nil
+}
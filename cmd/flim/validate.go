@@ -0,0 +1,115 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/l-donovan/flim"
+	"github.com/l-donovan/flim/common"
+	"os"
+)
+
+func runValidate(args []string) error {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	schemaFile := flags.String("schema", "", "schema, written in flim or as a JSON Schema (required)")
+	from := flags.String("from", "", "input format, one of flim, json, yaml, toml or binary (default: guessed from each file name)")
+	values := flags.Bool("values", false, "validate the evaluated value, without any transformers, instead of the document")
+	parsing := addParsingFlags(flags)
+
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: flim validate -schema schema [-from format] [-values] [-big] [-literal name=pattern]... [file...]")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *schemaFile == "" {
+		flags.Usage()
+		return fmt.Errorf("expected a schema")
+	}
+
+	schema, err := loadSchema(*schemaFile, parsing.options())
+
+	if err != nil {
+		return fmt.Errorf("%s: %w", *schemaFile, err)
+	}
+
+	filenames := flags.Args()
+
+	if len(filenames) == 0 {
+		filenames = []string{"-"}
+	}
+
+	valid := true
+
+	for _, filename := range filenames {
+		format := *from
+
+		if format == "" {
+			format = formatOf(filename)
+		}
+
+		input, err := readInput(filename)
+
+		if err != nil {
+			return err
+		}
+
+		var validateOptions []flim.ValidateOption
+		var expr common.Expression
+
+		if format == "flim" {
+			// Only flim sources can give the positions of violations
+			sourceMap := &flim.SourceMap{}
+			parser := flim.NewParser(append(parsing.options(), flim.WithSourceMap(sourceMap))...)
+			tokens, err := parser.Lex(string(input))
+
+			if err == nil {
+				expr, err = parser.Parse(tokens)
+			}
+
+			if err != nil {
+				return fmt.Errorf("%s: %w", filename, err)
+			}
+
+			validateOptions = append(validateOptions, flim.ValidateWithPositions(string(input), sourceMap))
+		} else if expr, err = parseDocument(input, format, parsing.options()); err != nil {
+			return fmt.Errorf("%s: %w", filename, err)
+		}
+
+		if expr, err = flim.ResolveReferences(expr); err != nil {
+			return fmt.Errorf("%s: %w", filename, err)
+		}
+
+		var violations []flim.Violation
+
+		if *values {
+			val, err := common.Evaluate(expr, nil)
+
+			if err != nil {
+				return fmt.Errorf("%s: %w", filename, err)
+			}
+
+			violations = schema.ValidateValue(val)
+		} else {
+			violations = schema.Validate(expr, validateOptions...)
+		}
+
+		for _, violation := range violations {
+			valid = false
+
+			if violation.Position.IsValid() {
+				fmt.Printf("%s:%s\n", filename, violation)
+			} else {
+				fmt.Printf("%s: %s\n", filename, violation)
+			}
+		}
+	}
+
+	if !valid {
+		os.Exit(1)
+	}
+
+	return nil
+}
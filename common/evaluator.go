@@ -0,0 +1,466 @@
+package common
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+type EvaluatorConfig struct {
+	handlers map[string]HandlerFunc
+	impure   map[string]bool
+	memoize  bool
+	debug    bool
+
+	// workers holds a token for each goroutine that may evaluate a subtree
+	// concurrently, or is nil when evaluation is sequential
+	workers chan struct{}
+
+	observers []EvalObserver
+
+	// within is the innermost tagged expression being evaluated by the
+	// caller, see Within
+	within *withinTag
+
+	// state is shared by the config and every config derived from it
+	state *evaluatorState
+}
+
+type evaluatorState struct {
+	mu          sync.Mutex
+	memo        map[*MemoKey]*memoEntry
+	memoHits    map[string]int
+	impureCalls int
+
+	// waits counts, for each tagged expression being evaluated, the tagged
+	// expressions its evaluation is waiting on another goroutine for
+	waits map[*MemoKey]map[*MemoKey]int
+}
+
+// MemoKey identifies a tagged expression. Its result is memoized by key
+// rather than by tag name, so tags with the same name written in different
+// places are kept apart.
+type MemoKey struct {
+	Tag string
+}
+
+func NewMemoKey(tag string) *MemoKey {
+	return &MemoKey{Tag: tag}
+}
+
+type withinTag struct {
+	key    *MemoKey
+	parent *withinTag
+}
+
+type memoEntry struct {
+	done   chan struct{}
+	result interface{}
+	ok     bool
+}
+
+type EvaluatorOption func(*EvaluatorConfig)
+
+// WithImpureHandlers marks handlers whose results may differ between calls
+// with the same input. Tagged expressions that call an impure handler are
+// never memoized.
+func WithImpureHandlers(names ...string) EvaluatorOption {
+	return func(c *EvaluatorConfig) {
+		for _, name := range names {
+			c.impure[name] = true
+		}
+	}
+}
+
+// WithoutMemoization evaluates every use of a tag separately.
+func WithoutMemoization() EvaluatorOption {
+	return func(c *EvaluatorConfig) {
+		c.memoize = false
+	}
+}
+
+// WithDebug records how many times each tag's memoized result was reused,
+// see MemoHits.
+func WithDebug() EvaluatorOption {
+	return func(c *EvaluatorConfig) {
+		c.debug = true
+	}
+}
+
+// WithWorkers evaluates sibling map values, list items and file expressions
+// concurrently using up to n additional goroutines. Handlers must be safe for
+// concurrent use, though each is given its own copy of the maps and lists of
+// a memoized tag, see Memoize. Results are identical to sequential
+// evaluation, and when several siblings fail the error of the first one in
+// document order is returned.
+func WithWorkers(n int) EvaluatorOption {
+	return func(c *EvaluatorConfig) {
+		if n > 0 {
+			c.workers = make(chan struct{}, n)
+		} else {
+			c.workers = nil
+		}
+	}
+}
+
+func NewEvaluatorConfig(handlers map[string]HandlerFunc, options ...EvaluatorOption) *EvaluatorConfig {
+	config := &EvaluatorConfig{
+		handlers: handlers,
+		impure:   map[string]bool{},
+		memoize:  true,
+		state: &evaluatorState{
+			memo:     map[*MemoKey]*memoEntry{},
+			memoHits: map[string]int{},
+			waits:    map[*MemoKey]map[*MemoKey]int{},
+		},
+	}
+
+	for _, option := range options {
+		option(config)
+	}
+
+	return config
+}
+
+func (c *EvaluatorConfig) Handler(name string) (HandlerFunc, bool) {
+	handler, exists := c.handlers[name]
+	return handler, exists
+}
+
+// IsImpure reports whether the named handler was marked impure with
+// WithImpureHandlers.
+func (c *EvaluatorConfig) IsImpure(name string) bool {
+	return c.impure[name]
+}
+
+func (c *EvaluatorConfig) CallHandler(name string, data interface{}) (interface{}, error) {
+	handler, exists := c.handlers[name]
+
+	if !exists {
+		return nil, fmt.Errorf("no handler for `%s'", name)
+	}
+
+	if c.impure[name] {
+		c.state.mu.Lock()
+		c.state.impureCalls++
+		c.state.mu.Unlock()
+	}
+
+	if len(c.observers) == 0 {
+		return handler(data)
+	}
+
+	start := time.Now()
+	result, err := handler(data)
+	duration := time.Since(start)
+
+	for _, observer := range c.observers {
+		observer.HandlerCall(name, data, result, err, duration)
+	}
+
+	return result, err
+}
+
+// Evaluate evaluates expr, notifying any observers. Expressions evaluate
+// their children through it rather than calling EvaluateWith directly.
+func (c *EvaluatorConfig) Evaluate(expr Expression) (interface{}, error) {
+	if len(c.observers) == 0 {
+		return c.evaluate(expr)
+	}
+
+	for _, observer := range c.observers {
+		observer.EnterNode(expr)
+	}
+
+	result, err := c.evaluate(expr)
+
+	for _, observer := range c.observers {
+		observer.ExitNode(expr, result, err)
+	}
+
+	return result, err
+}
+
+func (c *EvaluatorConfig) evaluate(expr Expression) (interface{}, error) {
+	if configExpr, ok := expr.(ConfigEvaluator); ok {
+		return configExpr.EvaluateWith(c)
+	}
+
+	return expr.Evaluate(c.handlers)
+}
+
+// PartialEvaluate partially evaluates expr. Expressions partially evaluate
+// their children through it rather than calling PartialEvaluateWith
+// directly.
+func (c *EvaluatorConfig) PartialEvaluate(expr Expression) (Expression, error) {
+	if configExpr, ok := expr.(ConfigEvaluator); ok {
+		return configExpr.PartialEvaluateWith(c)
+	}
+
+	return expr, nil
+}
+
+// ReferenceExpanded notifies observers that a reference to tag was evaluated.
+func (c *EvaluatorConfig) ReferenceExpanded(tag string) {
+	for _, observer := range c.observers {
+		observer.ReferenceExpanded(tag)
+	}
+}
+
+// Within returns a config for evaluating the tagged expression identified by
+// key, or an error if the caller is already evaluating it, in which case the
+// tag refers to itself.
+func (c *EvaluatorConfig) Within(key *MemoKey) (*EvaluatorConfig, error) {
+	for within := c.within; within != nil; within = within.parent {
+		if within.key == key {
+			return nil, fmt.Errorf("tag `%s' refers to itself", key.Tag)
+		}
+	}
+
+	child := *c
+	child.within = &withinTag{key: key, parent: c.within}
+
+	return &child, nil
+}
+
+// Memoize returns the result of eval for the tagged expression identified by
+// key, reusing the result of an earlier call for the same key when one is
+// available. eval is given the config to evaluate the tagged expression with,
+// see Within. Errors and results that depended on an impure handler are not
+// remembered. Concurrent calls for the same key wait for the first one rather
+// than evaluating it again.
+//
+// Each call is given its own copy of the maps and lists in a remembered
+// result, so a handler that modifies its input does not change what other
+// uses of the tag see. Other values, like those returned by handlers, are
+// shared.
+func (c *EvaluatorConfig) Memoize(key *MemoKey, eval func(config *EvaluatorConfig) (interface{}, error)) (interface{}, error) {
+	config, err := c.Within(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !c.memoize {
+		return eval(config)
+	}
+
+	state := c.state
+	state.mu.Lock()
+
+	if entry, exists := state.memo[key]; exists {
+		if err := c.wait(key); err != nil {
+			state.mu.Unlock()
+			return nil, err
+		}
+
+		state.mu.Unlock()
+		<-entry.done
+		state.mu.Lock()
+		c.stopWaiting(key)
+
+		if entry.ok && c.debug {
+			state.memoHits[key.Tag]++
+		}
+
+		state.mu.Unlock()
+
+		if !entry.ok {
+			return eval(config)
+		}
+
+		return copyValue(entry.result), nil
+	}
+
+	entry := &memoEntry{done: make(chan struct{})}
+	state.memo[key] = entry
+	impureCalls := state.impureCalls
+	state.mu.Unlock()
+
+	result, err := eval(config)
+
+	var cached interface{}
+
+	if err == nil {
+		cached = copyValue(result)
+	}
+
+	state.mu.Lock()
+
+	if err == nil && state.impureCalls == impureCalls {
+		entry.result = cached
+		entry.ok = true
+	} else {
+		delete(state.memo, key)
+	}
+
+	state.mu.Unlock()
+	close(entry.done)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// wait records that the tagged expressions the caller is evaluating are
+// waiting on key, which another goroutine is evaluating. If that evaluation is
+// itself waiting, however indirectly, on one of them, neither could finish, so
+// the tags refer to each other and an error is returned instead. The state
+// must be locked.
+func (c *EvaluatorConfig) wait(key *MemoKey) error {
+	waiting := map[*MemoKey]bool{}
+
+	for within := c.within; within != nil; within = within.parent {
+		waiting[within.key] = true
+	}
+
+	seen := map[*MemoKey]bool{}
+	pending := []*MemoKey{key}
+
+	for len(pending) > 0 {
+		next := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		if waiting[next] {
+			return fmt.Errorf("tag `%s' refers to itself", key.Tag)
+		}
+
+		if seen[next] {
+			continue
+		}
+
+		seen[next] = true
+
+		for waitedOn := range c.state.waits[next] {
+			pending = append(pending, waitedOn)
+		}
+	}
+
+	for within := c.within; within != nil; within = within.parent {
+		if c.state.waits[within.key] == nil {
+			c.state.waits[within.key] = map[*MemoKey]int{}
+		}
+
+		c.state.waits[within.key][key]++
+	}
+
+	return nil
+}
+
+// stopWaiting undoes wait once key has been evaluated. The state must be
+// locked.
+func (c *EvaluatorConfig) stopWaiting(key *MemoKey) {
+	for within := c.within; within != nil; within = within.parent {
+		waits := c.state.waits[within.key]
+		waits[key]--
+
+		if waits[key] == 0 {
+			delete(waits, key)
+		}
+
+		if len(waits) == 0 {
+			delete(c.state.waits, within.key)
+		}
+	}
+}
+
+// copyValue copies the maps and lists that evaluation builds, at any depth.
+func copyValue(val interface{}) interface{} {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+
+		for key, item := range v {
+			copied[key] = copyValue(item)
+		}
+
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+
+		for i, item := range v {
+			copied[i] = copyValue(item)
+		}
+
+		return copied
+	default:
+		return val
+	}
+}
+
+// EvaluateAll evaluates each expression and returns the results in order. If
+// the config was created WithWorkers, the expressions are evaluated
+// concurrently and the first error in document order is returned.
+func (c *EvaluatorConfig) EvaluateAll(exprs []Expression) ([]interface{}, error) {
+	results := make([]interface{}, len(exprs))
+
+	if c.workers == nil || len(exprs) < 2 {
+		for i, expr := range exprs {
+			result, err := c.Evaluate(expr)
+
+			if err != nil {
+				return nil, err
+			}
+
+			results[i] = result
+		}
+
+		return results, nil
+	}
+
+	errs := make([]error, len(exprs))
+	var wg sync.WaitGroup
+
+	for i, expr := range exprs {
+		select {
+		case c.workers <- struct{}{}:
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+				defer func() { <-c.workers }()
+
+				results[i], errs[i] = c.Evaluate(expr)
+			}()
+		default:
+			// Every worker is busy, so evaluate on this goroutine rather than
+			// wait, which could deadlock when nested subtrees hold workers
+			results[i], errs[i] = c.Evaluate(expr)
+		}
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+// MemoHits returns the number of times each tag's result was reused. Hits are
+// only counted when the config was created WithDebug.
+func (c *EvaluatorConfig) MemoHits() map[string]int {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	hits := make(map[string]int, len(c.state.memoHits))
+
+	for tag, count := range c.state.memoHits {
+		hits[tag] = count
+	}
+
+	return hits
+}
+
+func Evaluate(expr Expression, handlers map[string]HandlerFunc, options ...EvaluatorOption) (interface{}, error) {
+	return NewEvaluatorConfig(handlers, options...).Evaluate(expr)
+}
+
+func PartialEvaluate(expr Expression, handlers map[string]HandlerFunc, options ...EvaluatorOption) (Expression, error) {
+	return NewEvaluatorConfig(handlers, options...).PartialEvaluate(expr)
+}
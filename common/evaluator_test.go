@@ -0,0 +1,183 @@
+package common_test
+
+import (
+	"github.com/l-donovan/flim"
+	"github.com/l-donovan/flim/common"
+	flimexpr "github.com/l-donovan/flim/expressions"
+	"reflect"
+	"testing"
+)
+
+// Sibling transformers evaluated concurrently are each given their own copy
+// of a memoized value, and so can modify it without racing. Run with -race.
+func TestWorkersCopyMemoizedValues(t *testing.T) {
+	expr, err := flim.ParseString("#d { a 1 }\n{ w mut &d x mut &d y mut &d z mut &d plain &d }")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handlers := map[string]common.HandlerFunc{
+		"mut": func(data interface{}) (interface{}, error) {
+			fields := data.(map[string]interface{})
+			fields["added"] = true
+
+			return fields, nil
+		},
+	}
+
+	for _, options := range [][]common.EvaluatorOption{
+		{common.WithWorkers(4)},
+		{common.WithWorkers(4), common.WithoutMemoization()},
+	} {
+		val, err := common.Evaluate(expr, handlers, options...)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := map[string]interface{}{
+			"w":     map[string]interface{}{"a": int64(1), "added": true},
+			"x":     map[string]interface{}{"a": int64(1), "added": true},
+			"y":     map[string]interface{}{"a": int64(1), "added": true},
+			"z":     map[string]interface{}{"a": int64(1), "added": true},
+			"plain": map[string]interface{}{"a": int64(1)},
+		}
+
+		if !reflect.DeepEqual(val, want) {
+			t.Errorf("got %v, want %v", val, want)
+		}
+	}
+}
+
+// Tags with the same name are memoized apart, and a reference uses the last
+// tag with its name, as it is serialized.
+func TestDuplicateTags(t *testing.T) {
+	expr, err := flim.ParseString("{ a #x 1 b #x 2 c &x }")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, options := range [][]common.EvaluatorOption{
+		nil,
+		{common.WithWorkers(4)},
+		{common.WithoutMemoization()},
+	} {
+		val, err := common.Evaluate(expr, nil, options...)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := map[string]interface{}{"a": int64(1), "b": int64(2), "c": int64(2)}
+
+		if !reflect.DeepEqual(val, want) {
+			t.Errorf("got %v, want %v", val, want)
+		}
+	}
+
+	text, err := common.SerializeWith(expr, common.Minified())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "{a #x 1 b #x 2 c 2}"; text != want {
+		t.Errorf("serialized as `%s', want `%s'", text, want)
+	}
+}
+
+// Tags that refer to themselves, directly or through other tags, give an
+// error rather than evaluating forever, including when the tags are evaluated
+// concurrently.
+func TestTagCycles(t *testing.T) {
+	for _, text := range []string{
+		"#a [1 &a]",
+		"#a { x &a }\n{ y &a }",
+		"{ p #a [&b] q #b [&a] }",
+		"{ p #a [&b] q #b [&c] r #c { x &a } }",
+	} {
+		expr, err := flim.ParseString(text)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, options := range [][]common.EvaluatorOption{
+			nil,
+			{common.WithWorkers(4)},
+			{common.WithoutMemoization()},
+		} {
+			if _, err := common.Evaluate(expr, nil, options...); err == nil {
+				t.Errorf("`%s' evaluated without an error", text)
+			}
+		}
+
+		if _, err := common.PartialEvaluate(expr, nil); err == nil {
+			t.Errorf("`%s' partially evaluated without an error", text)
+		}
+	}
+}
+
+// constant is an expression that implements only Expression, as one written
+// outside of the expressions package might.
+type constant struct {
+	val interface{}
+}
+
+func (c constant) ToString() string {
+	return "constant"
+}
+
+func (c constant) GetTags() map[string]common.Expression {
+	return map[string]common.Expression{}
+}
+
+func (c constant) ReplaceReferences(map[string]common.Expression) (common.Expression, error) {
+	return c, nil
+}
+
+func (c constant) Evaluate(handlers map[string]common.HandlerFunc) (interface{}, error) {
+	return c.val, nil
+}
+
+func (c constant) Serialize(config *common.SerializerConfig, indentLevel int) (string, error) {
+	return "1", nil
+}
+
+// Expressions that do not implement ConfigEvaluator are evaluated with
+// Evaluate, and left in place by partial evaluation.
+func TestExpressionWithoutConfig(t *testing.T) {
+	pair, err := flimexpr.NewPairExpression("a", constant{int64(1)})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expr, err := flimexpr.NewMapExpression([]common.Expression{pair})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	val, err := common.Evaluate(expr, nil, common.WithObserver(common.NewTimingObserver()))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := map[string]interface{}{"a": int64(1)}; !reflect.DeepEqual(val, want) {
+		t.Errorf("got %v, want %v", val, want)
+	}
+
+	residual, err := common.PartialEvaluate(expr, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a := residual.(flimexpr.MapExpression).Pairs()[0].(flimexpr.PairExpression).Value(); a != (constant{int64(1)}) {
+		t.Errorf("got %v, want the constant left in place", a)
+	}
+}
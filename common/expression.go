@@ -4,8 +4,22 @@ type HandlerFunc func(data interface{}) (interface{}, error)
 
 type Expression interface {
 	ToString() string
+
+	// GetTags returns the tagged expressions within the expression by tag.
+	// Each is the TaggedExpression itself rather than the expression it
+	// tags, so that references to it share its memoized result.
 	GetTags() map[string]Expression
 	ReplaceReferences(map[string]Expression) (Expression, error)
-	Evaluate(map[string]HandlerFunc) (interface{}, error)
+	Evaluate(handlers map[string]HandlerFunc) (interface{}, error)
 	Serialize(config *SerializerConfig, indentLevel int) (string, error)
 }
+
+// ConfigEvaluator is implemented by expressions that can be evaluated with a
+// config other than the default one for the handlers, and partially
+// evaluated. Every expression in the expressions package implements it.
+// EvaluatorConfig evaluates expressions that do not with Evaluate, and leaves
+// them as they are when partially evaluating.
+type ConfigEvaluator interface {
+	EvaluateWith(config *EvaluatorConfig) (interface{}, error)
+	PartialEvaluateWith(config *EvaluatorConfig) (Expression, error)
+}
@@ -0,0 +1,182 @@
+package common
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EvalObserver is notified as an expression tree is evaluated. When the
+// evaluator is created WithWorkers, observers are called from several
+// goroutines and must be safe for concurrent use.
+type EvalObserver interface {
+	// EnterNode is called before expr is evaluated.
+	EnterNode(expr Expression)
+
+	// ExitNode is called after expr is evaluated, with its result or error.
+	ExitNode(expr Expression, result interface{}, err error)
+
+	// HandlerCall is called after the handler for a transformer returns.
+	HandlerCall(name string, input interface{}, output interface{}, err error, duration time.Duration)
+
+	// ReferenceExpanded is called when a reference to a tag is evaluated.
+	ReferenceExpanded(name string)
+}
+
+// WithObserver adds an observer to be notified during evaluation. It may be
+// given more than once.
+func WithObserver(observer EvalObserver) EvaluatorOption {
+	return func(c *EvaluatorConfig) {
+		c.observers = append(c.observers, observer)
+	}
+}
+
+// nodeName returns the unqualified type name of an expression.
+func nodeName(expr Expression) string {
+	name := fmt.Sprintf("%T", expr)
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// TraceObserver writes an indented trace of evaluation. The indentation is
+// only meaningful when evaluation is sequential.
+type TraceObserver struct {
+	w     io.Writer
+	mu    sync.Mutex
+	depth int
+}
+
+func NewTraceObserver(w io.Writer) *TraceObserver {
+	return &TraceObserver{w: w}
+}
+
+func (o *TraceObserver) printf(format string, args ...interface{}) {
+	fmt.Fprintf(o.w, "%s%s\n", strings.Repeat("  ", o.depth), fmt.Sprintf(format, args...))
+}
+
+func (o *TraceObserver) EnterNode(expr Expression) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.printf("%s", nodeName(expr))
+	o.depth++
+}
+
+func (o *TraceObserver) ExitNode(expr Expression, result interface{}, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.depth--
+
+	if err != nil {
+		o.printf("%s failed: %s", nodeName(expr), err)
+	} else {
+		o.printf("%s => %v", nodeName(expr), result)
+	}
+}
+
+func (o *TraceObserver) HandlerCall(name string, input interface{}, output interface{}, err error, duration time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if err != nil {
+		o.printf("%s(%v) failed after %s: %s", name, input, duration, err)
+	} else {
+		o.printf("%s(%v) => %v in %s", name, input, output, duration)
+	}
+}
+
+func (o *TraceObserver) ReferenceExpanded(name string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.printf("&%s", name)
+}
+
+type HandlerTiming struct {
+	Calls   int
+	Errors  int
+	Total   time.Duration
+	Longest time.Duration
+}
+
+// TimingObserver records how many times each handler was called and how long
+// those calls took.
+type TimingObserver struct {
+	mu      sync.Mutex
+	timings map[string]HandlerTiming
+}
+
+func NewTimingObserver() *TimingObserver {
+	return &TimingObserver{timings: map[string]HandlerTiming{}}
+}
+
+func (o *TimingObserver) EnterNode(expr Expression) {}
+
+func (o *TimingObserver) ExitNode(expr Expression, result interface{}, err error) {}
+
+func (o *TimingObserver) ReferenceExpanded(name string) {}
+
+func (o *TimingObserver) HandlerCall(name string, input interface{}, output interface{}, err error, duration time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	timing := o.timings[name]
+	timing.Calls++
+	timing.Total += duration
+
+	if err != nil {
+		timing.Errors++
+	}
+
+	if duration > timing.Longest {
+		timing.Longest = duration
+	}
+
+	o.timings[name] = timing
+}
+
+// Timings returns a snapshot of the recorded timings, keyed by handler name.
+func (o *TimingObserver) Timings() map[string]HandlerTiming {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	timings := make(map[string]HandlerTiming, len(o.timings))
+
+	for name, timing := range o.timings {
+		timings[name] = timing
+	}
+
+	return timings
+}
+
+// Report writes one line per handler, slowest in total first.
+func (o *TimingObserver) Report(w io.Writer) error {
+	timings := o.Timings()
+	names := make([]string, 0, len(timings))
+
+	for name := range timings {
+		names = append(names, name)
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		if timings[names[i]].Total != timings[names[j]].Total {
+			return timings[names[i]].Total > timings[names[j]].Total
+		}
+
+		return names[i] < names[j]
+	})
+
+	for _, name := range names {
+		timing := timings[name]
+		_, err := fmt.Fprintf(w, "%s: %d calls (%d failed), %s total, %s longest\n", name, timing.Calls, timing.Errors, timing.Total, timing.Longest)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
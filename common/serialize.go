@@ -2,38 +2,209 @@ package common
 
 import "strings"
 
+type QuoteStyle int
+
+const (
+	// DoubleQuotes always quotes strings with "
+	DoubleQuotes QuoteStyle = iota
+
+	// SingleQuotes always quotes strings with '
+	SingleQuotes
+
+	// MinimalQuotes uses whichever quote needs fewer escapes, preferring "
+	MinimalQuotes
+)
+
 type SerializerConfig struct {
-	useTabs    bool
-	indentSize int
-	minify     bool
+	UseTabs    bool
+	IndentSize int
+	Minify     bool
+
+	// SortKeys orders map keys alphabetically. Keys are never moved across
+	// an expansion, since that would change which value wins.
+	SortKeys bool
+
+	// MaxLineWidth, when positive, writes maps and lists on a single line if
+	// they fit within this many columns from their indentation.
+	MaxLineWidth int
+
+	// BlankLines is the number of empty lines between top-level expressions.
+	BlankLines int
+
+	TrailingNewline bool
+	QuoteStyle      QuoteStyle
+
+	// column is the width of what is written before a value on its line,
+	// past the indentation, e.g. a key and the space after it
+	column int
+}
+
+type SerializerOption func(*SerializerConfig)
+
+func WithTabs() SerializerOption {
+	return func(c *SerializerConfig) {
+		c.UseTabs = true
+		c.IndentSize = 1
+	}
+}
+
+func WithIndentSize(indentSize int) SerializerOption {
+	return func(c *SerializerConfig) {
+		c.IndentSize = indentSize
+	}
+}
+
+func Minified() SerializerOption {
+	return func(c *SerializerConfig) {
+		c.Minify = true
+	}
+}
+
+func WithSortedKeys() SerializerOption {
+	return func(c *SerializerConfig) {
+		c.SortKeys = true
+	}
+}
+
+func WithMaxLineWidth(width int) SerializerOption {
+	return func(c *SerializerConfig) {
+		c.MaxLineWidth = width
+	}
+}
+
+func WithBlankLines(blankLines int) SerializerOption {
+	return func(c *SerializerConfig) {
+		c.BlankLines = blankLines
+	}
+}
+
+func WithTrailingNewline() SerializerOption {
+	return func(c *SerializerConfig) {
+		c.TrailingNewline = true
+	}
+}
+
+func WithQuoteStyle(style QuoteStyle) SerializerOption {
+	return func(c *SerializerConfig) {
+		c.QuoteStyle = style
+	}
+}
+
+func NewSerializerConfig(options ...SerializerOption) *SerializerConfig {
+	config := &SerializerConfig{IndentSize: 4, BlankLines: 1}
+
+	for _, option := range options {
+		option(config)
+	}
+
+	return config
 }
 
 func (c SerializerConfig) Indent(indentLevel int) string {
-	if c.minify {
+	if c.Minify {
 		return ""
 	}
 
-	if c.useTabs {
-		return strings.Repeat("\t", c.indentSize*indentLevel)
+	if c.UseTabs {
+		return strings.Repeat("\t", c.IndentSize*indentLevel)
 	}
 
-	return strings.Repeat(" ", c.indentSize*indentLevel)
+	return strings.Repeat(" ", c.IndentSize*indentLevel)
 }
 
 func (c SerializerConfig) Sep(separator string, alt string) string {
-	if c.minify {
+	if c.Minify {
 		return alt
 	}
 
 	return separator
 }
 
+// Inline returns a copy of the config that writes everything on one line.
+func (c SerializerConfig) Inline() *SerializerConfig {
+	c.Minify = true
+	return &c
+}
+
+// After returns a copy of the config for a value written after prefix on the
+// same line, e.g. after a key or a tag.
+func (c SerializerConfig) After(prefix string) *SerializerConfig {
+	c.column += len(prefix)
+	return &c
+}
+
+// LineStart returns a copy of the config for a value written at the start of
+// a line, e.g. a list item.
+func (c SerializerConfig) LineStart() *SerializerConfig {
+	c.column = 0
+	return &c
+}
+
+// Column returns the column a value serialized at indentLevel starts at, its
+// line being indented one level less.
+func (c SerializerConfig) Column(indentLevel int) int {
+	return len(c.Indent(indentLevel-1)) + c.column
+}
+
+// Fits reports whether an inline form of a map or list, starting at column,
+// should be used in place of the multiline form.
+func (c SerializerConfig) Fits(inline string, column int) bool {
+	if c.Minify || c.MaxLineWidth <= 0 || strings.Contains(inline, "\n") {
+		return false
+	}
+
+	return column+len(inline) <= c.MaxLineWidth
+}
+
+// Quote quotes and escapes a string according to the configured QuoteStyle.
+func (c SerializerConfig) Quote(val string) string {
+	quote := "\""
+
+	switch c.QuoteStyle {
+	case SingleQuotes:
+		quote = "'"
+	case MinimalQuotes:
+		if strings.Count(val, "\"") > strings.Count(val, "'") {
+			quote = "'"
+		}
+	}
+
+	replacer := strings.NewReplacer(
+		"\\", "\\\\",
+		quote, "\\"+quote,
+		"\n", "\\n",
+		"\r", "\\r",
+		"\t", "\\t",
+	)
+
+	return quote + replacer.Replace(val) + quote
+}
+
+// Serialize writes a complete document, honoring TrailingNewline.
+func (c *SerializerConfig) Serialize(expr Expression) (string, error) {
+	out, err := expr.Serialize(c, 0)
+
+	if err != nil {
+		return "", err
+	}
+
+	if c.TrailingNewline {
+		out += "\n"
+	}
+
+	return out, nil
+}
+
 func Serialize(expr Expression, useTabs bool, indentSize int) (string, error) {
-	config := SerializerConfig{useTabs: useTabs, indentSize: indentSize}
-	return expr.Serialize(&config, 0)
+	config := NewSerializerConfig(WithIndentSize(indentSize))
+	config.UseTabs = useTabs
+	return config.Serialize(expr)
 }
 
 func Minify(expr Expression) (string, error) {
-	config := SerializerConfig{minify: true}
-	return expr.Serialize(&config, 0)
+	return NewSerializerConfig(Minified()).Serialize(expr)
+}
+
+func SerializeWith(expr Expression, options ...SerializerOption) (string, error) {
+	return NewSerializerConfig(options...).Serialize(expr)
 }
@@ -0,0 +1,622 @@
+package flim
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/l-donovan/flim/common"
+	flimexpr "github.com/l-donovan/flim/expressions"
+	"sort"
+	"strings"
+)
+
+type ChangeKind int
+
+const (
+	Added ChangeKind = iota
+	Removed
+	Changed
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	default:
+		return "changed"
+	}
+}
+
+// Change is a single difference between two documents. Old is nil for Added
+// changes and New is nil for Removed changes.
+//
+// List indices in Path are those the list has when the change is made, with
+// earlier changes already made, so applying the changes in order turns the
+// old document into the new one.
+type Change struct {
+	Kind ChangeKind
+	Path Path
+	Old  common.Expression
+	New  common.Expression
+}
+
+// String describes the change on a single line, e.g.
+// `~ inventory.port: 8000 -> 8001`.
+func (c Change) String() string {
+	switch c.Kind {
+	case Added:
+		return fmt.Sprintf("+ %s: %s", c.Path, inlineExpression(c.New))
+	case Removed:
+		return fmt.Sprintf("- %s: %s", c.Path, inlineExpression(c.Old))
+	default:
+		return fmt.Sprintf("~ %s: %s -> %s", c.Path, inlineExpression(c.Old), inlineExpression(c.New))
+	}
+}
+
+func inlineExpression(expr common.Expression) string {
+	text, err := expr.Serialize(common.NewSerializerConfig(common.Minified()), 0)
+
+	if err != nil {
+		return expr.ToString()
+	}
+
+	return text
+}
+
+type diffConfig struct {
+	evaluate   bool
+	handlers   map[string]common.HandlerFunc
+	evaluation []common.EvaluatorOption
+}
+
+type DiffOption func(*diffConfig)
+
+// CompareValues makes Diff evaluate both documents with handlers and compare
+// the results instead of the documents themselves.
+func CompareValues(handlers map[string]common.HandlerFunc, options ...common.EvaluatorOption) DiffOption {
+	return func(c *diffConfig) {
+		c.evaluate = true
+		c.handlers = handlers
+		c.evaluation = options
+	}
+}
+
+// Diff reports the differences between two documents by path. Documents are
+// compared by their canonical forms, so formatting, key order and the way
+// literals are written are ignored, and references must have been resolved.
+// A transformer with the same name on both sides is looked through, so
+// changes inside the map or list it is given are reported at their own paths.
+// A change to anything else under a transformer, or to anything under a
+// reference, is reported as a change to the whole transformer or reference,
+// so that every change can be made by a patch.
+func Diff(a, b common.Expression, options ...DiffOption) ([]Change, error) {
+	config := &diffConfig{}
+
+	for _, option := range options {
+		option(config)
+	}
+
+	if config.evaluate {
+		return diffValues(a, b, config)
+	}
+
+	nodeA, err := newDiffNode(documentValue(a))
+
+	if err != nil {
+		return nil, err
+	}
+
+	nodeB, err := newDiffNode(documentValue(b))
+
+	if err != nil {
+		return nil, err
+	}
+
+	differ := &differ{}
+	differ.diff(nodeA, nodeB, nil)
+	return differ.changes, nil
+}
+
+// DiffValues reports the differences between two evaluated values.
+func DiffValues(a, b interface{}) ([]Change, error) {
+	exprA, err := flimexpr.NewExpressionFromValue(a)
+
+	if err != nil {
+		return nil, err
+	}
+
+	exprB, err := flimexpr.NewExpressionFromValue(b)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return Diff(exprA, exprB)
+}
+
+func diffValues(a, b common.Expression, config *diffConfig) ([]Change, error) {
+	valA, err := common.Evaluate(a, config.handlers, config.evaluation...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	valB, err := common.Evaluate(b, config.handlers, config.evaluation...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return DiffValues(valA, valB)
+}
+
+// documentValue returns the expression that gives a file its value.
+func documentValue(expr common.Expression) common.Expression {
+	if fileExpr, ok := expr.(flimexpr.FileExpression); ok {
+		if exprs := fileExpr.Expressions(); len(exprs) > 0 {
+			return exprs[len(exprs)-1]
+		}
+	}
+
+	return expr
+}
+
+type differ struct {
+	changes []Change
+}
+
+func (d *differ) add(kind ChangeKind, path Path, old, new common.Expression) {
+	d.changes = append(d.changes, Change{Kind: kind, Path: path, Old: old, New: new})
+}
+
+// diffTarget looks through tags and resolved references.
+func diffTarget(expr common.Expression) common.Expression {
+	for {
+		switch e := expr.(type) {
+		case flimexpr.TaggedExpression:
+			expr = e.Expression()
+		case flimexpr.ReferenceExpression:
+			target, resolved := e.Target()
+
+			if !resolved {
+				return expr
+			}
+
+			expr = target
+		default:
+			return expr
+		}
+	}
+}
+
+// diffNode is an expression along with a hash of its canonical form. Maps,
+// lists and transformers that can be looked into also hold a node for each of
+// their entries, items or inputs, and are hashed from the hashes of those, so
+// that each part of a document is only hashed once however deep it is.
+type diffNode struct {
+	expr common.Expression
+	hash string
+
+	// entries is set for maps whose keys are known, and items for lists
+	// whose items are
+	entries *diffEntries
+	items   []*diffNode
+
+	// transformer is the name of a transformer, with @ or @@ before the names
+	// of mapped transformers, and input the node of what it is given
+	transformer string
+	input       *diffNode
+
+	// reference is set when the expression is a reference, which a patch
+	// cannot edit through
+	reference bool
+}
+
+// diffEntries is the nodes of a map's entries, in the order of mapEntries.
+type diffEntries struct {
+	keys   []string
+	values map[string]*diffNode
+}
+
+func newDiffNode(expr common.Expression) (*diffNode, error) {
+	node := &diffNode{expr: expr, reference: isReference(expr)}
+
+	switch target := diffTarget(expr).(type) {
+	case flimexpr.MapExpression:
+		entries, static := effectiveEntries(target)
+
+		if !static {
+			break
+		}
+
+		node.entries = &diffEntries{keys: entries.keys, values: map[string]*diffNode{}}
+		keys := append([]string{}, entries.keys...)
+		sort.Strings(keys)
+		parts := []string{"map"}
+
+		for _, key := range keys {
+			entry, err := newDiffNode(entries.values[key])
+
+			if err != nil {
+				return nil, err
+			}
+
+			node.entries.values[key] = entry
+			parts = append(parts, key, entry.hash)
+		}
+
+		node.hash = hashParts(parts)
+		return node, nil
+	case flimexpr.ListExpression:
+		listItems, static := effectiveItems(target)
+
+		if !static {
+			break
+		}
+
+		node.items = make([]*diffNode, len(listItems))
+		parts := []string{"list"}
+
+		for i, listItem := range listItems {
+			item, err := newDiffNode(listItem)
+
+			if err != nil {
+				return nil, err
+			}
+
+			node.items[i] = item
+			parts = append(parts, item.hash)
+		}
+
+		node.hash = hashParts(parts)
+		return node, nil
+	case flimexpr.TransformerExpression:
+		node.transformer = target.Name()
+		return node.withInput(target.Expression())
+	case flimexpr.MappedTransformerExpression:
+		node.transformer = "@" + target.Name()
+
+		if target.WithKeys() {
+			node.transformer = "@" + node.transformer
+		}
+
+		return node.withInput(target.Expression())
+	}
+
+	hash, err := Hash(expr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	node.hash = hash
+	return node, nil
+}
+
+// isReference reports whether expr is a reference, possibly tagged.
+func isReference(expr common.Expression) bool {
+	for {
+		switch e := expr.(type) {
+		case flimexpr.TaggedExpression:
+			expr = e.Expression()
+		case flimexpr.ReferenceExpression:
+			return true
+		default:
+			return false
+		}
+	}
+}
+
+// withInput sets the input of a transformer's node, and hashes it.
+func (node *diffNode) withInput(expr common.Expression) (*diffNode, error) {
+	input, err := newDiffNode(expr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	node.input = input
+	node.hash = hashParts([]string{"transformer", node.transformer, input.hash})
+	return node, nil
+}
+
+// hashParts returns the hex-encoded SHA-256 of parts, each written with its
+// length so that different parts never hash the same.
+func hashParts(parts []string) string {
+	h := sha256.New()
+
+	for _, part := range parts {
+		fmt.Fprintf(h, "%d:%s", len(part), part)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (d *differ) diff(a, b *diffNode, path Path) {
+	switch {
+	case a.hash == b.hash:
+		return
+	case !descends(a, b):
+		d.add(Changed, path, a.expr, b.expr)
+	case a.entries != nil:
+		d.diffMaps(a.entries, b.entries, path)
+	case a.items != nil:
+		d.diffLists(a.items, b.items, path)
+	default:
+		d.diff(a.input, b.input, path)
+	}
+}
+
+// descends reports whether the differences between a and b can be reported
+// as changes to their entries or items, rather than to the whole value.
+func descends(a, b *diffNode) bool {
+	switch {
+	case a.reference || b.reference:
+		return false
+	case a.entries != nil && b.entries != nil, a.items != nil && b.items != nil:
+		return true
+	case a.input != nil && b.input != nil && a.transformer == b.transformer:
+		return descends(a.input, b.input)
+	default:
+		return false
+	}
+}
+
+// mapEntries is a map's pairs after expansion, with overridden keys dropped.
+type mapEntries struct {
+	keys   []string
+	values map[string]common.Expression
+}
+
+// effectiveEntries merges expansions of literal maps into a map, returning
+// false if it expands anything else, whose keys cannot be known.
+func effectiveEntries(e flimexpr.MapExpression) (mapEntries, bool) {
+	entries := mapEntries{values: map[string]common.Expression{}}
+
+	for _, pairExpr := range e.Pairs() {
+		switch pair := pairExpr.(type) {
+		case flimexpr.PairExpression:
+			if _, exists := entries.values[pair.Key()]; !exists {
+				entries.keys = append(entries.keys, pair.Key())
+			}
+
+			entries.values[pair.Key()] = pair.Value()
+		case flimexpr.ExpandingExpression:
+			expandedMap, ok := diffTarget(pair.Expression()).(flimexpr.MapExpression)
+
+			if !ok {
+				return entries, false
+			}
+
+			expanded, ok := effectiveEntries(expandedMap)
+
+			if !ok {
+				return entries, false
+			}
+
+			for _, key := range expanded.keys {
+				if _, exists := entries.values[key]; !exists {
+					entries.keys = append(entries.keys, key)
+				}
+
+				entries.values[key] = expanded.values[key]
+			}
+		default:
+			return entries, false
+		}
+	}
+
+	return entries, true
+}
+
+// effectiveItems splices expansions of literal lists into a list, returning
+// false if it expands anything else.
+func effectiveItems(e flimexpr.ListExpression) ([]common.Expression, bool) {
+	listItems := []common.Expression{}
+
+	for _, listItem := range e.Items() {
+		expansion, ok := listItem.(flimexpr.ExpandingExpression)
+
+		if !ok {
+			listItems = append(listItems, listItem)
+			continue
+		}
+
+		expandedList, ok := diffTarget(expansion.Expression()).(flimexpr.ListExpression)
+
+		if !ok {
+			return nil, false
+		}
+
+		expandedItems, ok := effectiveItems(expandedList)
+
+		if !ok {
+			return nil, false
+		}
+
+		listItems = append(listItems, expandedItems...)
+	}
+
+	return listItems, true
+}
+
+// diffMaps reports removed keys first, then changed and added keys in the
+// order of the new map.
+func (d *differ) diffMaps(a, b *diffEntries, path Path) {
+	for _, key := range a.keys {
+		if _, exists := b.values[key]; !exists {
+			d.add(Removed, path.Child(key), a.values[key].expr, nil)
+		}
+	}
+
+	for _, key := range b.keys {
+		oldVal, exists := a.values[key]
+
+		if !exists {
+			d.add(Added, path.Child(key), nil, b.values[key].expr)
+			continue
+		}
+
+		d.diff(oldVal, b.values[key], path.Child(key))
+	}
+}
+
+// diffLists aligns the two lists on their longest common subsequence, so
+// that an inserted or removed item is reported once rather than as a change
+// to every item after it. Removals and insertions at the same place are
+// paired up and compared as changes.
+func (d *differ) diffLists(a, b []*diffNode, path Path) {
+	// lengths[i][j] is the length of the longest common subsequence of
+	// a[i:] and b[j:]
+	lengths := make([][]int, len(a)+1)
+
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i].hash == b[j].hash {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else {
+				lengths[i][j] = max(lengths[i+1][j], lengths[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+
+	for i < len(a) || j < len(b) {
+		if i < len(a) && j < len(b) && a[i].hash == b[j].hash {
+			i, j = i+1, j+1
+			continue
+		}
+
+		// Collect the run of removals and insertions before the next match
+		removed, added := 0, 0
+
+		for i+removed < len(a) || j+added < len(b) {
+			ii, jj := i+removed, j+added
+
+			if ii < len(a) && jj < len(b) && a[ii].hash == b[jj].hash {
+				break
+			}
+
+			if jj == len(b) || ii < len(a) && lengths[ii+1][jj] >= lengths[ii][jj+1] {
+				removed++
+			} else {
+				added++
+			}
+		}
+
+		paired := min(removed, added)
+
+		for k := 0; k < paired; k++ {
+			d.diff(a[i+k], b[j+k], path.Child(j+k))
+		}
+
+		// The index stays put while removing, since later items move up
+		for k := paired; k < removed; k++ {
+			d.add(Removed, path.Child(j+paired), a[i+k].expr, nil)
+		}
+
+		for k := paired; k < added; k++ {
+			d.add(Added, path.Child(j+k), nil, b[j+k].expr)
+		}
+
+		i, j = i+removed, j+added
+	}
+}
+
+// FormatChanges writes one line per change, as Change.String does.
+func FormatChanges(changes []Change) string {
+	var builder strings.Builder
+
+	for _, change := range changes {
+		builder.WriteString(change.String())
+		builder.WriteByte('\n')
+	}
+
+	return builder.String()
+}
+
+// PatchFromChanges writes changes as a patch document: a list of operations
+// such as {op "replace" path "/inventory/port" value 8001}, with paths
+// written as JSON Pointers. References in the new values are replaced by
+// what they refer to, as they are when serialized, so that the patch does not
+// depend on the tags of the document the changes were found in.
+func PatchFromChanges(changes []Change) (common.Expression, error) {
+	operations := make([]common.Expression, len(changes))
+
+	for i, change := range changes {
+		op := map[ChangeKind]string{Added: "add", Removed: "remove", Changed: "replace"}[change.Kind]
+		pairs := []common.Expression{}
+		var val common.Expression
+
+		if change.New != nil {
+			var err error
+
+			if val, err = inlineReferences(change.New); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, field := range []struct {
+			key string
+			val common.Expression
+		}{
+			{"op", stringExpression(op)},
+			{"path", stringExpression(change.Path.Pointer())},
+			{"value", val},
+		} {
+			if field.val == nil {
+				continue
+			}
+
+			pair, err := flimexpr.NewPairExpression(field.key, field.val)
+
+			if err != nil {
+				return nil, err
+			}
+
+			pairs = append(pairs, pair)
+		}
+
+		operation, err := flimexpr.NewMapExpression(pairs)
+
+		if err != nil {
+			return nil, err
+		}
+
+		operations[i] = operation
+	}
+
+	list, err := flimexpr.NewListExpression(operations)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return flimexpr.NewFileExpression([]common.Expression{list})
+}
+
+// inlineReferences replaces the resolved references in expr with what they
+// refer to.
+func inlineReferences(expr common.Expression) (common.Expression, error) {
+	return Rewrite(expr, func(node *Node) (common.Expression, error) {
+		if ref, ok := node.Expression.(flimexpr.ReferenceExpression); ok {
+			if target, resolved := ref.Target(); resolved {
+				return inlineReferences(target)
+			}
+		}
+
+		return node.Expression, nil
+	})
+}
+
+func stringExpression(val string) common.Expression {
+	expr, _ := flimexpr.NewStringLiteralExpression(val)
+	return expr
+}
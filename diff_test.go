@@ -0,0 +1,125 @@
+package flim
+
+import (
+	"testing"
+)
+
+// A patch written from the changes between two documents turns the old one
+// into the new one, whether it is applied to the expression or the source.
+func TestDiffPatchRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		old  string
+		new  string
+	}{
+		{"scalar", `{ a 1 b "x" }`, `{ a 2 b "x" }`},
+		{"added and removed keys", `{ a 1 b 2 }`, `{ b 2 c 3 }`},
+		{"nested map", `{ a { b { c 1 d 2 } } }`, `{ a { b { c 1 d 3 } } }`},
+		{"list items", `{ l [ 1 2 3 4 ] }`, `{ l [ 0 1 3 4 5 ] }`},
+		{"transformer input", `{ a from "x" }`, `{ a from "y" }`},
+		{"transformer renamed", `{ a from "x" }`, `{ a to "x" }`},
+		{"map under a transformer", `{ a env { name "x" port 1 } }`, `{ a env { name "x" port 2 } }`},
+		{"transformer in a list", `{ l [ 1 up "q" ] }`, `{ l [ 1 up "r" 3 ] }`},
+		{"nested transformers", `{ a outer inner "x" }`, `{ a outer inner "y" }`},
+		{"mapped transformer", `{ a @up [ "x" "y" ] }`, `{ a @up [ "x" "z" ] }`},
+		{"reference", "#base { port 80 }\n{ a &base }", "#base { port 81 }\n{ a &base }"},
+		{"reference under a transformer", "#base { port 80 }\n{ a env &base }", "#base { port 81 }\n{ a env &base }"},
+		{"tagged value", `{ a #t { port 80 } b &t }`, `{ a #t { port 81 } b &t }`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			oldExpr, err := ParseString(test.old)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			newExpr, err := ParseString(test.new)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			changes, err := Diff(oldExpr, newExpr)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(changes) == 0 {
+				t.Fatal("no changes found")
+			}
+
+			patchExpr, err := PatchFromChanges(changes)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			patch, err := ParsePatch(patchExpr)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			patched, err := patch.Apply(oldExpr)
+
+			if err != nil {
+				t.Fatalf("applying %v: %s", changes, err)
+			}
+
+			if patched, err = ResolveReferences(patched); err != nil {
+				t.Fatal(err)
+			}
+
+			if remaining, err := Diff(patched, newExpr); err != nil || len(remaining) > 0 {
+				t.Errorf("patched document differs from the new one: %v %v", remaining, err)
+			}
+
+			source, err := patch.ApplyToSource(test.old)
+
+			if err != nil {
+				t.Fatalf("applying %v to source: %s", changes, err)
+			}
+
+			patched, err = ParseString(source)
+
+			if err != nil {
+				t.Fatalf("patched source `%s': %s", source, err)
+			}
+
+			if remaining, err := Diff(patched, newExpr); err != nil || len(remaining) > 0 {
+				t.Errorf("patched source `%s' differs from the new one: %v %v", source, remaining, err)
+			}
+		})
+	}
+}
+
+// Changes that a patch could not make are reported at the transformer or
+// reference that holds them.
+func TestDiffDoesNotLookThroughReferences(t *testing.T) {
+	oldExpr, err := ParseString("#base { port 80 }\n{ a &base b from \"x\" }")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newExpr, err := ParseString("#base { port 81 }\n{ a &base b from \"y\" }")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := Diff(oldExpr, newExpr)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "~ a: {port 80} -> {port 81}\n~ b: from \"x\" -> from \"y\"\n"
+
+	if got := FormatChanges(changes); got != want {
+		t.Errorf("got changes\n%s\nwant\n%s", got, want)
+	}
+}
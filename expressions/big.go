@@ -0,0 +1,131 @@
+package expressions
+
+import (
+	"github.com/l-donovan/flim/common"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+type BigIntegerLiteralExpression struct {
+	val  *big.Int
+	text string
+}
+
+func NewBigIntegerLiteralExpression(val *big.Int) (BigIntegerLiteralExpression, error) {
+	return BigIntegerLiteralExpression{val: new(big.Int).Set(val)}, nil
+}
+
+// NewBigIntegerLiteralExpressionWithText creates an arbitrary-precision
+// integer literal that is serialized as text.
+func NewBigIntegerLiteralExpressionWithText(val *big.Int, text string) (BigIntegerLiteralExpression, error) {
+	return BigIntegerLiteralExpression{val: new(big.Int).Set(val), text: text}, nil
+}
+
+func (e BigIntegerLiteralExpression) Value() *big.Int {
+	return new(big.Int).Set(e.val)
+}
+
+func (e BigIntegerLiteralExpression) Text() string {
+	return e.text
+}
+
+func (e BigIntegerLiteralExpression) ToString() string {
+	return fmt.Sprintf("BigIntegerLiteralExpression<%s>", e.val.String())
+}
+
+func (e BigIntegerLiteralExpression) GetTags() map[string]common.Expression {
+	return map[string]common.Expression{}
+}
+
+func (e BigIntegerLiteralExpression) ReplaceReferences(tags map[string]common.Expression) (common.Expression, error) {
+	return e, nil
+}
+
+func (e BigIntegerLiteralExpression) Evaluate(handlers map[string]common.HandlerFunc) (interface{}, error) {
+	return common.Evaluate(e, handlers)
+}
+
+func (e BigIntegerLiteralExpression) EvaluateWith(config *common.EvaluatorConfig) (interface{}, error) {
+	// Return a copy so that handlers cannot modify the literal
+	return new(big.Int).Set(e.val), nil
+}
+
+func (e BigIntegerLiteralExpression) PartialEvaluate(handlers map[string]common.HandlerFunc) (common.Expression, error) {
+	return common.PartialEvaluate(e, handlers)
+}
+
+func (e BigIntegerLiteralExpression) PartialEvaluateWith(config *common.EvaluatorConfig) (common.Expression, error) {
+	return e, nil
+}
+
+func (e BigIntegerLiteralExpression) Serialize(config *common.SerializerConfig, indentLevel int) (string, error) {
+	if e.text != "" {
+		return e.text, nil
+	}
+
+	return e.val.String(), nil
+}
+
+type BigFloatLiteralExpression struct {
+	val *big.Float
+}
+
+func NewBigFloatLiteralExpression(val *big.Float) (BigFloatLiteralExpression, error) {
+	return BigFloatLiteralExpression{val: new(big.Float).Copy(val)}, nil
+}
+
+func (e BigFloatLiteralExpression) Value() *big.Float {
+	return new(big.Float).Copy(e.val)
+}
+
+func (e BigFloatLiteralExpression) ToString() string {
+	return fmt.Sprintf("BigFloatLiteralExpression<%s>", formatBigFloat(e.val))
+}
+
+func (e BigFloatLiteralExpression) GetTags() map[string]common.Expression {
+	return map[string]common.Expression{}
+}
+
+func (e BigFloatLiteralExpression) ReplaceReferences(tags map[string]common.Expression) (common.Expression, error) {
+	return e, nil
+}
+
+func (e BigFloatLiteralExpression) Evaluate(handlers map[string]common.HandlerFunc) (interface{}, error) {
+	return common.Evaluate(e, handlers)
+}
+
+func (e BigFloatLiteralExpression) EvaluateWith(config *common.EvaluatorConfig) (interface{}, error) {
+	return new(big.Float).Copy(e.val), nil
+}
+
+func (e BigFloatLiteralExpression) PartialEvaluate(handlers map[string]common.HandlerFunc) (common.Expression, error) {
+	return common.PartialEvaluate(e, handlers)
+}
+
+func (e BigFloatLiteralExpression) PartialEvaluateWith(config *common.EvaluatorConfig) (common.Expression, error) {
+	return e, nil
+}
+
+func (e BigFloatLiteralExpression) Serialize(config *common.SerializerConfig, indentLevel int) (string, error) {
+	return formatBigFloat(e.val), nil
+}
+
+// formatBigFloat writes the shortest representation of val that parses back
+// to the same value at its precision, always distinguishable from an integer.
+func formatBigFloat(val *big.Float) string {
+	switch {
+	case val.IsInf() && val.Sign() > 0:
+		return ".inf"
+	case val.IsInf():
+		return "-.inf"
+	}
+
+	out := val.Text('g', -1)
+
+	if !strings.ContainsAny(out, ".e") {
+		out += ".0"
+	}
+
+	return out
+}
@@ -0,0 +1,58 @@
+package expressions
+
+import (
+	"github.com/l-donovan/flim/common"
+	"fmt"
+)
+
+// CustomLiteralExpression holds a value of an application-defined literal
+// kind, along with the function that writes it back out.
+type CustomLiteralExpression struct {
+	kind      string
+	val       interface{}
+	serialize func(interface{}) (string, error)
+}
+
+func NewCustomLiteralExpression(kind string, val interface{}, serialize func(interface{}) (string, error)) (CustomLiteralExpression, error) {
+	return CustomLiteralExpression{kind: kind, val: val, serialize: serialize}, nil
+}
+
+func (e CustomLiteralExpression) Kind() string {
+	return e.kind
+}
+
+func (e CustomLiteralExpression) Value() interface{} {
+	return e.val
+}
+
+func (e CustomLiteralExpression) ToString() string {
+	return fmt.Sprintf("CustomLiteralExpression<%s, %v>", e.kind, e.val)
+}
+
+func (e CustomLiteralExpression) GetTags() map[string]common.Expression {
+	return map[string]common.Expression{}
+}
+
+func (e CustomLiteralExpression) ReplaceReferences(tags map[string]common.Expression) (common.Expression, error) {
+	return e, nil
+}
+
+func (e CustomLiteralExpression) Evaluate(handlers map[string]common.HandlerFunc) (interface{}, error) {
+	return common.Evaluate(e, handlers)
+}
+
+func (e CustomLiteralExpression) EvaluateWith(config *common.EvaluatorConfig) (interface{}, error) {
+	return e.val, nil
+}
+
+func (e CustomLiteralExpression) PartialEvaluate(handlers map[string]common.HandlerFunc) (common.Expression, error) {
+	return common.PartialEvaluate(e, handlers)
+}
+
+func (e CustomLiteralExpression) PartialEvaluateWith(config *common.EvaluatorConfig) (common.Expression, error) {
+	return e, nil
+}
+
+func (e CustomLiteralExpression) Serialize(config *common.SerializerConfig, indentLevel int) (string, error) {
+	return e.serialize(e.val)
+}
@@ -0,0 +1,70 @@
+package expressions_test
+
+import (
+	"github.com/l-donovan/flim"
+	"github.com/l-donovan/flim/common"
+	flimexpr "github.com/l-donovan/flim/expressions"
+	"reflect"
+	"testing"
+)
+
+// Resolving the references of an edited copy of a document leaves the
+// original, which shares its nested expressions, as it was.
+func TestEditThenResolveLeavesOriginal(t *testing.T) {
+	original, err := flim.ParseString("#t 5\n{ x { y &t } }")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := common.SerializeWith(original)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exprs := original.(flimexpr.FileExpression).Expressions()
+	six, _ := flimexpr.NewIntegerLiteralExpression(6)
+	tagged, _ := flimexpr.NewTaggedExpression("t", six)
+	edited, _ := flimexpr.NewFileExpression([]common.Expression{tagged, exprs[1].(flimexpr.MapExpression).Set("z", six)})
+
+	resolved, err := flim.ResolveReferences(edited)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	val, err := common.Evaluate(resolved, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]interface{}{"x": map[string]interface{}{"y": int64(6)}, "z": int64(6)}
+
+	if !reflect.DeepEqual(val, want) {
+		t.Errorf("edited document evaluated to %v, want %v", val, want)
+	}
+
+	val, err = common.Evaluate(original, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want = map[string]interface{}{"x": map[string]interface{}{"y": int64(5)}}
+
+	if !reflect.DeepEqual(val, want) {
+		t.Errorf("original document evaluated to %v, want %v", val, want)
+	}
+
+	after, err := common.SerializeWith(original)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if after != before {
+		t.Errorf("original document serialized as `%s', was `%s'", after, before)
+	}
+}
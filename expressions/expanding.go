@@ -13,6 +13,17 @@ func NewExpandingExpression(expr common.Expression) (ExpandingExpression, error)
 	return ExpandingExpression{expr: expr}, nil
 }
 
+func (e ExpandingExpression) Expression() common.Expression {
+	return e.expr
+}
+
+// WithExpression returns a copy of the expansion with the expression it
+// expands replaced.
+func (e ExpandingExpression) WithExpression(expr common.Expression) ExpandingExpression {
+	e.expr = expr
+	return e
+}
+
 func (e ExpandingExpression) ToString() string {
 	return fmt.Sprintf("ExpandingExpression<%s>", e.expr.ToString())
 }
@@ -34,11 +45,31 @@ func (e ExpandingExpression) ReplaceReferences(tags map[string]common.Expression
 }
 
 func (e ExpandingExpression) Evaluate(handlers map[string]common.HandlerFunc) (interface{}, error) {
-	return e.expr.Evaluate(handlers)
+	return common.Evaluate(e, handlers)
+}
+
+func (e ExpandingExpression) EvaluateWith(config *common.EvaluatorConfig) (interface{}, error) {
+	return config.Evaluate(e.expr)
+}
+
+func (e ExpandingExpression) PartialEvaluate(handlers map[string]common.HandlerFunc) (common.Expression, error) {
+	return common.PartialEvaluate(e, handlers)
+}
+
+func (e ExpandingExpression) PartialEvaluateWith(config *common.EvaluatorConfig) (common.Expression, error) {
+	newExpr, err := config.PartialEvaluate(e.expr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	e.expr = newExpr
+
+	return e, nil
 }
 
 func (e ExpandingExpression) Serialize(config *common.SerializerConfig, indentLevel int) (string, error) {
-	exprStr, err := e.expr.Serialize(config, indentLevel)
+	exprStr, err := e.expr.Serialize(config.After("*"), indentLevel)
 
 	if err != nil {
 		return "", err
@@ -14,6 +14,12 @@ func NewFileExpression(expressions []common.Expression) (FileExpression, error)
 	return FileExpression{expressions: expressions}, nil
 }
 
+func (e FileExpression) Expressions() []common.Expression {
+	expressions := make([]common.Expression, len(e.expressions))
+	copy(expressions, e.expressions)
+	return expressions
+}
+
 func (e FileExpression) ToString() string {
 	expressionStrings := []string{}
 
@@ -39,6 +45,8 @@ func (e FileExpression) GetTags() map[string]common.Expression {
 }
 
 func (e FileExpression) ReplaceReferences(tags map[string]common.Expression) (common.Expression, error) {
+	newExprs := make([]common.Expression, len(e.expressions))
+
 	for i, expr := range e.expressions {
 		newExpr, err := expr.ReplaceReferences(tags)
 
@@ -46,21 +54,28 @@ func (e FileExpression) ReplaceReferences(tags map[string]common.Expression) (co
 			return nil, err
 		}
 
-		e.expressions[i] = newExpr
+		newExprs[i] = newExpr
 	}
 
+	e.expressions = newExprs
+
 	return e, nil
 }
 
 func (e FileExpression) Evaluate(handlers map[string]common.HandlerFunc) (interface{}, error) {
+	return common.Evaluate(e, handlers)
+}
+
+func (e FileExpression) EvaluateWith(config *common.EvaluatorConfig) (interface{}, error) {
 	lastListItemResult := interface{}(nil)
+	results, err := config.EvaluateAll(e.expressions)
 
-	for _, expr := range e.expressions {
-		listItemResult, err := expr.Evaluate(handlers)
+	if err != nil {
+		return nil, err
+	}
 
-		if err != nil {
-			return nil, err
-		}
+	for i, expr := range e.expressions {
+		listItemResult := results[i]
 
 		if _, ok := expr.(ExpandingExpression); ok {
 			listItemExpanded, ok := listItemResult.([]interface{})
@@ -80,11 +95,33 @@ func (e FileExpression) Evaluate(handlers map[string]common.HandlerFunc) (interf
 	return lastListItemResult, nil
 }
 
+func (e FileExpression) PartialEvaluate(handlers map[string]common.HandlerFunc) (common.Expression, error) {
+	return common.PartialEvaluate(e, handlers)
+}
+
+func (e FileExpression) PartialEvaluateWith(config *common.EvaluatorConfig) (common.Expression, error) {
+	newExprs := make([]common.Expression, len(e.expressions))
+
+	for i, expr := range e.expressions {
+		newExpr, err := config.PartialEvaluate(expr)
+
+		if err != nil {
+			return nil, err
+		}
+
+		newExprs[i] = newExpr
+	}
+
+	e.expressions = newExprs
+
+	return e, nil
+}
+
 func (e FileExpression) Serialize(config *common.SerializerConfig, indentLevel int) (string, error) {
 	exprStrs := make([]string, len(e.expressions))
 
 	for i, expr := range e.expressions {
-		exprStr, err := expr.Serialize(config, indentLevel+1)
+		exprStr, err := expr.Serialize(config.LineStart(), indentLevel+1)
 
 		if err != nil {
 			return "", err
@@ -93,5 +130,5 @@ func (e FileExpression) Serialize(config *common.SerializerConfig, indentLevel i
 		exprStrs[i] = config.Indent(indentLevel) + exprStr
 	}
 
-	return strings.Join(exprStrs, config.Sep("\n\n", " ")), nil
+	return strings.Join(exprStrs, config.Sep(strings.Repeat("\n", config.BlankLines+1), " ")), nil
 }
@@ -14,6 +14,65 @@ func NewListExpression(listItems []common.Expression) (ListExpression, error) {
 	return ListExpression{listItems: listItems}, nil
 }
 
+func (e ListExpression) Items() []common.Expression {
+	listItems := make([]common.Expression, len(e.listItems))
+	copy(listItems, e.listItems)
+	return listItems
+}
+
+func (e ListExpression) checkIndex(index int, limit int) error {
+	if index < 0 || index > limit {
+		return fmt.Errorf("list index %d is out of range", index)
+	}
+
+	return nil
+}
+
+// Append returns a copy of the list with listItems added at the end.
+func (e ListExpression) Append(listItems ...common.Expression) ListExpression {
+	return ListExpression{listItems: append(e.Items(), listItems...)}
+}
+
+// Insert returns a copy of the list with listItem inserted before the item at
+// index. An index equal to the length of the list appends.
+func (e ListExpression) Insert(index int, listItem common.Expression) (ListExpression, error) {
+	if err := e.checkIndex(index, len(e.listItems)); err != nil {
+		return e, err
+	}
+
+	listItems := make([]common.Expression, 0, len(e.listItems)+1)
+	listItems = append(listItems, e.listItems[:index]...)
+	listItems = append(listItems, listItem)
+	listItems = append(listItems, e.listItems[index:]...)
+
+	return ListExpression{listItems: listItems}, nil
+}
+
+// Replace returns a copy of the list with the item at index replaced.
+func (e ListExpression) Replace(index int, listItem common.Expression) (ListExpression, error) {
+	if err := e.checkIndex(index, len(e.listItems)-1); err != nil {
+		return e, err
+	}
+
+	listItems := e.Items()
+	listItems[index] = listItem
+
+	return ListExpression{listItems: listItems}, nil
+}
+
+// Remove returns a copy of the list without the item at index.
+func (e ListExpression) Remove(index int) (ListExpression, error) {
+	if err := e.checkIndex(index, len(e.listItems)-1); err != nil {
+		return e, err
+	}
+
+	listItems := make([]common.Expression, 0, len(e.listItems)-1)
+	listItems = append(listItems, e.listItems[:index]...)
+	listItems = append(listItems, e.listItems[index+1:]...)
+
+	return ListExpression{listItems: listItems}, nil
+}
+
 func (e ListExpression) ToString() string {
 	listItemStrings := []string{}
 
@@ -39,6 +98,8 @@ func (e ListExpression) GetTags() map[string]common.Expression {
 }
 
 func (e ListExpression) ReplaceReferences(tags map[string]common.Expression) (common.Expression, error) {
+	newExprs := make([]common.Expression, len(e.listItems))
+
 	for i, listItem := range e.listItems {
 		newExpr, err := listItem.ReplaceReferences(tags)
 
@@ -46,21 +107,28 @@ func (e ListExpression) ReplaceReferences(tags map[string]common.Expression) (co
 			return nil, err
 		}
 
-		e.listItems[i] = newExpr
+		newExprs[i] = newExpr
 	}
 
+	e.listItems = newExprs
+
 	return e, nil
 }
 
 func (e ListExpression) Evaluate(handlers map[string]common.HandlerFunc) (interface{}, error) {
+	return common.Evaluate(e, handlers)
+}
+
+func (e ListExpression) EvaluateWith(config *common.EvaluatorConfig) (interface{}, error) {
 	listItemResults := []interface{}{}
+	results, err := config.EvaluateAll(e.listItems)
 
-	for _, listItem := range e.listItems {
-		listItemResult, err := listItem.Evaluate(handlers)
+	if err != nil {
+		return nil, err
+	}
 
-		if err != nil {
-			return nil, err
-		}
+	for i, listItem := range e.listItems {
+		listItemResult := results[i]
 
 		if _, ok := listItem.(ExpandingExpression); ok {
 			listItemExpanded, ok := listItemResult.([]interface{})
@@ -78,11 +146,45 @@ func (e ListExpression) Evaluate(handlers map[string]common.HandlerFunc) (interf
 	return listItemResults, nil
 }
 
+func (e ListExpression) PartialEvaluate(handlers map[string]common.HandlerFunc) (common.Expression, error) {
+	return common.PartialEvaluate(e, handlers)
+}
+
+func (e ListExpression) PartialEvaluateWith(config *common.EvaluatorConfig) (common.Expression, error) {
+	newExprs := make([]common.Expression, len(e.listItems))
+
+	for i, expr := range e.listItems {
+		newExpr, err := config.PartialEvaluate(expr)
+
+		if err != nil {
+			return nil, err
+		}
+
+		newExprs[i] = newExpr
+	}
+
+	e.listItems = newExprs
+
+	return e, nil
+}
+
 func (e ListExpression) Serialize(config *common.SerializerConfig, indentLevel int) (string, error) {
+	if len(e.listItems) > 0 && config.MaxLineWidth > 0 && !config.Minify {
+		inline, err := e.Serialize(config.Inline(), indentLevel)
+
+		if err != nil {
+			return "", err
+		}
+
+		if config.Fits(inline, config.Column(indentLevel)) {
+			return inline, nil
+		}
+	}
+
 	exprStrs := make([]string, len(e.listItems))
 
 	for i, listItem := range e.listItems {
-		listItemStr, err := listItem.Serialize(config, indentLevel+1)
+		listItemStr, err := listItem.Serialize(config.LineStart(), indentLevel+1)
 
 		if err != nil {
 			return "", err
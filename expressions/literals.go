@@ -3,17 +3,39 @@ package expressions
 import (
 	"github.com/l-donovan/flim/common"
 	"fmt"
+	"math"
+	"strconv"
 	"strings"
 )
 
 type IntegerLiteralExpression struct {
 	val int64
+
+	// text is the literal as written, e.g. 0x1F or 1_000, if it came from
+	// source rather than from a value
+	text string
 }
 
 func NewIntegerLiteralExpression(val int64) (IntegerLiteralExpression, error) {
 	return IntegerLiteralExpression{val: val}, nil
 }
 
+// NewIntegerLiteralExpressionWithText creates an integer literal that is
+// serialized as text, preserving its base and digit separators.
+func NewIntegerLiteralExpressionWithText(val int64, text string) (IntegerLiteralExpression, error) {
+	return IntegerLiteralExpression{val: val, text: text}, nil
+}
+
+func (e IntegerLiteralExpression) Value() int64 {
+	return e.val
+}
+
+// Text returns the literal as it was written, or an empty string if it was
+// not created from source.
+func (e IntegerLiteralExpression) Text() string {
+	return e.text
+}
+
 func (e IntegerLiteralExpression) ToString() string {
 	return fmt.Sprintf("IntegerLiteralExpression<%d>", e.val)
 }
@@ -27,13 +49,50 @@ func (e IntegerLiteralExpression) ReplaceReferences(tags map[string]common.Expre
 }
 
 func (e IntegerLiteralExpression) Evaluate(handlers map[string]common.HandlerFunc) (interface{}, error) {
+	return common.Evaluate(e, handlers)
+}
+
+func (e IntegerLiteralExpression) EvaluateWith(config *common.EvaluatorConfig) (interface{}, error) {
 	return e.val, nil
 }
 
+func (e IntegerLiteralExpression) PartialEvaluate(handlers map[string]common.HandlerFunc) (common.Expression, error) {
+	return common.PartialEvaluate(e, handlers)
+}
+
+func (e IntegerLiteralExpression) PartialEvaluateWith(config *common.EvaluatorConfig) (common.Expression, error) {
+	return e, nil
+}
+
 func (e IntegerLiteralExpression) Serialize(config *common.SerializerConfig, indentLevel int) (string, error) {
+	if e.text != "" {
+		return e.text, nil
+	}
+
 	return fmt.Sprintf("%d", e.val), nil
 }
 
+// formatFloat writes the shortest representation of val that parses back to
+// exactly the same float, always distinguishable from an integer.
+func formatFloat(val float64) string {
+	switch {
+	case math.IsNaN(val):
+		return ".nan"
+	case math.IsInf(val, 1):
+		return ".inf"
+	case math.IsInf(val, -1):
+		return "-.inf"
+	}
+
+	out := strconv.FormatFloat(val, 'g', -1, 64)
+
+	if !strings.ContainsAny(out, ".e") {
+		out += ".0"
+	}
+
+	return out
+}
+
 type FloatLiteralExpression struct {
 	val float64
 }
@@ -42,8 +101,12 @@ func NewFloatLiteralExpression(val float64) (FloatLiteralExpression, error) {
 	return FloatLiteralExpression{val: val}, nil
 }
 
+func (e FloatLiteralExpression) Value() float64 {
+	return e.val
+}
+
 func (e FloatLiteralExpression) ToString() string {
-	return fmt.Sprintf("FloatLiteralExpression<%f>", e.val)
+	return fmt.Sprintf("FloatLiteralExpression<%s>", formatFloat(e.val))
 }
 
 func (e FloatLiteralExpression) GetTags() map[string]common.Expression {
@@ -55,11 +118,23 @@ func (e FloatLiteralExpression) ReplaceReferences(tags map[string]common.Express
 }
 
 func (e FloatLiteralExpression) Evaluate(handlers map[string]common.HandlerFunc) (interface{}, error) {
+	return common.Evaluate(e, handlers)
+}
+
+func (e FloatLiteralExpression) EvaluateWith(config *common.EvaluatorConfig) (interface{}, error) {
 	return e.val, nil
 }
 
+func (e FloatLiteralExpression) PartialEvaluate(handlers map[string]common.HandlerFunc) (common.Expression, error) {
+	return common.PartialEvaluate(e, handlers)
+}
+
+func (e FloatLiteralExpression) PartialEvaluateWith(config *common.EvaluatorConfig) (common.Expression, error) {
+	return e, nil
+}
+
 func (e FloatLiteralExpression) Serialize(config *common.SerializerConfig, indentLevel int) (string, error) {
-	return fmt.Sprintf("%f", e.val), nil
+	return formatFloat(e.val), nil
 }
 
 type BooleanLiteralExpression struct {
@@ -70,6 +145,10 @@ func NewBooleanLiteralExpression(val bool) (BooleanLiteralExpression, error) {
 	return BooleanLiteralExpression{val: val}, nil
 }
 
+func (e BooleanLiteralExpression) Value() bool {
+	return e.val
+}
+
 func (e BooleanLiteralExpression) ToString() string {
 	return fmt.Sprintf("BooleanLiteralExpression<%t>", e.val)
 }
@@ -83,9 +162,21 @@ func (e BooleanLiteralExpression) GetTags() map[string]common.Expression {
 }
 
 func (e BooleanLiteralExpression) Evaluate(handlers map[string]common.HandlerFunc) (interface{}, error) {
+	return common.Evaluate(e, handlers)
+}
+
+func (e BooleanLiteralExpression) EvaluateWith(config *common.EvaluatorConfig) (interface{}, error) {
 	return e.val, nil
 }
 
+func (e BooleanLiteralExpression) PartialEvaluate(handlers map[string]common.HandlerFunc) (common.Expression, error) {
+	return common.PartialEvaluate(e, handlers)
+}
+
+func (e BooleanLiteralExpression) PartialEvaluateWith(config *common.EvaluatorConfig) (common.Expression, error) {
+	return e, nil
+}
+
 func (e BooleanLiteralExpression) Serialize(config *common.SerializerConfig, indentLevel int) (string, error) {
 	return fmt.Sprintf("%t", e.val), nil
 }
@@ -98,6 +189,10 @@ func NewStringLiteralExpression(val string) (StringLiteralExpression, error) {
 	return StringLiteralExpression{val: val}, nil
 }
 
+func (e StringLiteralExpression) Value() string {
+	return e.val
+}
+
 func (e StringLiteralExpression) ToString() string {
 	return fmt.Sprintf("StringLiteralExpression<%s>", e.val)
 }
@@ -111,16 +206,23 @@ func (e StringLiteralExpression) GetTags() map[string]common.Expression {
 }
 
 func (e StringLiteralExpression) Evaluate(handlers map[string]common.HandlerFunc) (interface{}, error) {
+	return common.Evaluate(e, handlers)
+}
+
+func (e StringLiteralExpression) EvaluateWith(config *common.EvaluatorConfig) (interface{}, error) {
 	return e.val, nil
 }
 
+func (e StringLiteralExpression) PartialEvaluate(handlers map[string]common.HandlerFunc) (common.Expression, error) {
+	return common.PartialEvaluate(e, handlers)
+}
+
+func (e StringLiteralExpression) PartialEvaluateWith(config *common.EvaluatorConfig) (common.Expression, error) {
+	return e, nil
+}
+
 func (e StringLiteralExpression) Serialize(config *common.SerializerConfig, indentLevel int) (string, error) {
-	// TODO Escape more things
-	replacer := strings.NewReplacer(
-		"\n", "\\n",
-	)
-	escapedVal := replacer.Replace(e.val)
-	return fmt.Sprintf("\"%s\"", escapedVal), nil
+	return config.Quote(e.val), nil
 }
 
 type NullLiteralExpression struct{}
@@ -142,9 +244,21 @@ func (e NullLiteralExpression) GetTags() map[string]common.Expression {
 }
 
 func (e NullLiteralExpression) Evaluate(handlers map[string]common.HandlerFunc) (interface{}, error) {
+	return common.Evaluate(e, handlers)
+}
+
+func (e NullLiteralExpression) EvaluateWith(config *common.EvaluatorConfig) (interface{}, error) {
 	return nil, nil
 }
 
+func (e NullLiteralExpression) PartialEvaluate(handlers map[string]common.HandlerFunc) (common.Expression, error) {
+	return common.PartialEvaluate(e, handlers)
+}
+
+func (e NullLiteralExpression) PartialEvaluateWith(config *common.EvaluatorConfig) (common.Expression, error) {
+	return e, nil
+}
+
 func (e NullLiteralExpression) Serialize(config *common.SerializerConfig, indentLevel int) (string, error) {
 	return "null", nil
 }
@@ -3,6 +3,8 @@ package expressions
 import (
 	"github.com/l-donovan/flim/common"
 	"fmt"
+	"regexp"
+	"sort"
 	"strings"
 )
 
@@ -20,6 +22,20 @@ func NewPairExpression(key string, val common.Expression) (PairExpression, error
 	return PairExpression{key: key, val: val}, nil
 }
 
+func (e PairExpression) Key() string {
+	return e.key
+}
+
+func (e PairExpression) Value() common.Expression {
+	return e.val
+}
+
+// WithValue returns a copy of the pair with its value replaced.
+func (e PairExpression) WithValue(val common.Expression) PairExpression {
+	e.val = val
+	return e
+}
+
 func (e PairExpression) ToString() string {
 	return fmt.Sprintf("PairExpression<%s: %s>", e.key, e.val.ToString())
 }
@@ -41,7 +57,11 @@ func (e PairExpression) ReplaceReferences(tags map[string]common.Expression) (co
 }
 
 func (e PairExpression) Evaluate(handlers map[string]common.HandlerFunc) (interface{}, error) {
-	result, err := e.val.Evaluate(handlers)
+	return common.Evaluate(e, handlers)
+}
+
+func (e PairExpression) EvaluateWith(config *common.EvaluatorConfig) (interface{}, error) {
+	result, err := config.Evaluate(e.val)
 
 	if err != nil {
 		return nil, err
@@ -50,14 +70,49 @@ func (e PairExpression) Evaluate(handlers map[string]common.HandlerFunc) (interf
 	return Pair{Key: e.key, Val: result}, nil
 }
 
+func (e PairExpression) PartialEvaluate(handlers map[string]common.HandlerFunc) (common.Expression, error) {
+	return common.PartialEvaluate(e, handlers)
+}
+
+func (e PairExpression) PartialEvaluateWith(config *common.EvaluatorConfig) (common.Expression, error) {
+	newExpr, err := config.PartialEvaluate(e.val)
+
+	if err != nil {
+		return nil, err
+	}
+
+	e.val = newExpr
+
+	return e, nil
+}
+
 func (e PairExpression) Serialize(config *common.SerializerConfig, indentLevel int) (string, error) {
-	exprStr, err := e.val.Serialize(config, indentLevel)
+	prefix := SerializeKey(config, e.key) + " "
+	exprStr, err := e.val.Serialize(config.After(prefix), indentLevel)
 
 	if err != nil {
 		return "", err
 	}
 
-	return fmt.Sprintf("%s %s", e.key, exprStr), nil
+	return prefix + exprStr, nil
+}
+
+var keywordPattern = regexp.MustCompile(`^[A-Za-z_]\w*$`)
+
+// SerializeKey writes a map key as a bare keyword where possible, quoting keys
+// that would otherwise be read back as some other token.
+func SerializeKey(config *common.SerializerConfig, key string) string {
+	if !keywordPattern.MatchString(key) {
+		return config.Quote(key)
+	}
+
+	for _, literal := range []string{"true", "false", "null"} {
+		if key == literal {
+			return config.Quote(key)
+		}
+	}
+
+	return key
 }
 
 type MapExpression struct {
@@ -68,6 +123,73 @@ func NewMapExpression(pairs []common.Expression) (MapExpression, error) {
 	return MapExpression{pairs: pairs}, nil
 }
 
+// Pairs returns the map's PairExpressions and ExpandingExpressions in
+// document order.
+func (e MapExpression) Pairs() []common.Expression {
+	pairs := make([]common.Expression, len(e.pairs))
+	copy(pairs, e.pairs)
+	return pairs
+}
+
+// Keys returns the keys of the map's pairs in document order, each once.
+// Keys that only come from expansions are not included.
+func (e MapExpression) Keys() []string {
+	keys := []string{}
+	seen := map[string]bool{}
+
+	for _, pairExpr := range e.pairs {
+		if pair, ok := pairExpr.(PairExpression); ok && !seen[pair.key] {
+			keys = append(keys, pair.key)
+			seen[pair.key] = true
+		}
+	}
+
+	return keys
+}
+
+// Get returns the value of the last pair with the given key, which is the one
+// that wins when the map is evaluated. Expansions are not looked into.
+func (e MapExpression) Get(key string) (common.Expression, bool) {
+	for i := len(e.pairs) - 1; i >= 0; i-- {
+		if pair, ok := e.pairs[i].(PairExpression); ok && pair.key == key {
+			return pair.val, true
+		}
+	}
+
+	return nil, false
+}
+
+// Set returns a copy of the map with key set to val. The last pair with the
+// key is replaced in place, or a new pair is added at the end.
+func (e MapExpression) Set(key string, val common.Expression) MapExpression {
+	pairs := e.Pairs()
+
+	for i := len(pairs) - 1; i >= 0; i-- {
+		if pair, ok := pairs[i].(PairExpression); ok && pair.key == key {
+			pairs[i] = pair.WithValue(val)
+			return MapExpression{pairs: pairs}
+		}
+	}
+
+	return MapExpression{pairs: append(pairs, PairExpression{key: key, val: val})}
+}
+
+// Delete returns a copy of the map without any pairs with the given key. The
+// key can still be given a value by an expansion.
+func (e MapExpression) Delete(key string) MapExpression {
+	pairs := []common.Expression{}
+
+	for _, pairExpr := range e.pairs {
+		if pair, ok := pairExpr.(PairExpression); ok && pair.key == key {
+			continue
+		}
+
+		pairs = append(pairs, pairExpr)
+	}
+
+	return MapExpression{pairs: pairs}
+}
+
 func (e MapExpression) ToString() string {
 	pairStrings := []string{}
 
@@ -93,6 +215,8 @@ func (e MapExpression) GetTags() map[string]common.Expression {
 }
 
 func (e MapExpression) ReplaceReferences(tags map[string]common.Expression) (common.Expression, error) {
+	newExprs := make([]common.Expression, len(e.pairs))
+
 	for i, pairExpr := range e.pairs {
 		newExpr, err := pairExpr.ReplaceReferences(tags)
 
@@ -100,21 +224,28 @@ func (e MapExpression) ReplaceReferences(tags map[string]common.Expression) (com
 			return nil, err
 		}
 
-		e.pairs[i] = newExpr
+		newExprs[i] = newExpr
 	}
 
+	e.pairs = newExprs
+
 	return e, nil
 }
 
 func (e MapExpression) Evaluate(handlers map[string]common.HandlerFunc) (interface{}, error) {
+	return common.Evaluate(e, handlers)
+}
+
+func (e MapExpression) EvaluateWith(config *common.EvaluatorConfig) (interface{}, error) {
 	pairResults := map[string]interface{}{}
+	results, err := config.EvaluateAll(e.pairs)
 
-	for _, pairExpr := range e.pairs {
-		pairResult, err := pairExpr.Evaluate(handlers)
+	if err != nil {
+		return nil, err
+	}
 
-		if err != nil {
-			return nil, err
-		}
+	for i, pairExpr := range e.pairs {
+		pairResult := results[i]
 
 		if _, ok := pairExpr.(ExpandingExpression); ok {
 			pairExprExpanded, ok := pairResult.(map[string]interface{})
@@ -135,11 +266,77 @@ func (e MapExpression) Evaluate(handlers map[string]common.HandlerFunc) (interfa
 	return pairResults, nil
 }
 
+func (e MapExpression) PartialEvaluate(handlers map[string]common.HandlerFunc) (common.Expression, error) {
+	return common.PartialEvaluate(e, handlers)
+}
+
+func (e MapExpression) PartialEvaluateWith(config *common.EvaluatorConfig) (common.Expression, error) {
+	newExprs := make([]common.Expression, len(e.pairs))
+
+	for i, expr := range e.pairs {
+		newExpr, err := config.PartialEvaluate(expr)
+
+		if err != nil {
+			return nil, err
+		}
+
+		newExprs[i] = newExpr
+	}
+
+	e.pairs = newExprs
+
+	return e, nil
+}
+
+// sortedPairs orders each run of pairs between expansions by key, since
+// moving a pair across an expansion could change which value wins.
+func sortedPairs(pairs []common.Expression) []common.Expression {
+	sorted := make([]common.Expression, len(pairs))
+	copy(sorted, pairs)
+	start := 0
+
+	for i := 0; i <= len(sorted); i++ {
+		if i < len(sorted) {
+			if _, ok := sorted[i].(PairExpression); ok {
+				continue
+			}
+		}
+
+		run := sorted[start:i]
+
+		sort.SliceStable(run, func(a, b int) bool {
+			return run[a].(PairExpression).key < run[b].(PairExpression).key
+		})
+
+		start = i + 1
+	}
+
+	return sorted
+}
+
 func (e MapExpression) Serialize(config *common.SerializerConfig, indentLevel int) (string, error) {
-	exprStrs := make([]string, len(e.pairs))
+	pairs := e.pairs
+
+	if config.SortKeys {
+		pairs = sortedPairs(pairs)
+	}
+
+	if len(pairs) > 0 && config.MaxLineWidth > 0 && !config.Minify {
+		inline, err := MapExpression{pairs: pairs}.Serialize(config.Inline(), indentLevel)
+
+		if err != nil {
+			return "", err
+		}
+
+		if config.Fits(inline, config.Column(indentLevel)) {
+			return inline, nil
+		}
+	}
+
+	exprStrs := make([]string, len(pairs))
 
-	for i, pair := range e.pairs {
-		pairStr, err := pair.Serialize(config, indentLevel+1)
+	for i, pair := range pairs {
+		pairStr, err := pair.Serialize(config.LineStart(), indentLevel+1)
 
 		if err != nil {
 			return "", err
@@ -148,7 +345,7 @@ func (e MapExpression) Serialize(config *common.SerializerConfig, indentLevel in
 		exprStrs[i] = config.Indent(indentLevel) + pairStr
 	}
 
-	if len(e.pairs) == 0 {
+	if len(pairs) == 0 {
 		return "{}", nil
 	} else {
 		out := fmt.Sprintf(
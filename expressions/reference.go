@@ -7,13 +7,38 @@ import (
 
 type ReferenceExpression struct {
 	name string
+
+	// tags are the tagged expressions this reference was resolved with by
+	// ReplaceReferences, or nil if it has not been resolved yet. The target
+	// is looked up when it is needed, so the tags can refer to each other.
+	tags map[string]common.Expression
 }
 
 func NewReferenceExpression(name string) (ReferenceExpression, error) {
 	return ReferenceExpression{name: name}, nil
 }
 
+func (e ReferenceExpression) Name() string {
+	return e.name
+}
+
+// Target returns the expression the reference was resolved to by
+// ReplaceReferences, if it has been resolved.
+func (e ReferenceExpression) Target() (common.Expression, bool) {
+	target, resolved := e.tags[e.name]
+
+	if tagged, ok := target.(TaggedExpression); ok {
+		return tagged.expr, true
+	}
+
+	return target, resolved
+}
+
 func (e ReferenceExpression) ToString() string {
+	if target, resolved := e.Target(); resolved {
+		return target.ToString()
+	}
+
 	return fmt.Sprintf("ReferenceExpression<%s>", e.name)
 }
 
@@ -21,18 +46,58 @@ func (e ReferenceExpression) GetTags() map[string]common.Expression {
 	return map[string]common.Expression{}
 }
 
+// ReplaceReferences resolves the reference to the tagged expression in tags
+// with its name. tags is kept rather than copied, and should not be changed
+// afterwards except to resolve the references in its own expressions, as
+// ResolveReferences does.
 func (e ReferenceExpression) ReplaceReferences(tags map[string]common.Expression) (common.Expression, error) {
-	if replacement, exists := tags[e.name]; exists {
-		return replacement, nil
+	if _, exists := tags[e.name]; exists {
+		e.tags = tags
+		return e, nil
 	} else {
 		return nil, fmt.Errorf("could not find tag `%s'", e.name)
 	}
 }
 
 func (e ReferenceExpression) Evaluate(handlers map[string]common.HandlerFunc) (interface{}, error) {
+	return common.Evaluate(e, handlers)
+}
+
+func (e ReferenceExpression) EvaluateWith(config *common.EvaluatorConfig) (interface{}, error) {
+	if target, resolved := e.tags[e.name]; resolved {
+		config.ReferenceExpanded(e.name)
+
+		// Every use of a tag shares the result of evaluating it once
+		if tagged, ok := target.(TaggedExpression); ok {
+			return tagged.evaluateExpression(config)
+		}
+
+		return config.Evaluate(target)
+	}
+
 	return nil, fmt.Errorf("attempted to Evaluate a ReferenceExpression (hint: call ReplaceReferences first)")
 }
 
+func (e ReferenceExpression) PartialEvaluate(handlers map[string]common.HandlerFunc) (common.Expression, error) {
+	return common.PartialEvaluate(e, handlers)
+}
+
+func (e ReferenceExpression) PartialEvaluateWith(config *common.EvaluatorConfig) (common.Expression, error) {
+	if target, resolved := e.tags[e.name]; resolved {
+		if tagged, ok := target.(TaggedExpression); ok {
+			return tagged.partialEvaluateExpression(config)
+		}
+
+		return config.PartialEvaluate(target)
+	}
+
+	return e, nil
+}
+
 func (e ReferenceExpression) Serialize(config *common.SerializerConfig, indentLevel int) (string, error) {
+	if target, resolved := e.Target(); resolved {
+		return target.Serialize(config, indentLevel)
+	}
+
 	return fmt.Sprintf("&%s", e.name), nil
 }
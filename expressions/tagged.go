@@ -8,19 +8,55 @@ import (
 type TaggedExpression struct {
 	tag  string
 	expr common.Expression
+
+	// key identifies this tagged expression, and so its memoized result,
+	// apart from any other with the same tag
+	key *common.MemoKey
 }
 
 func NewTaggedExpression(tag string, expr common.Expression) (TaggedExpression, error) {
-	return TaggedExpression{tag: tag, expr: expr}, nil
+	return TaggedExpression{tag: tag, expr: expr, key: common.NewMemoKey(tag)}, nil
+}
+
+// AddTag tags expr. An expression that already has the same tag is returned
+// as it is, but one with a different tag cannot be tagged again.
+func AddTag(tag string, expr common.Expression) (TaggedExpression, error) {
+	if tagged, ok := expr.(TaggedExpression); ok {
+		if tagged.tag != tag {
+			return tagged, fmt.Errorf("expression is already tagged `%s'", tagged.tag)
+		}
+
+		return tagged, nil
+	}
+
+	return NewTaggedExpression(tag, expr)
+}
+
+func (e TaggedExpression) Tag() string {
+	return e.tag
+}
+
+func (e TaggedExpression) Expression() common.Expression {
+	return e.expr
+}
+
+// WithExpression returns a copy of the tagged expression with the expression
+// it tags replaced.
+func (e TaggedExpression) WithExpression(expr common.Expression) TaggedExpression {
+	e.expr = expr
+	e.key = common.NewMemoKey(e.tag)
+	return e
 }
 
 func (e TaggedExpression) ToString() string {
 	return fmt.Sprintf("TaggedExpression<#%s, %s>", e.tag, e.expr.ToString())
 }
 
+// GetTags returns the tagged expressions in e by their tags, including e
+// itself.
 func (e TaggedExpression) GetTags() map[string]common.Expression {
 	tags := e.expr.GetTags()
-	tags[e.tag] = e.expr
+	tags[e.tag] = e
 
 	return tags
 }
@@ -38,15 +74,61 @@ func (e TaggedExpression) ReplaceReferences(tags map[string]common.Expression) (
 }
 
 func (e TaggedExpression) Evaluate(handlers map[string]common.HandlerFunc) (interface{}, error) {
-	return e.expr.Evaluate(handlers)
+	return common.Evaluate(e, handlers)
+}
+
+func (e TaggedExpression) EvaluateWith(config *common.EvaluatorConfig) (interface{}, error) {
+	return e.evaluateExpression(config)
+}
+
+// evaluateExpression evaluates the expression e tags, sharing the result
+// with every other use of e.
+func (e TaggedExpression) evaluateExpression(config *common.EvaluatorConfig) (interface{}, error) {
+	if e.key == nil {
+		return config.Evaluate(e.expr)
+	}
+
+	return config.Memoize(e.key, func(config *common.EvaluatorConfig) (interface{}, error) {
+		return config.Evaluate(e.expr)
+	})
+}
+
+func (e TaggedExpression) PartialEvaluate(handlers map[string]common.HandlerFunc) (common.Expression, error) {
+	return common.PartialEvaluate(e, handlers)
+}
+
+func (e TaggedExpression) PartialEvaluateWith(config *common.EvaluatorConfig) (common.Expression, error) {
+	newExpr, err := e.partialEvaluateExpression(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return e.WithExpression(newExpr), nil
+}
+
+// partialEvaluateExpression partially evaluates the expression e tags.
+func (e TaggedExpression) partialEvaluateExpression(config *common.EvaluatorConfig) (common.Expression, error) {
+	if e.key != nil {
+		within, err := config.Within(e.key)
+
+		if err != nil {
+			return nil, err
+		}
+
+		config = within
+	}
+
+	return config.PartialEvaluate(e.expr)
 }
 
 func (e TaggedExpression) Serialize(config *common.SerializerConfig, indentLevel int) (string, error) {
-	exprStr, err := e.expr.Serialize(config, indentLevel)
+	prefix := fmt.Sprintf("#%s ", e.tag)
+	exprStr, err := e.expr.Serialize(config.After(prefix), indentLevel)
 
 	if err != nil {
 		return "", err
 	}
 
-	return fmt.Sprintf("#%s %s", e.tag, exprStr), nil
+	return prefix + exprStr, nil
 }
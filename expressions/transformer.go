@@ -3,6 +3,7 @@ package expressions
 import (
 	"github.com/l-donovan/flim/common"
 	"fmt"
+	"sort"
 )
 
 type TransformerExpression struct {
@@ -14,6 +15,37 @@ func NewTransformerExpression(name string, expr common.Expression) (TransformerE
 	return TransformerExpression{name: name, expr: expr}, nil
 }
 
+// WrapInTransformer passes expr through the named transformer. If expr is
+// tagged the transformer goes inside the tag, so that references to the tag
+// see the transformed value as they did the original.
+func WrapInTransformer(name string, expr common.Expression) (common.Expression, error) {
+	if tagged, ok := expr.(TaggedExpression); ok {
+		inner, err := WrapInTransformer(name, tagged.expr)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return tagged.WithExpression(inner), nil
+	}
+
+	return NewTransformerExpression(name, expr)
+}
+
+func (e TransformerExpression) Name() string {
+	return e.name
+}
+
+func (e TransformerExpression) Expression() common.Expression {
+	return e.expr
+}
+
+// WithExpression returns a copy of the transformer with its input replaced.
+func (e TransformerExpression) WithExpression(expr common.Expression) TransformerExpression {
+	e.expr = expr
+	return e
+}
+
 func (e TransformerExpression) ToString() string {
 	return fmt.Sprintf("TransformerExpression<%s, %s>", e.name, e.expr.ToString())
 }
@@ -35,19 +67,21 @@ func (e TransformerExpression) ReplaceReferences(tags map[string]common.Expressi
 }
 
 func (e TransformerExpression) Evaluate(handlers map[string]common.HandlerFunc) (interface{}, error) {
-	handler, exists := handlers[e.name]
+	return common.Evaluate(e, handlers)
+}
 
-	if !exists {
+func (e TransformerExpression) EvaluateWith(config *common.EvaluatorConfig) (interface{}, error) {
+	if _, exists := config.Handler(e.name); !exists {
 		return nil, fmt.Errorf("no handler for `%s'", e.name)
 	}
 
-	exprResult, err := e.expr.Evaluate(handlers)
+	exprResult, err := config.Evaluate(e.expr)
 
 	if err != nil {
 		return nil, err
 	}
 
-	handlerResult, err := handler(exprResult)
+	handlerResult, err := config.CallHandler(e.name, exprResult)
 
 	if err != nil {
 		return nil, err
@@ -56,18 +90,43 @@ func (e TransformerExpression) Evaluate(handlers map[string]common.HandlerFunc)
 	return handlerResult, nil
 }
 
+func (e TransformerExpression) PartialEvaluate(handlers map[string]common.HandlerFunc) (common.Expression, error) {
+	return common.PartialEvaluate(e, handlers)
+}
+
+func (e TransformerExpression) PartialEvaluateWith(config *common.EvaluatorConfig) (common.Expression, error) {
+	newExpr, err := config.PartialEvaluate(e.expr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	e.expr = newExpr
+
+	// Unknown and impure handlers, and known handlers whose input still
+	// depends on unknown ones, are left in place for a later evaluation to
+	// finish
+	if _, exists := config.Handler(e.name); !exists || config.IsImpure(e.name) || !isResolved(newExpr) {
+		return e, nil
+	}
+
+	return foldTransformer(e, config)
+}
+
 func (e TransformerExpression) Serialize(config *common.SerializerConfig, indentLevel int) (string, error) {
-	exprStr, err := e.expr.Serialize(config, indentLevel)
+	prefix := e.name + " "
+	exprStr, err := e.expr.Serialize(config.After(prefix), indentLevel)
 
 	if err != nil {
 		return "", err
 	}
 
-	return fmt.Sprintf("%s %s", e.name, exprStr), nil
+	return prefix + exprStr, nil
 }
 
 type MappedTransformerExpression struct {
 	transformer string
+	withKeys    bool
 	expr        common.Expression
 }
 
@@ -75,7 +134,36 @@ func NewMappedTransformerExpression(transformer string, expr common.Expression)
 	return MappedTransformerExpression{transformer: transformer, expr: expr}, nil
 }
 
+// NewKeyedMappedTransformerExpression creates a mapped transformer that passes
+// each map entry to its handler as a Pair rather than just the value.
+func NewKeyedMappedTransformerExpression(transformer string, expr common.Expression) (MappedTransformerExpression, error) {
+	return MappedTransformerExpression{transformer: transformer, withKeys: true, expr: expr}, nil
+}
+
+func (e MappedTransformerExpression) Name() string {
+	return e.transformer
+}
+
+// WithKeys reports whether each map entry is passed to the handler as a Pair.
+func (e MappedTransformerExpression) WithKeys() bool {
+	return e.withKeys
+}
+
+func (e MappedTransformerExpression) Expression() common.Expression {
+	return e.expr
+}
+
+// WithExpression returns a copy of the transformer with its input replaced.
+func (e MappedTransformerExpression) WithExpression(expr common.Expression) MappedTransformerExpression {
+	e.expr = expr
+	return e
+}
+
 func (e MappedTransformerExpression) ToString() string {
+	if e.withKeys {
+		return fmt.Sprintf("MappedTransformerExpression<@%s, %s>", e.transformer, e.expr.ToString())
+	}
+
 	return fmt.Sprintf("MappedTransformerExpression<%s, %s>", e.transformer, e.expr.ToString())
 }
 
@@ -96,29 +184,108 @@ func (e MappedTransformerExpression) ReplaceReferences(tags map[string]common.Ex
 }
 
 func (e MappedTransformerExpression) Evaluate(handlers map[string]common.HandlerFunc) (interface{}, error) {
-	listItemResults := []interface{}{}
-	listExpr := e.expr.(ListExpression)
+	return common.Evaluate(e, handlers)
+}
 
-	for _, expr := range listExpr.listItems {
-		transformedExpr := TransformerExpression{name: e.transformer, expr: expr}
-		transformedResult, err := transformedExpr.Evaluate(handlers)
+func (e MappedTransformerExpression) EvaluateWith(config *common.EvaluatorConfig) (interface{}, error) {
+	if _, exists := config.Handler(e.transformer); !exists {
+		return nil, fmt.Errorf("no handler for `%s'", e.transformer)
+	}
 
-		if err != nil {
-			return nil, err
+	// The input is evaluated as a whole so that references, transformers and
+	// expanded items all resolve before the handler is mapped over the result.
+	exprResult, err := config.Evaluate(e.expr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch input := exprResult.(type) {
+	case []interface{}:
+		if e.withKeys {
+			return nil, fmt.Errorf("cannot map `%s' with keys over a list", e.transformer)
+		}
+
+		listItemResults := make([]interface{}, len(input))
+
+		for i, listItem := range input {
+			transformedResult, err := config.CallHandler(e.transformer, listItem)
+
+			if err != nil {
+				return nil, err
+			}
+
+			listItemResults[i] = transformedResult
+		}
+
+		return listItemResults, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(input))
+
+		for key := range input {
+			keys = append(keys, key)
+		}
+
+		// Sort the keys so that handlers are called, and errors reported, in
+		// a deterministic order
+		sort.Strings(keys)
+
+		pairResults := make(map[string]interface{}, len(input))
+
+		for _, key := range keys {
+			var handlerInput interface{} = input[key]
+
+			if e.withKeys {
+				handlerInput = Pair{Key: key, Val: input[key]}
+			}
+
+			transformedResult, err := config.CallHandler(e.transformer, handlerInput)
+
+			if err != nil {
+				return nil, err
+			}
+
+			pairResults[key] = transformedResult
 		}
 
-		listItemResults = append(listItemResults, transformedResult)
+		return pairResults, nil
+	default:
+		return nil, fmt.Errorf("cannot map `%s' over value of type %T (expected a list or map)", e.transformer, exprResult)
+	}
+}
+
+func (e MappedTransformerExpression) PartialEvaluate(handlers map[string]common.HandlerFunc) (common.Expression, error) {
+	return common.PartialEvaluate(e, handlers)
+}
+
+func (e MappedTransformerExpression) PartialEvaluateWith(config *common.EvaluatorConfig) (common.Expression, error) {
+	newExpr, err := config.PartialEvaluate(e.expr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	e.expr = newExpr
+
+	if _, exists := config.Handler(e.transformer); !exists || config.IsImpure(e.transformer) || !isResolved(newExpr) {
+		return e, nil
 	}
 
-	return listItemResults, nil
+	return foldTransformer(e, config)
 }
 
 func (e MappedTransformerExpression) Serialize(config *common.SerializerConfig, indentLevel int) (string, error) {
-	exprStr, err := e.expr.Serialize(config, indentLevel)
+	prefix := fmt.Sprintf("@%s ", e.transformer)
+
+	if e.withKeys {
+		prefix = "@" + prefix
+	}
+
+	exprStr, err := e.expr.Serialize(config.After(prefix), indentLevel)
 
 	if err != nil {
 		return "", err
 	}
 
-	return fmt.Sprintf("@%s %s", e.transformer, exprStr), nil
+	return prefix + exprStr, nil
 }
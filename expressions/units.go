@@ -0,0 +1,224 @@
+package expressions
+
+import (
+	"github.com/l-donovan/flim/common"
+	"fmt"
+	"math/big"
+	"regexp"
+	"time"
+)
+
+type DurationLiteralExpression struct {
+	val time.Duration
+}
+
+func NewDurationLiteralExpression(val time.Duration) (DurationLiteralExpression, error) {
+	return DurationLiteralExpression{val: val}, nil
+}
+
+func (e DurationLiteralExpression) Value() time.Duration {
+	return e.val
+}
+
+func (e DurationLiteralExpression) ToString() string {
+	return fmt.Sprintf("DurationLiteralExpression<%s>", e.val)
+}
+
+func (e DurationLiteralExpression) GetTags() map[string]common.Expression {
+	return map[string]common.Expression{}
+}
+
+func (e DurationLiteralExpression) ReplaceReferences(tags map[string]common.Expression) (common.Expression, error) {
+	return e, nil
+}
+
+func (e DurationLiteralExpression) Evaluate(handlers map[string]common.HandlerFunc) (interface{}, error) {
+	return common.Evaluate(e, handlers)
+}
+
+func (e DurationLiteralExpression) EvaluateWith(config *common.EvaluatorConfig) (interface{}, error) {
+	return e.val, nil
+}
+
+func (e DurationLiteralExpression) PartialEvaluate(handlers map[string]common.HandlerFunc) (common.Expression, error) {
+	return common.PartialEvaluate(e, handlers)
+}
+
+func (e DurationLiteralExpression) PartialEvaluateWith(config *common.EvaluatorConfig) (common.Expression, error) {
+	return e, nil
+}
+
+func (e DurationLiteralExpression) Serialize(config *common.SerializerConfig, indentLevel int) (string, error) {
+	return e.val.String(), nil
+}
+
+type sizeUnit struct {
+	suffix string
+	bytes  int64
+}
+
+// sizeUnits lists every unit suffix accepted in size literals. Binary units
+// come first so that they are preferred when serializing.
+var sizeUnits = []sizeUnit{
+	{"EiB", 1 << 60},
+	{"PiB", 1 << 50},
+	{"TiB", 1 << 40},
+	{"GiB", 1 << 30},
+	{"MiB", 1 << 20},
+	{"KiB", 1 << 10},
+	{"EB", 1e18},
+	{"PB", 1e15},
+	{"TB", 1e12},
+	{"GB", 1e9},
+	{"MB", 1e6},
+	{"kB", 1e3},
+	{"KB", 1e3},
+	{"B", 1},
+}
+
+var sizePattern = regexp.MustCompile(`^(\d+(?:\.\d+)?)([A-Za-z]+)$`)
+
+// ParseSize parses a size literal such as 512MiB or 1.5GB into a byte count.
+func ParseSize(text string) (int64, error) {
+	match := sizePattern.FindStringSubmatch(text)
+
+	if match == nil {
+		return 0, fmt.Errorf("invalid size literal %s", text)
+	}
+
+	for _, unit := range sizeUnits {
+		if unit.suffix != match[2] {
+			continue
+		}
+
+		amount, ok := new(big.Rat).SetString(match[1])
+
+		if !ok {
+			return 0, fmt.Errorf("invalid size literal %s", text)
+		}
+
+		amount.Mul(amount, new(big.Rat).SetInt64(unit.bytes))
+
+		if !amount.IsInt() {
+			return 0, fmt.Errorf("size literal %s is not a whole number of bytes", text)
+		}
+
+		if !amount.Num().IsInt64() {
+			return 0, fmt.Errorf("size literal %s is out of range", text)
+		}
+
+		return amount.Num().Int64(), nil
+	}
+
+	return 0, fmt.Errorf("unknown size unit %s in %s", match[2], text)
+}
+
+// formatSize writes a byte count using the unit that gives the smallest whole
+// number, e.g. 1536 as 1536B but 1048576 as 1MiB.
+func formatSize(bytes int64) string {
+	best := sizeUnit{"B", 1}
+
+	if bytes == 0 {
+		return "0B"
+	}
+
+	for _, unit := range sizeUnits {
+		if bytes%unit.bytes == 0 && unit.bytes > best.bytes {
+			best = unit
+		}
+	}
+
+	return fmt.Sprintf("%d%s", bytes/best.bytes, best.suffix)
+}
+
+type SizeLiteralExpression struct {
+	val int64
+}
+
+func NewSizeLiteralExpression(val int64) (SizeLiteralExpression, error) {
+	if val < 0 {
+		return SizeLiteralExpression{}, fmt.Errorf("size cannot be negative")
+	}
+
+	return SizeLiteralExpression{val: val}, nil
+}
+
+func (e SizeLiteralExpression) Value() int64 {
+	return e.val
+}
+
+func (e SizeLiteralExpression) ToString() string {
+	return fmt.Sprintf("SizeLiteralExpression<%s>", formatSize(e.val))
+}
+
+func (e SizeLiteralExpression) GetTags() map[string]common.Expression {
+	return map[string]common.Expression{}
+}
+
+func (e SizeLiteralExpression) ReplaceReferences(tags map[string]common.Expression) (common.Expression, error) {
+	return e, nil
+}
+
+func (e SizeLiteralExpression) Evaluate(handlers map[string]common.HandlerFunc) (interface{}, error) {
+	return common.Evaluate(e, handlers)
+}
+
+func (e SizeLiteralExpression) EvaluateWith(config *common.EvaluatorConfig) (interface{}, error) {
+	return e.val, nil
+}
+
+func (e SizeLiteralExpression) PartialEvaluate(handlers map[string]common.HandlerFunc) (common.Expression, error) {
+	return common.PartialEvaluate(e, handlers)
+}
+
+func (e SizeLiteralExpression) PartialEvaluateWith(config *common.EvaluatorConfig) (common.Expression, error) {
+	return e, nil
+}
+
+func (e SizeLiteralExpression) Serialize(config *common.SerializerConfig, indentLevel int) (string, error) {
+	return formatSize(e.val), nil
+}
+
+type TimestampLiteralExpression struct {
+	val time.Time
+}
+
+func NewTimestampLiteralExpression(val time.Time) (TimestampLiteralExpression, error) {
+	return TimestampLiteralExpression{val: val}, nil
+}
+
+func (e TimestampLiteralExpression) Value() time.Time {
+	return e.val
+}
+
+func (e TimestampLiteralExpression) ToString() string {
+	return fmt.Sprintf("TimestampLiteralExpression<%s>", e.val.Format(time.RFC3339Nano))
+}
+
+func (e TimestampLiteralExpression) GetTags() map[string]common.Expression {
+	return map[string]common.Expression{}
+}
+
+func (e TimestampLiteralExpression) ReplaceReferences(tags map[string]common.Expression) (common.Expression, error) {
+	return e, nil
+}
+
+func (e TimestampLiteralExpression) Evaluate(handlers map[string]common.HandlerFunc) (interface{}, error) {
+	return common.Evaluate(e, handlers)
+}
+
+func (e TimestampLiteralExpression) EvaluateWith(config *common.EvaluatorConfig) (interface{}, error) {
+	return e.val, nil
+}
+
+func (e TimestampLiteralExpression) PartialEvaluate(handlers map[string]common.HandlerFunc) (common.Expression, error) {
+	return common.PartialEvaluate(e, handlers)
+}
+
+func (e TimestampLiteralExpression) PartialEvaluateWith(config *common.EvaluatorConfig) (common.Expression, error) {
+	return e, nil
+}
+
+func (e TimestampLiteralExpression) Serialize(config *common.SerializerConfig, indentLevel int) (string, error) {
+	return e.val.Format(time.RFC3339Nano), nil
+}
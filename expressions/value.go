@@ -0,0 +1,187 @@
+package expressions
+
+import (
+	"github.com/l-donovan/flim/common"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// NewExpressionFromValue builds a literal expression tree from an evaluated
+// value, the inverse of Evaluate for the types that flim can represent.
+func NewExpressionFromValue(val interface{}) (common.Expression, error) {
+	switch v := val.(type) {
+	case nil:
+		return NewNullLiteralExpression()
+	case bool:
+		return NewBooleanLiteralExpression(v)
+	case string:
+		return NewStringLiteralExpression(v)
+	case time.Duration:
+		return NewDurationLiteralExpression(v)
+	case time.Time:
+		return NewTimestampLiteralExpression(v)
+	case int:
+		return NewIntegerLiteralExpression(int64(v))
+	case int8:
+		return NewIntegerLiteralExpression(int64(v))
+	case int16:
+		return NewIntegerLiteralExpression(int64(v))
+	case int32:
+		return NewIntegerLiteralExpression(int64(v))
+	case int64:
+		return NewIntegerLiteralExpression(v)
+	case uint8:
+		return NewIntegerLiteralExpression(int64(v))
+	case uint16:
+		return NewIntegerLiteralExpression(int64(v))
+	case uint32:
+		return NewIntegerLiteralExpression(int64(v))
+	case uint:
+		return NewExpressionFromValue(uint64(v))
+	case uint64:
+		if v > math.MaxInt64 {
+			return NewBigIntegerLiteralExpression(new(big.Int).SetUint64(v))
+		}
+
+		return NewIntegerLiteralExpression(int64(v))
+	case *big.Int:
+		return NewBigIntegerLiteralExpression(v)
+	case *big.Float:
+		return NewBigFloatLiteralExpression(v)
+	case float32:
+		return NewFloatLiteralExpression(float64(v))
+	case float64:
+		return NewFloatLiteralExpression(v)
+	case []interface{}:
+		listItems := make([]common.Expression, len(v))
+
+		for i, listItemVal := range v {
+			listItem, err := NewExpressionFromValue(listItemVal)
+
+			if err != nil {
+				return nil, err
+			}
+
+			listItems[i] = listItem
+		}
+
+		return NewListExpression(listItems)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+
+		for key := range v {
+			keys = append(keys, key)
+		}
+
+		sort.Strings(keys)
+
+		pairs := make([]common.Expression, len(keys))
+
+		for i, key := range keys {
+			pairVal, err := NewExpressionFromValue(v[key])
+
+			if err != nil {
+				return nil, err
+			}
+
+			pair, err := NewPairExpression(key, pairVal)
+
+			if err != nil {
+				return nil, err
+			}
+
+			pairs[i] = pair
+		}
+
+		return NewMapExpression(pairs)
+	default:
+		return nil, fmt.Errorf("cannot represent value of type %T as an expression", val)
+	}
+}
+
+// isResolved reports whether expr can be evaluated without any handlers,
+// i.e. it contains no transformers, unresolved references or tags that refer
+// to themselves.
+func isResolved(expr common.Expression) bool {
+	return isResolvedWithin(expr, map[*common.MemoKey]bool{})
+}
+
+// isResolvedWithin is isResolved for an expression inside the tagged
+// expressions in within.
+func isResolvedWithin(expr common.Expression, within map[*common.MemoKey]bool) bool {
+	switch e := expr.(type) {
+	case IntegerLiteralExpression, FloatLiteralExpression, BooleanLiteralExpression, StringLiteralExpression, NullLiteralExpression:
+		return true
+	case BigIntegerLiteralExpression, BigFloatLiteralExpression:
+		return true
+	case DurationLiteralExpression, SizeLiteralExpression, TimestampLiteralExpression, CustomLiteralExpression:
+		return true
+	case PairExpression:
+		return isResolvedWithin(e.val, within)
+	case ExpandingExpression:
+		return isResolvedWithin(e.expr, within)
+	case TaggedExpression:
+		if e.key != nil {
+			if within[e.key] {
+				return false
+			}
+
+			within[e.key] = true
+			defer delete(within, e.key)
+		}
+
+		return isResolvedWithin(e.expr, within)
+	case ReferenceExpression:
+		target, resolved := e.tags[e.name]
+		return resolved && isResolvedWithin(target, within)
+	case ListExpression:
+		for _, listItem := range e.listItems {
+			if !isResolvedWithin(listItem, within) {
+				return false
+			}
+		}
+
+		return true
+	case MapExpression:
+		for _, pair := range e.pairs {
+			if !isResolvedWithin(pair, within) {
+				return false
+			}
+		}
+
+		return true
+	default:
+		return false
+	}
+}
+
+// foldTransformer evaluates a transformer whose input has been fully resolved
+// and converts the result back into an expression. If the handler result
+// cannot be represented exactly, e.g. an int that would be read back as an
+// int64, the transformer is left in place so that later handlers are given
+// the same values they would be in a full evaluation.
+func foldTransformer(expr common.Expression, config *common.EvaluatorConfig) (common.Expression, error) {
+	result, err := config.Evaluate(expr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	folded, err := NewExpressionFromValue(result)
+
+	if err != nil {
+		return expr, nil
+	}
+
+	foldedResult, err := common.Evaluate(folded, nil)
+
+	if err != nil || !reflect.DeepEqual(foldedResult, result) {
+		return expr, nil
+	}
+
+	return folded, nil
+}
@@ -0,0 +1,318 @@
+package flim
+
+import (
+	"fmt"
+	"go/format"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// goInitialisms are the words written in capitals in Go names.
+var goInitialisms = map[string]bool{
+	"api": true, "cpu": true, "dns": true, "html": true, "http": true, "https": true, "id": true, "ip": true,
+	"json": true, "sql": true, "ssh": true, "tcp": true, "tls": true, "ttl": true, "udp": true, "ui": true,
+	"uri": true, "url": true, "uuid": true, "xml": true,
+}
+
+// GoTypes writes the Go source of a file declaring a type named typeName for
+// the values a schema describes, along with a struct type for each map it has
+// keys for. Struct fields have `flim` tags for Unpack and are documented with
+// the descriptions of their keys. Shared schemas become types named after
+// their tag.
+//
+// Types map to Go as follows: string to string, int and size to int64, float
+// and number to float64, bool to bool, duration to time.Duration, timestamp
+// to time.Time, lists to slices and maps without keys to map[string]T. A
+// value that may be null is a pointer, and anything else is an interface{}.
+func GoTypes(schema *Schema, packageName, typeName string) ([]byte, error) {
+	g := &goGenerator{names: map[string]bool{}, tagged: map[string]string{}, imports: map[string]bool{}, building: map[string]bool{}}
+
+	// The root is named typeName rather than after any tag, and is declared
+	// first whatever its kind
+	root := *schema
+	root.Tag = ""
+
+	if isStruct(&root) {
+		g.structType(&root, typeName)
+	} else {
+		g.names[typeName] = true
+		g.decls = append(g.decls, "")
+		g.decls[0] = fmt.Sprintf("%stype %s %s\n", goComment(root.Description, ""), typeName, g.typeOf(&root, typeName))
+	}
+
+	var builder strings.Builder
+	builder.WriteString("// Code generated by flim. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&builder, "package %s\n", packageName)
+
+	if len(g.imports) > 0 {
+		paths := make([]string, 0, len(g.imports))
+
+		for path := range g.imports {
+			paths = append(paths, strconv.Quote(path))
+		}
+
+		sort.Strings(paths)
+
+		if len(paths) == 1 {
+			fmt.Fprintf(&builder, "\nimport %s\n", paths[0])
+		} else {
+			fmt.Fprintf(&builder, "\nimport (\n%s\n)\n", strings.Join(paths, "\n"))
+		}
+	}
+
+	for _, decl := range g.decls {
+		builder.WriteString("\n")
+		builder.WriteString(decl)
+	}
+
+	return format.Source([]byte(builder.String()))
+}
+
+type goGenerator struct {
+	decls []string
+
+	// names holds the type names in use, and tagged the type declared for
+	// each shared schema
+	names   map[string]bool
+	tagged  map[string]string
+	imports map[string]bool
+
+	// building holds the structs whose fields are being declared
+	building map[string]bool
+}
+
+// typeOf returns the Go type for the values a schema describes, declaring a
+// struct named after name, or the schema's tag, if it needs one.
+func (g *goGenerator) typeOf(s *Schema, name string) string {
+	if s == nil {
+		return "interface{}"
+	}
+
+	types, nullable := goSchemaTypes(s)
+	goType := g.typeOfTypes(s, types, name)
+
+	if nullable && goType != "interface{}" && !strings.HasPrefix(goType, "[]") && !strings.HasPrefix(goType, "map[") {
+		return "*" + goType
+	}
+
+	return goType
+}
+
+func (g *goGenerator) typeOfTypes(s *Schema, types []string, name string) string {
+	if len(types) == 0 || containsString(types, "any") {
+		return "interface{}"
+	}
+
+	numeric := true
+
+	for _, t := range types {
+		numeric = numeric && (t == "int" || t == "float" || t == "number")
+	}
+
+	switch {
+	case numeric && len(types) == 1 && types[0] == "int":
+		return "int64"
+	case numeric:
+		return "float64"
+	case len(types) > 1:
+		return "interface{}"
+	}
+
+	switch types[0] {
+	case "string":
+		return "string"
+	case "size":
+		return "int64"
+	case "bool":
+		return "bool"
+	case "duration":
+		g.imports["time"] = true
+		return "time.Duration"
+	case "timestamp":
+		g.imports["time"] = true
+		return "time.Time"
+	case "list":
+		return "[]" + g.typeOf(s.Items, singular(name))
+	case "map":
+		if isStruct(s) {
+			return g.structType(s, name)
+		}
+
+		return "map[string]" + g.typeOf(s.AdditionalKeys, name+"Value")
+	default:
+		// Custom literals evaluate to whatever their kind decodes them to
+		return "interface{}"
+	}
+}
+
+// structType declares a struct for a map with keys, returning its name.
+func (g *goGenerator) structType(s *Schema, name string) string {
+	if s.Tag != "" {
+		if typeName, exists := g.tagged[s.Tag]; exists {
+			return typeName
+		}
+
+		name = goName(s.Tag)
+	}
+
+	typeName := g.reserve(name)
+
+	if s.Tag != "" {
+		g.tagged[s.Tag] = typeName
+	}
+
+	// The struct is declared before the types of its fields
+	index := len(g.decls)
+	g.decls = append(g.decls, "")
+
+	var fields strings.Builder
+	fieldNames := map[string]bool{}
+	g.building[typeName] = true
+
+	for _, key := range s.Keys {
+		fieldName := goName(key.Name)
+
+		for i := 2; fieldNames[fieldName]; i++ {
+			fieldName = fmt.Sprintf("%s%d", goName(key.Name), i)
+		}
+
+		fieldNames[fieldName] = true
+		nestedName := fieldName
+
+		if g.names[nestedName] {
+			nestedName = typeName + fieldName
+		}
+
+		fieldType := g.typeOf(key.Schema, nestedName)
+
+		// A struct can only hold itself through a pointer
+		if g.building[fieldType] {
+			fieldType = "*" + fieldType
+		}
+
+		options := ""
+
+		if key.Schema.Optional {
+			options = ",omitempty"
+		}
+
+		fields.WriteString(goComment(key.Schema.Description, "\t"))
+		fmt.Fprintf(&fields, "\t%s %s %s\n", fieldName, fieldType, goTag(key.Name+options))
+	}
+
+	delete(g.building, typeName)
+	g.decls[index] = fmt.Sprintf("%stype %s struct {\n%s}\n", goComment(s.Description, ""), typeName, fields.String())
+	return typeName
+}
+
+// goSchemaTypes returns the types a schema allows other than null, and
+// whether it allows null. A schema with keys or items but no types describes
+// maps or lists.
+func goSchemaTypes(s *Schema) ([]string, bool) {
+	types := []string{}
+	nullable := false
+
+	for _, t := range s.Types {
+		if t == "null" {
+			nullable = true
+		} else {
+			types = append(types, t)
+		}
+	}
+
+	switch {
+	case len(s.Types) > 0:
+	case len(s.Keys) > 0 || s.AdditionalKeys != nil || s.ClosedKeys:
+		types = []string{"map"}
+	case s.Items != nil:
+		types = []string{"list"}
+	}
+
+	return types, nullable
+}
+
+// isStruct reports whether the values a schema describes are maps with
+// known keys, or null.
+func isStruct(s *Schema) bool {
+	types, _ := goSchemaTypes(s)
+	return len(types) == 1 && types[0] == "map" && len(s.Keys) > 0
+}
+
+// reserve returns name, or a variation of it if it is already in use.
+func (g *goGenerator) reserve(name string) string {
+	typeName := name
+
+	for i := 2; g.names[typeName]; i++ {
+		typeName = fmt.Sprintf("%s%d", name, i)
+	}
+
+	g.names[typeName] = true
+	return typeName
+}
+
+// goName turns a key into an exported Go name, e.g. file_mode into FileMode.
+func goName(key string) string {
+	var builder strings.Builder
+
+	words := strings.FieldsFunc(key, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for _, word := range words {
+		if goInitialisms[strings.ToLower(word)] {
+			builder.WriteString(strings.ToUpper(word))
+			continue
+		}
+
+		runes := []rune(word)
+		runes[0] = unicode.ToUpper(runes[0])
+		builder.WriteString(string(runes))
+	}
+
+	name := builder.String()
+
+	if name == "" || !unicode.IsLetter([]rune(name)[0]) {
+		name = "X" + name
+	}
+
+	return name
+}
+
+// singular guesses the name of an item of a list from the name of the list.
+func singular(name string) string {
+	switch {
+	case strings.HasSuffix(name, "ies") && len(name) > 3:
+		return name[:len(name)-3] + "y"
+	case strings.HasSuffix(name, "s") && !strings.HasSuffix(name, "ss") && len(name) > 1:
+		return name[:len(name)-1]
+	default:
+		return name + "Item"
+	}
+}
+
+func goComment(description, indent string) string {
+	if description == "" {
+		return ""
+	}
+
+	var builder strings.Builder
+
+	for _, line := range strings.Split(description, "\n") {
+		builder.WriteString(strings.TrimRight(indent+"// "+line, " ") + "\n")
+	}
+
+	return builder.String()
+}
+
+// goTag writes the struct tag of a field.
+func goTag(name string) string {
+	tag := "flim:" + strconv.Quote(name)
+
+	if strings.Contains(tag, "`") {
+		return strconv.Quote(tag)
+	}
+
+	return "`" + tag + "`"
+}
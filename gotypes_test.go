@@ -0,0 +1,278 @@
+package flim
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGoTypes(t *testing.T) {
+	doc, err := ParseDocument(`// A backend server
+#backend {
+    keys {
+        host "string"
+        port { type "int" optional true }
+    }
+}
+{
+    keys {
+        // The name
+        // of the service
+        service_name "string"
+        api_url { type "string" optional true }
+        timeout "duration"
+        started { type [ "timestamp" "null" ] }
+        ratio "number"
+        backends { items &backend }
+        primary &backend
+        labels { type "map" additional_keys "string" }
+        any_value "any"
+        "2fa" "bool"
+        "odd` + "`" + `key" "size"
+        entries { items { keys { a "int" } } }
+    }
+}
+`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema, err := ParseSchemaDocument(doc)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source, err := GoTypes(schema, "config", "Config")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "// Code generated by flim. DO NOT EDIT.\n" +
+		"\n" +
+		"package config\n" +
+		"\n" +
+		"import \"time\"\n" +
+		"\n" +
+		"type Config struct {\n" +
+		"\t// The name\n" +
+		"\t// of the service\n" +
+		"\tServiceName string        `flim:\"service_name\"`\n" +
+		"\tAPIURL      string        `flim:\"api_url,omitempty\"`\n" +
+		"\tTimeout     time.Duration `flim:\"timeout\"`\n" +
+		"\tStarted     *time.Time    `flim:\"started\"`\n" +
+		"\tRatio       float64       `flim:\"ratio\"`\n" +
+		"\tBackends    []Backend     `flim:\"backends\"`\n" +
+		"\t// A backend server\n" +
+		"\tPrimary  Backend           `flim:\"primary\"`\n" +
+		"\tLabels   map[string]string `flim:\"labels\"`\n" +
+		"\tAnyValue interface{}       `flim:\"any_value\"`\n" +
+		"\tX2fa     bool              `flim:\"2fa\"`\n" +
+		"\tOddKey   int64             \"flim:\\\"odd`key\\\"\"\n" +
+		"\tEntries  []Entry           `flim:\"entries\"`\n" +
+		"}\n" +
+		"\n" +
+		"// A backend server\n" +
+		"type Backend struct {\n" +
+		"\tHost string `flim:\"host\"`\n" +
+		"\tPort int64  `flim:\"port,omitempty\"`\n" +
+		"}\n" +
+		"\n" +
+		"type Entry struct {\n" +
+		"\tA int64 `flim:\"a\"`\n" +
+		"}\n"
+
+	if string(source) != want {
+		t.Errorf("got\n%s\nwant\n%s", source, want)
+	}
+}
+
+// typeCheck type-checks generated source and returns the names it declares
+// along with their types.
+func typeCheck(t *testing.T, source []byte) map[string]string {
+	t.Helper()
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "config.go", source, 0)
+
+	if err != nil {
+		t.Fatalf("%s\n%s", err, source)
+	}
+
+	config := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	pkg, err := config.Check("config", fset, []*ast.File{file}, nil)
+
+	if err != nil {
+		t.Fatalf("%s\n%s", err, source)
+	}
+
+	declared := map[string]string{}
+
+	for _, name := range pkg.Scope().Names() {
+		declared[name] = pkg.Scope().Lookup(name).Type().Underlying().String()
+	}
+
+	return declared
+}
+
+// Generated source compiles whatever the schema: names are kept apart, and
+// structs that hold themselves do so through pointers or slices.
+func TestGoTypesCompile(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		want   map[string]string
+	}{
+		{
+			"not a map",
+			`{ type "list" items "string" }`,
+			map[string]string{"Config": "[]string"},
+		},
+		{
+			"recursive",
+			"#node { keys { name \"string\" next &node children { items &node } } }\n{ keys { root &node } }",
+			map[string]string{
+				"Config": `struct{Root config.Node "flim:\"root\""}`,
+				"Node":   `struct{Name string "flim:\"name\""; Next *config.Node "flim:\"next\""; Children []config.Node "flim:\"children\""}`,
+			},
+		},
+		{
+			"clashing names",
+			`{ keys { config { keys { a "int" } } other { keys { config { keys { b "int" } } } } } }`,
+			map[string]string{
+				"Config":       `struct{Config config.ConfigConfig "flim:\"config\""; Other config.Other "flim:\"other\""}`,
+				"ConfigConfig": `struct{A int64 "flim:\"a\""}`,
+				"Other":        `struct{Config config.OtherConfig "flim:\"config\""}`,
+				"OtherConfig":  `struct{B int64 "flim:\"b\""}`,
+			},
+		},
+		{
+			"clashing fields",
+			`{ keys { file_mode "string" "file-mode" "int" } }`,
+			map[string]string{"Config": `struct{FileMode string "flim:\"file_mode\""; FileMode2 int64 "flim:\"file-mode\""}`},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source, err := GoTypes(parseTestSchema(t, test.schema), "config", "Config")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := typeCheck(t, source); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// Unpack stores documents in structs like those GoTypes generates, following
+// their flim tags.
+func TestUnpack(t *testing.T) {
+	type backend struct {
+		Host string `flim:"host"`
+		Port int64  `flim:"port,omitempty"`
+	}
+
+	type config struct {
+		Name     string            `flim:"name"`
+		Timeout  time.Duration     `flim:"timeout"`
+		Ratio    float32           `flim:"ratio"`
+		Count    uint8             `flim:"count"`
+		Backends []backend         `flim:"backends"`
+		Primary  *backend          `flim:"primary"`
+		Labels   map[string]string `flim:"labels"`
+		Missing  string            `flim:"missing"`
+		Skipped  string            `flim:"-"`
+		Untagged bool
+		Nothing  *int
+	}
+
+	expr, err := ParseString(`{
+		name "svc"
+		timeout 1m30s
+		ratio 0.5
+		count 200
+		backends [ { host "a" port 80 } { host "b" } ]
+		primary { host "c" }
+		labels { team "x" }
+		"-" "ignored"
+		Untagged true
+		Nothing null
+		extra 1
+	}`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got config
+	got.Missing = "kept"
+
+	if err := Unpack(evaluateDocument(t, expr), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	want := config{
+		Name:     "svc",
+		Timeout:  90 * time.Second,
+		Ratio:    0.5,
+		Count:    200,
+		Backends: []backend{{"a", 80}, {"b", 0}},
+		Primary:  &backend{Host: "c"},
+		Labels:   map[string]string{"team": "x"},
+		Missing:  "kept",
+		Untagged: true,
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	var bigInt int64
+
+	if err := Unpack(big.NewInt(7), &bigInt); err != nil || bigInt != 7 {
+		t.Errorf("unpacking a big integer gave %d, %v", bigInt, err)
+	}
+}
+
+func TestUnpackErrors(t *testing.T) {
+	var str string
+	var small struct {
+		Count uint8 `flim:"count"`
+	}
+	var list struct {
+		Items []int `flim:"items"`
+	}
+
+	tests := []struct {
+		name string
+		val  interface{}
+		out  interface{}
+		err  string
+	}{
+		{"not a pointer", "a", str, "expected a non-nil pointer"},
+		{"wrong type", int64(1), &str, ".: cannot unpack 1 into string"},
+		{"overflow", map[string]interface{}{"count": int64(256)}, &small, "count: cannot unpack 256 into uint8"},
+		{"negative unsigned", map[string]interface{}{"count": int64(-1)}, &small, "count: cannot unpack -1 into uint8"},
+		{"nested path", map[string]interface{}{"items": []interface{}{int64(1), "two"}}, &list, "items[1]: cannot unpack \"two\" into int"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := Unpack(test.val, test.out); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("got error %v, want one containing `%s'", err, test.err)
+			}
+		})
+	}
+}
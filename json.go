@@ -0,0 +1,292 @@
+package flim
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/l-donovan/flim/common"
+	flimexpr "github.com/l-donovan/flim/expressions"
+	"io"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FromJSON builds an expression tree from a JSON document. Object keys keep
+// their document order, integers become IntegerLiteralExpressions (or
+// BigIntegerLiteralExpressions if they overflow int64), and numbers with a
+// fraction or exponent become FloatLiteralExpressions.
+func FromJSON(data []byte) (common.Expression, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	expr, err := decodeJSONValue(decoder)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after JSON value")
+	}
+
+	return flimexpr.NewFileExpression([]common.Expression{expr})
+}
+
+func decodeJSONValue(decoder *json.Decoder) (common.Expression, error) {
+	token, err := decoder.Token()
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch val := token.(type) {
+	case json.Delim:
+		switch val {
+		case '{':
+			pairs := []common.Expression{}
+
+			for decoder.More() {
+				keyToken, err := decoder.Token()
+
+				if err != nil {
+					return nil, err
+				}
+
+				pairVal, err := decodeJSONValue(decoder)
+
+				if err != nil {
+					return nil, err
+				}
+
+				pair, err := flimexpr.NewPairExpression(keyToken.(string), pairVal)
+
+				if err != nil {
+					return nil, err
+				}
+
+				pairs = append(pairs, pair)
+			}
+
+			// Throw away the closing brace
+			if _, err := decoder.Token(); err != nil {
+				return nil, err
+			}
+
+			return flimexpr.NewMapExpression(pairs)
+		case '[':
+			listItems := []common.Expression{}
+
+			for decoder.More() {
+				listItem, err := decodeJSONValue(decoder)
+
+				if err != nil {
+					return nil, err
+				}
+
+				listItems = append(listItems, listItem)
+			}
+
+			// Throw away the closing bracket
+			if _, err := decoder.Token(); err != nil {
+				return nil, err
+			}
+
+			return flimexpr.NewListExpression(listItems)
+		default:
+			return nil, fmt.Errorf("unexpected JSON delimiter %s", val)
+		}
+	case json.Number:
+		return decodeJSONNumber(string(val))
+	case string:
+		return flimexpr.NewStringLiteralExpression(val)
+	case bool:
+		return flimexpr.NewBooleanLiteralExpression(val)
+	case nil:
+		return flimexpr.NewNullLiteralExpression()
+	default:
+		return nil, fmt.Errorf("unexpected JSON token %v", token)
+	}
+}
+
+func decodeJSONNumber(number string) (common.Expression, error) {
+	if strings.ContainsAny(number, ".eE") {
+		val, err := strconv.ParseFloat(number, 64)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return flimexpr.NewFloatLiteralExpression(val)
+	}
+
+	val, err := strconv.ParseInt(number, 10, 64)
+
+	if errors.Is(err, strconv.ErrRange) {
+		bigVal, ok := new(big.Int).SetString(number, 10)
+
+		if !ok {
+			return nil, fmt.Errorf("invalid JSON number %s", number)
+		}
+
+		return flimexpr.NewBigIntegerLiteralExpression(bigVal)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return flimexpr.NewIntegerLiteralExpression(val)
+}
+
+// ToJSON encodes an evaluated value as JSON. Map keys are sorted, integers are
+// written without a fraction and floats always with one, so that the JSON
+// reads back with the same types. Durations and timestamps are written as
+// strings in their flim form.
+func ToJSON(val interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+
+	if err := encodeJSONValue(&buf, val); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func encodeJSONValue(buf *bytes.Buffer, val interface{}) error {
+	switch v := val.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case string:
+		return encodeJSONString(buf, v)
+	case int:
+		buf.WriteString(strconv.FormatInt(int64(v), 10))
+	case int8:
+		buf.WriteString(strconv.FormatInt(int64(v), 10))
+	case int16:
+		buf.WriteString(strconv.FormatInt(int64(v), 10))
+	case int32:
+		buf.WriteString(strconv.FormatInt(int64(v), 10))
+	case int64:
+		buf.WriteString(strconv.FormatInt(v, 10))
+	case uint:
+		buf.WriteString(strconv.FormatUint(uint64(v), 10))
+	case uint8:
+		buf.WriteString(strconv.FormatUint(uint64(v), 10))
+	case uint16:
+		buf.WriteString(strconv.FormatUint(uint64(v), 10))
+	case uint32:
+		buf.WriteString(strconv.FormatUint(uint64(v), 10))
+	case uint64:
+		buf.WriteString(strconv.FormatUint(v, 10))
+	case float32:
+		return encodeJSONFloat(buf, float64(v))
+	case float64:
+		return encodeJSONFloat(buf, v)
+	case *big.Int:
+		buf.WriteString(v.String())
+	case *big.Float:
+		if v.IsInf() {
+			return fmt.Errorf("cannot encode %s as JSON", v.String())
+		}
+
+		out := v.Text('g', -1)
+
+		if !strings.ContainsAny(out, ".e") {
+			out += ".0"
+		}
+
+		buf.WriteString(out)
+	case time.Duration:
+		return encodeJSONString(buf, v.String())
+	case time.Time:
+		return encodeJSONString(buf, v.Format(time.RFC3339Nano))
+	case []interface{}:
+		buf.WriteByte('[')
+
+		for i, listItem := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+
+			if err := encodeJSONValue(buf, listItem); err != nil {
+				return err
+			}
+		}
+
+		buf.WriteByte(']')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+
+		for key := range v {
+			keys = append(keys, key)
+		}
+
+		sort.Strings(keys)
+		buf.WriteByte('{')
+
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+
+			if err := encodeJSONString(buf, key); err != nil {
+				return err
+			}
+
+			buf.WriteByte(':')
+
+			if err := encodeJSONValue(buf, v[key]); err != nil {
+				return err
+			}
+		}
+
+		buf.WriteByte('}')
+	default:
+		// Anything else, e.g. a custom literal value, encodes the way
+		// encoding/json would encode it
+		out, err := json.Marshal(v)
+
+		if err != nil {
+			return err
+		}
+
+		buf.Write(out)
+	}
+
+	return nil
+}
+
+func encodeJSONFloat(buf *bytes.Buffer, val float64) error {
+	if math.IsNaN(val) || math.IsInf(val, 0) {
+		return fmt.Errorf("cannot encode %v as JSON", val)
+	}
+
+	out := strconv.FormatFloat(val, 'g', -1, 64)
+
+	if !strings.ContainsAny(out, ".e") {
+		out += ".0"
+	}
+
+	buf.WriteString(out)
+	return nil
+}
+
+func encodeJSONString(buf *bytes.Buffer, val string) error {
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+
+	if err := encoder.Encode(val); err != nil {
+		return err
+	}
+
+	// Encode always terminates the value with a newline
+	buf.Truncate(buf.Len() - 1)
+	return nil
+}
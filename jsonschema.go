@@ -0,0 +1,806 @@
+package flim
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/l-donovan/flim/common"
+	flimexpr "github.com/l-donovan/flim/expressions"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// jsonSchemaDialect is the version of JSON Schema written by JSONSchema.
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// jsonDurationPattern matches durations as ToJSON writes them.
+const jsonDurationPattern = `^-?(?:[0-9]+(?:\.[0-9]+)?(?:ns|us|µs|μs|ms|s|m|h))+$`
+
+// jsonSchemaTypes maps the schema types that JSON has to their JSON Schema
+// names.
+var jsonSchemaTypes = map[string]string{
+	"map":    "object",
+	"list":   "array",
+	"string": "string",
+	"int":    "integer",
+	"float":  "number",
+	"number": "number",
+	"bool":   "boolean",
+	"null":   "null",
+}
+
+// jsonSchemaAnnotations are the JSON Schema keywords that do not constrain
+// values, and so are ignored by ParseJSONSchema.
+var jsonSchemaAnnotations = []string{
+	"$schema", "$id", "$anchor", "$comment", "$defs", "definitions", "title", "default", "examples",
+	"deprecated", "readOnly", "writeOnly", "contentEncoding", "contentMediaType",
+}
+
+// jsonObject is a JSON object that keeps the order of its members.
+type jsonObject []jsonMember
+
+type jsonMember struct {
+	Key   string
+	Value interface{}
+}
+
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+
+	for i, member := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		if err := encodeJSONString(&buf, member.Key); err != nil {
+			return nil, err
+		}
+
+		out, err := json.Marshal(member.Value)
+
+		if err != nil {
+			return nil, err
+		}
+
+		buf.WriteByte(':')
+		buf.Write(out)
+	}
+
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// JSONSchema writes a JSON Schema describing the evaluated values the schema
+// allows, as ToJSON encodes them, so that tools that speak JSON Schema can
+// check them. Shared schemas are written once under $defs and referred to
+// with $ref, and transformers, which evaluated values no longer have, are
+// left out.
+//
+// A schema with keys but no types is written as describing objects, and one
+// with items but no types as describing arrays.
+//
+// Types JSON does not have are written as what they are encoded as, along
+// with an x-flim-type keyword naming them: durations and timestamps as
+// strings, sizes as integers and custom literals as anything. Bounds on
+// durations and timestamps are kept in x-flim-minimum and x-flim-maximum,
+// in their flim form. ParseJSONSchema reads all of these back.
+func (s *Schema) JSONSchema() ([]byte, error) {
+	e := &jsonSchemaExporter{refs: map[string]string{}, descriptions: map[string]string{}}
+	e.describe(s, map[*Schema]bool{})
+
+	// The root is written in place even if it is shared
+	if s.Tag != "" {
+		e.refs[s.Tag] = "#"
+	}
+
+	root, err := e.body(s)
+
+	if err != nil {
+		return nil, err
+	}
+
+	root = append(jsonObject{{"$schema", jsonSchemaDialect}}, root...)
+
+	if len(e.defs) > 0 {
+		root = append(root, jsonMember{"$defs", e.defs})
+	}
+
+	return json.MarshalIndent(root, "", "  ")
+}
+
+type jsonSchemaExporter struct {
+	defs jsonObject
+
+	// refs holds the reference to each shared schema, and descriptions the
+	// description of each shared schema every use of it agrees on
+	refs         map[string]string
+	descriptions map[string]string
+}
+
+// describe finds the descriptions of the shared schemas. Where the uses of a
+// shared schema describe it differently, each keeps its own description.
+func (e *jsonSchemaExporter) describe(s *Schema, seen map[*Schema]bool) {
+	if s == nil || seen[s] {
+		return
+	}
+
+	seen[s] = true
+
+	if s.Tag != "" {
+		if description, exists := e.descriptions[s.Tag]; !exists {
+			e.descriptions[s.Tag] = s.Description
+		} else if description != s.Description {
+			e.descriptions[s.Tag] = ""
+		}
+	}
+
+	e.describe(s.Items, seen)
+	e.describe(s.AdditionalKeys, seen)
+
+	for _, key := range s.Keys {
+		e.describe(key.Schema, seen)
+	}
+
+	for _, alternative := range s.OneOf {
+		e.describe(alternative, seen)
+	}
+}
+
+func (e *jsonSchemaExporter) schema(s *Schema) (jsonObject, error) {
+	if s == nil {
+		return jsonObject{}, nil
+	}
+
+	if s.Tag == "" {
+		return e.body(s)
+	}
+
+	ref, exists := e.refs[s.Tag]
+
+	if !exists {
+		ref = "#/$defs/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(s.Tag)
+		e.refs[s.Tag] = ref
+
+		// The definition's place is kept before its body is written, so that
+		// the shared schemas inside it come after it
+		index := len(e.defs)
+		e.defs = append(e.defs, jsonMember{s.Tag, nil})
+		shared := *s
+		shared.Description = e.descriptions[s.Tag]
+		def, err := e.body(&shared)
+
+		if err != nil {
+			return nil, err
+		}
+
+		e.defs[index].Value = def
+	}
+
+	obj := jsonObject{{"$ref", ref}}
+
+	if s.Description != "" && s.Description != e.descriptions[s.Tag] {
+		obj = append(obj, jsonMember{"description", s.Description})
+	}
+
+	return obj, nil
+}
+
+func (e *jsonSchemaExporter) body(s *Schema) (jsonObject, error) {
+	obj := jsonObject{}
+
+	if s.Description != "" {
+		obj = append(obj, jsonMember{"description", s.Description})
+	}
+
+	obj = append(obj, jsonTypeMembers(s)...)
+
+	if len(s.Enum) > 0 {
+		enum := make([]json.RawMessage, len(s.Enum))
+
+		for i, val := range s.Enum {
+			out, err := ToJSON(val)
+
+			if err != nil {
+				return nil, err
+			}
+
+			enum[i] = out
+		}
+
+		obj = append(obj, jsonMember{"enum", enum})
+	}
+
+	for _, bound := range []struct {
+		keyword string
+		val     interface{}
+	}{{"minimum", s.Min}, {"maximum", s.Max}} {
+		switch bound.val.(type) {
+		case nil:
+		case time.Duration, time.Time:
+			obj = append(obj, jsonMember{"x-flim-" + bound.keyword, schemaValueString(bound.val)})
+		default:
+			out, err := ToJSON(bound.val)
+
+			if err != nil {
+				return nil, err
+			}
+
+			obj = append(obj, jsonMember{bound.keyword, json.RawMessage(out)})
+		}
+	}
+
+	if s.Pattern != nil {
+		obj = append(obj, jsonMember{"pattern", s.Pattern.String()})
+	}
+
+	if s.MinItems != nil {
+		obj = append(obj, jsonMember{"minItems", *s.MinItems})
+	}
+
+	if s.MaxItems != nil {
+		obj = append(obj, jsonMember{"maxItems", *s.MaxItems})
+	}
+
+	if s.Items != nil {
+		items, err := e.schema(s.Items)
+
+		if err != nil {
+			return nil, err
+		}
+
+		obj = append(obj, jsonMember{"items", items})
+	}
+
+	if len(s.Keys) > 0 {
+		properties := jsonObject{}
+		required := []string{}
+
+		for _, key := range s.Keys {
+			keySchema, err := e.schema(key.Schema)
+
+			if err != nil {
+				return nil, err
+			}
+
+			properties = append(properties, jsonMember{key.Name, keySchema})
+
+			if !key.Schema.Optional {
+				required = append(required, key.Name)
+			}
+		}
+
+		obj = append(obj, jsonMember{"properties", properties})
+
+		if len(required) > 0 {
+			obj = append(obj, jsonMember{"required", required})
+		}
+	}
+
+	if s.ClosedKeys {
+		obj = append(obj, jsonMember{"additionalProperties", false})
+	} else if s.AdditionalKeys != nil {
+		additional, err := e.schema(s.AdditionalKeys)
+
+		if err != nil {
+			return nil, err
+		}
+
+		obj = append(obj, jsonMember{"additionalProperties", additional})
+	}
+
+	if len(s.OneOf) > 0 {
+		alternatives := make([]jsonObject, len(s.OneOf))
+
+		for i, alternative := range s.OneOf {
+			var err error
+
+			if alternatives[i], err = e.schema(alternative); err != nil {
+				return nil, err
+			}
+		}
+
+		obj = append(obj, jsonMember{"oneOf", alternatives})
+	}
+
+	return obj, nil
+}
+
+// jsonTypeMembers writes the types of a schema as JSON Schema keywords.
+func jsonTypeMembers(s *Schema) jsonObject {
+	// A schema with keys or items but no types describes maps or lists, as
+	// it does for GoTypes, so properties and items come with their type
+	types := s.Types
+
+	if len(types) == 0 {
+		types, _ = goSchemaTypes(s)
+	}
+
+	jsonTypes := []string{}
+	native := true
+	unconstrained := len(types) == 0
+
+	for _, t := range types {
+		jsonType, exists := jsonSchemaTypes[t]
+
+		switch {
+		case exists:
+		case t == "duration", t == "timestamp":
+			jsonType, native = "string", false
+		case t == "size":
+			jsonType, native = "integer", false
+		case t == "any":
+			unconstrained = true
+		default:
+			// Custom literals evaluate to whatever their kind decodes them to
+			unconstrained, native = true, false
+		}
+
+		if jsonType != "" && !containsString(jsonTypes, jsonType) {
+			jsonTypes = append(jsonTypes, jsonType)
+		}
+	}
+
+	members := jsonObject{}
+
+	if !unconstrained {
+		if len(jsonTypes) == 1 {
+			members = append(members, jsonMember{"type", jsonTypes[0]})
+		} else {
+			members = append(members, jsonMember{"type", jsonTypes})
+		}
+	}
+
+	if native {
+		return members
+	}
+
+	if len(types) > 1 {
+		return append(members, jsonMember{"x-flim-type", types})
+	}
+
+	members = append(members, jsonMember{"x-flim-type", types[0]})
+
+	switch types[0] {
+	case "duration":
+		if s.Pattern == nil {
+			members = append(members, jsonMember{"pattern", jsonDurationPattern})
+		}
+	case "timestamp":
+		members = append(members, jsonMember{"format", "date-time"})
+	}
+
+	return members
+}
+
+// ParseJSONSchema reads a JSON Schema, so that evaluated values can be
+// checked against it with ValidateValue. It supports type, enum, const,
+// minimum, maximum, pattern, minItems, maxItems, items, properties, required,
+// additionalProperties and oneOf, along with $ref to anything in the same
+// document, and reads back the x-flim keywords JSONSchema writes. A string
+// with the date-time format also allows timestamps. Keywords that only
+// annotate, along with any starting with x-, are ignored, while any other
+// keyword is an error rather than going unchecked. Options are used to parse
+// the flim the x-flim keywords hold, and WithLiterals gives the literal kinds
+// x-flim-type can name.
+func ParseJSONSchema(data []byte, options ...ParserOption) (*Schema, error) {
+	expr, err := FromJSON(data)
+
+	if err != nil {
+		return nil, fmt.Errorf("json schema: %w", err)
+	}
+
+	i := &jsonSchemaImporter{root: expr, options: options, refs: map[string]*Schema{}, shared: map[*Schema]bool{}}
+	schema, err := i.ref("#", Path{})
+
+	if err != nil {
+		return nil, fmt.Errorf("json schema: %w", err)
+	}
+
+	// Copies of shared schemas are only made now that every shared schema
+	// has been read
+	for _, c := range i.copies {
+		optional, description := c.schema.Optional, c.schema.Description
+		*c.schema = *c.shared
+		c.schema.Optional = optional
+
+		if description != "" {
+			c.schema.Description = description
+		}
+	}
+
+	return schema, nil
+}
+
+type jsonSchemaImporter struct {
+	root    common.Expression
+	options []ParserOption
+
+	// refs holds the schema read for each reference, and shared the schemas
+	// that can be referred to
+	refs   map[string]*Schema
+	shared map[*Schema]bool
+
+	// copies holds the schemas that are copies of shared ones, made to give
+	// them a description of their own or make them optional keys
+	copies []jsonSchemaCopy
+}
+
+type jsonSchemaCopy struct {
+	schema *Schema
+	shared *Schema
+}
+
+// ref returns the schema a reference refers to.
+func (i *jsonSchemaImporter) ref(ref string, path Path) (*Schema, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("%s: cannot follow `%s', only references within the document are supported", path, ref)
+	}
+
+	pointer, err := url.PathUnescape(ref[1:])
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if schema, exists := i.refs[pointer]; exists {
+		return schema, nil
+	}
+
+	tokens, err := parsePointer(pointer)
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	target, err := lookupPointer(i.root, tokens)
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: cannot follow `%s': %w", path, ref, err)
+	}
+
+	// The schema is kept before it is read so that it can refer to itself
+	schema := &Schema{}
+	i.refs[pointer] = schema
+	i.shared[schema] = true
+	targetPath := Path{}
+
+	for _, token := range tokens {
+		targetPath = targetPath.Child(token)
+	}
+
+	read, err := i.schema(target, targetPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	*schema = *read
+
+	if n := len(tokens); n >= 2 && (tokens[n-2] == "$defs" || tokens[n-2] == "definitions") {
+		schema.Tag = tokens[n-1]
+	}
+
+	return schema, nil
+}
+
+func (i *jsonSchemaImporter) schema(expr common.Expression, path Path) (*Schema, error) {
+	if b, ok := expr.(flimexpr.BooleanLiteralExpression); ok {
+		if !b.Value() {
+			return nil, fmt.Errorf("%s: the schema false is only supported as additionalProperties", path)
+		}
+
+		return &Schema{}, nil
+	}
+
+	m, ok := expr.(flimexpr.MapExpression)
+
+	if !ok {
+		return nil, fmt.Errorf("%s: a schema must be an object or a boolean", path)
+	}
+
+	entries, _ := effectiveEntries(m)
+
+	if refExpr, exists := entries.values["$ref"]; exists {
+		for _, key := range entries.keys {
+			if key != "$ref" && key != "description" && !isJSONSchemaAnnotation(key) {
+				return nil, fmt.Errorf("%s: `%s' alongside $ref is not supported", path, key)
+			}
+		}
+
+		ref, err := i.string(refExpr, path.Child("$ref"))
+
+		if err != nil {
+			return nil, err
+		}
+
+		shared, err := i.ref(ref, path.Child("$ref"))
+
+		if err != nil || entries.values["description"] == nil {
+			return shared, err
+		}
+
+		description, err := i.string(entries.values["description"], path.Child("description"))
+		return i.copy(shared, description), err
+	}
+
+	schema := &Schema{}
+	var required, flimTypes []string
+	var format string
+
+	for _, key := range entries.keys {
+		val := entries.values[key]
+		keyPath := path.Child(key)
+		var err error
+
+		switch key {
+		case "required":
+			required, err = i.strings(val, keyPath)
+		case "format":
+			format, err = i.string(val, keyPath)
+		case "x-flim-type":
+			flimTypes, err = i.strings(val, keyPath)
+		default:
+			err = i.set(schema, key, val, keyPath)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, name := range required {
+		keySchema, exists := schema.Key(name)
+
+		if !exists {
+			keySchema = &Schema{}
+			schema.Keys = append(schema.Keys, SchemaKey{name, keySchema})
+		}
+
+		keySchema.Optional = false
+	}
+
+	// The flim types written by JSONSchema take the place of the JSON ones
+	if flimTypes != nil {
+		for _, t := range flimTypes {
+			if _, custom := NewParser(i.options...).literals.lookup(t); !custom && !containsString(schemaTypes, t) {
+				return nil, fmt.Errorf("%s: unknown type `%s'", path.Child("x-flim-type"), t)
+			}
+		}
+
+		schema.Types = flimTypes
+	} else if format == "date-time" && containsString(schema.Types, "string") {
+		schema.Types = append(schema.Types, "timestamp")
+	}
+
+	return schema, nil
+}
+
+// set sets a field of a schema from a keyword.
+func (i *jsonSchemaImporter) set(s *Schema, key string, expr common.Expression, path Path) error {
+	switch key {
+	case "type":
+		names, err := i.strings(expr, path)
+
+		if err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			t := ""
+
+			for flimType, jsonType := range jsonSchemaTypes {
+				if jsonType == name && flimType != "float" {
+					t = flimType
+				}
+			}
+
+			if t == "" {
+				return fmt.Errorf("%s: unknown type `%s'", path, name)
+			}
+
+			if !containsString(s.Types, t) {
+				s.Types = append(s.Types, t)
+			}
+		}
+	case "description":
+		description, err := i.string(expr, path)
+		s.Description = description
+		return err
+	case "enum", "const":
+		val, err := common.Evaluate(expr, nil)
+
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		enum, ok := val.([]interface{})
+
+		if key == "const" {
+			enum, ok = []interface{}{val}, true
+		}
+
+		if !ok {
+			return fmt.Errorf("%s: expected an array", path)
+		}
+
+		s.Enum = enum
+	case "minimum", "maximum", "x-flim-minimum", "x-flim-maximum":
+		val, err := i.bound(expr, strings.HasPrefix(key, "x-flim-"), path)
+
+		if err != nil {
+			return err
+		}
+
+		if strings.HasSuffix(key, "minimum") {
+			s.Min = val
+		} else {
+			s.Max = val
+		}
+	case "pattern":
+		pattern, err := i.string(expr, path)
+
+		if err != nil {
+			return err
+		}
+
+		if s.Pattern, err = regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	case "minItems", "maxItems":
+		val, err := common.Evaluate(expr, nil)
+
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		count, ok := val.(int64)
+
+		if !ok || count < 0 {
+			return fmt.Errorf("%s: expected a count", path)
+		}
+
+		n := int(count)
+
+		if key == "minItems" {
+			s.MinItems = &n
+		} else {
+			s.MaxItems = &n
+		}
+	case "items":
+		if _, ok := expr.(flimexpr.ListExpression); ok {
+			return fmt.Errorf("%s: arrays of item schemas are not supported", path)
+		}
+
+		items, err := i.schema(expr, path)
+		s.Items = items
+		return err
+	case "properties":
+		m, ok := expr.(flimexpr.MapExpression)
+
+		if !ok {
+			return fmt.Errorf("%s: expected an object", path)
+		}
+
+		entries, _ := effectiveEntries(m)
+
+		for _, name := range entries.keys {
+			keySchema, err := i.schema(entries.values[name], path.Child(name))
+
+			if err != nil {
+				return err
+			}
+
+			if i.shared[keySchema] {
+				keySchema = i.copy(keySchema, "")
+			}
+
+			keySchema.Optional = true
+			s.Keys = append(s.Keys, SchemaKey{name, keySchema})
+		}
+	case "additionalProperties":
+		if b, ok := expr.(flimexpr.BooleanLiteralExpression); ok {
+			s.ClosedKeys = !b.Value()
+			return nil
+		}
+
+		additional, err := i.schema(expr, path)
+		s.AdditionalKeys = additional
+		return err
+	case "oneOf":
+		list, ok := expr.(flimexpr.ListExpression)
+
+		if !ok {
+			return fmt.Errorf("%s: expected an array of schemas", path)
+		}
+
+		for n, listItem := range list.Items() {
+			alternative, err := i.schema(listItem, path.Child(n))
+
+			if err != nil {
+				return err
+			}
+
+			s.OneOf = append(s.OneOf, alternative)
+		}
+	default:
+		if !isJSONSchemaAnnotation(key) {
+			return fmt.Errorf("%s: unsupported JSON Schema keyword `%s'", path, key)
+		}
+	}
+
+	return nil
+}
+
+// copy returns a copy of a shared schema. The shared schema may not have
+// been read yet if it refers to itself, so the copy is only filled in once
+// it has.
+func (i *jsonSchemaImporter) copy(shared *Schema, description string) *Schema {
+	copied := &Schema{Description: description}
+	i.copies = append(i.copies, jsonSchemaCopy{copied, shared})
+	return copied
+}
+
+func isJSONSchemaAnnotation(key string) bool {
+	return strings.HasPrefix(key, "x-") || containsString(jsonSchemaAnnotations, key)
+}
+
+// bound reads minimum or maximum, which are written in flim for the x-flim
+// keywords.
+func (i *jsonSchemaImporter) bound(expr common.Expression, flimForm bool, path Path) (interface{}, error) {
+	if flimForm {
+		text, err := i.string(expr, path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if expr, err = ParseString(text, i.options...); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	val, err := common.Evaluate(expr, nil)
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if _, ok := schemaOrdered(val); !ok {
+		return nil, fmt.Errorf("%s: expected a number", path)
+	}
+
+	return val, nil
+}
+
+func (i *jsonSchemaImporter) string(expr common.Expression, path Path) (string, error) {
+	if str, ok := expr.(flimexpr.StringLiteralExpression); ok {
+		return str.Value(), nil
+	}
+
+	return "", fmt.Errorf("%s: expected a string", path)
+}
+
+// strings reads a string or an array of strings.
+func (i *jsonSchemaImporter) strings(expr common.Expression, path Path) ([]string, error) {
+	list, ok := expr.(flimexpr.ListExpression)
+
+	if !ok {
+		str, err := i.string(expr, path)
+		return []string{str}, err
+	}
+
+	strs := make([]string, len(list.Items()))
+
+	for n, listItem := range list.Items() {
+		var err error
+
+		if strs[n], err = i.string(listItem, path.Child(n)); err != nil {
+			return nil, err
+		}
+	}
+
+	return strs, nil
+}
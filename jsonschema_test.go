@@ -0,0 +1,194 @@
+package flim
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJSONSchema(t *testing.T) {
+	schema := parseTestSchema(t, `#port { type "int" min 1 max 65535 }
+{
+    keys {
+        port &port
+        timeout { type "duration" max 1m optional true }
+        hosts { items "string" min_items 1 }
+        labels { additional_keys "string" }
+        extra { keys { a "int" } additional_keys false }
+    }
+}`)
+
+	data, err := schema.JSONSchema()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got interface{}
+
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	var want interface{}
+
+	err = json.Unmarshal([]byte(`{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"type": "object",
+		"properties": {
+			"port": {"$ref": "#/$defs/port"},
+			"timeout": {
+				"type": "string",
+				"x-flim-type": "duration",
+				"pattern": "^-?(?:[0-9]+(?:\\.[0-9]+)?(?:ns|us|µs|μs|ms|s|m|h))+$",
+				"x-flim-maximum": "1m0s"
+			},
+			"hosts": {"type": "array", "minItems": 1, "items": {"type": "string"}},
+			"labels": {"type": "object", "additionalProperties": {"type": "string"}},
+			"extra": {
+				"type": "object",
+				"properties": {"a": {"type": "integer"}},
+				"required": ["a"],
+				"additionalProperties": false
+			}
+		},
+		"required": ["port", "hosts", "labels", "extra"],
+		"$defs": {"port": {"type": "integer", "minimum": 1, "maximum": 65535}}
+	}`), &want)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%s", data)
+	}
+}
+
+// A schema exported with JSONSchema and read back with ParseJSONSchema finds
+// the same violations in evaluated values.
+func TestJSONSchemaRoundTrip(t *testing.T) {
+	schema := parseTestSchema(t, serviceSchema)
+	data, err := schema.JSONSchema()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readBack, err := ParseJSONSchema(data)
+
+	if err != nil {
+		t.Fatalf("reading back %s: %s", data, err)
+	}
+
+	for _, text := range []string{
+		`{ name "svc" port 80 mode "safe" hosts [ "a" "b" ] timeout 5s backends [ { host "b" port 1 } ] }`,
+		`{ name "svc" port 80 hosts [ "a" ] timeout null }`,
+		`{ name 1 port "80" hosts [ "a" 2 ] }`,
+		`{ name "svc" }`,
+		`{ name "Svc" port 70000 mode "slow" hosts [] }`,
+		`{ name "svc" port 80 hosts [ "a" "b" "c" ] backends [ { host "b" port 1 } { host "c" port 0 weight 2 } ] }`,
+		`[ 1 ]`,
+	} {
+		t.Run(text, func(t *testing.T) {
+			expr, err := ParseString(text)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			val := evaluateDocument(t, expr)
+			want := violationStrings(schema.ValidateValue(val))
+
+			if got := violationStrings(readBack.ValidateValue(val)); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestParseJSONSchema(t *testing.T) {
+	schema, err := ParseJSONSchema([]byte(`{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"title": "ignored",
+		"type": "object",
+		"properties": {
+			"name": {"type": "string", "pattern": "^[a-z]+$"},
+			"mode": {"enum": ["fast", "safe"]},
+			"at": {"type": "string", "format": "date-time"},
+			"node": {"$ref": "#/$defs/node"},
+			"value": {"oneOf": [{"type": "integer"}, {"type": "string"}]},
+			"labels": {"type": "object", "additionalProperties": {"type": "string"}}
+		},
+		"required": ["name"],
+		"additionalProperties": false,
+		"$defs": {
+			"node": {
+				"type": "object",
+				"properties": {"children": {"type": "array", "items": {"$ref": "#/$defs/node"}}},
+				"additionalProperties": false
+			}
+		}
+	}`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		doc  string
+		want []string
+	}{
+		{`{ name "a" mode "fast" at 2024-01-02T03:04:05Z node { children [ { children [] } ] } value 1 labels { x "y" } }`, []string{}},
+		{`{ name "a" at "2024-01-02T03:04:05Z" value "v" }`, []string{}},
+		{`{ mode "slow" }`, []string{"mode: \"slow\" is not one of [\"fast\" \"safe\"]", ".: missing required key `name'"}},
+		{`{ name "a" node { children [ { other 1 } ] } }`, []string{"node.children[0].other: unexpected key `other'"}},
+		{`{ name "a" value true labels { x 1 } extra 1 }`, []string{
+			"extra: unexpected key `extra'",
+			"labels.x: expected string, found int",
+			"value: matches none of the one_of schemas",
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.doc, func(t *testing.T) {
+			expr, err := ParseString(test.doc)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := violationStrings(schema.ValidateValue(evaluateDocument(t, expr))); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseJSONSchemaErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		err    string
+	}{
+		{"not JSON", `{`, "json schema:"},
+		{"not a schema", `1`, "a schema must be an object or a boolean"},
+		{"unknown keyword", `{"type": "string", "minLength": 1}`, "unsupported JSON Schema keyword `minLength'"},
+		{"unknown type", `{"type": "widget"}`, "unknown type `widget'"},
+		{"external reference", `{"$ref": "other.json"}`, "only references within the document are supported"},
+		{"missing reference", `{"$ref": "#/$defs/missing"}`, "cannot follow `#/$defs/missing'"},
+		{"keyword beside a reference", `{"$ref": "#", "type": "object"}`, "`type' alongside $ref is not supported"},
+		{"false schema", `{"items": false}`, "the schema false is only supported as additionalProperties"},
+		{"tuple items", `{"items": [{"type": "string"}]}`, "arrays of item schemas are not supported"},
+		{"unknown flim type", `{"x-flim-type": "widget"}`, "[\"x-flim-type\"]: unknown type `widget'"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := ParseJSONSchema([]byte(test.schema)); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("got error %v, want one containing `%s'", err, test.err)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package flim
 import (
 	"fmt"
 	"regexp"
+	"strings"
 )
 
 type TokenDefinition struct {
@@ -15,6 +16,9 @@ var tokenDefintions []TokenDefinition
 type LexerToken struct {
 	Name     string
 	Contents string
+
+	// Offset is the byte offset of the token in the lexed text
+	Offset int
 }
 
 func (t LexerToken) IsOfType(names ...string) bool {
@@ -27,50 +31,102 @@ func (t LexerToken) IsOfType(names ...string) bool {
 	return false
 }
 
+// Lex splits text into tokens, without whitespace or comments. Documents with
+// custom literals are lexed with Parser.Lex instead.
 func Lex(text string) ([]LexerToken, error) {
+	return lex(text, tokenDefintions, isSignificant)
+}
+
+func isSignificant(name string) bool {
+	return name != "Whitespace" && name != "LineComment" && name != "Newline"
+}
+
+// Comments returns the span of each line comment in text, including its
+// slashes.
+func Comments(text string) ([]Span, error) {
+	tokens, err := lex(text, tokenDefintions, func(name string) bool {
+		return name == "LineComment"
+	})
+
+	spans := make([]Span, len(tokens))
+
+	for i, token := range tokens {
+		spans[i] = Span{token.Offset, token.Offset + len(token.Contents)}
+	}
+
+	return spans, err
+}
+
+// lex splits text into tokens using definitions, keeping those whose names
+// keep accepts. At each point the longest match is taken, so that a keyword
+// like nullable is not read as null followed by able, and ties go to the
+// definition tried first, so that 10ms is a duration rather than a keyword.
+func lex(text string, definitions []TokenDefinition, keep func(name string) bool) ([]LexerToken, error) {
 	tokens := []LexerToken{}
-	var found bool
 	offset := 0
 
 	for len(text) > 0 {
-		found = false
+		var longest *TokenDefinition
+		length := 0
 
-		for _, tokenDefinition := range tokenDefintions {
-			match := tokenDefinition.Pattern.FindStringSubmatchIndex(text)
+		for i, tokenDefinition := range definitions {
+			match := tokenDefinition.Pattern.FindStringIndex(text)
 
-			if match == nil {
-				continue
+			// An empty match would never consume any input
+			if match != nil && match[1] > length {
+				longest = &definitions[i]
+				length = match[1]
 			}
+		}
 
-			found = true
-			contents := text[match[0]:match[1]]
-			token := LexerToken{Name: tokenDefinition.Name, Contents: contents}
-			offset += match[1]
-			text = text[match[1]:]
-
-			if tokenDefinition.Name != "Whitespace" && tokenDefinition.Name != "LineComment" && tokenDefinition.Name != "Newline" {
-				tokens = append(tokens, token)
-			}
+		if longest == nil {
+			return tokens, &SyntaxError{Offset: offset, Err: fmt.Errorf("could not find token matching %s", lexSnippet(text))}
 		}
 
-		if !found {
-			return tokens, fmt.Errorf("could not find token matching %s", text)
+		if keep(longest.Name) {
+			tokens = append(tokens, LexerToken{Name: longest.Name, Contents: text[:length], Offset: offset})
 		}
+
+		offset += length
+		text = text[length:]
 	}
 
 	return tokens, nil
 }
 
+// lexSnippetLength is the most runes of unlexable text an error quotes.
+const lexSnippetLength = 20
+
+// lexSnippet quotes the start of text that could not be lexed, up to the end
+// of its word and no longer than lexSnippetLength, so that an error does not
+// hold the rest of the document.
+func lexSnippet(text string) string {
+	word := text
+
+	if end := strings.IndexAny(text[1:], " \t\r\n"); end >= 0 {
+		word = text[:end+1]
+	}
+
+	if runes := []rune(word); len(runes) > lexSnippetLength {
+		return fmt.Sprintf("%q...", string(runes[:lexSnippetLength]))
+	}
+
+	return fmt.Sprintf("%q", word)
+}
+
 func init() {
 	tokenDefintions = []TokenDefinition{
 		{"Newline", *regexp.MustCompile(`^\n`)},
 		{"LineComment", *regexp.MustCompile(`^//[^\n]+`)},
-		{"Float", *regexp.MustCompile(`^-?\d*\.\d+`)},
-		{"Integer", *regexp.MustCompile(`^-?\d+`)},
+		{"Timestamp", *regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:Z|[+-]\d{2}:\d{2})`)},
+		{"Duration", *regexp.MustCompile(`^-?(?:\d+(?:\.\d+)?(?:ns|us|µs|μs|ms|s|m|h))+`)},
+		{"Size", *regexp.MustCompile(`^\d+(?:\.\d+)?(?:[KMGTPE]iB|[kKMGTPE]B|B)`)},
+		{"Float", *regexp.MustCompile(`^(?:-?(?:\d(?:_?\d)*\.(?:\d(?:_?\d)*)?|\.\d(?:_?\d)*)(?:[eE][+-]?\d(?:_?\d)*)?|-?\d(?:_?\d)*[eE][+-]?\d(?:_?\d)*|-?\.inf|\.nan)`)},
+		{"Integer", *regexp.MustCompile(`^-?(?:0[xX][0-9a-fA-F](?:_?[0-9a-fA-F])*|0[oO][0-7](?:_?[0-7])*|0[bB][01](?:_?[01])*|\d(?:_?\d)*)`)},
 		{"Boolean", *regexp.MustCompile(`^(true|false)`)},
 		{"Null", *regexp.MustCompile(`^null`)},
 		{"Keyword", *regexp.MustCompile(`^[\w_]+`)},
-		{"String", *regexp.MustCompile(`^\".+?\"`)},
+		{"String", *regexp.MustCompile(`^(?:"(?:[^"\\\n]|\\.)*"|'(?:[^'\\\n]|\\.)*')`)},
 		{"Star", *regexp.MustCompile(`^\*`)},
 		{"Pound", *regexp.MustCompile(`^#`)},
 		{"Ampersand", *regexp.MustCompile(`^&`)},
@@ -0,0 +1,190 @@
+package flim
+
+import (
+	"errors"
+	"fmt"
+	"github.com/l-donovan/flim/common"
+	"strings"
+	"testing"
+)
+
+func TestLexKeywordBoundaries(t *testing.T) {
+	tests := []struct {
+		text string
+		name string
+	}{
+		// Keywords that start with a boolean
+		{"trueish", "Keyword"},
+		{"true_value", "Keyword"},
+		{"truer", "Keyword"},
+		{"true1", "Keyword"},
+		{"falsey", "Keyword"},
+		{"false_positive", "Keyword"},
+		{"falsehood", "Keyword"},
+
+		// Keywords that start with null
+		{"nullable", "Keyword"},
+		{"null_ok", "Keyword"},
+		{"nullify", "Keyword"},
+		{"nulls", "Keyword"},
+		{"null2", "Keyword"},
+
+		// Keywords that start with an integer, in every base
+		{"2fa", "Keyword"},
+		{"123abc", "Keyword"},
+		{"1_000_items", "Keyword"},
+		{"0x1fg", "Keyword"},
+		{"0o17z", "Keyword"},
+		{"0b101x", "Keyword"},
+		{"0xdeadbeefs", "Keyword"},
+
+		// Keywords that start with a float
+		{"1e5x", "Keyword"},
+		{"2E10_max", "Keyword"},
+
+		// Keywords that start with a duration
+		{"1hour", "Keyword"},
+		{"5min", "Keyword"},
+		{"3sec", "Keyword"},
+		{"10ms_timeout", "Keyword"},
+		{"4us_x", "Keyword"},
+		{"7ns2", "Keyword"},
+		{"1h30m_left", "Keyword"},
+
+		// Keywords that start with a size
+		{"1Bit", "Keyword"},
+		{"4KBytes", "Keyword"},
+		{"2MiB_max", "Keyword"},
+		{"8GBx", "Keyword"},
+
+		// Literals on their own
+		{"true", "Boolean"},
+		{"false", "Boolean"},
+		{"null", "Null"},
+		{"123", "Integer"},
+		{"1_000", "Integer"},
+		{"0x1F", "Integer"},
+		{"0o17", "Integer"},
+		{"0b101", "Integer"},
+		{"1e5", "Float"},
+		{"1.5", "Float"},
+		{"10ms", "Duration"},
+		{"1h30m", "Duration"},
+		{"10KiB", "Size"},
+		{"4KB", "Size"},
+		{"2024-01-02T03:04:05Z", "Timestamp"},
+		{`"true"`, "String"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			tokens, err := Lex(test.text)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(tokens) != 1 || !tokens[0].IsOfType(test.name) || tokens[0].Contents != test.text {
+				t.Fatalf("`%s' was lexed as %v, expected a single %s", test.text, tokens, test.name)
+			}
+
+			if test.name != "Keyword" {
+				return
+			}
+
+			// As a key, the keyword must survive parsing and serializing
+			expr, err := ParseString(fmt.Sprintf("{ %s true other null }", test.text))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			serialized, err := common.SerializeWith(expr)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			reparsed, err := ParseString(serialized)
+
+			if err != nil {
+				t.Fatalf("serialized as `%s': %s", serialized, err)
+			}
+
+			val, err := common.Evaluate(reparsed, nil)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			fields := val.(map[string]interface{})
+
+			if len(fields) != 2 || fields[test.text] != true {
+				t.Fatalf("`%s' as a map key evaluated to %v", test.text, val)
+			}
+		})
+	}
+}
+
+func TestLexQuotedLiteralKeys(t *testing.T) {
+	for _, key := range []string{"true", "false", "null"} {
+		t.Run(key, func(t *testing.T) {
+			expr, err := ParseString(fmt.Sprintf("{ %q true }", key))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			serialized, err := common.SerializeWith(expr)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			reparsed, err := ParseString(serialized)
+
+			if err != nil {
+				t.Fatalf("serialized as `%s': %s", serialized, err)
+			}
+
+			val, err := common.Evaluate(reparsed, nil)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if fields := val.(map[string]interface{}); len(fields) != 1 || fields[key] != true {
+				t.Fatalf("`%s' as a map key evaluated to %v", key, val)
+			}
+		})
+	}
+}
+
+// Errors for text that cannot be lexed quote a short piece of it, not the
+// rest of the document.
+func TestLexErrorSnippet(t *testing.T) {
+	rest := "\n{ a 1 }\n" + strings.Repeat("// padding\n", 100)
+
+	tests := []struct {
+		text string
+		err  string
+	}{
+		{"{ a $b }" + rest, "could not find token matching \"$b\""},
+		{"{ a $" + strings.Repeat("x", 50) + " }" + rest, "could not find token matching \"$xxxxxxxxxxxxxxxxxxx\"..."},
+		{"{ a $\n}" + rest, "could not find token matching \"$\""},
+	}
+
+	for _, test := range tests {
+		_, err := NewParser().Lex(test.text)
+
+		if err == nil || err.Error() != test.err {
+			t.Errorf("got error %v, want `%s'", err, test.err)
+		}
+
+		var syntaxErr *SyntaxError
+
+		if !errors.As(err, &syntaxErr) || syntaxErr.Offset != 4 {
+			t.Errorf("got %#v, want a syntax error at offset 4", err)
+		}
+	}
+}
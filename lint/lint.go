@@ -0,0 +1,283 @@
+// Package lint checks flim documents for mistakes that still parse, like
+// tags that are never used or keys whose values are silently replaced.
+//
+// Each check is a Rule. Problems can be suppressed with a comment naming the
+// rules to ignore, either for the line it is written on, or the next line if
+// it is on a line of its own:
+//
+//	// lint:ignore unused-tag kept for the schema
+//	#legacy { ... }
+//
+// or for the whole document:
+//
+//	// lint:file-ignore empty-collection
+//
+// Several rules can be named at once, separated by commas. Anything after
+// them is ignored, and can be used to say why.
+package lint
+
+import (
+	"fmt"
+	"github.com/l-donovan/flim"
+	"github.com/l-donovan/flim/common"
+	flimexpr "github.com/l-donovan/flim/expressions"
+	"sort"
+	"strings"
+)
+
+// Problem is something a rule found in a document.
+type Problem struct {
+	Rule     string
+	Span     flim.Span
+	Position flim.Position
+	Message  string
+}
+
+// String describes the problem, e.g. `3:5: tag `port' is never used
+// (unused-tag)`.
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s (%s)", p.Position, p.Message, p.Rule)
+}
+
+// A Rule checks a document for one kind of problem, reporting what it finds
+// through the pass.
+type Rule interface {
+	// Name is what the rule is called in suppression comments and on the
+	// command line, e.g. unused-tag.
+	Name() string
+	Check(pass *Pass)
+}
+
+// Pass is a document being checked by a rule.
+type Pass struct {
+	Source string
+
+	// Tokens holds the document's tokens, without whitespace or comments
+	Tokens []flim.LexerToken
+
+	// Expression is the parsed document, with its references left
+	// unresolved, and SourceMap where its expressions were written. Both are
+	// nil if the document could not be parsed, in which case only rules that
+	// look at Tokens can find anything.
+	Expression common.Expression
+	SourceMap  *flim.SourceMap
+
+	rule     string
+	problems []Problem
+
+	// lookup finds where a path in the top-level expression being inspected
+	// was written, or is nil if it is not in the source map
+	lookup func(path flim.Path) (flim.SourceNode, bool)
+}
+
+// Reportf reports a problem found at span.
+func (p *Pass) Reportf(span flim.Span, format string, args ...interface{}) {
+	p.problems = append(p.problems, Problem{
+		Rule:     p.rule,
+		Span:     span,
+		Position: flim.PositionOf(p.Source, span.Start),
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// Inspect calls f for each node of the document as flim.Inspect does,
+// keeping track of the top-level expression it is in so that SourceOf can
+// find where the nodes were written.
+func (p *Pass) Inspect(f func(node *flim.Node) bool) {
+	if p.Expression == nil {
+		return
+	}
+
+	last := -1
+
+	if fileExpr, ok := p.Expression.(flimexpr.FileExpression); ok {
+		last = len(fileExpr.Expressions()) - 1
+	}
+
+	top := -1
+	p.lookup = p.SourceMap.Lookup
+
+	flim.Inspect(p.Expression, func(node *flim.Node) bool {
+		if node != nil && node.Parent != nil && node.Parent.Kind == flim.FileNode {
+			top++
+			p.lookup = nil
+
+			if tagged, ok := node.Expression.(flimexpr.TaggedExpression); ok {
+				tag := tagged.Tag()
+
+				p.lookup = func(path flim.Path) (flim.SourceNode, bool) {
+					return p.SourceMap.LookupTag(tag, path)
+				}
+			} else if top == last {
+				// Only the last untagged top-level expression, the document's
+				// value, is kept in the source map
+				p.lookup = p.SourceMap.Lookup
+			}
+		}
+
+		return f(node)
+	})
+
+	p.lookup = nil
+}
+
+// SourceOf returns where a node met by Inspect was written. Nodes inside an
+// expansion share their path with the map they are expanded into, and so are
+// not found.
+func (p *Pass) SourceOf(node *flim.Node) (flim.SourceNode, bool) {
+	if p.lookup == nil {
+		return flim.SourceNode{}, false
+	}
+
+	for n := node; n.Parent != nil; n = n.Parent {
+		if n.Kind == flim.ExpandingNode {
+			return flim.SourceNode{}, false
+		}
+
+		// Pairs, list items and top-level expressions start a new path
+		if n.Parent.Kind == flim.MapNode || n.Parent.Kind == flim.ListNode || n.Parent.Kind == flim.FileNode {
+			break
+		}
+	}
+
+	return p.lookup(node.Path)
+}
+
+// Lint checks source with each of the rules, returning the problems they
+// found in the order they appear in the document, less any that are
+// suppressed. If source cannot be parsed, the error is returned along with
+// whatever the rules that look at its tokens found.
+func Lint(source string, rules []Rule, options ...flim.ParserOption) ([]Problem, error) {
+	sourceMap := &flim.SourceMap{}
+	parser := flim.NewParser(append(append([]flim.ParserOption{}, options...), flim.WithSourceMap(sourceMap))...)
+	tokens, err := parser.Lex(source)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pass := &Pass{Source: source, Tokens: tokens}
+	expr, parseErr := parser.Parse(tokens)
+
+	if parseErr == nil {
+		pass.Expression = expr
+		pass.SourceMap = sourceMap
+	}
+
+	for _, rule := range rules {
+		pass.rule = rule.Name()
+		rule.Check(pass)
+	}
+
+	problems, err := suppress(source, pass.problems)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].Span.Start < problems[j].Span.Start
+	})
+
+	return problems, parseErr
+}
+
+// suppression is what a lint:ignore or lint:file-ignore comment suppresses.
+type suppression struct {
+	rules map[string]bool
+
+	// line is the line the suppression applies to, or 0 for the whole
+	// document
+	line int
+}
+
+// suppressions finds the suppression comments in source.
+func suppressions(source string) ([]suppression, error) {
+	comments, err := flim.Comments(source)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var result []suppression
+
+	for _, comment := range comments {
+		text := strings.TrimSpace(strings.TrimPrefix(comment.Text(source), "//"))
+		directive, rest, _ := strings.Cut(text, " ")
+		fields := strings.Fields(rest)
+
+		if len(fields) == 0 {
+			continue
+		}
+
+		s := suppression{rules: map[string]bool{}}
+
+		for _, name := range strings.Split(fields[0], ",") {
+			s.rules[name] = true
+		}
+
+		switch directive {
+		case "lint:file-ignore":
+		case "lint:ignore":
+			s.line = flim.PositionOf(source, comment.Start).Line
+			lineStart := strings.LastIndexByte(source[:comment.Start], '\n') + 1
+
+			// A comment on a line of its own applies to the next line that
+			// is not blank or another comment
+			if strings.TrimSpace(source[lineStart:comment.Start]) == "" {
+				s.line = nextCodeLine(source, comment.End)
+			}
+		default:
+			continue
+		}
+
+		result = append(result, s)
+	}
+
+	return result, nil
+}
+
+// nextCodeLine returns the number of the first line after offset that holds
+// anything besides whitespace and comments.
+func nextCodeLine(source string, offset int) int {
+	line := flim.PositionOf(source, offset).Line
+
+	for _, text := range strings.Split(source[offset:], "\n")[1:] {
+		line++
+		text = strings.TrimSpace(text)
+
+		if text != "" && !strings.HasPrefix(text, "//") {
+			return line
+		}
+	}
+
+	return line
+}
+
+func suppress(source string, problems []Problem) ([]Problem, error) {
+	suppressed, err := suppressions(source)
+
+	if err != nil {
+		return nil, err
+	}
+
+	kept := []Problem{}
+
+	for _, problem := range problems {
+		if !isSuppressed(problem, suppressed) {
+			kept = append(kept, problem)
+		}
+	}
+
+	return kept, nil
+}
+
+func isSuppressed(problem Problem, suppressed []suppression) bool {
+	for _, s := range suppressed {
+		if s.rules[problem.Rule] && (s.line == 0 || s.line == problem.Position.Line) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,130 @@
+package lint
+
+import (
+	"github.com/l-donovan/flim/common"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func problemStrings(problems []Problem) []string {
+	strs := make([]string, len(problems))
+
+	for i, problem := range problems {
+		strs[i] = problem.String()
+	}
+
+	return strs
+}
+
+func TestRules(t *testing.T) {
+	handlers := map[string]common.HandlerFunc{
+		"env": func(data interface{}) (interface{}, error) { return data, nil },
+	}
+
+	tests := []struct {
+		name   string
+		rule   Rule
+		source string
+		want   []string
+	}{
+		{
+			"unused tag",
+			UnusedTags(),
+			"#used 1\n#unused 2\n#doc { a &used }\n",
+			[]string{"2:1: tag `unused' is never used (unused-tag)"},
+		},
+		{
+			"forward reference",
+			ForwardReferences(),
+			"#top 1\n{\n    a &inner\n    b #inner 2\n    c &top\n}\n",
+			[]string{"3:7: tag `inner' is used before it is defined at 4:7 (forward-reference)"},
+		},
+		{
+			"unknown transformer",
+			UnknownTransformers(handlers),
+			"{\n    a env \"A\"\n    b lookup \"B\"\n}\n",
+			[]string{"3:7: unknown transformer `lookup' (unknown-transformer)"},
+		},
+		{
+			"shadowed keys",
+			ShadowedKeys(),
+			"#base { a 1 b 2 }\n{\n    a 0\n    *&base\n    b 3\n    c 1\n    c 2\n}\n",
+			[]string{
+				"3:5: key `a' is replaced by the expansion at 4:5 (shadowed-key)",
+				"6:5: key `c' is replaced by the pair at 7:5 (shadowed-key)",
+			},
+		},
+		{
+			"empty collections",
+			EmptyCollections(),
+			"{\n    a {}\n    b [ 1 ]\n    c []\n}\n",
+			[]string{"2:7: empty map (empty-collection)", "4:7: empty list (empty-collection)"},
+		},
+		{
+			"split keyword",
+			SplitKeywords(),
+			"{ timeout 1.5sec 1 }\n",
+			[]string{"1:11: `1.5sec' is read as the duration 1.5s followed by the keyword `ec' (split-keyword)"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			problems, err := Lint(test.source, []Rule{test.rule})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := problemStrings(problems); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSuppression(t *testing.T) {
+	source := `// lint:file-ignore empty-collection
+// lint:ignore unused-tag kept for the schema
+
+#legacy 1
+#other 2 // lint:ignore unused-tag,shadowed-key
+#third 3
+{
+    a []
+}
+`
+
+	problems, err := Lint(source, DefaultRules())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"6:1: tag `third' is never used (unused-tag)"}
+
+	if got := problemStrings(problems); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// A document that cannot be parsed is still checked by rules that look at
+// its tokens.
+func TestLintParseError(t *testing.T) {
+	problems, err := Lint("{ a 1.5sec", DefaultRules())
+
+	if err == nil {
+		t.Fatal("expected a parse error")
+	}
+
+	want := []string{"1:5: `1.5sec' is read as the duration 1.5s followed by the keyword `ec' (split-keyword)"}
+
+	if got := problemStrings(problems); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, err := Lint("{ a $x }", DefaultRules()); err == nil || !strings.Contains(err.Error(), "could not find token") {
+		t.Errorf("got error %v, want one containing `could not find token'", err)
+	}
+}
@@ -0,0 +1,365 @@
+package lint
+
+import (
+	"github.com/l-donovan/flim"
+	"github.com/l-donovan/flim/common"
+	flimexpr "github.com/l-donovan/flim/expressions"
+	"strings"
+)
+
+// DefaultRules returns every built-in rule that needs no configuration.
+func DefaultRules() []Rule {
+	return []Rule{
+		UnusedTags(),
+		ForwardReferences(),
+		ShadowedKeys(),
+		EmptyCollections(),
+		SplitKeywords(),
+	}
+}
+
+type unusedTags struct{}
+
+// UnusedTags reports tags that are never referred to. A tag on the
+// document's value is left alone, as it names the document rather than
+// being there to be referred to.
+func UnusedTags() Rule {
+	return unusedTags{}
+}
+
+func (unusedTags) Name() string {
+	return "unused-tag"
+}
+
+func (unusedTags) Check(pass *Pass) {
+	if pass.SourceMap == nil {
+		return
+	}
+
+	used := map[string]bool{}
+
+	for _, ref := range pass.SourceMap.References {
+		used[ref.Name] = true
+	}
+
+	if fileExpr, ok := pass.Expression.(flimexpr.FileExpression); ok {
+		if exprs := fileExpr.Expressions(); len(exprs) > 0 {
+			if tagged, ok := exprs[len(exprs)-1].(flimexpr.TaggedExpression); ok {
+				used[tagged.Tag()] = true
+			}
+		}
+	}
+
+	for name, span := range pass.SourceMap.Tags {
+		if !used[name] {
+			pass.Reportf(flim.Span{Start: span.Start - 1, End: span.End}, "tag `%s' is never used", name)
+		}
+	}
+}
+
+type forwardReferences struct{}
+
+// ForwardReferences reports references written before the tag they refer
+// to. Tags are found wherever they are in a document, but once converted to
+// YAML, where a tag becomes an anchor and a reference an alias, the anchor
+// must come first. Top-level tagged expressions are left alone, as they are
+// anchored wherever they are first used.
+func ForwardReferences() Rule {
+	return forwardReferences{}
+}
+
+func (forwardReferences) Name() string {
+	return "forward-reference"
+}
+
+func (forwardReferences) Check(pass *Pass) {
+	if pass.SourceMap == nil {
+		return
+	}
+
+	topLevel := map[string]bool{}
+
+	if fileExpr, ok := pass.Expression.(flimexpr.FileExpression); ok {
+		for _, expr := range fileExpr.Expressions() {
+			if tagged, ok := expr.(flimexpr.TaggedExpression); ok {
+				topLevel[tagged.Tag()] = true
+			}
+		}
+	}
+
+	for _, ref := range pass.SourceMap.References {
+		tagSpan, exists := pass.SourceMap.Tags[ref.Name]
+
+		if exists && !topLevel[ref.Name] && ref.Span.Start < tagSpan.Start {
+			pass.Reportf(flim.Span{Start: ref.Span.Start - 1, End: ref.Span.End}, "tag `%s' is used before it is defined at %s", ref.Name, flim.PositionOf(pass.Source, tagSpan.Start-1))
+		}
+	}
+}
+
+type unknownTransformers struct {
+	handlers map[string]common.HandlerFunc
+}
+
+// UnknownTransformers reports transformers that have no handler in handlers,
+// the handlers the document will be evaluated with.
+func UnknownTransformers(handlers map[string]common.HandlerFunc) Rule {
+	return unknownTransformers{handlers}
+}
+
+func (unknownTransformers) Name() string {
+	return "unknown-transformer"
+}
+
+func (r unknownTransformers) Check(pass *Pass) {
+	pass.Inspect(func(node *flim.Node) bool {
+		if node == nil {
+			return false
+		}
+
+		var name string
+
+		switch e := node.Expression.(type) {
+		case flimexpr.TransformerExpression:
+			name = e.Name()
+		case flimexpr.MappedTransformerExpression:
+			name = e.Name()
+		default:
+			return true
+		}
+
+		if _, exists := r.handlers[name]; !exists {
+			pass.Reportf(transformerSpan(pass, node, name), "unknown transformer `%s'", name)
+		}
+
+		return true
+	})
+}
+
+// transformerSpan finds the name of a transformer in the source, as the
+// source map does not record where transformers are.
+func transformerSpan(pass *Pass, node *flim.Node, name string) flim.Span {
+	within := flim.Span{Start: 0, End: len(pass.Source)}
+
+	for n := node; n != nil; n = n.Parent {
+		if source, found := pass.SourceOf(n); found {
+			within = source.Value
+			break
+		}
+	}
+
+	for i, token := range pass.Tokens {
+		if token.Offset < within.Start || token.Offset >= within.End {
+			continue
+		}
+
+		// Names after a pound sign or ampersand are tags and references
+		if token.IsOfType("Keyword") && token.Contents == name && (i == 0 || !pass.Tokens[i-1].IsOfType("Pound", "Ampersand")) {
+			return flim.Span{Start: token.Offset, End: token.Offset + len(token.Contents)}
+		}
+	}
+
+	return flim.Span{Start: within.Start, End: within.Start}
+}
+
+type shadowedKeys struct{}
+
+// ShadowedKeys reports map keys whose values are replaced by a later pair or
+// expansion in the same map, once expansions of maps and references to them
+// are expanded. Replacing a key that an earlier expansion brought in is what
+// expansions are for, and is left alone.
+func ShadowedKeys() Rule {
+	return shadowedKeys{}
+}
+
+func (shadowedKeys) Name() string {
+	return "shadowed-key"
+}
+
+func (shadowedKeys) Check(pass *Pass) {
+	if pass.Expression == nil {
+		return
+	}
+
+	tags := pass.Expression.GetTags()
+
+	pass.Inspect(func(node *flim.Node) bool {
+		if node == nil {
+			return false
+		}
+
+		mapExpr, ok := node.Expression.(flimexpr.MapExpression)
+
+		if !ok {
+			return true
+		}
+
+		source, found := pass.SourceOf(node)
+		pairs := mapExpr.Pairs()
+
+		if !found || len(source.Entries) != len(pairs) {
+			return true
+		}
+
+		// Each key is set by the entry at an index, which is an expansion or
+		// a pair
+		setBy := map[string]int{}
+		expanded := map[string]bool{}
+
+		for i, pairExpr := range pairs {
+			var keys []string
+
+			switch e := pairExpr.(type) {
+			case flimexpr.PairExpression:
+				keys = []string{e.Key()}
+			case flimexpr.ExpandingExpression:
+				keys = expandedKeys(e.Expression(), tags, map[string]bool{})
+			}
+
+			_, expansion := pairExpr.(flimexpr.ExpandingExpression)
+			at := flim.PositionOf(pass.Source, source.Entries[i].Start)
+
+			for _, key := range keys {
+				if previous, exists := setBy[key]; exists {
+					switch {
+					case !expanded[key] && expansion:
+						pass.Reportf(source.Entries[previous], "key `%s' is replaced by the expansion at %s", key, at)
+					case !expanded[key]:
+						pass.Reportf(source.Entries[previous], "key `%s' is replaced by the pair at %s", key, at)
+					case expansion:
+						pass.Reportf(source.Entries[previous], "key `%s' from this expansion is replaced by the expansion at %s", key, at)
+					}
+				}
+
+				setBy[key] = i
+				expanded[key] = expansion
+			}
+		}
+
+		return true
+	})
+}
+
+// expandedKeys returns the keys of the map an expansion expands, in order,
+// or nothing if they cannot be known without evaluating it.
+func expandedKeys(expr common.Expression, tags map[string]common.Expression, seen map[string]bool) []string {
+	switch e := expr.(type) {
+	case flimexpr.ReferenceExpression:
+		target, exists := tags[e.Name()]
+
+		if !exists || seen[e.Name()] {
+			return nil
+		}
+
+		seen[e.Name()] = true
+		return expandedKeys(target, tags, seen)
+	case flimexpr.TaggedExpression:
+		return expandedKeys(e.Expression(), tags, seen)
+	case flimexpr.MapExpression:
+		var keys []string
+		found := map[string]bool{}
+
+		for _, pairExpr := range e.Pairs() {
+			var pairKeys []string
+
+			switch pair := pairExpr.(type) {
+			case flimexpr.PairExpression:
+				pairKeys = []string{pair.Key()}
+			case flimexpr.ExpandingExpression:
+				pairKeys = expandedKeys(pair.Expression(), tags, seen)
+			}
+
+			for _, key := range pairKeys {
+				if !found[key] {
+					found[key] = true
+					keys = append(keys, key)
+				}
+			}
+		}
+
+		return keys
+	default:
+		return nil
+	}
+}
+
+type emptyCollections struct{}
+
+// EmptyCollections reports maps and lists with nothing in them, which are
+// often left behind by mistake.
+func EmptyCollections() Rule {
+	return emptyCollections{}
+}
+
+func (emptyCollections) Name() string {
+	return "empty-collection"
+}
+
+func (emptyCollections) Check(pass *Pass) {
+	pass.Inspect(func(node *flim.Node) bool {
+		if node == nil {
+			return false
+		}
+
+		var kind string
+
+		switch e := node.Expression.(type) {
+		case flimexpr.MapExpression:
+			if len(e.Pairs()) > 0 {
+				return true
+			}
+
+			kind = "map"
+		case flimexpr.ListExpression:
+			if len(e.Items()) > 0 {
+				return true
+			}
+
+			kind = "list"
+		default:
+			return true
+		}
+
+		if source, found := pass.SourceOf(node); found && source.Container {
+			// The body is between the brackets
+			pass.Reportf(flim.Span{Start: source.Body.Start - 1, End: source.Body.End + 1}, "empty %s", kind)
+		}
+
+		return true
+	})
+}
+
+type splitKeywords struct{}
+
+// SplitKeywords reports words the lexer read as a literal followed by a
+// keyword, like 1.5sec, read as the duration 1.5s and then ec. Keywords are
+// read whole, but a literal can end partway through a word that is not a
+// keyword because it has a dot in it. Such a word in a map usually fails to
+// parse, but can also be read as a value followed by the next key.
+func SplitKeywords() Rule {
+	return splitKeywords{}
+}
+
+func (splitKeywords) Name() string {
+	return "split-keyword"
+}
+
+func (splitKeywords) Check(pass *Pass) {
+	for i := 0; i+1 < len(pass.Tokens); i++ {
+		token, next := pass.Tokens[i], pass.Tokens[i+1]
+
+		if !token.IsOfType("Boolean", "Null", "Integer", "Float", "Duration", "Size", "Timestamp") || !next.IsOfType("Keyword") {
+			continue
+		}
+
+		// Only tokens written without anything between them are one word
+		if token.Offset+len(token.Contents) != next.Offset {
+			continue
+		}
+
+		pass.Reportf(
+			flim.Span{Start: token.Offset, End: next.Offset + len(next.Contents)},
+			"`%s%s' is read as the %s %s followed by the keyword `%s'",
+			token.Contents, next.Contents, strings.ToLower(token.Name), token.Contents, next.Contents,
+		)
+	}
+}
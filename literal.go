@@ -0,0 +1,99 @@
+package flim
+
+import (
+	"fmt"
+	"github.com/l-donovan/flim/common"
+	flimexpr "github.com/l-donovan/flim/expressions"
+	"regexp"
+)
+
+// LiteralKind describes an application-defined literal type, such as an IP
+// address or a semantic version, that is validated as the file is parsed.
+type LiteralKind struct {
+	// Name identifies the literal kind and is used as its token name.
+	Name string
+
+	// Pattern is a regular expression matching the literal at the start of
+	// the remaining input. The longest match among the custom and built-in
+	// tokens is taken, with custom patterns winning ties, so
+	// they must not match whole keywords that are meant as map keys.
+	Pattern string
+
+	// Parse converts the matched text into the value the literal evaluates to.
+	Parse func(text string) (interface{}, error)
+
+	// Serialize converts a value produced by Parse back into text matching
+	// Pattern.
+	Serialize func(val interface{}) (string, error)
+}
+
+// Literals is a set of literal kinds, given to the parser with WithLiterals.
+// A nil *Literals holds none.
+type Literals struct {
+	kinds       map[string]LiteralKind
+	definitions []TokenDefinition
+}
+
+func NewLiterals() *Literals {
+	return &Literals{kinds: map[string]LiteralKind{}}
+}
+
+// Register adds a literal kind to the set. It must not be called while the
+// set is being used to parse a document.
+func (l *Literals) Register(kind LiteralKind) error {
+	if kind.Name == "" || kind.Pattern == "" || kind.Parse == nil || kind.Serialize == nil {
+		return fmt.Errorf("literal kind must have a name, pattern, parse and serialize function")
+	}
+
+	for _, tokenDefinition := range tokenDefintions {
+		if tokenDefinition.Name == kind.Name {
+			return fmt.Errorf("literal kind `%s' conflicts with a built-in token", kind.Name)
+		}
+	}
+
+	pattern, err := regexp.Compile(`^(?:` + kind.Pattern + `)`)
+
+	if err != nil {
+		return err
+	}
+
+	if _, exists := l.kinds[kind.Name]; exists {
+		return fmt.Errorf("literal kind `%s' is already registered", kind.Name)
+	}
+
+	l.kinds[kind.Name] = kind
+	l.definitions = append(l.definitions, TokenDefinition{Name: kind.Name, Pattern: *pattern})
+
+	return nil
+}
+
+func (l *Literals) lookup(name string) (LiteralKind, bool) {
+	if l == nil {
+		return LiteralKind{}, false
+	}
+
+	kind, exists := l.kinds[name]
+	return kind, exists
+}
+
+// tokenDefinitions returns the set's literal definitions followed by the
+// built-in ones, in the order they should be tried.
+func (l *Literals) tokenDefinitions() []TokenDefinition {
+	if l == nil {
+		return tokenDefintions
+	}
+
+	definitions := make([]TokenDefinition, 0, len(l.definitions)+len(tokenDefintions))
+	definitions = append(definitions, l.definitions...)
+	return append(definitions, tokenDefintions...)
+}
+
+func parseCustomLiteral(kind LiteralKind, text string) (common.Expression, error) {
+	val, err := kind.Parse(text)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s literal %s: %w", kind.Name, text, err)
+	}
+
+	return flimexpr.NewCustomLiteralExpression(kind.Name, val, kind.Serialize)
+}
@@ -1,27 +1,236 @@
 package flim
 
 import (
+	"errors"
 	"fmt"
 	"github.com/l-donovan/flim/common"
 	flimexpr "github.com/l-donovan/flim/expressions"
+	"math"
+	"math/big"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type Parser struct {
-	tokens []LexerToken
+	tokens     []LexerToken
+	bigNumbers bool
+	literals   *Literals
+
+	// sources, if set, records where expressions were written. path is the
+	// path of the expression being parsed, and start and end are the offsets
+	// of the last token popped and just past it.
+	sources *SourceMap
+	scope   string
+	path    Path
+	start   int
+	end     int
 }
 
+// SyntaxError is an error in the text of a document, found by Lex or Parse.
+type SyntaxError struct {
+	// Offset is the byte offset in the document the error was found at.
+	Offset int
+	Err    error
+}
+
+func (e *SyntaxError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return e.Err
+}
+
+type ParserOption func(*Parser)
+
+// WithBigNumbers parses every integer as a *big.Int and every decimal as a
+// *big.Float, so that values beyond the range or precision of int64 and
+// float64 are kept exactly.
+func WithBigNumbers() ParserOption {
+	return func(p *Parser) {
+		p.bigNumbers = true
+	}
+}
+
+// WithLiterals reads the literal kinds in literals, which must be lexed with
+// Parser.Lex.
+func WithLiterals(literals *Literals) ParserOption {
+	return func(p *Parser) {
+		p.literals = literals
+	}
+}
+
+func NewParser(options ...ParserOption) *Parser {
+	parser := &Parser{}
+
+	for _, option := range options {
+		option(parser)
+	}
+
+	return parser
+}
+
+// Lex splits text into tokens as Lex does, along with the literal kinds the
+// parser was given.
+func (p *Parser) Lex(text string) ([]LexerToken, error) {
+	return lex(text, p.literals.tokenDefinitions(), isSignificant)
+}
+
+// popToken returns the next token, or an EOF token if there are none left.
 func (p *Parser) popToken() LexerToken {
-	token := p.tokens[0]
-	p.tokens = p.tokens[1:]
+	token := p.peekToken()
+
+	if len(p.tokens) > 0 {
+		p.tokens = p.tokens[1:]
+	}
+
+	p.start, p.end = token.Offset, token.Offset+len(token.Contents)
 	return token
 }
 
+// record updates the source node at the current path.
+func (p *Parser) record(update func(node *SourceNode)) {
+	if p.sources == nil {
+		return
+	}
+
+	key := p.path.Pointer()
+	node := p.sources.scopes[p.scope][key]
+	update(&node)
+	p.sources.scopes[p.scope][key] = node
+}
+
 func (p *Parser) peekToken() LexerToken {
+	if len(p.tokens) == 0 {
+		return LexerToken{Name: "EOF", Offset: p.end}
+	}
+
 	return p.tokens[0]
 }
 
+// parseFloat parses a Float token, including the special values .inf, -.inf
+// and .nan.
+func parseFloat(contents string) (float64, error) {
+	switch contents {
+	case ".inf":
+		return math.Inf(1), nil
+	case "-.inf":
+		return math.Inf(-1), nil
+	case ".nan":
+		return math.NaN(), nil
+	}
+
+	return strconv.ParseFloat(strings.ReplaceAll(contents, "_", ""), 64)
+}
+
+// integerDigits returns the digits of an Integer token with any separators
+// removed, along with the base they are written in.
+func integerDigits(contents string) (string, int) {
+	digits := strings.ReplaceAll(contents, "_", "")
+	sign := ""
+
+	if strings.HasPrefix(digits, "-") {
+		sign, digits = "-", digits[1:]
+	}
+
+	if len(digits) > 1 && digits[0] == '0' {
+		switch digits[1] {
+		case 'x', 'X':
+			return sign + digits[2:], 16
+		case 'o', 'O':
+			return sign + digits[2:], 8
+		case 'b', 'B':
+			return sign + digits[2:], 2
+		}
+	}
+
+	// A leading zero without a prefix is still decimal
+	return sign + digits, 10
+}
+
+func parseInteger(contents string) (int64, error) {
+	digits, base := integerDigits(contents)
+	val, err := strconv.ParseInt(digits, base, 64)
+
+	if errors.Is(err, strconv.ErrRange) {
+		return 0, fmt.Errorf("integer literal %s is out of range (hint: parse WithBigNumbers)", contents)
+	}
+
+	return val, err
+}
+
+func parseBigInteger(contents string) (*big.Int, error) {
+	digits, base := integerDigits(contents)
+	val, ok := new(big.Int).SetString(digits, base)
+
+	if !ok {
+		return nil, fmt.Errorf("invalid integer literal %s", contents)
+	}
+
+	return val, nil
+}
+
+func parseBigFloat(contents string) (*big.Float, error) {
+	switch contents {
+	case ".inf":
+		return new(big.Float).SetInf(false), nil
+	case "-.inf":
+		return new(big.Float).SetInf(true), nil
+	case ".nan":
+		return nil, fmt.Errorf("%s cannot be represented as a big number", contents)
+	}
+
+	// Four bits per digit is always enough to hold every digit written
+	prec := uint(len(contents) * 4)
+
+	if prec < 64 {
+		prec = 64
+	}
+
+	val, ok := new(big.Float).SetPrec(prec).SetString(contents)
+
+	if !ok {
+		return nil, fmt.Errorf("invalid float literal %s", contents)
+	}
+
+	return val, nil
+}
+
+// unquoteString strips the quotes from a String token and resolves its escape
+// sequences. A backslash before any other character is kept as written, so
+// strings like "C:\path" and "\d+" need no escaping.
+func unquoteString(contents string) string {
+	var builder strings.Builder
+	body := contents[1 : len(contents)-1]
+
+	for i := 0; i < len(body); i++ {
+		if body[i] != '\\' {
+			builder.WriteByte(body[i])
+			continue
+		}
+
+		i++
+
+		switch body[i] {
+		case 'n':
+			builder.WriteByte('\n')
+		case 'r':
+			builder.WriteByte('\r')
+		case 't':
+			builder.WriteByte('\t')
+		case '\\', '"', '\'':
+			builder.WriteByte(body[i])
+		default:
+			builder.WriteByte('\\')
+			builder.WriteByte(body[i])
+		}
+	}
+
+	return builder.String()
+}
+
 func (p *Parser) parseMapPairExpression() (common.Expression, error) {
 	if p.peekToken().IsOfType("Star") {
 		expr, err := p.parseExpression()
@@ -34,12 +243,27 @@ func (p *Parser) parseMapPairExpression() (common.Expression, error) {
 	}
 
 	leftToken := p.popToken()
+	left := leftToken.Contents
+
+	if leftToken.IsOfType("EOF") {
+		return nil, fmt.Errorf("unexpected end of document in map")
+	}
+
+	keySpan := Span{leftToken.Offset, p.end}
 
-	if !leftToken.IsOfType("Keyword") {
+	if leftToken.IsOfType("String") {
+		// Keys that are not valid keywords can be written as strings
+		left = unquoteString(leftToken.Contents)
+	} else if !leftToken.IsOfType("Keyword") {
 		return nil, fmt.Errorf("map pair cannot start with token of type %s", leftToken.Name)
 	}
 
-	left := leftToken.Contents
+	p.path = p.path.Child(left)
+
+	// A repeated key replaces what was recorded for the earlier pair
+	p.record(func(node *SourceNode) {
+		*node = SourceNode{}
+	})
 
 	right, err := p.parseExpression()
 
@@ -47,13 +271,23 @@ func (p *Parser) parseMapPairExpression() (common.Expression, error) {
 		return nil, err
 	}
 
+	p.record(func(node *SourceNode) {
+		node.Key = keySpan
+		node.Entry = Span{keySpan.Start, p.end}
+	})
+
+	p.path = p.path[:len(p.path)-1]
+
 	return flimexpr.NewPairExpression(left, right)
 }
 
 func (p *Parser) parseMapExpression() (common.Expression, error) {
 	pairs := []common.Expression{}
+	entries := []Span{}
+	bodyStart := p.end
 
 	for !p.peekToken().IsOfType("RightCurlyBrace") {
+		entryStart := p.peekToken().Offset
 		pair, err := p.parseMapPairExpression()
 
 		if err != nil {
@@ -61,36 +295,83 @@ func (p *Parser) parseMapExpression() (common.Expression, error) {
 		}
 
 		pairs = append(pairs, pair)
+		entries = append(entries, Span{entryStart, p.end})
 	}
 
 	// Throw away the right curly brace
-	p.popToken()
+	bodyEnd := p.popToken().Offset
+
+	p.record(func(node *SourceNode) {
+		node.Body = Span{bodyStart, bodyEnd}
+		node.Container = true
+		node.Entries = entries
+	})
 
 	return flimexpr.NewMapExpression(pairs)
 }
 
 func (p *Parser) parseListExpression() (common.Expression, error) {
 	listItems := []common.Expression{}
+	bodyStart := p.end
 
 	for !p.peekToken().IsOfType("RightSquareBracket") {
+		p.path = p.path.Child(len(listItems))
+		itemStart := p.peekToken().Offset
 		listItem, err := p.parseExpression()
 
 		if err != nil {
 			return nil, err
 		}
 
+		p.record(func(node *SourceNode) {
+			node.Entry = Span{itemStart, p.end}
+		})
+
+		p.path = p.path[:len(p.path)-1]
 		listItems = append(listItems, listItem)
 	}
 
 	// Throw away the right square bracket
-	p.popToken()
+	bodyEnd := p.popToken().Offset
+
+	p.record(func(node *SourceNode) {
+		node.Body = Span{bodyStart, bodyEnd}
+		node.Container = true
+	})
 
 	return flimexpr.NewListExpression(listItems)
 }
 
 func (p *Parser) parseExpression() (common.Expression, error) {
+	start := p.peekToken().Offset
+	expr, err := p.parseNode()
+
+	if err != nil {
+		return nil, err
+	}
+
+	// The value of a tagged expression is recorded without its tag, so that
+	// replacing the value keeps the tag
+	if _, tagged := expr.(flimexpr.TaggedExpression); !tagged {
+		p.record(func(node *SourceNode) {
+			node.Value = Span{start, p.end}
+		})
+	}
+
+	return expr, nil
+}
+
+func (p *Parser) parseNode() (common.Expression, error) {
 	token := p.popToken()
 
+	if token.IsOfType("EOF") {
+		return nil, fmt.Errorf("unexpected end of document")
+	}
+
+	if kind, exists := p.literals.lookup(token.Name); exists {
+		return parseCustomLiteral(kind, token.Contents)
+	}
+
 	if token.IsOfType("Star") {
 		baseExpr, err := p.parseExpression()
 
@@ -109,6 +390,12 @@ func (p *Parser) parseExpression() (common.Expression, error) {
 		}
 
 		tagName := token.Contents
+
+		if p.sources != nil {
+			p.sources.Tags[tagName] = Span{token.Offset, p.end}
+			p.sources.tagged[tagName] = sourceLocation{p.scope, append(Path{}, p.path...)}
+		}
+
 		baseExpr, err := p.parseExpression()
 
 		if err != nil {
@@ -119,6 +406,13 @@ func (p *Parser) parseExpression() (common.Expression, error) {
 	}
 
 	if token.IsOfType("AtSign") {
+		// A second @ means the handler receives each key along with its value
+		withKeys := p.peekToken().IsOfType("AtSign")
+
+		if withKeys {
+			p.popToken()
+		}
+
 		token := p.popToken()
 
 		if !token.IsOfType("Keyword") {
@@ -132,6 +426,10 @@ func (p *Parser) parseExpression() (common.Expression, error) {
 			return nil, err
 		}
 
+		if withKeys {
+			return flimexpr.NewKeyedMappedTransformerExpression(transformerName, baseExpr)
+		}
+
 		return flimexpr.NewMappedTransformerExpression(transformerName, baseExpr)
 	}
 
@@ -141,12 +439,21 @@ func (p *Parser) parseExpression() (common.Expression, error) {
 	}
 
 	if token.IsOfType("String") {
-		val := token.Contents[1 : len(token.Contents)-1]
-		return flimexpr.NewStringLiteralExpression(val)
+		return flimexpr.NewStringLiteralExpression(unquoteString(token.Contents))
 	}
 
 	if token.IsOfType("Float") {
-		val, err := strconv.ParseFloat(token.Contents, 64)
+		if p.bigNumbers {
+			val, err := parseBigFloat(token.Contents)
+
+			if err != nil {
+				return nil, err
+			}
+
+			return flimexpr.NewBigFloatLiteralExpression(val)
+		}
+
+		val, err := parseFloat(token.Contents)
 
 		if err != nil {
 			return nil, err
@@ -156,13 +463,53 @@ func (p *Parser) parseExpression() (common.Expression, error) {
 	}
 
 	if token.IsOfType("Integer") {
-		val, err := strconv.ParseInt(token.Contents, 10, 64)
+		if p.bigNumbers {
+			val, err := parseBigInteger(token.Contents)
+
+			if err != nil {
+				return nil, err
+			}
+
+			return flimexpr.NewBigIntegerLiteralExpressionWithText(val, token.Contents)
+		}
+
+		val, err := parseInteger(token.Contents)
 
 		if err != nil {
 			return nil, err
 		}
 
-		return flimexpr.NewIntegerLiteralExpression(val)
+		return flimexpr.NewIntegerLiteralExpressionWithText(val, token.Contents)
+	}
+
+	if token.IsOfType("Duration") {
+		val, err := time.ParseDuration(token.Contents)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return flimexpr.NewDurationLiteralExpression(val)
+	}
+
+	if token.IsOfType("Size") {
+		val, err := flimexpr.ParseSize(token.Contents)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return flimexpr.NewSizeLiteralExpression(val)
+	}
+
+	if token.IsOfType("Timestamp") {
+		val, err := time.Parse(time.RFC3339Nano, token.Contents)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return flimexpr.NewTimestampLiteralExpression(val)
 	}
 
 	if token.IsOfType("Null") {
@@ -194,6 +541,10 @@ func (p *Parser) parseExpression() (common.Expression, error) {
 			return nil, fmt.Errorf("tag references must be keywords")
 		}
 
+		if p.sources != nil {
+			p.sources.References = append(p.sources.References, SourceReference{nameToken.Contents, Span{nameToken.Offset, p.end}})
+		}
+
 		return flimexpr.NewReferenceExpression(nameToken.Contents)
 	}
 
@@ -204,6 +555,19 @@ func (p *Parser) parseFileExpression() (common.Expression, error) {
 	expressions := []common.Expression{}
 
 	for len(p.tokens) > 0 {
+		// Each top-level expression is recorded under its tag, so that only
+		// untagged expressions before the document's value are forgotten
+		if p.sources != nil {
+			p.scope = ""
+
+			if len(p.tokens) > 1 && p.tokens[0].IsOfType("Pound") {
+				p.scope = p.tokens[1].Contents
+			}
+
+			p.sources.scopes[p.scope] = map[string]SourceNode{}
+			p.sources.document = p.scope
+		}
+
 		expr, err := p.parseExpression()
 
 		if err != nil {
@@ -222,34 +586,63 @@ func (p *Parser) Parse(tokens []LexerToken) (common.Expression, error) {
 	fileExpr, err := p.parseFileExpression()
 
 	if err != nil {
-		return nil, err
+		return nil, &SyntaxError{Offset: p.start, Err: err}
 	}
 
 	return fileExpr, nil
 }
 
-func ParseFile(filename string) (common.Expression, error) {
+func ParseFile(filename string, options ...ParserOption) (common.Expression, error) {
 	fileContents, err := os.ReadFile(filename)
 
 	if err != nil {
 		return nil, err
 	}
 
-	tokens, err := Lex(string(fileContents))
+	return ParseString(string(fileContents), options...)
+}
+
+func ParseString(text string, options ...ParserOption) (common.Expression, error) {
+	parser := NewParser(options...)
+	tokens, err := parser.Lex(text)
 
 	if err != nil {
 		return nil, err
 	}
 
-	parser := Parser{}
 	expr, err := parser.Parse(tokens)
 
 	if err != nil {
 		return nil, err
 	}
 
+	return ResolveReferences(expr)
+}
+
+// ResolveReferences replaces every reference in expr with the expression it
+// refers to, as ParseFile does after parsing. expr itself is left unchanged.
+func ResolveReferences(expr common.Expression) (common.Expression, error) {
 	tags := expr.GetTags()
-	newExpr, err := expr.ReplaceReferences(tags)
+
+	// References look up their targets in tags when they are used, so the
+	// references in the tagged expressions themselves are resolved in place
+	resolved := make(map[string]common.Expression, len(tags))
+
+	for name, tagged := range tags {
+		resolved[name] = tagged
+	}
+
+	for name, tagged := range tags {
+		newTagged, err := tagged.ReplaceReferences(resolved)
+
+		if err != nil {
+			return nil, err
+		}
+
+		resolved[name] = newTagged
+	}
+
+	newExpr, err := expr.ReplaceReferences(resolved)
 
 	if err != nil {
 		return nil, err
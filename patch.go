@@ -0,0 +1,960 @@
+package flim
+
+import (
+	"fmt"
+	"github.com/l-donovan/flim/common"
+	flimexpr "github.com/l-donovan/flim/expressions"
+	"strconv"
+	"strings"
+)
+
+// PatchOperation is a single edit, modelled on JSON Patch (RFC 6902). Op is
+// one of add, remove, replace, move or test. Path and From are JSON Pointers
+// into the document's value, in which transformers and tags are transparent
+// and list indices count the items as written, before any expansion. The
+// path of an add into a list may end in - to append.
+type PatchOperation struct {
+	Op    string
+	Path  string
+	From  string
+	Value common.Expression
+}
+
+// Patch is a list of operations applied in order. A patch is written as a
+// flim list of maps, e.g.
+//
+//	[
+//	    {op "replace" path "/inventory/port" value 8001}
+//	    {op "add" path "/inventory/items/-" value item {host "10.0.0.2"}}
+//	]
+//
+// so values can hold transformers and references. A JSON Patch document read
+// with FromJSON is also a valid patch.
+type Patch []PatchOperation
+
+// ParsePatch reads a patch from its flim form.
+func ParsePatch(expr common.Expression) (Patch, error) {
+	list, ok := documentValue(expr).(flimexpr.ListExpression)
+
+	if !ok {
+		return nil, fmt.Errorf("patch: expected a list of operations")
+	}
+
+	patch := Patch{}
+
+	for i, listItem := range list.Items() {
+		operationMap, ok := listItem.(flimexpr.MapExpression)
+
+		if !ok {
+			return nil, fmt.Errorf("patch: operation %d is not a map", i)
+		}
+
+		operation := PatchOperation{}
+
+		for _, pairExpr := range operationMap.Pairs() {
+			pair, ok := pairExpr.(flimexpr.PairExpression)
+
+			if !ok {
+				return nil, fmt.Errorf("patch: operation %d cannot contain expansions", i)
+			}
+
+			if pair.Key() == "value" {
+				operation.Value = pair.Value()
+				continue
+			}
+
+			str, ok := pair.Value().(flimexpr.StringLiteralExpression)
+
+			if !ok {
+				return nil, fmt.Errorf("patch: %s of operation %d must be a string", pair.Key(), i)
+			}
+
+			switch pair.Key() {
+			case "op":
+				operation.Op = str.Value()
+			case "path":
+				operation.Path = str.Value()
+			case "from":
+				operation.From = str.Value()
+			default:
+				return nil, fmt.Errorf("patch: unknown field `%s' in operation %d", pair.Key(), i)
+			}
+		}
+
+		if err := operation.validate(); err != nil {
+			return nil, fmt.Errorf("patch: operation %d: %w", i, err)
+		}
+
+		patch = append(patch, operation)
+	}
+
+	return patch, nil
+}
+
+func (o PatchOperation) validate() error {
+	switch o.Op {
+	case "add", "replace", "test":
+		if o.Value == nil {
+			return fmt.Errorf("%s needs a value", o.Op)
+		}
+	case "move":
+		if o.From == "" {
+			return fmt.Errorf("move needs a from path")
+		}
+
+		if o.Path == o.From || strings.HasPrefix(o.Path, o.From+"/") {
+			return fmt.Errorf("cannot move %s into itself", o.From)
+		}
+	case "remove":
+	default:
+		return fmt.Errorf("unknown op `%s'", o.Op)
+	}
+
+	return nil
+}
+
+// parsePointer splits a JSON Pointer into its unescaped tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("path `%s' must be empty or start with /", pointer)
+	}
+
+	unescaper := strings.NewReplacer("~1", "/", "~0", "~")
+	tokens := strings.Split(pointer[1:], "/")
+
+	for i, token := range tokens {
+		tokens[i] = unescaper.Replace(token)
+	}
+
+	return tokens, nil
+}
+
+// Apply applies the patch to a document and returns the result, leaving expr
+// unchanged. Only the edited values change, so tags, references and
+// transformers elsewhere are kept, and replacing a tagged value keeps its
+// tag. References in the result are not resolved.
+func (p Patch) Apply(expr common.Expression) (common.Expression, error) {
+	for i, operation := range p {
+		var err error
+
+		if expr, err = operation.apply(expr); err != nil {
+			return nil, fmt.Errorf("patch: operation %d (%s %s): %w", i, operation.Op, operation.Path, err)
+		}
+	}
+
+	return expr, nil
+}
+
+func (o PatchOperation) apply(doc common.Expression) (common.Expression, error) {
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+
+	tokens, err := parsePointer(o.Path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch o.Op {
+	case "add":
+		return editDocument(doc, tokens, func(container common.Expression, token string) (common.Expression, error) {
+			return addChild(container, token, o.Value)
+		})
+	case "replace":
+		return editDocument(doc, tokens, func(container common.Expression, token string) (common.Expression, error) {
+			if _, err := childOf(container, token, true); err != nil {
+				return nil, err
+			}
+
+			return setChild(container, token, o.Value)
+		})
+	case "remove":
+		if len(tokens) == 0 {
+			return nil, fmt.Errorf("cannot remove the document itself")
+		}
+
+		return editDocument(doc, tokens, removeChild)
+	case "test":
+		found, err := lookupPointer(doc, tokens)
+
+		if err != nil {
+			return nil, err
+		}
+
+		foundStr, err := CanonicalString(found, WithUnresolvedReferences())
+
+		if err != nil {
+			return nil, err
+		}
+
+		expectedStr, err := CanonicalString(o.Value, WithUnresolvedReferences())
+
+		if err != nil {
+			return nil, err
+		}
+
+		if foundStr != expectedStr {
+			return nil, fmt.Errorf("test failed: found %s", inlineExpression(found))
+		}
+
+		return doc, nil
+	default:
+		fromTokens, err := parsePointer(o.From)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(fromTokens) == 0 {
+			return nil, fmt.Errorf("cannot move the document itself")
+		}
+
+		moved, err := ownValueAt(doc, fromTokens)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if doc, err = editDocument(doc, fromTokens, removeChild); err != nil {
+			return nil, err
+		}
+
+		return PatchOperation{Op: "add", Path: o.Path, Value: moved}.apply(doc)
+	}
+}
+
+// editDocument edits the document's value, which is the last expression of a
+// file.
+func editDocument(doc common.Expression, tokens []string, edit func(container common.Expression, token string) (common.Expression, error)) (common.Expression, error) {
+	fileExpr, isFile := doc.(flimexpr.FileExpression)
+	exprs := []common.Expression{doc}
+
+	if isFile {
+		exprs = fileExpr.Expressions()
+
+		if len(exprs) == 0 {
+			return nil, fmt.Errorf("document is empty")
+		}
+	}
+
+	last := exprs[len(exprs)-1]
+	var err error
+
+	if len(tokens) == 0 {
+		// Only add and replace can reach the document itself
+		last, err = edit(nil, "")
+	} else {
+		last, err = editPath(last, tokens, edit)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !isFile {
+		return last, nil
+	}
+
+	exprs[len(exprs)-1] = last
+	return flimexpr.NewFileExpression(exprs)
+}
+
+// editPath rebuilds expr with edit applied to the container holding the last
+// token. Tags and transformers are looked through and kept.
+func editPath(expr common.Expression, tokens []string, edit func(container common.Expression, token string) (common.Expression, error)) (common.Expression, error) {
+	switch e := expr.(type) {
+	case flimexpr.TaggedExpression:
+		inner, err := editPath(e.Expression(), tokens, edit)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return e.WithExpression(inner), nil
+	case flimexpr.TransformerExpression:
+		inner, err := editPath(e.Expression(), tokens, edit)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return e.WithExpression(inner), nil
+	case flimexpr.MappedTransformerExpression:
+		inner, err := editPath(e.Expression(), tokens, edit)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return e.WithExpression(inner), nil
+	case flimexpr.ReferenceExpression:
+		return nil, fmt.Errorf("cannot edit through reference `%s'", e.Name())
+	}
+
+	if len(tokens) == 1 {
+		return edit(expr, tokens[0])
+	}
+
+	child, err := childOf(expr, tokens[0], false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	newChild, err := editPath(child, tokens[1:], edit)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return setChild(expr, tokens[0], newChild)
+}
+
+// listIndex parses a list index token, allowing - for the end of the list
+// when appending.
+func listIndex(token string, length int, appending bool) (int, error) {
+	if appending && token == "-" {
+		return length, nil
+	}
+
+	index, err := strconv.Atoi(token)
+
+	if err != nil || index < 0 || token != strconv.Itoa(index) {
+		return 0, fmt.Errorf("invalid list index `%s'", token)
+	}
+
+	limit := length - 1
+
+	if appending {
+		limit = length
+	}
+
+	if index > limit {
+		return 0, fmt.Errorf("list index %d is out of range", index)
+	}
+
+	return index, nil
+}
+
+// childOf returns the child of a map or list written in the document. If
+// viaExpansion is set, a map key that only comes from an expansion is found
+// too.
+func childOf(container common.Expression, token string, viaExpansion bool) (common.Expression, error) {
+	switch e := container.(type) {
+	case nil:
+		// The document itself
+		return nil, nil
+	case flimexpr.MapExpression:
+		if val, exists := e.Get(token); exists {
+			return val, nil
+		}
+
+		if entries, _ := effectiveEntries(e); entries.values[token] != nil {
+			if viaExpansion {
+				return entries.values[token], nil
+			}
+
+			return nil, fmt.Errorf("key `%s' comes from an expansion and cannot be edited in place", token)
+		}
+
+		return nil, fmt.Errorf("key `%s' does not exist", token)
+	case flimexpr.ListExpression:
+		listItems := e.Items()
+		index, err := listIndex(token, len(listItems), false)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return listItems[index], nil
+	default:
+		return nil, fmt.Errorf("cannot find `%s' in %s", token, inlineExpression(container))
+	}
+}
+
+// keepTag wraps val in old's tag, so that references to a replaced value
+// still find it.
+func keepTag(old, val common.Expression) (common.Expression, error) {
+	taggedExpr, wasTagged := old.(flimexpr.TaggedExpression)
+
+	if _, isTagged := val.(flimexpr.TaggedExpression); !wasTagged || isTagged {
+		return val, nil
+	}
+
+	return flimexpr.AddTag(taggedExpr.Tag(), val)
+}
+
+// addChild sets a map key, replacing its last pair or adding one at the end,
+// or inserts a list item. With a nil container it replaces the document.
+func addChild(container common.Expression, token string, val common.Expression) (common.Expression, error) {
+	switch e := container.(type) {
+	case nil:
+		return val, nil
+	case flimexpr.MapExpression:
+		if old, exists := e.Get(token); exists {
+			var err error
+
+			if val, err = keepTag(old, val); err != nil {
+				return nil, err
+			}
+		}
+
+		return e.Set(token, val), nil
+	case flimexpr.ListExpression:
+		index, err := listIndex(token, len(e.Items()), true)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return e.Insert(index, val)
+	default:
+		return nil, fmt.Errorf("cannot add `%s' to %s", token, inlineExpression(container))
+	}
+}
+
+// setChild replaces an existing map value or list item.
+func setChild(container common.Expression, token string, val common.Expression) (common.Expression, error) {
+	list, ok := container.(flimexpr.ListExpression)
+
+	if !ok {
+		return addChild(container, token, val)
+	}
+
+	listItems := list.Items()
+	index, err := listIndex(token, len(listItems), false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if val, err = keepTag(listItems[index], val); err != nil {
+		return nil, err
+	}
+
+	return list.Replace(index, val)
+}
+
+func removeChild(container common.Expression, token string) (common.Expression, error) {
+	switch e := container.(type) {
+	case flimexpr.MapExpression:
+		if _, err := childOf(e, token, false); err != nil {
+			return nil, err
+		}
+
+		newMap := e.Delete(token)
+
+		if entries, _ := effectiveEntries(newMap); entries.values[token] != nil {
+			return nil, fmt.Errorf("key `%s' would still come from an expansion", token)
+		}
+
+		return newMap, nil
+	case flimexpr.ListExpression:
+		index, err := listIndex(token, len(e.Items()), false)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return e.Remove(index)
+	default:
+		return nil, fmt.Errorf("cannot remove `%s' from %s", token, inlineExpression(container))
+	}
+}
+
+// ownValueAt returns the value written at a path, which must not come from
+// an expansion.
+func ownValueAt(doc common.Expression, tokens []string) (common.Expression, error) {
+	var found common.Expression
+
+	_, err := editDocument(doc, tokens, func(container common.Expression, token string) (common.Expression, error) {
+		child, err := childOf(container, token, false)
+		found = child
+		return container, err
+	})
+
+	return found, err
+}
+
+// lookupPointer returns the value at a path, looking through references and
+// expansions.
+func lookupPointer(doc common.Expression, tokens []string) (common.Expression, error) {
+	expr := documentValue(doc)
+
+	for _, token := range tokens {
+		target := diffTarget(expr)
+
+		for {
+			switch e := target.(type) {
+			case flimexpr.TransformerExpression:
+				target = diffTarget(e.Expression())
+				continue
+			case flimexpr.MappedTransformerExpression:
+				target = diffTarget(e.Expression())
+				continue
+			}
+
+			break
+		}
+
+		switch e := target.(type) {
+		case flimexpr.MapExpression:
+			entries, _ := effectiveEntries(e)
+
+			if entries.values[token] == nil {
+				return nil, fmt.Errorf("key `%s' does not exist", token)
+			}
+
+			expr = entries.values[token]
+		case flimexpr.ListExpression:
+			listItems, _ := effectiveItems(e)
+			index, err := listIndex(token, len(listItems), false)
+
+			if err != nil {
+				return nil, err
+			}
+
+			expr = listItems[index]
+		default:
+			return nil, fmt.Errorf("cannot find `%s' in %s", token, inlineExpression(target))
+		}
+	}
+
+	return expr, nil
+}
+
+// ApplyToSource applies the patch to the flim source of a document. Only the
+// text of the edited values changes, so comments and formatting elsewhere are
+// kept, and new values are written with the indentation the source uses.
+func (p Patch) ApplyToSource(source string, options ...ParserOption) (string, error) {
+	for i, operation := range p {
+		var err error
+
+		if source, err = operation.applyToSource(source, options); err != nil {
+			return "", fmt.Errorf("patch: operation %d (%s %s): %w", i, operation.Op, operation.Path, err)
+		}
+	}
+
+	return source, nil
+}
+
+func (o PatchOperation) applyToSource(source string, options []ParserOption) (string, error) {
+	editor, err := newSourceEditor(source, options)
+
+	if err != nil {
+		return "", err
+	}
+
+	// Applying the operation to the document catches anything that cannot
+	// be done before the source is touched
+	if _, err := o.apply(editor.doc); err != nil {
+		return "", err
+	}
+
+	tokens, _ := parsePointer(o.Path)
+
+	switch o.Op {
+	case "test":
+		return source, nil
+	case "remove":
+		return editor.remove(tokens, options)
+	case "move":
+		fromTokens, _ := parsePointer(o.From)
+		moved, _ := ownValueAt(editor.doc, fromTokens)
+		raw, rawLevel, err := editor.rawValue(fromTokens)
+
+		if err != nil {
+			return "", err
+		}
+
+		if source, err = editor.remove(fromTokens, options); err != nil {
+			return "", err
+		}
+
+		if editor, err = newSourceEditor(source, options); err != nil {
+			return "", err
+		}
+
+		return editor.set(tokens, true, moved, func(level int, inline bool) (string, error) {
+			return editor.reindent(raw, level-rawLevel), nil
+		})
+	default:
+		return editor.set(tokens, o.Op == "add", o.Value, func(level int, inline bool) (string, error) {
+			if inline {
+				return o.Value.Serialize(editor.config.Inline(), 0)
+			}
+
+			return o.Value.Serialize(editor.config, level+1)
+		})
+	}
+}
+
+// renderFunc writes a value that starts on a line indented to level. Values
+// written inside a single-line map or list are written inline.
+type renderFunc func(level int, inline bool) (string, error)
+
+// sourceEditor edits the text of a parsed document.
+type sourceEditor struct {
+	source  string
+	sources *SourceMap
+	doc     common.Expression
+	config  *common.SerializerConfig
+}
+
+func newSourceEditor(source string, options []ParserOption) (*sourceEditor, error) {
+	sourceMap := &SourceMap{}
+	parser := NewParser(append(append([]ParserOption{}, options...), WithSourceMap(sourceMap))...)
+	tokens, err := parser.Lex(source)
+
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := parser.Parse(tokens)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Operations are checked against the resolved document, as Apply would
+	// see it, so that keys from expansions of references can be found
+	if doc, err = ResolveReferences(doc); err != nil {
+		return nil, err
+	}
+
+	return &sourceEditor{source: source, sources: sourceMap, doc: doc, config: sourceIndentation(source)}, nil
+}
+
+// sourceIndentation returns a serializer config that indents as the first
+// indented line of source does.
+func sourceIndentation(source string) *common.SerializerConfig {
+	for _, line := range strings.Split(source, "\n") {
+		trimmed := strings.TrimLeft(line, " \t")
+
+		if trimmed == "" || trimmed == line {
+			continue
+		}
+
+		if line[0] == '\t' {
+			return common.NewSerializerConfig(common.WithTabs())
+		}
+
+		return common.NewSerializerConfig(common.WithIndentSize(len(line) - len(trimmed)))
+	}
+
+	return common.NewSerializerConfig()
+}
+
+func (e *sourceEditor) lineStart(offset int) int {
+	return strings.LastIndexByte(e.source[:offset], '\n') + 1
+}
+
+func (e *sourceEditor) lineEnd(offset int) int {
+	if end := strings.IndexByte(e.source[offset:], '\n'); end >= 0 {
+		return offset + end
+	}
+
+	return len(e.source)
+}
+
+// lineLevel returns the indentation level of the line holding offset.
+func (e *sourceEditor) lineLevel(offset int) int {
+	line := e.source[e.lineStart(offset):]
+	indent := len(line) - len(strings.TrimLeft(line, " \t"))
+	return indent / max(len(e.config.Indent(1)), 1)
+}
+
+// reindent shifts every line of text after the first by delta levels.
+func (e *sourceEditor) reindent(text string, delta int) string {
+	lines := strings.Split(text, "\n")
+
+	for i := 1; i < len(lines); i++ {
+		if delta > 0 {
+			lines[i] = e.config.Indent(delta) + lines[i]
+			continue
+		}
+
+		trimmed := strings.TrimLeft(lines[i], " \t")
+		strip := min(len(lines[i])-len(trimmed), len(e.config.Indent(-delta)))
+		lines[i] = lines[i][strip:]
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (e *sourceEditor) splice(start, end int, text string) string {
+	return e.source[:start] + text + e.source[end:]
+}
+
+// resolve turns pointer tokens into the path of the parent of the value they
+// point to, and returns the parent with any tags and transformers removed.
+func (e *sourceEditor) resolve(tokens []string) (Path, common.Expression, error) {
+	path := Path{}
+	expr := documentValue(e.doc)
+
+	for i, token := range tokens {
+		for unwrapped := false; !unwrapped; {
+			switch wrapper := expr.(type) {
+			case flimexpr.TaggedExpression:
+				expr = wrapper.Expression()
+			case flimexpr.TransformerExpression:
+				expr = wrapper.Expression()
+			case flimexpr.MappedTransformerExpression:
+				expr = wrapper.Expression()
+			default:
+				unwrapped = true
+			}
+		}
+
+		if i == len(tokens)-1 {
+			break
+		}
+
+		child, err := childOf(expr, token, false)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		path = path.Child(pathElement(expr, token))
+		expr = child
+	}
+
+	return path, expr, nil
+}
+
+// pathElement converts a pointer token into a list index or map key.
+func pathElement(container common.Expression, token string) interface{} {
+	if list, ok := container.(flimexpr.ListExpression); ok {
+		index, _ := listIndex(token, len(list.Items()), true)
+		return index
+	}
+
+	return token
+}
+
+func (e *sourceEditor) lookup(path Path) (SourceNode, error) {
+	node, exists := e.sources.Lookup(path)
+
+	if !exists {
+		return SourceNode{}, fmt.Errorf("%s is not written in the source", path)
+	}
+
+	return node, nil
+}
+
+// valueStart returns where the value at path starts, including its tag.
+func (e *sourceEditor) valueStart(node SourceNode, old common.Expression) int {
+	if _, tagged := old.(flimexpr.TaggedExpression); tagged {
+		return strings.LastIndexByte(e.source[:node.Value.Start], '#')
+	}
+
+	return node.Value.Start
+}
+
+// rawValue returns the text of the value the tokens point to, with its tag,
+// and the level of the line it starts on.
+func (e *sourceEditor) rawValue(tokens []string) (string, int, error) {
+	parentPath, container, err := e.resolve(tokens)
+
+	if err != nil {
+		return "", 0, err
+	}
+
+	token := tokens[len(tokens)-1]
+	node, err := e.lookup(parentPath.Child(pathElement(container, token)))
+
+	if err != nil {
+		return "", 0, err
+	}
+
+	old, _ := childOf(container, token, false)
+	start := e.valueStart(node, old)
+	return e.source[start:node.Value.End], e.lineLevel(start), nil
+}
+
+// set writes val at the tokens. It replaces an existing value, or inserts a
+// new pair or list item if there is none or insert is set for a list.
+func (e *sourceEditor) set(tokens []string, insert bool, val common.Expression, render renderFunc) (string, error) {
+	if len(tokens) == 0 {
+		return e.replace(Path{}, documentValue(e.doc), val, render)
+	}
+
+	parentPath, container, err := e.resolve(tokens)
+
+	if err != nil {
+		return "", err
+	}
+
+	token := tokens[len(tokens)-1]
+
+	switch c := container.(type) {
+	case flimexpr.MapExpression:
+		if old, err := childOf(c, token, false); err == nil {
+			return e.replace(parentPath.Child(token), old, val, render)
+		}
+
+		return e.insert(parentPath, -1, func(level int, inline bool) (string, error) {
+			text, err := render(level, inline)
+			return flimexpr.SerializeKey(e.config, token) + " " + text, err
+		})
+	case flimexpr.ListExpression:
+		listItems := c.Items()
+		index, err := listIndex(token, len(listItems), insert)
+
+		if err != nil {
+			return "", err
+		}
+
+		if !insert {
+			return e.replace(parentPath.Child(index), listItems[index], val, render)
+		}
+
+		if index == len(listItems) {
+			index = -1
+		}
+
+		return e.insert(parentPath, index, render)
+	default:
+		return "", fmt.Errorf("cannot add `%s' to %s", token, inlineExpression(container))
+	}
+}
+
+// replace replaces the text of the value at path. An untagged value keeps the
+// tag of the value it replaces.
+func (e *sourceEditor) replace(path Path, old, val common.Expression, render renderFunc) (string, error) {
+	node, err := e.lookup(path)
+
+	if err != nil {
+		return "", err
+	}
+
+	start := node.Value.Start
+
+	if _, tagged := val.(flimexpr.TaggedExpression); tagged {
+		start = e.valueStart(node, old)
+	}
+
+	text, err := render(e.lineLevel(start), false)
+
+	if err != nil {
+		return "", err
+	}
+
+	return e.splice(start, node.Value.End, text), nil
+}
+
+// insert adds an entry to the map or list at path, before the item at index
+// or at the end if index is negative. Entries go on their own line unless the
+// map or list is written on a single line.
+func (e *sourceEditor) insert(path Path, index int, render renderFunc) (string, error) {
+	node, err := e.lookup(path)
+
+	if err != nil {
+		return "", err
+	}
+
+	if !node.Container {
+		return "", fmt.Errorf("%s is not written as a map or list", path)
+	}
+
+	body := node.Body.Text(e.source)
+	trimmed := strings.TrimSpace(body)
+	multiline := strings.Contains(body, "\n") || trimmed == ""
+	level := e.lineLevel(node.Body.Start) + 1
+	text, err := render(level, !multiline)
+
+	if err != nil {
+		return "", err
+	}
+
+	if index >= 0 {
+		itemNode, err := e.lookup(path.Child(index))
+
+		if err != nil {
+			return "", err
+		}
+
+		start := itemNode.Entry.Start
+		indent := e.source[e.lineStart(start):start]
+
+		if multiline && strings.TrimSpace(indent) == "" {
+			return e.splice(start, start, text+"\n"+indent), nil
+		}
+
+		return e.splice(start, start, text+" "), nil
+	}
+
+	end := node.Body.Start + len(strings.TrimRight(body, " \t\r\n"))
+
+	switch {
+	case trimmed == "" && !strings.Contains(body, "\n"):
+		return e.splice(node.Body.Start, node.Body.End, "\n"+e.config.Indent(level)+text+"\n"+e.config.Indent(level-1)), nil
+	case multiline:
+		return e.splice(end, end, "\n"+e.config.Indent(level)+text), nil
+	default:
+		return e.splice(end, end, " "+text), nil
+	}
+}
+
+// remove deletes the pair or list item the tokens point to. A pair or item
+// that has its own line is removed with its line, along with any comment
+// that follows it on that line.
+func (e *sourceEditor) remove(tokens []string, options []ParserOption) (string, error) {
+	parentPath, container, err := e.resolve(tokens)
+
+	if err != nil {
+		return "", err
+	}
+
+	token := tokens[len(tokens)-1]
+	node, err := e.lookup(parentPath.Child(pathElement(container, token)))
+
+	if err != nil {
+		return "", err
+	}
+
+	start, end := node.Entry.Start, node.Entry.End
+	lineStart, lineEnd := e.lineStart(start), e.lineEnd(end)
+	before := e.source[lineStart:start]
+	after := strings.TrimSpace(e.source[end:lineEnd])
+
+	switch {
+	case strings.TrimSpace(before) == "" && (after == "" || strings.HasPrefix(after, "//")):
+		start, end = lineStart, min(lineEnd+1, len(e.source))
+	case strings.TrimSpace(before) == "" || strings.TrimRight(before, " \t") == before:
+		for end < len(e.source) && (e.source[end] == ' ' || e.source[end] == '\t') {
+			end++
+		}
+	default:
+		for start > lineStart && (e.source[start-1] == ' ' || e.source[start-1] == '\t') {
+			start--
+		}
+	}
+
+	source := e.splice(start, end, "")
+
+	if _, isMap := container.(flimexpr.MapExpression); !isMap {
+		return source, nil
+	}
+
+	// A key written more than once has every pair removed
+	editor, err := newSourceEditor(source, options)
+
+	if err != nil {
+		return "", err
+	}
+
+	if _, container, err := editor.resolve(tokens); err == nil {
+		if _, err := childOf(container, token, false); err == nil {
+			return editor.remove(tokens, options)
+		}
+	}
+
+	return source, nil
+}
@@ -0,0 +1,275 @@
+package flim
+
+import (
+	"strings"
+	"testing"
+)
+
+func parsePatchText(t *testing.T, text string) Patch {
+	t.Helper()
+	expr, err := ParseString(text)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patch, err := ParsePatch(expr)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return patch
+}
+
+func TestPatchApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+	}{
+		{
+			"replace",
+			"{ a 1 b { c 2 } }",
+			`[ { op "replace" path "/b/c" value 3 } ]`,
+			"{ a 1 b { c 3 } }",
+		},
+		{
+			"add a key",
+			"{ a 1 }",
+			`[ { op "add" path "/b" value [ 1 ] } ]`,
+			"{ a 1 b [ 1 ] }",
+		},
+		{
+			"add a list item",
+			"{ l [ 1 3 ] }",
+			`[ { op "add" path "/l/1" value 2 } { op "add" path "/l/-" value 4 } ]`,
+			"{ l [ 1 2 3 4 ] }",
+		},
+		{
+			"remove",
+			"{ a 1 b 2 l [ 1 2 3 ] }",
+			`[ { op "remove" path "/a" } { op "remove" path "/l/0" } ]`,
+			"{ b 2 l [ 2 3 ] }",
+		},
+		{
+			"move",
+			"{ a { b 1 } c {} }",
+			`[ { op "move" from "/a/b" path "/c/d" } ]`,
+			"{ a {} c { d 1 } }",
+		},
+		{
+			"test then replace",
+			"{ a 1 }",
+			`[ { op "test" path "/a" value 0x1 } { op "replace" path "/a" value 2 } ]`,
+			"{ a 2 }",
+		},
+		{
+			"replace the document",
+			"{ a 1 }",
+			`[ { op "replace" path "" value [ 1 ] } ]`,
+			"[ 1 ]",
+		},
+		{
+			"escaped pointer",
+			`{ "a/b" 1 "c~d" 2 }`,
+			`[ { op "replace" path "/a~1b" value 3 } { op "remove" path "/c~0d" } ]`,
+			`{ "a/b" 3 }`,
+		},
+		{
+			"through a transformer",
+			`{ a env { name "x" } }`,
+			`[ { op "replace" path "/a/name" value "y" } ]`,
+			`{ a env { name "y" } }`,
+		},
+		{
+			"replacing a tagged value keeps its tag",
+			"{ a #t 1 b &t }",
+			`[ { op "replace" path "/a" value 2 } ]`,
+			"{ a 2 b 2 }",
+		},
+		{
+			"replacing a key from an expansion",
+			"#base { port 80 }\n{ *&base }",
+			`[ { op "replace" path "/port" value 81 } ]`,
+			"{ port 81 }",
+		},
+		{
+			"value with a transformer",
+			"{ a 1 }",
+			`[ { op "replace" path "/a" value upper "x" } ]`,
+			`{ a upper "x" }`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			doc, err := ParseString(test.doc)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want, err := ParseString(test.want)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			patch := parsePatchText(t, test.patch)
+			patched, err := patch.Apply(doc)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if patched, err = ResolveReferences(patched); err != nil {
+				t.Fatal(err)
+			}
+
+			if changes, err := Diff(patched, want); err != nil || len(changes) > 0 {
+				t.Errorf("patched document differs: %v %v", changes, err)
+			}
+
+			source, err := patch.ApplyToSource(test.doc)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if patched, err = ParseString(source); err != nil {
+				t.Fatalf("patched source `%s': %s", source, err)
+			}
+
+			if changes, err := Diff(patched, want); err != nil || len(changes) > 0 {
+				t.Errorf("patched source `%s' differs: %v %v", source, changes, err)
+			}
+		})
+	}
+}
+
+// Applying a patch to source only changes the text of the edited values.
+func TestPatchApplyToSourceKeepsFormatting(t *testing.T) {
+	source := "// settings\n{\n    port 8000 // the port\n    hosts [\n        \"a\"\n    ]\n}\n"
+
+	patch := parsePatchText(t, `[
+		{ op "replace" path "/port" value 0x1F41 }
+		{ op "add" path "/hosts/-" value "b" }
+	]`)
+
+	got, err := patch.ApplyToSource(source)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "// settings\n{\n    port 0x1F41 // the port\n    hosts [\n        \"a\"\n        \"b\"\n    ]\n}\n"
+
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestPatchApplyErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		err   string
+	}{
+		{"missing key", "{ a 1 }", `[ { op "replace" path "/b" value 1 } ]`, "key `b' does not exist"},
+		{"index out of range", "{ l [ 1 ] }", `[ { op "remove" path "/l/1" } ]`, "out of range"},
+		{"invalid index", "{ l [ 1 ] }", `[ { op "add" path "/l/x" value 1 } ]`, "invalid list index `x'"},
+		{"failed test", "{ a 1 }", `[ { op "test" path "/a" value 2 } ]`, "test failed"},
+		{"through a reference", "#base { port 80 }\n{ a &base }", `[ { op "replace" path "/a/port" value 81 } ]`, "cannot edit through reference"},
+		{"removing a key from an expansion", "#base { port 80 }\n{ *&base }", `[ { op "remove" path "/port" } ]`, "comes from an expansion"},
+		{"removing the document", "{ a 1 }", `[ { op "remove" path "" } ]`, "cannot remove the document"},
+		{"into a scalar", "{ a 1 }", `[ { op "add" path "/a/b" value 1 } ]`, "cannot"},
+		{"relative pointer", "{ a 1 }", `[ { op "remove" path "a" } ]`, "must be empty or start with /"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			doc, err := ParseString(test.doc)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = parsePatchText(t, test.patch).Apply(doc)
+
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("got error %v, want one containing `%s'", err, test.err)
+			}
+		})
+	}
+}
+
+func TestParsePatchErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		err   string
+	}{
+		{"not a list", `{ op "remove" path "/a" }`, "expected a list of operations"},
+		{"not a map", `[ 1 ]`, "operation 0 is not a map"},
+		{"unknown op", `[ { op "copy" path "/a" } ]`, "unknown op `copy'"},
+		{"unknown field", `[ { op "remove" path "/a" extra "x" } ]`, "unknown field `extra'"},
+		{"missing value", `[ { op "add" path "/a" } ]`, "add needs a value"},
+		{"path not a string", `[ { op "remove" path 1 } ]`, "path of operation 0 must be a string"},
+		{"move into itself", `[ { op "move" from "/a" path "/a/b" } ]`, "cannot move /a into itself"},
+		{"move without from", `[ { op "move" path "/a" } ]`, "move needs a from path"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expr, err := ParseString(test.patch)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := ParsePatch(expr); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("got error %v, want one containing `%s'", err, test.err)
+			}
+		})
+	}
+}
+
+// A JSON Patch document is also a patch.
+func TestPatchFromJSON(t *testing.T) {
+	expr, err := FromJSON([]byte(`[{"op": "replace", "path": "/a", "value": {"b": [1, 2]}}]`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patch, err := ParsePatch(expr)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := ParseString("{ a 1 }")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patched, err := patch.Apply(doc)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := ParseString("{ a { b [ 1 2 ] } }")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if changes, err := Diff(patched, want); err != nil || len(changes) > 0 {
+		t.Errorf("patched document differs: %v %v", changes, err)
+	}
+}
@@ -0,0 +1,72 @@
+package flim
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Path locates a value within a document. Each element is either a string
+// map key or an int list index.
+type Path []interface{}
+
+var pathKeyPattern = regexp.MustCompile(`^[A-Za-z_]\w*$`)
+
+// Child returns a copy of the path with element appended.
+func (p Path) Child(element interface{}) Path {
+	child := make(Path, len(p), len(p)+1)
+	copy(child, p)
+	return append(child, element)
+}
+
+// String writes the path as it would be written in a query, e.g.
+// inventory.items[2].host. Keys that are not identifiers are quoted, as in
+// labels["app.kubernetes.io/name"]. The empty path is written as ".".
+func (p Path) String() string {
+	if len(p) == 0 {
+		return "."
+	}
+
+	var builder strings.Builder
+
+	for i, element := range p {
+		switch element := element.(type) {
+		case int:
+			fmt.Fprintf(&builder, "[%d]", element)
+		case string:
+			if !pathKeyPattern.MatchString(element) {
+				builder.WriteString("[" + strconv.Quote(element) + "]")
+				continue
+			}
+
+			if i > 0 {
+				builder.WriteByte('.')
+			}
+
+			builder.WriteString(element)
+		}
+	}
+
+	return builder.String()
+}
+
+// Pointer writes the path as a JSON Pointer (RFC 6901), e.g.
+// /inventory/items/2/host.
+func (p Path) Pointer() string {
+	var builder strings.Builder
+	escaper := strings.NewReplacer("~", "~0", "/", "~1")
+
+	for _, element := range p {
+		builder.WriteByte('/')
+
+		switch element := element.(type) {
+		case int:
+			builder.WriteString(strconv.Itoa(element))
+		case string:
+			builder.WriteString(escaper.Replace(element))
+		}
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,985 @@
+package flim
+
+import (
+	"fmt"
+	"github.com/l-donovan/flim/common"
+	flimexpr "github.com/l-donovan/flim/expressions"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Query selects values from a document by path. A query is a series of
+// steps, each applied to everything the steps before it selected:
+//
+//	.key or key      the value of a map key
+//	["any key"]      the same, for keys that are not identifiers
+//	[2] or [-1]      a list item, counting from the end if negative
+//	.* or [*]        every value of a map or item of a list
+//	..step           the step applied at any depth, e.g. ..port
+//	[?filter]        every value or item for which filter holds
+//
+// A query of just . selects the whole document, so the String of any Path is
+// a query that selects it.
+//
+// Filters compare paths relative to the value being tested with literals,
+// e.g. [?name == "A"], [?port >= 8000 && !disabled] or [?@ != null], where
+// @ is the value itself. A path on its own holds if it exists. Comparisons
+// hold if any value the path selects matches.
+type Query struct {
+	text  string
+	steps []queryStep
+}
+
+type queryStepKind int
+
+const (
+	keyStep queryStepKind = iota
+	indexStep
+	wildcardStep
+	filterStep
+)
+
+type queryStep struct {
+	kind      queryStepKind
+	recursive bool
+	key       string
+	index     int
+	filter    queryFilter
+}
+
+// ParseQuery parses a query.
+func ParseQuery(text string) (*Query, error) {
+	tokens, err := lexQuery(text)
+
+	if err != nil {
+		return nil, err
+	}
+
+	p := &queryParser{tokens: tokens}
+
+	// A leading dot on its own stands for the document
+	if p.peek().is(".") && (len(p.tokens) == 2 || p.tokens[1].kind != queryIdentifier && !p.tokens[1].is("*")) {
+		p.pop()
+	}
+
+	steps, err := p.steps(true)
+
+	if err != nil {
+		return nil, fmt.Errorf("query `%s': %w", text, err)
+	}
+
+	if !p.peek().is("") {
+		return nil, fmt.Errorf("query `%s': unexpected `%s'", text, p.peek().text)
+	}
+
+	return &Query{text: text, steps: steps}, nil
+}
+
+func (q *Query) String() string {
+	return q.text
+}
+
+// QueryResult is a value selected by a query. Running a query on evaluated
+// values sets Value, while running it on an expression sets Expression, and
+// Position if the query was given the document's source.
+type QueryResult struct {
+	Path       Path
+	Value      interface{}
+	Expression common.Expression
+	Position   Position
+}
+
+type queryConfig struct {
+	source    string
+	sourceMap *SourceMap
+	order     common.Expression
+}
+
+type QueryOption func(*queryConfig)
+
+// WithPositions gives each result of Query.Expressions the position in source
+// where it was written, using a source map filled in by parsing source with
+// WithSourceMap.
+func WithPositions(source string, sourceMap *SourceMap) QueryOption {
+	return func(c *queryConfig) {
+		c.source = source
+		c.sourceMap = sourceMap
+	}
+}
+
+// WithDocumentOrder makes Query.Values visit map keys in the order they are
+// written in expr, the document val was evaluated from. Keys that expr does
+// not give an order for are visited after those that it does, in sorted order.
+func WithDocumentOrder(expr common.Expression) QueryOption {
+	return func(c *queryConfig) {
+		c.order = expr
+	}
+}
+
+// Values runs the query on an evaluated value, such as the result of
+// common.Evaluate. Map keys are visited in sorted order unless the query is
+// given the document with WithDocumentOrder.
+func (q *Query) Values(val interface{}, options ...QueryOption) []QueryResult {
+	config := &queryConfig{}
+
+	for _, option := range options {
+		option(config)
+	}
+
+	root := queryNode{path: Path{}, value: val}
+
+	if config.order != nil {
+		root.order = documentValue(config.order)
+	}
+
+	nodes := q.run([]queryNode{root})
+	results := make([]QueryResult, len(nodes))
+
+	for i, node := range nodes {
+		results[i] = QueryResult{Path: node.path, Value: node.value}
+	}
+
+	return results
+}
+
+// Expressions runs the query on the value of a document. Tags, transformers
+// and resolved references are looked through, and expansions of maps and
+// lists are merged into them as they would be when evaluated. The selected
+// expressions are returned as written, with any tags and transformers.
+func (q *Query) Expressions(expr common.Expression, options ...QueryOption) []QueryResult {
+	config := &queryConfig{}
+
+	for _, option := range options {
+		option(config)
+	}
+
+	nodes := q.run([]queryNode{{path: Path{}, expr: documentValue(expr), written: Path{}}})
+	results := make([]QueryResult, len(nodes))
+
+	for i, node := range nodes {
+		results[i] = QueryResult{Path: node.path, Expression: node.expr}
+
+		if config.sourceMap == nil || node.written == nil {
+			continue
+		}
+
+		if sourceNode, exists := config.sourceMap.Lookup(node.written); exists {
+			start := sourceNode.Value.Start
+
+			if sourceNode.Entry.End > 0 {
+				start = sourceNode.Entry.Start
+			}
+
+			results[i].Position = PositionOf(config.source, start)
+		}
+	}
+
+	return results
+}
+
+func (q *Query) run(nodes []queryNode) []queryNode {
+	for _, step := range q.steps {
+		selected := []queryNode{}
+
+		for _, node := range nodes {
+			if step.recursive {
+				for _, descendant := range node.descendants() {
+					selected = append(selected, step.apply(descendant)...)
+				}
+
+				continue
+			}
+
+			selected = append(selected, step.apply(node)...)
+		}
+
+		nodes = selected
+	}
+
+	return nodes
+}
+
+// queryNode is a value met while running a query: either an evaluated value
+// or an expression. written is the path the expression was written at, which
+// differs from path for list items after an expansion, and is nil for
+// expressions that came from an expansion or reference. Those have the path
+// of their nearest written ancestor in anchor instead. An evaluated value can
+// have the expression it was evaluated from in order, which gives the order
+// of its keys.
+type queryNode struct {
+	path    Path
+	value   interface{}
+	expr    common.Expression
+	written Path
+	anchor  Path
+	order   common.Expression
+}
+
+func (n queryNode) child(element interface{}, val interface{}, expr common.Expression, writtenElement interface{}) queryNode {
+	child := queryNode{path: n.path.Child(element), value: val, expr: expr}
+
+	switch {
+	case n.written != nil && writtenElement != nil:
+		child.written = n.written.Child(writtenElement)
+	case n.written != nil:
+		child.anchor = n.written
+	default:
+		child.anchor = n.anchor
+	}
+
+	return child
+}
+
+// target looks through tags, transformers and resolved references, and
+// reports whether it went through a reference.
+func (n queryNode) target() (common.Expression, bool) {
+	expr := n.expr
+	viaReference := false
+
+	for {
+		switch e := expr.(type) {
+		case flimexpr.TaggedExpression:
+			expr = e.Expression()
+		case flimexpr.TransformerExpression:
+			expr = e.Expression()
+		case flimexpr.MappedTransformerExpression:
+			expr = e.Expression()
+		case flimexpr.ReferenceExpression:
+			target, resolved := e.Target()
+
+			if !resolved {
+				return expr, viaReference
+			}
+
+			expr = target
+			viaReference = true
+		default:
+			return expr, viaReference
+		}
+	}
+}
+
+// mapEntries returns the values of a map in order, or false if the node is
+// not a map.
+func (n queryNode) mapEntries() ([]queryNode, bool) {
+	if n.expr == nil {
+		m, ok := n.value.(map[string]interface{})
+
+		if !ok {
+			return nil, false
+		}
+
+		keys := make([]string, 0, len(m))
+		orders := map[string]common.Expression{}
+
+		if n.order != nil {
+			written, _ := queryNode{expr: n.order}.mapEntries()
+
+			for _, entry := range written {
+				key := entry.path[len(entry.path)-1].(string)
+
+				if _, exists := m[key]; exists {
+					keys = append(keys, key)
+					orders[key] = entry.expr
+				}
+			}
+		}
+
+		unordered := []string{}
+
+		for key := range m {
+			if _, exists := orders[key]; !exists {
+				unordered = append(unordered, key)
+			}
+		}
+
+		sort.Strings(unordered)
+		keys = append(keys, unordered...)
+		entries := make([]queryNode, len(keys))
+
+		for i, key := range keys {
+			entries[i] = n.child(key, m[key], nil, nil)
+			entries[i].order = orders[key]
+		}
+
+		return entries, true
+	}
+
+	target, viaReference := n.target()
+	m, ok := target.(flimexpr.MapExpression)
+
+	if !ok {
+		return nil, false
+	}
+
+	return n.collectEntries(m, !viaReference), true
+}
+
+// collectEntries merges the pairs and expansions of m, later keys replacing
+// earlier ones in place.
+func (n queryNode) collectEntries(m flimexpr.MapExpression, own bool) []queryNode {
+	entries := []queryNode{}
+	positions := map[string]int{}
+
+	set := func(entry queryNode) {
+		key := entry.path[len(entry.path)-1].(string)
+
+		if i, exists := positions[key]; exists {
+			entries[i] = entry
+			return
+		}
+
+		positions[key] = len(entries)
+		entries = append(entries, entry)
+	}
+
+	for _, pairExpr := range m.Pairs() {
+		switch pair := pairExpr.(type) {
+		case flimexpr.PairExpression:
+			var writtenElement interface{}
+
+			if own {
+				writtenElement = pair.Key()
+			}
+
+			set(n.child(pair.Key(), nil, pair.Value(), writtenElement))
+		case flimexpr.ExpandingExpression:
+			target, _ := queryNode{expr: pair.Expression()}.target()
+			expanded, ok := target.(flimexpr.MapExpression)
+
+			if !ok {
+				continue
+			}
+
+			for _, entry := range n.collectEntries(expanded, false) {
+				set(entry)
+			}
+		}
+	}
+
+	return entries
+}
+
+// listItems returns the items of a list, or false if the node is not a list.
+func (n queryNode) listItems() ([]queryNode, bool) {
+	if n.expr == nil {
+		list, ok := n.value.([]interface{})
+
+		if !ok {
+			return nil, false
+		}
+
+		listItems := make([]queryNode, len(list))
+		written := []queryNode{}
+
+		if n.order != nil {
+			written, _ = queryNode{expr: n.order}.listItems()
+		}
+
+		for i, listItem := range list {
+			listItems[i] = n.child(i, listItem, nil, nil)
+
+			if i < len(written) {
+				listItems[i].order = written[i].expr
+			}
+		}
+
+		return listItems, true
+	}
+
+	target, viaReference := n.target()
+	list, ok := target.(flimexpr.ListExpression)
+
+	if !ok {
+		return nil, false
+	}
+
+	listItems := []queryNode{}
+	n.collectItems(list, !viaReference, &listItems)
+	return listItems, true
+}
+
+func (n queryNode) collectItems(list flimexpr.ListExpression, own bool, listItems *[]queryNode) {
+	for i, listItem := range list.Items() {
+		expansion, ok := listItem.(flimexpr.ExpandingExpression)
+
+		if !ok {
+			var writtenElement interface{}
+
+			if own {
+				writtenElement = i
+			}
+
+			*listItems = append(*listItems, n.child(len(*listItems), nil, listItem, writtenElement))
+			continue
+		}
+
+		target, _ := queryNode{expr: expansion.Expression()}.target()
+
+		if expanded, ok := target.(flimexpr.ListExpression); ok {
+			n.collectItems(expanded, false, listItems)
+		}
+	}
+}
+
+// children returns the values of a map or items of a list.
+func (n queryNode) children() []queryNode {
+	if entries, ok := n.mapEntries(); ok {
+		return entries
+	}
+
+	listItems, _ := n.listItems()
+	return listItems
+}
+
+// descendants returns the node and everything below it, depth first.
+func (n queryNode) descendants() []queryNode {
+	nodes := []queryNode{n}
+
+	for _, child := range n.children() {
+		nodes = append(nodes, child.descendants()...)
+	}
+
+	return nodes
+}
+
+// scalar returns the value of a node that is not a map or list. Expressions
+// that cannot be evaluated without handlers have no value.
+func (n queryNode) scalar() (interface{}, bool) {
+	if n.expr == nil {
+		switch n.value.(type) {
+		case map[string]interface{}, []interface{}:
+			return nil, false
+		}
+
+		return n.value, true
+	}
+
+	target, _ := n.target()
+
+	if KindOf(target) != LiteralNode {
+		return nil, false
+	}
+
+	val, err := common.Evaluate(target, nil)
+	return val, err == nil
+}
+
+func (s queryStep) apply(n queryNode) []queryNode {
+	switch s.kind {
+	case keyStep:
+		entries, _ := n.mapEntries()
+
+		for _, entry := range entries {
+			if entry.path[len(entry.path)-1] == s.key {
+				return []queryNode{entry}
+			}
+		}
+	case indexStep:
+		listItems, _ := n.listItems()
+		index := s.index
+
+		if index < 0 {
+			index += len(listItems)
+		}
+
+		if index >= 0 && index < len(listItems) {
+			return []queryNode{listItems[index]}
+		}
+	case wildcardStep:
+		return n.children()
+	case filterStep:
+		selected := []queryNode{}
+
+		for _, child := range n.children() {
+			if s.filter.holds(child) {
+				selected = append(selected, child)
+			}
+		}
+
+		return selected
+	}
+
+	return nil
+}
+
+// queryFilter is a condition on a node.
+type queryFilter interface {
+	holds(n queryNode) bool
+}
+
+type notFilter struct {
+	filter queryFilter
+}
+
+func (f notFilter) holds(n queryNode) bool {
+	return !f.filter.holds(n)
+}
+
+type logicalFilter struct {
+	and         bool
+	left, right queryFilter
+}
+
+func (f logicalFilter) holds(n queryNode) bool {
+	if f.and {
+		return f.left.holds(n) && f.right.holds(n)
+	}
+
+	return f.left.holds(n) || f.right.holds(n)
+}
+
+// existsFilter holds if the relative query selects anything.
+type existsFilter struct {
+	query *Query
+}
+
+func (f existsFilter) holds(n queryNode) bool {
+	return len(f.query.run([]queryNode{n})) > 0
+}
+
+type compareFilter struct {
+	query    *Query
+	operator string
+	literal  interface{}
+}
+
+func (f compareFilter) holds(n queryNode) bool {
+	for _, selected := range f.query.run([]queryNode{n}) {
+		if val, ok := selected.scalar(); ok && compareQueryValues(val, f.operator, f.literal) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// compareQueryValues compares an evaluated value with a literal from a filter.
+// Numbers of any type compare by value, and strings, including those of
+// custom literals, compare lexically.
+func compareQueryValues(val interface{}, operator string, literal interface{}) bool {
+	var cmp int
+
+	if a, ok := queryNumber(val); ok {
+		b, ok := queryNumber(literal)
+
+		if !ok {
+			return operator == "!="
+		}
+
+		cmp = a.Cmp(b)
+	} else if a, ok := val.(string); ok {
+		b, ok := literal.(string)
+
+		if !ok {
+			return operator == "!="
+		}
+
+		cmp = strings.Compare(a, b)
+	} else if a, ok := val.(fmt.Stringer); ok && literal != nil && reflect.TypeOf(literal).Kind() == reflect.String {
+		cmp = strings.Compare(a.String(), literal.(string))
+	} else {
+		equal := reflect.DeepEqual(val, literal)
+		return operator == "==" && equal || operator == "!=" && !equal
+	}
+
+	switch operator {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+func queryNumber(val interface{}) (*big.Float, bool) {
+	switch v := val.(type) {
+	case *big.Int:
+		return new(big.Float).SetInt(v), true
+	case *big.Float:
+		return v, true
+	}
+
+	rv := reflect.ValueOf(val)
+
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return new(big.Float).SetInt64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return new(big.Float).SetUint64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return big.NewFloat(rv.Float()), true
+	default:
+		return nil, false
+	}
+}
+
+type queryTokenKind int
+
+const (
+	queryPunctuation queryTokenKind = iota
+	queryIdentifier
+	queryString
+	queryNumberLiteral
+	queryEnd
+)
+
+type queryToken struct {
+	kind queryTokenKind
+	text string
+}
+
+func (t queryToken) is(text string) bool {
+	if text == "" {
+		return t.kind == queryEnd
+	}
+
+	return t.kind == queryPunctuation && t.text == text
+}
+
+var queryOperators = []string{"..", "==", "!=", "<=", ">=", "&&", "||", ".", "[", "]", "*", "?", "@", "(", ")", "<", ">", "!"}
+
+func lexQuery(text string) ([]queryToken, error) {
+	tokens := []queryToken{}
+
+	for i := 0; i < len(text); {
+		c := rune(text[i])
+
+		switch {
+		case unicode.IsSpace(c):
+			i++
+			continue
+		case c == '"' || c == '\'':
+			end := i + 1
+
+			for end < len(text) && text[end] != byte(c) {
+				if text[end] == '\\' {
+					end++
+				}
+
+				end++
+			}
+
+			if end >= len(text) {
+				return nil, fmt.Errorf("unterminated string in query `%s'", text)
+			}
+
+			raw := text[i : end+1]
+
+			if c == '\'' {
+				raw = `"` + strings.ReplaceAll(strings.ReplaceAll(raw[1:len(raw)-1], `\'`, `'`), `"`, `\"`) + `"`
+			}
+
+			str, err := strconv.Unquote(raw)
+
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %s in query", text[i:end+1])
+			}
+
+			tokens = append(tokens, queryToken{queryString, str})
+			i = end + 1
+			continue
+		case c == '-' || c >= '0' && c <= '9':
+			end := i + 1
+
+			for end < len(text) && strings.ContainsRune("0123456789.eE_", rune(text[end])) {
+				// A dot is only part of a number if a digit follows
+				if text[end] == '.' && (end+1 >= len(text) || text[end+1] < '0' || text[end+1] > '9') {
+					break
+				}
+
+				end++
+			}
+
+			tokens = append(tokens, queryToken{queryNumberLiteral, text[i:end]})
+			i = end
+			continue
+		case c == '_' || unicode.IsLetter(c):
+			end := i + 1
+
+			for end < len(text) && (text[end] == '_' || unicode.IsLetter(rune(text[end])) || unicode.IsDigit(rune(text[end]))) {
+				end++
+			}
+
+			tokens = append(tokens, queryToken{queryIdentifier, text[i:end]})
+			i = end
+			continue
+		}
+
+		found := false
+
+		for _, operator := range queryOperators {
+			if strings.HasPrefix(text[i:], operator) {
+				tokens = append(tokens, queryToken{queryPunctuation, operator})
+				i += len(operator)
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("unexpected `%c' in query `%s'", c, text)
+		}
+	}
+
+	return append(tokens, queryToken{kind: queryEnd}), nil
+}
+
+type queryParser struct {
+	tokens []queryToken
+}
+
+func (p *queryParser) peek() queryToken {
+	return p.tokens[0]
+}
+
+func (p *queryParser) pop() queryToken {
+	token := p.tokens[0]
+
+	if token.kind != queryEnd {
+		p.tokens = p.tokens[1:]
+	}
+
+	return token
+}
+
+func (p *queryParser) expect(text string) error {
+	if token := p.pop(); !token.is(text) {
+		if token.kind == queryEnd {
+			return fmt.Errorf("expected `%s' at end of query", text)
+		}
+
+		return fmt.Errorf("expected `%s', found `%s'", text, token.text)
+	}
+
+	return nil
+}
+
+// steps parses steps until something that cannot start a step. A bare key is
+// allowed first, as in inventory.items.
+func (p *queryParser) steps(first bool) ([]queryStep, error) {
+	steps := []queryStep{}
+
+	if first && p.peek().kind == queryIdentifier {
+		steps = append(steps, queryStep{kind: keyStep, key: p.pop().text})
+	}
+
+	for {
+		token := p.peek()
+
+		switch {
+		case token.is("."), token.is(".."):
+			p.pop()
+			step := queryStep{recursive: token.is("..")}
+
+			switch next := p.pop(); {
+			case next.kind == queryIdentifier:
+				step.kind = keyStep
+				step.key = next.text
+			case next.is("*"):
+				step.kind = wildcardStep
+			case next.is("[") && step.recursive:
+				bracketStep, err := p.bracket()
+
+				if err != nil {
+					return nil, err
+				}
+
+				bracketStep.recursive = true
+				step = bracketStep
+			default:
+				return nil, fmt.Errorf("expected a key after `%s'", token.text)
+			}
+
+			steps = append(steps, step)
+		case token.is("["):
+			p.pop()
+			step, err := p.bracket()
+
+			if err != nil {
+				return nil, err
+			}
+
+			steps = append(steps, step)
+		default:
+			return steps, nil
+		}
+	}
+}
+
+// bracket parses the rest of a step that starts with [.
+func (p *queryParser) bracket() (queryStep, error) {
+	var step queryStep
+	token := p.pop()
+
+	switch {
+	case token.kind == queryString:
+		step = queryStep{kind: keyStep, key: token.text}
+	case token.kind == queryNumberLiteral:
+		index, err := strconv.Atoi(token.text)
+
+		if err != nil {
+			return step, fmt.Errorf("invalid list index `%s'", token.text)
+		}
+
+		step = queryStep{kind: indexStep, index: index}
+	case token.is("*"):
+		step = queryStep{kind: wildcardStep}
+	case token.is("?"):
+		filter, err := p.or()
+
+		if err != nil {
+			return step, err
+		}
+
+		step = queryStep{kind: filterStep, filter: filter}
+	case token.kind == queryEnd:
+		return step, fmt.Errorf("unexpected end of query after `['")
+	default:
+		return step, fmt.Errorf("unexpected `%s' after `['", token.text)
+	}
+
+	return step, p.expect("]")
+}
+
+func (p *queryParser) or() (queryFilter, error) {
+	left, err := p.and()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().is("||") {
+		p.pop()
+		right, err := p.and()
+
+		if err != nil {
+			return nil, err
+		}
+
+		left = logicalFilter{and: false, left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *queryParser) and() (queryFilter, error) {
+	left, err := p.unary()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().is("&&") {
+		p.pop()
+		right, err := p.unary()
+
+		if err != nil {
+			return nil, err
+		}
+
+		left = logicalFilter{and: true, left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *queryParser) unary() (queryFilter, error) {
+	switch {
+	case p.peek().is("!"):
+		p.pop()
+		filter, err := p.unary()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return notFilter{filter}, nil
+	case p.peek().is("("):
+		p.pop()
+		filter, err := p.or()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return filter, p.expect(")")
+	}
+
+	// A relative path, which @ starts if it does not start with a key
+	query := &Query{}
+
+	if p.peek().is("@") {
+		p.pop()
+	} else if p.peek().kind != queryIdentifier {
+		return nil, fmt.Errorf("expected a path in filter, found `%s'", p.peek().text)
+	}
+
+	steps, err := p.steps(true)
+
+	if err != nil {
+		return nil, err
+	}
+
+	query.steps = steps
+
+	operator := p.peek()
+
+	if operator.kind != queryPunctuation || !strings.Contains(" == != < <= > >= ", " "+operator.text+" ") {
+		return existsFilter{query}, nil
+	}
+
+	p.pop()
+	literal, err := p.literal()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return compareFilter{query: query, operator: operator.text, literal: literal}, nil
+}
+
+func (p *queryParser) literal() (interface{}, error) {
+	token := p.pop()
+
+	switch token.kind {
+	case queryString:
+		return token.text, nil
+	case queryNumberLiteral:
+		text := strings.ReplaceAll(token.text, "_", "")
+
+		if val, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return val, nil
+		}
+
+		val, err := strconv.ParseFloat(text, 64)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid number `%s' in filter", token.text)
+		}
+
+		return val, nil
+	case queryIdentifier:
+		switch token.text {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+	}
+
+	return nil, fmt.Errorf("expected a string, number, true, false or null, found `%s'", token.text)
+}
@@ -0,0 +1,237 @@
+package flim
+
+import (
+	"github.com/l-donovan/flim/common"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const queryDocument = `#defaults { port 80 }
+{
+    name "svc"
+    servers [
+        { *&defaults host "a" }
+        { host "b" port 8080 disabled true }
+        { host "c" port 9090 }
+    ]
+    limits { memory 64 cpu 4 }
+    "dotted.key" 1
+    extra [ *[ 1 2 ] 3 ]
+}
+`
+
+func resultPaths(results []QueryResult) []string {
+	paths := make([]string, len(results))
+
+	for i, result := range results {
+		paths[i] = result.Path.String()
+	}
+
+	return paths
+}
+
+func TestQueryExpressions(t *testing.T) {
+	expr, err := ParseString(queryDocument)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{".", []string{"."}},
+		{".name", []string{"name"}},
+		{"name", []string{"name"}},
+		{`["dotted.key"]`, []string{`["dotted.key"]`}},
+		{".servers[1].host", []string{"servers[1].host"}},
+		{".servers[-1].host", []string{"servers[2].host"}},
+		{".servers[5]", []string{}},
+		{".missing.key", []string{}},
+		{".limits.*", []string{"limits.memory", "limits.cpu"}},
+		{".servers[*].port", []string{"servers[0].port", "servers[1].port", "servers[2].port"}},
+		{".extra[*]", []string{"extra[0]", "extra[1]", "extra[2]"}},
+		{"..port", []string{"servers[0].port", "servers[1].port", "servers[2].port"}},
+		{"..host", []string{"servers[0].host", "servers[1].host", "servers[2].host"}},
+		{`.servers[?host == "b"]`, []string{"servers[1]"}},
+		{".servers[?port >= 8000 && !disabled].host", []string{"servers[2].host"}},
+		{".servers[?disabled]", []string{"servers[1]"}},
+		{".servers[?port == 80 || port == 9090]", []string{"servers[0]", "servers[2]"}},
+		{".limits[?@ > 10]", []string{"limits.memory"}},
+		{".extra[?@ != 2]", []string{"extra[0]", "extra[2]"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			query, err := ParseQuery(test.query)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := resultPaths(query.Expressions(expr)); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+// Running a query on evaluated values selects the same paths, with keys in
+// sorted order unless the document gives their order.
+func TestQueryValues(t *testing.T) {
+	expr, err := ParseString(queryDocument)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	val, err := common.Evaluate(expr, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query   string
+		ordered bool
+		want    []string
+	}{
+		{".limits.*", false, []string{"limits.cpu", "limits.memory"}},
+		{".limits.*", true, []string{"limits.memory", "limits.cpu"}},
+		{".*", false, []string{`["dotted.key"]`, "extra", "limits", "name", "servers"}},
+		{".*", true, []string{"name", "servers", "limits", `["dotted.key"]`, "extra"}},
+		{"..port", true, []string{"servers[0].port", "servers[1].port", "servers[2].port"}},
+		{"..[?@ == 4 || @ == 3]", true, []string{"limits.cpu", "extra[2]"}},
+		{"..[?@ == 4 || @ == 3]", false, []string{"extra[2]", "limits.cpu"}},
+		{".servers[0].*", false, []string{"servers[0].host", "servers[0].port"}},
+		{".servers[0].*", true, []string{"servers[0].port", "servers[0].host"}},
+		{".servers[?port >= 8000 && !disabled].host", false, []string{"servers[2].host"}},
+		{".extra[-1]", false, []string{"extra[2]"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			query, err := ParseQuery(test.query)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var options []QueryOption
+
+			if test.ordered {
+				options = append(options, WithDocumentOrder(expr))
+			}
+
+			if got := resultPaths(query.Values(val, options...)); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+
+	query, err := ParseQuery(".servers[1].port")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if results := query.Values(val); len(results) != 1 || results[0].Value != int64(8080) {
+		t.Errorf("got %v, want 8080", results)
+	}
+}
+
+// The String of a path is a query that selects it.
+func TestQueryPathString(t *testing.T) {
+	expr, err := ParseString(queryDocument)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := ParseQuery("..*")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, result := range all.Expressions(expr) {
+		query, err := ParseQuery(result.Path.String())
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := resultPaths(query.Expressions(expr)); len(got) != 1 || got[0] != result.Path.String() {
+			t.Errorf("`%s' selected %v", result.Path, got)
+		}
+	}
+}
+
+func TestQueryPositions(t *testing.T) {
+	sourceMap := &SourceMap{}
+	expr, err := ParseString(queryDocument, WithSourceMap(sourceMap))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query string
+		line  int
+	}{
+		{".name", 3},
+		{".servers[1].port", 6},
+		{".limits.cpu", 9},
+		{".extra[2]", 11},
+		// Written in the tag, not at this path
+		{".servers[0].port", 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			query, err := ParseQuery(test.query)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			results := query.Expressions(expr, WithPositions(queryDocument, sourceMap))
+
+			if len(results) != 1 {
+				t.Fatalf("got %d results", len(results))
+			}
+
+			if got := results[0].Position.Line; got != test.line {
+				t.Errorf("got line %d, want %d", got, test.line)
+			}
+		})
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		err   string
+	}{
+		{`["open`, "unterminated string"},
+		{".a[1.5]", "invalid list index `1.5'"},
+		{".a[x]", "unexpected `x' after `['"},
+		{".a[", "unexpected end of query"},
+		{".a b", "unexpected `b'"},
+		{".a..", "expected a key after `..'"},
+		{".a[?b == ]", "expected a string, number, true, false or null"},
+		{".a[?== 1]", "expected a path in filter"},
+		{".a[?b == 1", "expected `]' at end of query"},
+		{".a$", "unexpected `$'"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			if _, err := ParseQuery(test.query); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("got error %v, want one containing `%s'", err, test.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,1086 @@
+package flim
+
+import (
+	"fmt"
+	"github.com/l-donovan/flim/common"
+	flimexpr "github.com/l-donovan/flim/expressions"
+	"math/big"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Schema describes the values a document, or part of one, may hold. Schemas
+// are written in flim as maps, e.g.
+//
+//	{
+//	    type "map"
+//	    keys {
+//	        port {type "int" min 1 max 65535}
+//	        mode {type "string" enum ["fast" "safe"] optional true}
+//	        hosts {type "list" min_items 1 items "string"}
+//	    }
+//	    additional_keys false
+//	    transformers {
+//	        from {input "string" output "any"}
+//	    }
+//	}
+//
+// A schema written as just a string, like "string" above, only gives a type.
+// The types are any, map, list, string, int, float, number (an int or a
+// float), bool, null, duration, size and timestamp, along with the name of any
+// literal kind given by WithLiterals. type can also be a list of types. one_of
+// lists schemas of which a value must match exactly one.
+//
+// Keys are required unless their schema sets optional. Tags and references
+// can be used to share schemas, and a shared schema can refer to itself.
+// Comments written above a schema describe it, unless it has a description of
+// its own.
+type Schema struct {
+	// Types lists the types the value may have. Any type is allowed if it is
+	// empty.
+	Types []string
+
+	// Optional marks a key of a map as not required.
+	Optional bool
+
+	// Description documents the value.
+	Description string
+
+	// Tag is the tag the schema was written under, if it was shared
+	Tag string
+
+	// Enum lists the values allowed, if it is not empty.
+	Enum []interface{}
+
+	// Min and Max bound numbers, durations, sizes and timestamps. They are nil
+	// if unset.
+	Min interface{}
+	Max interface{}
+
+	// Pattern is a regular expression strings must match.
+	Pattern *regexp.Regexp
+
+	// MinItems and MaxItems bound the length of a list. They are nil if unset.
+	MinItems *int
+	MaxItems *int
+
+	// Items describes each item of a list.
+	Items *Schema
+
+	// Keys describes the keys of a map, in the order they were written.
+	Keys []SchemaKey
+
+	// AdditionalKeys describes the values of keys not in Keys. Any key is
+	// allowed if it is nil, unless ClosedKeys is set.
+	AdditionalKeys *Schema
+	ClosedKeys     bool
+
+	// OneOf lists schemas of which the value must match exactly one, as well
+	// as matching this schema.
+	OneOf []*Schema
+
+	// Transformers describes what transformers accept and produce. It is
+	// only read from the top-level schema and applies throughout the
+	// document.
+	Transformers map[string]TransformerSchema
+}
+
+// SchemaKey is a key of a map and the schema of its value.
+type SchemaKey struct {
+	Name   string
+	Schema *Schema
+}
+
+// TransformerSchema describes a transformer. Input, if set, is checked
+// against what the transformer is applied to, or each item a mapped
+// transformer is applied to. Output, if set, stands in for the value the
+// transformer produces, which cannot be known without running it.
+type TransformerSchema struct {
+	Input  *Schema
+	Output *Schema
+}
+
+// Key returns the schema of a key of a map.
+func (s *Schema) Key(name string) (*Schema, bool) {
+	for _, key := range s.Keys {
+		if key.Name == name {
+			return key.Schema, true
+		}
+	}
+
+	return nil, false
+}
+
+var schemaTypes = []string{"any", "map", "list", "string", "int", "float", "number", "bool", "null", "duration", "size", "timestamp"}
+
+// LoadSchema reads a schema from a flim file.
+func LoadSchema(filename string, options ...ParserOption) (*Schema, error) {
+	doc, err := LoadDocument(filename, options...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseSchemaDocument(doc, options...)
+}
+
+// ParseSchemaDocument reads a schema from a document, taking the
+// descriptions the schema lacks from its comments. Options are those the
+// document was parsed with, as for ParseSchema.
+func ParseSchemaDocument(doc *Document, options ...ParserOption) (*Schema, error) {
+	schema, err := ParseSchema(doc.Expression, options...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	schema.describe(doc, documentValue(doc.Expression), sourceLocation{doc.SourceMap.document, Path{}}, map[*Schema]bool{})
+	return schema, nil
+}
+
+// ParseSchema reads a schema from a parsed document, whose references must
+// have been resolved. Options are those the document was parsed with, so that
+// types can name the literal kinds given by WithLiterals.
+func ParseSchema(expr common.Expression, options ...ParserOption) (*Schema, error) {
+	p := &schemaParser{literals: NewParser(options...).literals, parsing: map[string]*Schema{}}
+	schema, err := parseSchema(documentValue(expr), Path{}, p)
+
+	if err != nil {
+		return nil, fmt.Errorf("schema: %w", err)
+	}
+
+	return schema, nil
+}
+
+// schemaParser holds what is needed while reading a schema: the literal kinds
+// types can name, and the shared schemas being read, by tag, so that a schema
+// that refers to one it is within gets that same schema.
+type schemaParser struct {
+	literals *Literals
+	parsing  map[string]*Schema
+}
+
+func parseSchema(expr common.Expression, path Path, p *schemaParser) (*Schema, error) {
+	schema := &Schema{}
+
+	switch e := expr.(type) {
+	case flimexpr.TaggedExpression:
+		schema.Tag = e.Tag()
+	case flimexpr.ReferenceExpression:
+		schema.Tag = e.Name()
+	}
+
+	if schema.Tag != "" {
+		if enclosing, exists := p.parsing[schema.Tag]; exists {
+			return enclosing, nil
+		}
+
+		p.parsing[schema.Tag] = schema
+		defer delete(p.parsing, schema.Tag)
+	}
+
+	switch e := diffTarget(expr).(type) {
+	case flimexpr.StringLiteralExpression:
+		if err := schema.setTypes(e, path, p); err != nil {
+			return nil, err
+		}
+
+		return schema, nil
+	case flimexpr.MapExpression:
+		entries, static := effectiveEntries(e)
+
+		if !static {
+			return nil, fmt.Errorf("%s: schemas can only expand other maps", path)
+		}
+
+		for _, key := range entries.keys {
+			if err := schema.set(key, entries.values[key], path.Child(key), p); err != nil {
+				return nil, err
+			}
+		}
+
+		return schema, nil
+	default:
+		return nil, fmt.Errorf("%s: a schema must be a map or a type name", path)
+	}
+}
+
+func (s *Schema) setTypes(expr common.Expression, path Path, p *schemaParser) error {
+	val, err := common.Evaluate(expr, nil)
+
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	types, ok := val.([]interface{})
+
+	if !ok {
+		types = []interface{}{val}
+	}
+
+	for _, t := range types {
+		name, ok := t.(string)
+
+		if !ok {
+			return fmt.Errorf("%s: types must be strings", path)
+		}
+
+		if _, custom := p.literals.lookup(name); !custom && !containsString(schemaTypes, name) {
+			return fmt.Errorf("%s: unknown type `%s'", path, name)
+		}
+
+		s.Types = append(s.Types, name)
+	}
+
+	return nil
+}
+
+func containsString(strs []string, str string) bool {
+	for _, s := range strs {
+		if s == str {
+			return true
+		}
+	}
+
+	return false
+}
+
+// set sets a field of the schema from a key of its map.
+func (s *Schema) set(key string, expr common.Expression, path Path, p *schemaParser) error {
+	if key == "type" {
+		return s.setTypes(expr, path, p)
+	}
+
+	if key == "items" || key == "additional_keys" || key == "keys" || key == "transformers" || key == "one_of" {
+		return s.setNested(key, expr, path, p)
+	}
+
+	val, err := common.Evaluate(expr, nil)
+
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	switch key {
+	case "optional":
+		optional, ok := val.(bool)
+
+		if !ok {
+			return fmt.Errorf("%s: expected a bool", path)
+		}
+
+		s.Optional = optional
+	case "description":
+		description, ok := val.(string)
+
+		if !ok {
+			return fmt.Errorf("%s: expected a string", path)
+		}
+
+		s.Description = description
+	case "enum":
+		enum, ok := val.([]interface{})
+
+		if !ok {
+			return fmt.Errorf("%s: expected a list", path)
+		}
+
+		s.Enum = enum
+	case "min", "max":
+		if _, ok := schemaOrdered(val); !ok {
+			return fmt.Errorf("%s: expected a number, duration, size or timestamp", path)
+		}
+
+		if key == "min" {
+			s.Min = val
+		} else {
+			s.Max = val
+		}
+	case "pattern":
+		pattern, ok := val.(string)
+
+		if !ok {
+			return fmt.Errorf("%s: expected a string", path)
+		}
+
+		if s.Pattern, err = regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	case "min_items", "max_items":
+		count, ok := val.(int64)
+
+		if !ok || count < 0 {
+			return fmt.Errorf("%s: expected a count", path)
+		}
+
+		n := int(count)
+
+		if key == "min_items" {
+			s.MinItems = &n
+		} else {
+			s.MaxItems = &n
+		}
+	default:
+		return fmt.Errorf("%s: unknown schema key `%s'", path, key)
+	}
+
+	return nil
+}
+
+func (s *Schema) setNested(key string, expr common.Expression, path Path, p *schemaParser) error {
+	switch key {
+	case "items":
+		items, err := parseSchema(expr, path, p)
+		s.Items = items
+		return err
+	case "one_of":
+		list, ok := diffTarget(expr).(flimexpr.ListExpression)
+		listItems, static := effectiveItems(list)
+
+		if !ok || !static {
+			return fmt.Errorf("%s: expected a list of schemas", path)
+		}
+
+		for i, listItem := range listItems {
+			alternative, err := parseSchema(listItem, path.Child(i), p)
+
+			if err != nil {
+				return err
+			}
+
+			s.OneOf = append(s.OneOf, alternative)
+		}
+
+		return nil
+	case "additional_keys":
+		if b, ok := diffTarget(expr).(flimexpr.BooleanLiteralExpression); ok {
+			s.ClosedKeys = !b.Value()
+			return nil
+		}
+
+		additional, err := parseSchema(expr, path, p)
+		s.AdditionalKeys = additional
+		return err
+	}
+
+	m, ok := diffTarget(expr).(flimexpr.MapExpression)
+	entries, static := effectiveEntries(m)
+
+	if !ok || !static {
+		return fmt.Errorf("%s: expected a map", path)
+	}
+
+	for _, name := range entries.keys {
+		entryPath := path.Child(name)
+
+		if key == "keys" {
+			keySchema, err := parseSchema(entries.values[name], entryPath, p)
+
+			if err != nil {
+				return err
+			}
+
+			s.Keys = append(s.Keys, SchemaKey{name, keySchema})
+			continue
+		}
+
+		transformer, err := parseTransformerSchema(entries.values[name], entryPath, p)
+
+		if err != nil {
+			return err
+		}
+
+		if s.Transformers == nil {
+			s.Transformers = map[string]TransformerSchema{}
+		}
+
+		s.Transformers[name] = transformer
+	}
+
+	return nil
+}
+
+func parseTransformerSchema(expr common.Expression, path Path, p *schemaParser) (TransformerSchema, error) {
+	transformer := TransformerSchema{}
+	m, ok := diffTarget(expr).(flimexpr.MapExpression)
+	entries, static := effectiveEntries(m)
+
+	if !ok || !static {
+		return transformer, fmt.Errorf("%s: expected a map of input and output", path)
+	}
+
+	for _, key := range entries.keys {
+		schema, err := parseSchema(entries.values[key], path.Child(key), p)
+
+		if err != nil {
+			return transformer, err
+		}
+
+		switch key {
+		case "input":
+			transformer.Input = schema
+		case "output":
+			transformer.Output = schema
+		default:
+			return transformer, fmt.Errorf("%s: unknown transformer schema key `%s'", path.Child(key), key)
+		}
+	}
+
+	return transformer, nil
+}
+
+// describe sets the descriptions of the schema written at location, and the
+// schemas within it, that have none from the comments written above them.
+// Shared schemas fall back to the comment above their tag. seen holds the
+// schemas already described, as a schema can be within itself.
+func (s *Schema) describe(doc *Document, expr common.Expression, location sourceLocation, seen map[*Schema]bool) {
+	if s == nil || seen[s] {
+		return
+	}
+
+	seen[s] = true
+
+	if s.Description == "" {
+		s.Description = doc.commentAt(location)
+	}
+
+	for {
+		if tagged, ok := expr.(flimexpr.TaggedExpression); ok {
+			expr = tagged.Expression()
+			continue
+		}
+
+		ref, ok := expr.(flimexpr.ReferenceExpression)
+
+		if !ok {
+			break
+		}
+
+		target, resolved := ref.Target()
+		tagLocation, exists := doc.SourceMap.tagged[ref.Name()]
+
+		if !resolved || !exists {
+			return
+		}
+
+		if s.Description == "" {
+			s.Description = doc.TagComment(ref.Name())
+		}
+
+		expr, location = target, tagLocation
+	}
+
+	for _, pair := range writtenPairs(expr) {
+		at := location.child(pair.Key())
+
+		switch pair.Key() {
+		case "items":
+			s.Items.describe(doc, pair.Value(), at, seen)
+		case "additional_keys":
+			s.AdditionalKeys.describe(doc, pair.Value(), at, seen)
+		case "one_of":
+			if list, ok := pair.Value().(flimexpr.ListExpression); ok {
+				for i, listItem := range list.Items() {
+					if i < len(s.OneOf) {
+						s.OneOf[i].describe(doc, listItem, at.child(i), seen)
+					}
+				}
+			}
+		case "keys":
+			for _, keyPair := range writtenPairs(pair.Value()) {
+				keySchema, _ := s.Key(keyPair.Key())
+				keySchema.describe(doc, keyPair.Value(), at.child(keyPair.Key()), seen)
+			}
+		case "transformers":
+			for _, transformerPair := range writtenPairs(pair.Value()) {
+				transformer := s.Transformers[transformerPair.Key()]
+				transformerAt := at.child(transformerPair.Key())
+
+				for _, schemaPair := range writtenPairs(transformerPair.Value()) {
+					if schemaPair.Key() == "input" {
+						transformer.Input.describe(doc, schemaPair.Value(), transformerAt.child("input"), seen)
+					} else {
+						transformer.Output.describe(doc, schemaPair.Value(), transformerAt.child("output"), seen)
+					}
+				}
+			}
+		}
+	}
+}
+
+// writtenPairs returns the pairs written in a map literal, leaving out any
+// expansions.
+func writtenPairs(expr common.Expression) []flimexpr.PairExpression {
+	m, ok := expr.(flimexpr.MapExpression)
+
+	if !ok {
+		return nil
+	}
+
+	pairs := []flimexpr.PairExpression{}
+
+	for _, pairExpr := range m.Pairs() {
+		if pair, ok := pairExpr.(flimexpr.PairExpression); ok {
+			pairs = append(pairs, pair)
+		}
+	}
+
+	return pairs
+}
+
+// schemaOrdered converts a value that can be bounded by min and max into a
+// number.
+func schemaOrdered(val interface{}) (*big.Float, bool) {
+	if t, ok := val.(time.Time); ok {
+		return new(big.Float).SetInt64(t.UnixNano()), true
+	}
+
+	return queryNumber(val)
+}
+
+// Violation is a way a document does not match a schema.
+type Violation struct {
+	Path     Path
+	Position Position
+	Message  string
+}
+
+// String describes the violation, e.g. `12:5: items[0].port: expected int,
+// found string`. The position is left out if it is not known.
+func (v Violation) String() string {
+	if v.Position.IsValid() {
+		return fmt.Sprintf("%s: %s: %s", v.Position, v.Path, v.Message)
+	}
+
+	return fmt.Sprintf("%s: %s", v.Path, v.Message)
+}
+
+type validateConfig struct {
+	source    string
+	sourceMap *SourceMap
+}
+
+type ValidateOption func(*validateConfig)
+
+// ValidateWithPositions gives each violation found by Validate the position
+// in source of the value at fault, using a source map filled in by parsing
+// source with WithSourceMap.
+func ValidateWithPositions(source string, sourceMap *SourceMap) ValidateOption {
+	return func(c *validateConfig) {
+		c.source = source
+		c.sourceMap = sourceMap
+	}
+}
+
+// Validate checks a document against the schema and reports every violation
+// found, in document order. Tags and resolved references are looked through.
+// Transformers cannot be run, so values they produce are only checked if the
+// schema gives their output, and transformers it does not describe are
+// assumed to produce whatever is expected.
+func (s *Schema) Validate(expr common.Expression, options ...ValidateOption) []Violation {
+	config := &validateConfig{}
+
+	for _, option := range options {
+		option(config)
+	}
+
+	v := &validator{root: s, config: config}
+	v.validate(s, queryNode{path: Path{}, expr: documentValue(expr), written: Path{}})
+	return v.violations
+}
+
+// ValidateValue checks an evaluated value against the schema.
+func (s *Schema) ValidateValue(val interface{}) []Violation {
+	v := &validator{root: s, config: &validateConfig{}}
+	v.validate(s, queryNode{path: Path{}, value: val})
+	return v.violations
+}
+
+type validator struct {
+	root       *Schema
+	config     *validateConfig
+	violations []Violation
+}
+
+func (v *validator) report(n queryNode, format string, args ...interface{}) {
+	violation := Violation{Path: n.path, Message: fmt.Sprintf(format, args...)}
+
+	// Values that were not written in the document are reported where what
+	// holds them was
+	written := n.written
+
+	if written == nil {
+		written = n.anchor
+	}
+
+	if v.config.sourceMap != nil && written != nil {
+		if sourceNode, exists := v.config.sourceMap.Lookup(written); exists {
+			violation.Position = PositionOf(v.config.source, sourceNode.Value.Start)
+		}
+	}
+
+	v.violations = append(v.violations, violation)
+}
+
+func (v *validator) validate(s *Schema, n queryNode) {
+	if s == nil {
+		return
+	}
+
+	if n.expr != nil && v.transformer(s, n) {
+		return
+	}
+
+	if len(s.OneOf) > 0 {
+		v.oneOf(s, n)
+	}
+
+	actual := typeOfNode(n)
+
+	if actual == "" {
+		// An unresolved reference, which could be anything
+		return
+	}
+
+	if !s.allows(actual) {
+		v.report(n, "expected %s, found %s", strings.Join(s.Types, " or "), actual)
+		return
+	}
+
+	switch actual {
+	case "map":
+		v.validateMap(s, n)
+	case "list":
+		v.validateList(s, n)
+	default:
+		if val, ok := n.scalar(); ok {
+			v.validateScalar(s, n, val)
+		}
+	}
+}
+
+// transformer checks a value written with a transformer, reporting whether
+// it was one.
+func (v *validator) transformer(s *Schema, n queryNode) bool {
+	var name string
+	var input common.Expression
+	mapped := false
+
+	switch e := diffTarget(n.expr).(type) {
+	case flimexpr.TransformerExpression:
+		name, input = e.Name(), e.Expression()
+	case flimexpr.MappedTransformerExpression:
+		name, input, mapped = e.Name(), e.Expression(), true
+	default:
+		return false
+	}
+
+	transformer, described := v.root.Transformers[name]
+
+	if !described {
+		return true
+	}
+
+	inputNode := queryNode{path: n.path, expr: input, written: n.written}
+
+	if !mapped {
+		v.validate(transformer.Input, inputNode)
+
+		if transformer.Output != nil && !s.allowsSchema(transformer.Output) {
+			v.report(n, "transformer `%s' produces %s, expected %s", name, strings.Join(transformer.Output.Types, " or "), strings.Join(s.Types, " or "))
+		}
+
+		return true
+	}
+
+	// A mapped transformer keeps the shape of its input, with each value
+	// passed through the transformer
+	actual := typeOfNode(inputNode)
+
+	if actual == "" {
+		return true
+	}
+
+	if !s.allows(actual) {
+		v.report(n, "expected %s, found %s", strings.Join(s.Types, " or "), actual)
+		return true
+	}
+
+	for _, child := range inputNode.children() {
+		v.validate(transformer.Input, child)
+
+		if transformer.Output == nil {
+			continue
+		}
+
+		var childSchema *Schema
+
+		if actual == "list" {
+			childSchema = s.Items
+		} else {
+			childSchema = s.keySchema(child.path[len(child.path)-1].(string))
+		}
+
+		if childSchema != nil && !childSchema.allowsSchema(transformer.Output) {
+			v.report(child, "transformer `%s' produces %s, expected %s", name, strings.Join(transformer.Output.Types, " or "), strings.Join(childSchema.Types, " or "))
+		}
+	}
+
+	return true
+}
+
+// oneOf checks that a value matches exactly one of a schema's alternatives.
+func (v *validator) oneOf(s *Schema, n queryNode) {
+	matches := 0
+
+	for _, alternative := range s.OneOf {
+		attempt := &validator{root: v.root, config: v.config}
+		attempt.validate(alternative, n)
+
+		if len(attempt.violations) == 0 {
+			matches++
+		}
+	}
+
+	switch {
+	case matches == 0:
+		v.report(n, "matches none of the one_of schemas")
+	case matches > 1:
+		v.report(n, "matches %d of the one_of schemas, expected exactly one", matches)
+	}
+}
+
+func (s *Schema) keySchema(key string) *Schema {
+	if keySchema, exists := s.Key(key); exists {
+		return keySchema
+	}
+
+	return s.AdditionalKeys
+}
+
+func (v *validator) validateMap(s *Schema, n queryNode) {
+	entries, _ := n.mapEntries()
+	present := map[string]bool{}
+
+	for _, entry := range entries {
+		key := entry.path[len(entry.path)-1].(string)
+		present[key] = true
+
+		if keySchema, exists := s.Key(key); exists {
+			v.validate(keySchema, entry)
+		} else if s.ClosedKeys {
+			v.report(entry, "unexpected key `%s'", key)
+		} else {
+			v.validate(s.AdditionalKeys, entry)
+		}
+	}
+
+	// Keys from an expansion of anything but a literal map cannot be known
+	if target, _ := n.target(); n.expr != nil {
+		if _, static := effectiveEntries(target.(flimexpr.MapExpression)); !static {
+			return
+		}
+	}
+
+	for _, key := range s.Keys {
+		if !present[key.Name] && !key.Schema.Optional {
+			v.report(n, "missing required key `%s'", key.Name)
+		}
+	}
+}
+
+func (v *validator) validateList(s *Schema, n queryNode) {
+	listItems, _ := n.listItems()
+
+	if s.MinItems != nil && len(listItems) < *s.MinItems {
+		v.report(n, "list has %d items, fewer than the minimum of %d", len(listItems), *s.MinItems)
+	}
+
+	if s.MaxItems != nil && len(listItems) > *s.MaxItems {
+		v.report(n, "list has %d items, more than the maximum of %d", len(listItems), *s.MaxItems)
+	}
+
+	for _, listItem := range listItems {
+		v.validate(s.Items, listItem)
+	}
+}
+
+func (v *validator) validateScalar(s *Schema, n queryNode, val interface{}) {
+	if len(s.Enum) > 0 {
+		found := false
+
+		for _, allowed := range s.Enum {
+			if compareQueryValues(val, "==", allowed) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			v.report(n, "%s is not one of %s", schemaValueString(val), schemaValueString(s.Enum))
+		}
+	}
+
+	if s.Min != nil || s.Max != nil {
+		if number, ok := schemaOrdered(val); ok {
+			if min, ok := schemaOrdered(s.Min); ok && number.Cmp(min) < 0 {
+				v.report(n, "%s is less than the minimum of %s", schemaValueString(val), schemaValueString(s.Min))
+			}
+
+			if max, ok := schemaOrdered(s.Max); ok && number.Cmp(max) > 0 {
+				v.report(n, "%s is greater than the maximum of %s", schemaValueString(val), schemaValueString(s.Max))
+			}
+		}
+	}
+
+	if str, ok := val.(string); ok && s.Pattern != nil && !s.Pattern.MatchString(str) {
+		v.report(n, "%s does not match the pattern `%s'", schemaValueString(val), s.Pattern)
+	}
+}
+
+// schemaValueString writes a value in flim for a violation's message.
+func schemaValueString(val interface{}) string {
+	expr, err := flimexpr.NewExpressionFromValue(val)
+
+	if err != nil {
+		return fmt.Sprint(val)
+	}
+
+	return inlineExpression(expr)
+}
+
+// allows reports whether a value of the given type is allowed.
+func (s *Schema) allows(actual string) bool {
+	if len(s.Types) == 0 {
+		return true
+	}
+
+	for _, t := range s.Types {
+		switch {
+		case t == actual, t == "any":
+			return true
+		case t == "number" && (actual == "int" || actual == "float"):
+			return true
+		case actual == "custom" && !containsString(schemaTypes, t):
+			// Evaluated custom literals cannot be told apart
+			return true
+		case actual == "int" && t == "size":
+			// Sizes evaluate to integers
+			return true
+		}
+	}
+
+	return false
+}
+
+// allowsSchema reports whether every type other allows is allowed.
+func (s *Schema) allowsSchema(other *Schema) bool {
+	if len(other.Types) == 0 {
+		return len(s.Types) == 0 || containsString(s.Types, "any")
+	}
+
+	for _, t := range other.Types {
+		if t == "any" {
+			continue
+		}
+
+		if !s.allows(t) && !(t == "number" && s.allows("int") && s.allows("float")) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// typeOfNode returns the schema type of a value, or the empty string for an
+// unresolved reference.
+func typeOfNode(n queryNode) string {
+	if n.expr == nil {
+		switch n.value.(type) {
+		case map[string]interface{}:
+			return "map"
+		case []interface{}:
+			return "list"
+		case string:
+			return "string"
+		case int64, *big.Int:
+			return "int"
+		case float64, *big.Float:
+			return "float"
+		case bool:
+			return "bool"
+		case nil:
+			return "null"
+		case time.Duration:
+			return "duration"
+		case time.Time:
+			return "timestamp"
+		default:
+			return "custom"
+		}
+	}
+
+	target, _ := n.target()
+
+	switch e := target.(type) {
+	case flimexpr.MapExpression:
+		return "map"
+	case flimexpr.ListExpression:
+		return "list"
+	case flimexpr.StringLiteralExpression:
+		return "string"
+	case flimexpr.IntegerLiteralExpression, flimexpr.BigIntegerLiteralExpression:
+		return "int"
+	case flimexpr.FloatLiteralExpression, flimexpr.BigFloatLiteralExpression:
+		return "float"
+	case flimexpr.BooleanLiteralExpression:
+		return "bool"
+	case flimexpr.NullLiteralExpression:
+		return "null"
+	case flimexpr.DurationLiteralExpression:
+		return "duration"
+	case flimexpr.SizeLiteralExpression:
+		return "size"
+	case flimexpr.TimestampLiteralExpression:
+		return "timestamp"
+	case flimexpr.CustomLiteralExpression:
+		return e.Kind()
+	default:
+		return ""
+	}
+}
+
+// InferSchema describes the values of sample documents, taking descriptions
+// from the comments written in them. Keys that some of the samples lack are
+// optional, and a value whose type differs between samples, or between the
+// items of a list, allows each type. Transformers are looked through, so
+// values are described as they were written.
+func InferSchema(docs ...*Document) *Schema {
+	var schema *Schema
+
+	for _, doc := range docs {
+		root := queryNode{path: Path{}, expr: documentValue(doc.Expression), written: Path{}}
+		schema = mergeSchemas(schema, inferSchema(doc, root))
+	}
+
+	if schema == nil {
+		return &Schema{}
+	}
+
+	return schema
+}
+
+func inferSchema(doc *Document, n queryNode) *Schema {
+	schema := &Schema{}
+
+	if n.written != nil {
+		schema.Description = doc.Comment(n.written)
+	}
+
+	switch e := n.expr.(type) {
+	case flimexpr.TaggedExpression:
+		schema.Tag = e.Tag()
+	case flimexpr.ReferenceExpression:
+		schema.Tag = e.Name()
+
+		if schema.Description == "" {
+			schema.Description = doc.TagComment(e.Name())
+		}
+	}
+
+	actual := typeOfNode(n)
+
+	if actual == "" {
+		return schema
+	}
+
+	schema.Types = []string{actual}
+
+	switch actual {
+	case "map":
+		entries, _ := n.mapEntries()
+
+		for _, entry := range entries {
+			key := entry.path[len(entry.path)-1].(string)
+			schema.Keys = append(schema.Keys, SchemaKey{key, inferSchema(doc, entry)})
+		}
+	case "list":
+		listItems, _ := n.listItems()
+
+		for _, listItem := range listItems {
+			schema.Items = mergeSchemas(schema.Items, inferSchema(doc, listItem))
+		}
+	}
+
+	return schema
+}
+
+// mergeSchemas returns a schema that allows what either does, with the keys
+// only one of two maps has made optional.
+func mergeSchemas(a, b *Schema) *Schema {
+	if a == nil {
+		return b
+	}
+
+	if b == nil {
+		return a
+	}
+
+	merged := &Schema{Description: a.Description, Tag: a.Tag, Optional: a.Optional || b.Optional}
+
+	if merged.Description == "" {
+		merged.Description = b.Description
+	}
+
+	if a.Tag != b.Tag {
+		merged.Tag = ""
+	}
+
+	// No types at all allows any type
+	if len(a.Types) > 0 && len(b.Types) > 0 {
+		merged.Types = append([]string{}, a.Types...)
+
+		for _, t := range b.Types {
+			if !containsString(merged.Types, t) {
+				merged.Types = append(merged.Types, t)
+			}
+		}
+	}
+
+	merged.Items = mergeSchemas(a.Items, b.Items)
+	merged.AdditionalKeys = mergeSchemas(a.AdditionalKeys, b.AdditionalKeys)
+	bothMaps := containsString(a.Types, "map") && containsString(b.Types, "map")
+
+	for _, key := range a.Keys {
+		keySchema := key.Schema
+
+		if other, exists := b.Key(key.Name); exists {
+			keySchema = mergeSchemas(keySchema, other)
+		} else if bothMaps {
+			optional := *keySchema
+			optional.Optional = true
+			keySchema = &optional
+		}
+
+		merged.Keys = append(merged.Keys, SchemaKey{key.Name, keySchema})
+	}
+
+	for _, key := range b.Keys {
+		if _, exists := a.Key(key.Name); exists {
+			continue
+		}
+
+		keySchema := key.Schema
+
+		if bothMaps {
+			optional := *keySchema
+			optional.Optional = true
+			keySchema = &optional
+		}
+
+		merged.Keys = append(merged.Keys, SchemaKey{key.Name, keySchema})
+	}
+
+	return merged
+}
@@ -0,0 +1,322 @@
+package flim
+
+import (
+	"github.com/l-donovan/flim/common"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func parseTestSchema(t *testing.T, text string) *Schema {
+	t.Helper()
+	expr, err := ParseString(text)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema, err := ParseSchema(expr)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return schema
+}
+
+func violationStrings(violations []Violation) []string {
+	strs := make([]string, len(violations))
+
+	for i, violation := range violations {
+		strs[i] = violation.String()
+	}
+
+	return strs
+}
+
+const serviceSchema = `#port { type "int" min 1 max 65535 }
+{
+    type "map"
+    keys {
+        name { type "string" pattern "^[a-z]+$" }
+        port &port
+        mode { type "string" enum [ "fast" "safe" ] optional true }
+        hosts { type "list" min_items 1 max_items 2 items "string" }
+        timeout { type [ "duration" "null" ] optional true }
+        backends {
+            type "list"
+            optional true
+            items { keys { host "string" port &port } additional_keys false }
+        }
+    }
+    transformers {
+        env { input "string" output "string" }
+    }
+}
+`
+
+func TestSchemaValidate(t *testing.T) {
+	schema := parseTestSchema(t, serviceSchema)
+
+	tests := []struct {
+		name string
+		doc  string
+		want []string
+	}{
+		{
+			"valid",
+			`{ name "svc" port 80 mode "safe" hosts [ "a" "b" ] timeout 5s backends [ { host "b" port 1 } ] }`,
+			[]string{},
+		},
+		{
+			"null allowed by a list of types",
+			`{ name "svc" port 80 hosts [ "a" ] timeout null }`,
+			[]string{},
+		},
+		{
+			"wrong types",
+			`{ name 1 port "80" hosts [ "a" 2 ] }`,
+			[]string{"name: expected string, found int", "port: expected int, found string", "hosts[1]: expected string, found int"},
+		},
+		{
+			"missing keys",
+			`{ name "svc" }`,
+			[]string{".: missing required key `port'", ".: missing required key `hosts'"},
+		},
+		{
+			"bounds and enums",
+			`{ name "Svc" port 70000 mode "slow" hosts [] }`,
+			[]string{
+				"name: \"Svc\" does not match the pattern `^[a-z]+$'",
+				"port: 70000 is greater than the maximum of 65535",
+				"mode: \"slow\" is not one of [\"fast\" \"safe\"]",
+				"hosts: list has 0 items, fewer than the minimum of 1",
+			},
+		},
+		{
+			"nested paths",
+			`{ name "svc" port 80 hosts [ "a" "b" "c" ] backends [ { host "b" port 1 } { host "c" port 0 weight 2 } ] }`,
+			[]string{
+				"hosts: list has 3 items, more than the maximum of 2",
+				"backends[1].port: 0 is less than the minimum of 1",
+				"backends[1].weight: unexpected key `weight'",
+			},
+		},
+		{
+			"transformers",
+			`{ name env "NAME" port env "PORT" hosts [ "a" ] }`,
+			[]string{"port: transformer `env' produces string, expected int"},
+		},
+		{
+			"expansions and references",
+			"#base { name \"svc\" port 0 }\n{ *&base hosts [ \"a\" ] }",
+			[]string{"port: 0 is less than the minimum of 1"},
+		},
+		{
+			"not a map",
+			`[ 1 ]`,
+			[]string{".: expected map, found list"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expr, err := ParseString(test.doc)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := violationStrings(schema.Validate(expr)); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSchemaOneOf(t *testing.T) {
+	schema := parseTestSchema(t, `{ one_of [ { type "int" max 10 } { type "int" min 5 } "string" ] }`)
+
+	tests := []struct {
+		doc  string
+		want []string
+	}{
+		{`1`, []string{}},
+		{`"a"`, []string{}},
+		{`7`, []string{".: matches 2 of the one_of schemas, expected exactly one"}},
+		{`true`, []string{".: matches none of the one_of schemas"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.doc, func(t *testing.T) {
+			expr, err := ParseString(test.doc)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := violationStrings(schema.Validate(expr)); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// Violations found with ValidateWithPositions give where the value at fault
+// was written.
+func TestSchemaValidateWithPositions(t *testing.T) {
+	schema := parseTestSchema(t, serviceSchema)
+	source := "{\n    name \"svc\"\n    port \"80\"\n    hosts [\n        \"a\"\n        2\n    ]\n}\n"
+	sourceMap := &SourceMap{}
+	expr, err := ParseString(source, WithSourceMap(sourceMap))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := violationStrings(schema.Validate(expr, ValidateWithPositions(source, sourceMap)))
+	want := []string{"3:10: port: expected int, found string", "6:9: hosts[1]: expected string, found int"}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSchemaValidateValue(t *testing.T) {
+	schema := parseTestSchema(t, serviceSchema)
+	expr, err := ParseString(`{ name "svc" port 0 hosts [ "a" ] }`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	val, err := common.Evaluate(expr, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := violationStrings(schema.ValidateValue(val))
+	want := []string{"port: 0 is less than the minimum of 1"}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestParseSchemaErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		err    string
+	}{
+		{"not a map", `1`, "a schema must be a map or a type name"},
+		{"unknown type", `{ type "widget" }`, "unknown type `widget'"},
+		{"unknown key", `{ type "int" minimum 1 }`, "unknown schema key `minimum'"},
+		{"bad pattern", `{ type "string" pattern "(" }`, "pattern"},
+		{"nested error", `{ keys { a { type "widget" } } }`, "keys.a.type: unknown type `widget'"},
+		{"optional not a bool", `{ optional "yes" }`, "expected a bool"},
+		{"one_of not a list", `{ one_of "int" }`, "expected a list of schemas"},
+		{"bad transformer schema", `{ transformers { env "string" } }`, "expected a map of input and output"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expr, err := ParseString(test.schema)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := ParseSchema(expr); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("got error %v, want one containing `%s'", err, test.err)
+			}
+		})
+	}
+}
+
+// A schema inferred from samples accepts each of them, takes descriptions
+// from their comments and makes keys that some samples lack optional.
+func TestInferSchema(t *testing.T) {
+	var docs []*Document
+
+	for _, source := range []string{
+		"{\n    // The service name\n    name \"a\"\n    port 80\n    hosts [ \"a\" ]\n}",
+		"{ name \"b\" port 8080 hosts [ \"b\" 1 ] debug true }",
+	} {
+		doc, err := ParseDocument(source)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		docs = append(docs, doc)
+	}
+
+	schema := InferSchema(docs...)
+
+	for _, doc := range docs {
+		if violations := schema.Validate(doc.Expression); len(violations) > 0 {
+			t.Errorf("%s does not match the inferred schema: %q", doc.Source, violationStrings(violations))
+		}
+	}
+
+	name, _ := schema.Key("name")
+
+	if name == nil || name.Description != "The service name" || name.Optional {
+		t.Errorf("got name schema %+v", name)
+	}
+
+	if debug, _ := schema.Key("debug"); debug == nil || !debug.Optional {
+		t.Errorf("got debug schema %+v", debug)
+	}
+
+	if hosts, _ := schema.Key("hosts"); hosts == nil || hosts.Items == nil || !reflect.DeepEqual(hosts.Items.Types, []string{"string", "int"}) {
+		t.Errorf("got hosts schema %+v", hosts)
+	}
+
+	expr, err := ParseString(`{ name 1 port 80 hosts [] }`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if violations := schema.Validate(expr); len(violations) == 0 {
+		t.Errorf("a document with a name of the wrong type matches the inferred schema")
+	}
+}
+
+// A shared schema can describe values that hold values like themselves.
+func TestRecursiveSchema(t *testing.T) {
+	doc, err := ParseDocument("// A tree node\n#node { keys { name \"string\" children { items &node optional true } } }\n{ keys { root &node } }")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema, err := ParseSchemaDocument(doc)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root, _ := schema.Key("root")
+	children, _ := root.Key("children")
+
+	if children.Items != root || root.Description != "A tree node" {
+		t.Errorf("the schema of children is not the node schema")
+	}
+
+	expr, err := ParseString(`{ root { name "a" children [ { name "b" children [ { name 1 } ] } ] } }`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := violationStrings(schema.Validate(expr))
+	want := []string{"root.children[0].children[0].name: expected string, found int"}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,281 @@
+package flim
+
+import (
+	"fmt"
+	"github.com/l-donovan/flim/common"
+	"os"
+	"strings"
+)
+
+// Span is a range of bytes in a document's source, from Start up to but not
+// including End.
+type Span struct {
+	Start int
+	End   int
+}
+
+// Text returns the part of source covered by the span.
+func (s Span) Text(source string) string {
+	return source[s.Start:s.End]
+}
+
+// SourceNode records where the value at a path was written.
+type SourceNode struct {
+	// Key is the key of a map pair. It is empty for list items and the
+	// document itself.
+	Key Span
+
+	// Value is the value, after any tags but including any transformers.
+	Value Span
+
+	// Entry is the whole map pair or list item, including any tags.
+	Entry Span
+
+	// Body is the part between the brackets of a map or list literal. It is
+	// only set if Container is true.
+	Body      Span
+	Container bool
+
+	// Entries holds the span of each pair and expansion of a map literal, in
+	// the order they were written, including pairs whose key is repeated
+	// later and so cannot be found by path.
+	Entries []Span
+}
+
+// SourceReference is a reference to a tag, e.g. &name.
+type SourceReference struct {
+	Name string
+
+	// Span covers the name, without the ampersand
+	Span Span
+}
+
+// SourceMap records where the expressions of a parsed document were written.
+// Lookup finds the parts of the document's value by path, while the other
+// top-level expressions can be found through their tags with LookupTag.
+type SourceMap struct {
+	// scopes holds the nodes of each top-level expression by the pointer of
+	// their path, under the expression's tag or the empty string if it has
+	// none. document is the scope of the last top-level expression.
+	scopes   map[string]map[string]SourceNode
+	document string
+
+	// tagged holds where each tagged expression is
+	tagged map[string]sourceLocation
+
+	// Tags holds the span of each tag's name, without the pound sign
+	Tags map[string]Span
+
+	References []SourceReference
+}
+
+type sourceLocation struct {
+	scope string
+	path  Path
+}
+
+func (l sourceLocation) child(element interface{}) sourceLocation {
+	return sourceLocation{l.scope, l.path.Child(element)}
+}
+
+func newSourceMap() *SourceMap {
+	return &SourceMap{
+		scopes: map[string]map[string]SourceNode{"": {}},
+		tagged: map[string]sourceLocation{},
+		Tags:   map[string]Span{},
+	}
+}
+
+// Lookup returns where the value at path was written. Values that come from
+// an expansion of a reference were not written at their path, and so are
+// not found.
+func (m *SourceMap) Lookup(path Path) (SourceNode, bool) {
+	return m.lookupAt(sourceLocation{m.document, path})
+}
+
+// LookupTag returns where the value at path within a tagged expression was
+// written.
+func (m *SourceMap) LookupTag(tag string, path Path) (SourceNode, bool) {
+	location, exists := m.tagged[tag]
+
+	if !exists {
+		return SourceNode{}, false
+	}
+
+	return m.lookupAt(sourceLocation{location.scope, append(append(Path{}, location.path...), path...)})
+}
+
+func (m *SourceMap) lookupAt(location sourceLocation) (SourceNode, bool) {
+	node, exists := m.scopes[location.scope][location.path.Pointer()]
+	return node, exists
+}
+
+// WithSourceMap records where each parsed expression was written into
+// sourceMap, replacing anything it held before.
+func WithSourceMap(sourceMap *SourceMap) ParserOption {
+	return func(p *Parser) {
+		*sourceMap = *newSourceMap()
+		p.sources = sourceMap
+	}
+}
+
+// Position is a place in a document's source. Line and Column count from 1,
+// with columns counted in bytes. The zero Position is not valid.
+type Position struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+// IsValid reports whether the position is known.
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+// String writes the position as line:column.
+func (p Position) String() string {
+	if !p.IsValid() {
+		return "-"
+	}
+
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// PositionOf returns the position of a byte offset in source.
+func PositionOf(source string, offset int) Position {
+	before := source[:offset]
+	line := strings.Count(before, "\n") + 1
+	column := offset - strings.LastIndexByte(before, '\n')
+	return Position{Offset: offset, Line: line, Column: column}
+}
+
+// Comment returns the line comments written directly above the map pair or
+// list item, or failing that, the comment at the end of its line if it was
+// written on just one. The slashes and a space after them are removed from
+// each line.
+func (n SourceNode) Comment(source string) string {
+	entry := n.Entry
+
+	if entry == (Span{}) {
+		entry = n.Value
+	}
+
+	if comment := commentAbove(source, entry.Start); comment != "" {
+		return comment
+	}
+
+	if strings.Contains(entry.Text(source), "\n") {
+		return ""
+	}
+
+	rest := source[entry.End:]
+
+	if end := strings.IndexByte(rest, '\n'); end >= 0 {
+		rest = rest[:end]
+	}
+
+	if rest = strings.TrimSpace(rest); strings.HasPrefix(rest, "//") {
+		return commentText(rest)
+	}
+
+	return ""
+}
+
+// commentAbove returns the line comments on the lines just before the one
+// offset is on, provided nothing else comes before offset on its line.
+func commentAbove(source string, offset int) string {
+	lineStart := strings.LastIndexByte(source[:offset], '\n') + 1
+
+	if strings.TrimSpace(source[lineStart:offset]) != "" {
+		return ""
+	}
+
+	lines := []string{}
+
+	for lineStart > 0 {
+		previousStart := strings.LastIndexByte(source[:lineStart-1], '\n') + 1
+		line := strings.TrimSpace(source[previousStart : lineStart-1])
+
+		if !strings.HasPrefix(line, "//") {
+			break
+		}
+
+		lines = append([]string{commentText(line)}, lines...)
+		lineStart = previousStart
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func commentText(line string) string {
+	text := strings.TrimPrefix(line, "//")
+	return strings.TrimRight(strings.TrimPrefix(text, " "), " \t\r")
+}
+
+// Document is a parsed document along with its source and where each of its
+// expressions was written, so that what surrounds them, like comments, can be
+// found.
+type Document struct {
+	Source     string
+	Expression common.Expression
+	SourceMap  *SourceMap
+}
+
+// LoadDocument reads and parses a document from a flim file.
+func LoadDocument(filename string, options ...ParserOption) (*Document, error) {
+	fileContents, err := os.ReadFile(filename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseDocument(string(fileContents), options...)
+}
+
+// ParseDocument parses a document, resolving its references as ParseString
+// does.
+func ParseDocument(source string, options ...ParserOption) (*Document, error) {
+	sourceMap := &SourceMap{}
+	parserOptions := append(append([]ParserOption{}, options...), WithSourceMap(sourceMap))
+	expr, err := ParseString(source, parserOptions...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Document{Source: source, Expression: expr, SourceMap: sourceMap}, nil
+}
+
+// Comment returns the comment written for the value at path in the
+// document's value, as SourceNode.Comment does.
+func (d *Document) Comment(path Path) string {
+	return d.commentAt(sourceLocation{d.SourceMap.document, path})
+}
+
+// TagComment returns the comment written directly above a tagged expression.
+func (d *Document) TagComment(tag string) string {
+	location, exists := d.SourceMap.tagged[tag]
+
+	if !exists {
+		return ""
+	}
+
+	// A tagged map pair or list item has its comment above the entry, while
+	// a top-level expression has it above the tag
+	if len(location.path) > 0 {
+		return d.commentAt(location)
+	}
+
+	nameStart := d.SourceMap.Tags[tag].Start
+	return commentAbove(d.Source, strings.LastIndexByte(d.Source[:nameStart], '#'))
+}
+
+func (d *Document) commentAt(location sourceLocation) string {
+	node, exists := d.SourceMap.lookupAt(location)
+
+	if !exists {
+		return ""
+	}
+
+	return node.Comment(d.Source)
+}
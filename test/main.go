@@ -1,9 +1,13 @@
 package main
 
 import (
-	"flim"
-	"flim/common"
 	"fmt"
+	"github.com/l-donovan/flim"
+	"github.com/l-donovan/flim/common"
+	"github.com/l-donovan/flim/expressions"
+	"net/netip"
+	"os"
+	"time"
 )
 
 func main() {
@@ -13,6 +17,22 @@ func main() {
 		panic(err)
 	}
 
+	// A schema checks the shape of a document without running any handlers,
+	// and reports every problem rather than just the first
+	schema, err := flim.LoadSchema("./test.schema.flim")
+
+	if err != nil {
+		panic(err)
+	}
+
+	if violations := schema.Validate(expr); len(violations) > 0 {
+		for _, violation := range violations {
+			fmt.Println(violation)
+		}
+
+		os.Exit(1)
+	}
+
 	exportedValues := map[string]interface{}{
 		"hostname":  "100.100.100.102",
 		"base_port": 8000,
@@ -35,7 +55,7 @@ func main() {
 		// We can use a handler to provide default values
 		"item": func(data interface{}) (interface{}, error) {
 			out := map[string]interface{}{
-				"timeout": 30,
+				"timeout": 30 * time.Second,
 			}
 
 			for key, val := range data.(map[string]interface{}) {
@@ -70,11 +90,25 @@ func main() {
 
 			return input * input, nil
 		},
+
+		// Keyed mapped transformers receive each map entry as a Pair
+		"label": func(data interface{}) (interface{}, error) {
+			pair := data.(expressions.Pair)
+
+			return fmt.Sprintf("%s=%v", pair.Key, pair.Val), nil
+		},
 	}
 
 	fmt.Println(expr.ToString())
 
-	output, err := expr.Evaluate(handlers)
+	timings := common.NewTimingObserver()
+	evaluatorConfig := common.NewEvaluatorConfig(
+		handlers,
+		common.WithDebug(),
+		common.WithWorkers(4),
+		common.WithObserver(timings),
+	)
+	output, err := evaluatorConfig.Evaluate(expr)
 
 	if err != nil {
 		panic(err)
@@ -82,6 +116,11 @@ func main() {
 
 	fmt.Println()
 	fmt.Println(output)
+	fmt.Println(evaluatorConfig.MemoHits())
+
+	if err := timings.Report(os.Stdout); err != nil {
+		panic(err)
+	}
 
 	serialized, err := common.Serialize(expr, false, 4)
 
@@ -100,4 +139,79 @@ func main() {
 
 	fmt.Println()
 	fmt.Println(minified)
+
+	// Partial evaluation folds everything it can and leaves transformers
+	// without a handler in place, to be finished by another process
+	partialHandlers := map[string]common.HandlerFunc{
+		"from": handlers["from"],
+		"add":  handlers["add"],
+	}
+
+	residual, err := common.PartialEvaluate(expr, partialHandlers)
+
+	if err != nil {
+		panic(err)
+	}
+
+	residualSerialized, err := common.Serialize(residual, false, 4)
+
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println()
+	fmt.Println(residualSerialized)
+
+	residualOutput, err := residual.Evaluate(handlers)
+
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println()
+	fmt.Println(residualOutput)
+
+	// Applications can add their own literal types, validated during parsing
+	literals := flim.NewLiterals()
+	err = literals.Register(flim.LiteralKind{
+		Name:    "IPAddress",
+		Pattern: `\d{1,3}(?:\.\d{1,3}){3}`,
+		Parse: func(text string) (interface{}, error) {
+			return netip.ParseAddr(text)
+		},
+		Serialize: func(val interface{}) (string, error) {
+			return val.(netip.Addr).String(), nil
+		},
+	})
+
+	if err != nil {
+		panic(err)
+	}
+
+	network, err := flim.ParseFile("./network.flim", flim.WithLiterals(literals))
+
+	if err != nil {
+		panic(err)
+	}
+
+	networkOutput, err := network.Evaluate(nil)
+
+	if err != nil {
+		panic(err)
+	}
+
+	gateway := networkOutput.(map[string]interface{})["gateway"].(netip.Addr)
+
+	fmt.Println()
+	fmt.Println(networkOutput)
+	fmt.Println(gateway.Is4(), gateway.IsPrivate())
+
+	networkSerialized, err := common.Serialize(network, false, 4)
+
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println()
+	fmt.Println(networkSerialized)
 }
@@ -0,0 +1,1170 @@
+package flim
+
+import (
+	"errors"
+	"fmt"
+	"github.com/l-donovan/flim/common"
+	flimexpr "github.com/l-donovan/flim/expressions"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FromTOML builds an expression tree from a TOML document. Keys keep their
+// document order, integers keep their original text, and offset date-times
+// become TimestampLiteralExpressions. TOML has no type for local dates and
+// times, so they become strings.
+func FromTOML(data []byte) (common.Expression, error) {
+	parser := &tomlParser{text: strings.ReplaceAll(string(data), "\r\n", "\n"), line: 1}
+	root := newTOMLTable()
+	current := root
+
+	for {
+		parser.skipBlank()
+
+		if parser.done() {
+			break
+		}
+
+		var err error
+
+		switch {
+		case strings.HasPrefix(parser.text[parser.pos:], "[["):
+			current, err = parser.parseArrayTableHeader(root)
+		case parser.peek() == '[':
+			current, err = parser.parseTableHeader(root)
+		default:
+			err = parser.parseKeyValue(current)
+		}
+
+		if err == nil {
+			err = parser.endLine()
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("toml: line %d: %w", parser.line, err)
+		}
+	}
+
+	expr, err := root.expression()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return flimexpr.NewFileExpression([]common.Expression{expr})
+}
+
+// tomlTable is a table that remembers the order its keys were defined in.
+type tomlTable struct {
+	keys    []string
+	entries map[string]*tomlEntry
+	// explicit is set once the table has its own [header] or is an inline
+	// table, after which it cannot be defined again
+	explicit bool
+	inline   bool
+}
+
+// tomlEntry holds exactly one of a value, a table or an array of tables.
+type tomlEntry struct {
+	value  common.Expression
+	table  *tomlTable
+	tables []*tomlTable
+}
+
+func newTOMLTable() *tomlTable {
+	return &tomlTable{entries: map[string]*tomlEntry{}}
+}
+
+func (t *tomlTable) set(key string, entry *tomlEntry) {
+	if _, exists := t.entries[key]; !exists {
+		t.keys = append(t.keys, key)
+	}
+
+	t.entries[key] = entry
+}
+
+// subtable returns the table at key, creating it if needed. When key holds an
+// array of tables, the last table in the array is returned.
+func (t *tomlTable) subtable(key string) (*tomlTable, error) {
+	entry, exists := t.entries[key]
+
+	switch {
+	case !exists:
+		table := newTOMLTable()
+		t.set(key, &tomlEntry{table: table})
+		return table, nil
+	case entry.table != nil:
+		if entry.table.inline {
+			return nil, fmt.Errorf("cannot extend inline table `%s'", key)
+		}
+
+		return entry.table, nil
+	case entry.tables != nil:
+		return entry.tables[len(entry.tables)-1], nil
+	default:
+		return nil, fmt.Errorf("key `%s' is already defined as a value", key)
+	}
+}
+
+func (t *tomlTable) expression() (common.Expression, error) {
+	pairs := make([]common.Expression, len(t.keys))
+
+	for i, key := range t.keys {
+		entry := t.entries[key]
+		var val common.Expression
+		var err error
+
+		switch {
+		case entry.value != nil:
+			val = entry.value
+		case entry.table != nil:
+			val, err = entry.table.expression()
+		default:
+			listItems := make([]common.Expression, len(entry.tables))
+
+			for j, table := range entry.tables {
+				listItems[j], err = table.expression()
+
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			val, err = flimexpr.NewListExpression(listItems)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		pairs[i], err = flimexpr.NewPairExpression(key, val)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return flimexpr.NewMapExpression(pairs)
+}
+
+type tomlParser struct {
+	text string
+	pos  int
+	line int
+}
+
+func (p *tomlParser) done() bool {
+	return p.pos >= len(p.text)
+}
+
+func (p *tomlParser) peek() byte {
+	if p.done() {
+		return 0
+	}
+
+	return p.text[p.pos]
+}
+
+func (p *tomlParser) advance(n int) {
+	p.line += strings.Count(p.text[p.pos:p.pos+n], "\n")
+	p.pos += n
+}
+
+func (p *tomlParser) skipSpace() {
+	for p.peek() == ' ' || p.peek() == '\t' {
+		p.pos++
+	}
+}
+
+func (p *tomlParser) skipComment() {
+	if p.peek() == '#' {
+		for !p.done() && p.peek() != '\n' {
+			p.pos++
+		}
+	}
+}
+
+// skipBlank skips whitespace, newlines and comments.
+func (p *tomlParser) skipBlank() {
+	for {
+		p.skipSpace()
+		p.skipComment()
+
+		if p.peek() != '\n' {
+			return
+		}
+
+		p.advance(1)
+	}
+}
+
+// endLine checks that nothing but a comment follows on the current line.
+func (p *tomlParser) endLine() error {
+	p.skipSpace()
+	p.skipComment()
+
+	if !p.done() && p.peek() != '\n' {
+		return fmt.Errorf("unexpected %q at end of line", p.restOfLine())
+	}
+
+	return nil
+}
+
+func (p *tomlParser) restOfLine() string {
+	end := strings.IndexByte(p.text[p.pos:], '\n')
+
+	if end < 0 {
+		return p.text[p.pos:]
+	}
+
+	return p.text[p.pos : p.pos+end]
+}
+
+var tomlBareKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+`)
+
+// parseKey parses a possibly dotted key into its parts.
+func (p *tomlParser) parseKey() ([]string, error) {
+	parts := []string{}
+
+	for {
+		p.skipSpace()
+		var part string
+		var err error
+
+		switch p.peek() {
+		case '"':
+			part, err = p.parseBasicString()
+		case '\'':
+			part, err = p.parseLiteralString()
+		default:
+			bare := tomlBareKeyPattern.FindString(p.text[p.pos:])
+
+			if bare == "" {
+				return nil, fmt.Errorf("expected a key, found %q", p.restOfLine())
+			}
+
+			part = bare
+			p.pos += len(bare)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		parts = append(parts, part)
+		p.skipSpace()
+
+		if p.peek() != '.' {
+			return parts, nil
+		}
+
+		p.pos++
+	}
+}
+
+func (p *tomlParser) parseTableHeader(root *tomlTable) (*tomlTable, error) {
+	p.pos++
+	parts, err := p.parseKey()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek() != ']' {
+		return nil, fmt.Errorf("expected `]' after table name")
+	}
+
+	p.pos++
+	table := root
+
+	for _, part := range parts {
+		if table, err = table.subtable(part); err != nil {
+			return nil, err
+		}
+	}
+
+	if table.explicit {
+		return nil, fmt.Errorf("table `%s' is defined more than once", strings.Join(parts, "."))
+	}
+
+	table.explicit = true
+	return table, nil
+}
+
+func (p *tomlParser) parseArrayTableHeader(root *tomlTable) (*tomlTable, error) {
+	p.pos += 2
+	parts, err := p.parseKey()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(p.text[p.pos:], "]]") {
+		return nil, fmt.Errorf("expected `]]' after array table name")
+	}
+
+	p.pos += 2
+	parent := root
+
+	for _, part := range parts[:len(parts)-1] {
+		if parent, err = parent.subtable(part); err != nil {
+			return nil, err
+		}
+	}
+
+	key := parts[len(parts)-1]
+	table := newTOMLTable()
+	table.explicit = true
+	entry, exists := parent.entries[key]
+
+	switch {
+	case !exists:
+		parent.set(key, &tomlEntry{tables: []*tomlTable{table}})
+	case entry.tables != nil:
+		entry.tables = append(entry.tables, table)
+	default:
+		return nil, fmt.Errorf("key `%s' is already defined", strings.Join(parts, "."))
+	}
+
+	return table, nil
+}
+
+func (p *tomlParser) parseKeyValue(table *tomlTable) error {
+	parts, err := p.parseKey()
+
+	if err != nil {
+		return err
+	}
+
+	if p.peek() != '=' {
+		return fmt.Errorf("expected `=' after key `%s'", strings.Join(parts, "."))
+	}
+
+	p.pos++
+	p.skipSpace()
+
+	val, err := p.parseValue()
+
+	if err != nil {
+		return err
+	}
+
+	for _, part := range parts[:len(parts)-1] {
+		if table, err = table.subtable(part); err != nil {
+			return err
+		}
+	}
+
+	key := parts[len(parts)-1]
+
+	if _, exists := table.entries[key]; exists {
+		return fmt.Errorf("key `%s' is defined more than once", strings.Join(parts, "."))
+	}
+
+	table.set(key, val)
+	return nil
+}
+
+var (
+	tomlIntegerPattern  = regexp.MustCompile(`^[+-]?(?:0|[1-9](?:_?[0-9])*)$`)
+	tomlPrefixedPattern = regexp.MustCompile(`^0(?:x[0-9a-fA-F](?:_?[0-9a-fA-F])*|o[0-7](?:_?[0-7])*|b[01](?:_?[01])*)$`)
+	tomlFloatPattern    = regexp.MustCompile(`^[+-]?(?:0|[1-9](?:_?[0-9])*)(?:\.[0-9](?:_?[0-9])*)?(?:[eE][+-]?[0-9](?:_?[0-9])*)?$`)
+	tomlDatePattern     = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+	tomlDateTimePattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}[Tt ]\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:[Zz]|[+-]\d{2}:\d{2})?$`)
+	tomlTimePattern     = regexp.MustCompile(`^\d{2}:\d{2}:\d{2}(?:\.\d+)?$`)
+)
+
+func (p *tomlParser) parseValue() (*tomlEntry, error) {
+	switch p.peek() {
+	case '[':
+		return p.parseArray()
+	case '{':
+		table, err := p.parseInlineTable()
+		return &tomlEntry{table: table}, err
+	}
+
+	var expr common.Expression
+	var err error
+
+	switch {
+	case strings.HasPrefix(p.text[p.pos:], `"""`):
+		var val string
+
+		if val, err = p.parseMultilineBasicString(); err == nil {
+			expr, err = flimexpr.NewStringLiteralExpression(val)
+		}
+	case strings.HasPrefix(p.text[p.pos:], `'''`):
+		var val string
+
+		if val, err = p.parseMultilineLiteralString(); err == nil {
+			expr, err = flimexpr.NewStringLiteralExpression(val)
+		}
+	case p.peek() == '"':
+		var val string
+
+		if val, err = p.parseBasicString(); err == nil {
+			expr, err = flimexpr.NewStringLiteralExpression(val)
+		}
+	case p.peek() == '\'':
+		var val string
+
+		if val, err = p.parseLiteralString(); err == nil {
+			expr, err = flimexpr.NewStringLiteralExpression(val)
+		}
+	default:
+		expr, err = p.parseAtom()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &tomlEntry{value: expr}, nil
+}
+
+// parseAtom parses booleans, numbers and dates.
+func (p *tomlParser) parseAtom() (common.Expression, error) {
+	start := p.pos
+
+	for !p.done() && strings.IndexByte("0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ_+-.:", p.peek()) >= 0 {
+		p.pos++
+	}
+
+	text := p.text[start:p.pos]
+
+	// Dates and times may be separated by a space
+	if tomlDatePattern.MatchString(text) && p.peek() == ' ' && p.pos+1 < len(p.text) && p.text[p.pos+1] >= '0' && p.text[p.pos+1] <= '9' {
+		end := p.pos + 1
+
+		for end < len(p.text) && strings.IndexByte("0123456789:.+-Zz", p.text[end]) >= 0 {
+			end++
+		}
+
+		if tomlDateTimePattern.MatchString(text + p.text[p.pos:end]) {
+			text += p.text[p.pos:end]
+			p.pos = end
+		}
+	}
+
+	switch text {
+	case "":
+		return nil, fmt.Errorf("expected a value, found %q", p.restOfLine())
+	case "true", "false":
+		return flimexpr.NewBooleanLiteralExpression(text == "true")
+	case "inf", "+inf":
+		return flimexpr.NewFloatLiteralExpression(math.Inf(1))
+	case "-inf":
+		return flimexpr.NewFloatLiteralExpression(math.Inf(-1))
+	case "nan", "+nan", "-nan":
+		return flimexpr.NewFloatLiteralExpression(math.NaN())
+	}
+
+	switch {
+	case tomlIntegerPattern.MatchString(text):
+		return tomlInteger(text, 10)
+	case tomlPrefixedPattern.MatchString(text):
+		return tomlInteger(text, map[byte]int{'x': 16, 'o': 8, 'b': 2}[text[1]])
+	case tomlFloatPattern.MatchString(text):
+		val, err := strconv.ParseFloat(strings.ReplaceAll(text, "_", ""), 64)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return flimexpr.NewFloatLiteralExpression(val)
+	case tomlDateTimePattern.MatchString(text):
+		normalized := strings.ToUpper(text[:10] + "T" + text[11:])
+
+		if val, err := time.Parse(time.RFC3339Nano, normalized); err == nil {
+			return flimexpr.NewTimestampLiteralExpression(val)
+		}
+
+		// Local date-times have no offset
+		return flimexpr.NewStringLiteralExpression(text)
+	case tomlDatePattern.MatchString(text), tomlTimePattern.MatchString(text):
+		return flimexpr.NewStringLiteralExpression(text)
+	default:
+		return nil, fmt.Errorf("invalid value `%s'", text)
+	}
+}
+
+func tomlInteger(text string, base int) (common.Expression, error) {
+	digits := strings.ReplaceAll(strings.TrimPrefix(text, "+"), "_", "")
+
+	if base != 10 {
+		digits = digits[2:]
+	}
+
+	val, err := strconv.ParseInt(digits, base, 64)
+
+	if errors.Is(err, strconv.ErrRange) {
+		bigVal, ok := new(big.Int).SetString(digits, base)
+
+		if !ok {
+			return nil, err
+		}
+
+		return flimexpr.NewBigIntegerLiteralExpressionWithText(bigVal, strings.TrimPrefix(text, "+"))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(text, "+") {
+		return flimexpr.NewIntegerLiteralExpression(val)
+	}
+
+	return flimexpr.NewIntegerLiteralExpressionWithText(val, text)
+}
+
+func (p *tomlParser) parseArray() (*tomlEntry, error) {
+	p.pos++
+	listItems := []common.Expression{}
+
+	for {
+		p.skipBlank()
+
+		if p.peek() == ']' {
+			p.pos++
+			break
+		}
+
+		entry, err := p.parseValue()
+
+		if err != nil {
+			return nil, err
+		}
+
+		listItem := entry.value
+
+		if entry.table != nil {
+			if listItem, err = entry.table.expression(); err != nil {
+				return nil, err
+			}
+		}
+
+		listItems = append(listItems, listItem)
+		p.skipBlank()
+
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ']':
+		default:
+			return nil, fmt.Errorf("expected `,' or `]' in array")
+		}
+	}
+
+	expr, err := flimexpr.NewListExpression(listItems)
+	return &tomlEntry{value: expr}, err
+}
+
+func (p *tomlParser) parseInlineTable() (*tomlTable, error) {
+	p.pos++
+	table := newTOMLTable()
+	p.skipSpace()
+
+	if p.peek() == '}' {
+		p.pos++
+		table.explicit, table.inline = true, true
+		return table, nil
+	}
+
+	for {
+		p.skipSpace()
+
+		if err := p.parseKeyValue(table); err != nil {
+			return nil, err
+		}
+
+		p.skipSpace()
+
+		if p.peek() == '}' {
+			p.pos++
+			break
+		}
+
+		if p.peek() != ',' {
+			return nil, fmt.Errorf("expected `,' or `}' in inline table")
+		}
+
+		p.pos++
+	}
+
+	table.explicit, table.inline = true, true
+	return table, nil
+}
+
+func (p *tomlParser) parseLiteralString() (string, error) {
+	end := strings.IndexAny(p.text[p.pos+1:], "'\n")
+
+	if end < 0 || p.text[p.pos+1+end] != '\'' {
+		return "", fmt.Errorf("unterminated string")
+	}
+
+	val := p.text[p.pos+1 : p.pos+1+end]
+	p.pos += end + 2
+	return val, nil
+}
+
+func (p *tomlParser) parseMultilineLiteralString() (string, error) {
+	p.advance(3)
+
+	if p.peek() == '\n' {
+		p.advance(1)
+	}
+
+	end := strings.Index(p.text[p.pos:], `'''`)
+
+	if end < 0 {
+		return "", fmt.Errorf("unterminated string")
+	}
+
+	// Up to two quotes may directly precede the closing delimiter
+	for i := 0; i < 2 && strings.HasPrefix(p.text[p.pos+end+1:], `'''`); i++ {
+		end++
+	}
+
+	val := p.text[p.pos : p.pos+end]
+	p.advance(end + 3)
+	return val, nil
+}
+
+func (p *tomlParser) parseBasicString() (string, error) {
+	p.pos++
+	var builder strings.Builder
+
+	for {
+		if p.done() || p.peek() == '\n' {
+			return "", fmt.Errorf("unterminated string")
+		}
+
+		c := p.peek()
+
+		switch c {
+		case '"':
+			p.pos++
+			return builder.String(), nil
+		case '\\':
+			if err := p.parseEscape(&builder); err != nil {
+				return "", err
+			}
+		default:
+			builder.WriteByte(c)
+			p.pos++
+		}
+	}
+}
+
+func (p *tomlParser) parseMultilineBasicString() (string, error) {
+	p.advance(3)
+
+	if p.peek() == '\n' {
+		p.advance(1)
+	}
+
+	var builder strings.Builder
+
+	for {
+		if p.done() {
+			return "", fmt.Errorf("unterminated string")
+		}
+
+		if strings.HasPrefix(p.text[p.pos:], `"""`) {
+			// Up to two quotes may directly precede the closing delimiter
+			for strings.HasPrefix(p.text[p.pos+1:], `"""`) {
+				builder.WriteByte('"')
+				p.pos++
+			}
+
+			p.pos += 3
+			return builder.String(), nil
+		}
+
+		c := p.peek()
+
+		switch {
+		case c == '\\':
+			// A backslash at the end of a line trims the following whitespace
+			rest := strings.TrimLeft(p.text[p.pos+1:], " \t")
+
+			if strings.HasPrefix(rest, "\n") {
+				p.advance(len(p.text[p.pos:]) - len(rest))
+
+				for !p.done() && strings.IndexByte(" \t\n", p.peek()) >= 0 {
+					p.advance(1)
+				}
+
+				continue
+			}
+
+			if err := p.parseEscape(&builder); err != nil {
+				return "", err
+			}
+		default:
+			builder.WriteByte(c)
+			p.advance(1)
+		}
+	}
+}
+
+func (p *tomlParser) parseEscape(builder *strings.Builder) error {
+	if p.pos+1 >= len(p.text) {
+		return fmt.Errorf("unterminated escape sequence")
+	}
+
+	escape := p.text[p.pos+1]
+	simple := map[byte]byte{'b': '\b', 't': '\t', 'n': '\n', 'f': '\f', 'r': '\r', 'e': '\x1b', '"': '"', '\\': '\\'}
+
+	if replacement, ok := simple[escape]; ok {
+		builder.WriteByte(replacement)
+		p.pos += 2
+		return nil
+	}
+
+	width := map[byte]int{'x': 2, 'u': 4, 'U': 8}[escape]
+
+	if width == 0 || p.pos+2+width > len(p.text) {
+		return fmt.Errorf("invalid escape sequence `\\%c'", escape)
+	}
+
+	code, err := strconv.ParseUint(p.text[p.pos+2:p.pos+2+width], 16, 32)
+
+	if err != nil {
+		return fmt.Errorf("invalid escape sequence `%s'", p.text[p.pos:p.pos+2+width])
+	}
+
+	builder.WriteRune(rune(code))
+	p.pos += 2 + width
+	return nil
+}
+
+// ToTOML writes an expression tree as a TOML document. The last top-level
+// expression must be a map. Tags are dropped, references are replaced by
+// their targets and expansions are merged, so expr must have had its
+// references resolved. TOML has no null or transformers, so neither can be
+// written.
+func ToTOML(expr common.Expression) ([]byte, error) {
+	if fileExpr, ok := expr.(flimexpr.FileExpression); ok {
+		expressions := fileExpr.Expressions()
+
+		if len(expressions) == 0 {
+			return []byte{}, nil
+		}
+
+		expr = expressions[len(expressions)-1]
+	}
+
+	root, err := tomlTableOf(expr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var builder strings.Builder
+
+	if err := writeTOMLTable(&builder, root, nil); err != nil {
+		return nil, err
+	}
+
+	return []byte(strings.TrimPrefix(builder.String(), "\n")), nil
+}
+
+// tomlTarget looks through tags and references to the expression that gives
+// a value its shape.
+func tomlTarget(expr common.Expression) (common.Expression, error) {
+	for {
+		switch e := expr.(type) {
+		case flimexpr.TaggedExpression:
+			expr = e.Expression()
+		case flimexpr.ReferenceExpression:
+			target, resolved := e.Target()
+
+			if !resolved {
+				return nil, fmt.Errorf("toml: unresolved reference `%s'", e.Name())
+			}
+
+			expr = target
+		case flimexpr.TransformerExpression:
+			return nil, fmt.Errorf("toml: cannot write transformer `%s'", e.Name())
+		case flimexpr.MappedTransformerExpression:
+			return nil, fmt.Errorf("toml: cannot write transformer `%s'", e.Name())
+		default:
+			return expr, nil
+		}
+	}
+}
+
+// tomlTableOf flattens a map, merging in any expansions.
+func tomlTableOf(expr common.Expression) (*tomlTable, error) {
+	target, err := tomlTarget(expr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	mapExpr, ok := target.(flimexpr.MapExpression)
+
+	if !ok {
+		return nil, fmt.Errorf("toml: expected a map, found %s", target.ToString())
+	}
+
+	table := newTOMLTable()
+
+	for _, pairExpr := range mapExpr.Pairs() {
+		switch pair := pairExpr.(type) {
+		case flimexpr.PairExpression:
+			entry, err := tomlEntryOf(pair.Value())
+
+			if err != nil {
+				return nil, err
+			}
+
+			table.set(pair.Key(), entry)
+		case flimexpr.ExpandingExpression:
+			expanded, err := tomlTableOf(pair.Expression())
+
+			if err != nil {
+				return nil, err
+			}
+
+			for _, key := range expanded.keys {
+				table.set(key, expanded.entries[key])
+			}
+		default:
+			return nil, fmt.Errorf("toml: unexpected %s in map", pairExpr.ToString())
+		}
+	}
+
+	return table, nil
+}
+
+func tomlEntryOf(expr common.Expression) (*tomlEntry, error) {
+	target, err := tomlTarget(expr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch e := target.(type) {
+	case flimexpr.MapExpression:
+		table, err := tomlTableOf(e)
+		return &tomlEntry{table: table}, err
+	case flimexpr.ListExpression:
+		listItems, err := tomlListItems(e)
+
+		if err != nil {
+			return nil, err
+		}
+
+		tables := make([]*tomlTable, 0, len(listItems))
+
+		for _, listItem := range listItems {
+			listTarget, err := tomlTarget(listItem)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if _, isMap := listTarget.(flimexpr.MapExpression); !isMap {
+				break
+			}
+
+			table, err := tomlTableOf(listTarget)
+
+			if err != nil {
+				return nil, err
+			}
+
+			tables = append(tables, table)
+		}
+
+		if len(tables) > 0 && len(tables) == len(listItems) {
+			return &tomlEntry{tables: tables}, nil
+		}
+
+		return &tomlEntry{value: target}, nil
+	default:
+		return &tomlEntry{value: target}, nil
+	}
+}
+
+// tomlListItems returns the items of a list with any expansions spliced in.
+func tomlListItems(list flimexpr.ListExpression) ([]common.Expression, error) {
+	listItems := []common.Expression{}
+
+	for _, listItem := range list.Items() {
+		expansion, ok := listItem.(flimexpr.ExpandingExpression)
+
+		if !ok {
+			listItems = append(listItems, listItem)
+			continue
+		}
+
+		target, err := tomlTarget(expansion.Expression())
+
+		if err != nil {
+			return nil, err
+		}
+
+		expandedList, ok := target.(flimexpr.ListExpression)
+
+		if !ok {
+			return nil, fmt.Errorf("toml: cannot expand %s into a list", target.ToString())
+		}
+
+		expandedItems, err := tomlListItems(expandedList)
+
+		if err != nil {
+			return nil, err
+		}
+
+		listItems = append(listItems, expandedItems...)
+	}
+
+	return listItems, nil
+}
+
+func tomlKeyPath(path []string) string {
+	keys := make([]string, len(path))
+
+	for i, key := range path {
+		keys[i] = tomlKey(key)
+	}
+
+	return strings.Join(keys, ".")
+}
+
+func tomlKey(key string) string {
+	if key != "" && tomlBareKeyPattern.FindString(key) == key {
+		return key
+	}
+
+	return tomlQuote(key)
+}
+
+// writeTOMLTable writes the values of a table, followed by its tables and
+// arrays of tables, which TOML requires to come after any plain keys.
+func writeTOMLTable(builder *strings.Builder, table *tomlTable, path []string) error {
+	hasValues := false
+
+	for _, key := range table.keys {
+		hasValues = hasValues || table.entries[key].value != nil
+	}
+
+	if path != nil && (hasValues || len(table.keys) == 0) {
+		fmt.Fprintf(builder, "\n[%s]\n", tomlKeyPath(path))
+	}
+
+	for _, key := range table.keys {
+		if entry := table.entries[key]; entry.value != nil {
+			val, err := tomlValue(entry.value)
+
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(builder, "%s = %s\n", tomlKey(key), val)
+		}
+	}
+
+	for _, key := range table.keys {
+		if entry := table.entries[key]; entry.table != nil {
+			if err := writeTOMLTable(builder, entry.table, append(path[:len(path):len(path)], key)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, key := range table.keys {
+		entry := table.entries[key]
+		tablePath := append(path[:len(path):len(path)], key)
+
+		for _, element := range entry.tables {
+			fmt.Fprintf(builder, "\n[[%s]]\n", tomlKeyPath(tablePath))
+
+			// The header already names the element, so its own header is
+			// left out by writing it as though it were the root
+			var elementBuilder strings.Builder
+
+			if err := writeTOMLTable(&elementBuilder, element, nil); err != nil {
+				return err
+			}
+
+			builder.WriteString(strings.TrimPrefix(rebaseTOMLHeaders(elementBuilder.String(), tomlKeyPath(tablePath)), "\n"))
+		}
+	}
+
+	return nil
+}
+
+// rebaseTOMLHeaders prefixes the table headers written for an array element
+// with the path of the array.
+func rebaseTOMLHeaders(text string, prefix string) string {
+	lines := strings.Split(text, "\n")
+
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "[["):
+			lines[i] = "[[" + prefix + "." + line[2:]
+		case strings.HasPrefix(line, "["):
+			lines[i] = "[" + prefix + "." + line[1:]
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func tomlValue(expr common.Expression) (string, error) {
+	target, err := tomlTarget(expr)
+
+	if err != nil {
+		return "", err
+	}
+
+	switch e := target.(type) {
+	case flimexpr.MapExpression:
+		table, err := tomlTableOf(e)
+
+		if err != nil {
+			return "", err
+		}
+
+		return tomlInlineTable(table)
+	case flimexpr.ListExpression:
+		listItems, err := tomlListItems(e)
+
+		if err != nil {
+			return "", err
+		}
+
+		vals := make([]string, len(listItems))
+
+		for i, listItem := range listItems {
+			if vals[i], err = tomlValue(listItem); err != nil {
+				return "", err
+			}
+		}
+
+		return "[" + strings.Join(vals, ", ") + "]", nil
+	case flimexpr.NullLiteralExpression:
+		return "", fmt.Errorf("toml: cannot write null")
+	case flimexpr.BooleanLiteralExpression:
+		return strconv.FormatBool(e.Value()), nil
+	case flimexpr.StringLiteralExpression:
+		return tomlQuote(e.Value()), nil
+	case flimexpr.IntegerLiteralExpression:
+		if text := e.Text(); tomlPrefixedPattern.MatchString(text) || tomlIntegerPattern.MatchString(text) {
+			return text, nil
+		}
+
+		return strconv.FormatInt(e.Value(), 10), nil
+	case flimexpr.BigIntegerLiteralExpression:
+		return "", fmt.Errorf("toml: integer %s does not fit in 64 bits", e.Value())
+	case flimexpr.FloatLiteralExpression:
+		switch val := e.Value(); {
+		case math.IsInf(val, 1):
+			return "inf", nil
+		case math.IsInf(val, -1):
+			return "-inf", nil
+		case math.IsNaN(val):
+			return "nan", nil
+		}
+
+		return target.Serialize(common.NewSerializerConfig(), 0)
+	case flimexpr.BigFloatLiteralExpression:
+		return target.Serialize(common.NewSerializerConfig(), 0)
+	case flimexpr.TimestampLiteralExpression:
+		return e.Value().Format(time.RFC3339Nano), nil
+	case flimexpr.DurationLiteralExpression, flimexpr.SizeLiteralExpression, flimexpr.CustomLiteralExpression:
+		// TOML has no equivalent type, so these become strings
+		text, err := target.Serialize(common.NewSerializerConfig(), 0)
+
+		if err != nil {
+			return "", err
+		}
+
+		return tomlQuote(text), nil
+	default:
+		return "", fmt.Errorf("toml: cannot write %s", target.ToString())
+	}
+}
+
+// tomlInlineTable writes a table that appears inside an array.
+func tomlInlineTable(table *tomlTable) (string, error) {
+	fields := make([]string, len(table.keys))
+
+	for i, key := range table.keys {
+		entry := table.entries[key]
+		var val string
+		var err error
+
+		switch {
+		case entry.value != nil:
+			val, err = tomlValue(entry.value)
+		case entry.table != nil:
+			val, err = tomlInlineTable(entry.table)
+		default:
+			elements := make([]string, len(entry.tables))
+
+			for j, element := range entry.tables {
+				if elements[j], err = tomlInlineTable(element); err != nil {
+					return "", err
+				}
+			}
+
+			val = "[" + strings.Join(elements, ", ") + "]"
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		fields[i] = tomlKey(key) + " = " + val
+	}
+
+	if len(fields) == 0 {
+		return "{}", nil
+	}
+
+	return "{ " + strings.Join(fields, ", ") + " }", nil
+}
+
+// tomlQuote writes a basic string, escaping only what TOML requires.
+func tomlQuote(val string) string {
+	var builder strings.Builder
+	builder.WriteByte('"')
+
+	for _, r := range val {
+		switch r {
+		case '"':
+			builder.WriteString(`\"`)
+		case '\\':
+			builder.WriteString(`\\`)
+		case '\b':
+			builder.WriteString(`\b`)
+		case '\t':
+			builder.WriteString(`\t`)
+		case '\n':
+			builder.WriteString(`\n`)
+		case '\f':
+			builder.WriteString(`\f`)
+		case '\r':
+			builder.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&builder, `\u%04X`, r)
+			} else {
+				builder.WriteRune(r)
+			}
+		}
+	}
+
+	builder.WriteByte('"')
+	return builder.String()
+}
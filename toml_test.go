@@ -0,0 +1,175 @@
+package flim
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFromTOML(t *testing.T) {
+	tests := []struct {
+		name string
+		toml string
+		want interface{}
+	}{
+		{
+			"key/value pairs",
+			"name = \"flim\"\nport = 8080\nratio = 0.5\nenabled = true\n",
+			object{"name": "flim", "port": int64(8080), "ratio": 0.5, "enabled": true},
+		},
+		{
+			"integers and floats",
+			"hex = 0x1F\noctal = 0o17\nbinary = 0b101\nbig = 1_000\nexp = 1e3\n",
+			object{"hex": int64(31), "octal": int64(15), "binary": int64(5), "big": int64(1000), "exp": 1000.0},
+		},
+		{
+			"strings",
+			"basic = \"tab\\there\"\nliteral = 'C:\\path'\nmulti = \"\"\"\nline one\nline two\"\"\"\nraw = '''\nkept \\n'''\n",
+			object{"basic": "tab\there", "literal": `C:\path`, "multi": "line one\nline two", "raw": `kept \n`},
+		},
+		{
+			"comments",
+			"# leading\na = 1 # trailing\nb = \"# kept\"\n",
+			object{"a": int64(1), "b": "# kept"},
+		},
+		{
+			"tables",
+			"top = 1\n\n[server]\nhost = \"a\"\n\n[server.limits]\ncpu = 4\n",
+			object{"top": int64(1), "server": object{"host": "a", "limits": object{"cpu": int64(4)}}},
+		},
+		{
+			"dotted keys",
+			"server.host = \"a\"\nserver.limits.cpu = 4\n\"quoted.key\" = 1\n",
+			object{"server": object{"host": "a", "limits": object{"cpu": int64(4)}}, "quoted.key": int64(1)},
+		},
+		{
+			"dotted keys in a table",
+			"[server]\nlimits.cpu = 4\nlimits.memory = 64\n",
+			object{"server": object{"limits": object{"cpu": int64(4), "memory": int64(64)}}},
+		},
+		{
+			"arrays",
+			"ports = [ 1, 2, 3 ]\nmixed = [\n  \"a\",\n  [ 1 ],\n]\n",
+			object{"ports": array{int64(1), int64(2), int64(3)}, "mixed": array{"a", array{int64(1)}}},
+		},
+		{
+			"inline tables",
+			"point = { x = 1, y = 2 }\nnested = { a.b = 1 }\n",
+			object{"point": object{"x": int64(1), "y": int64(2)}, "nested": object{"a": object{"b": int64(1)}}},
+		},
+		{
+			"arrays of tables",
+			"[[items]]\nname = \"a\"\n\n[[items]]\nname = \"b\"\n\n[items.limits]\ncpu = 2\n",
+			object{"items": array{object{"name": "a"}, object{"name": "b", "limits": object{"cpu": int64(2)}}}},
+		},
+		{
+			"nested arrays of tables",
+			"[[a]]\n[[a.b]]\nx = 1\n[[a.b]]\nx = 2\n",
+			object{"a": array{object{"b": array{object{"x": int64(1)}, object{"x": int64(2)}}}}},
+		},
+		{
+			"dates and times",
+			"at = 2024-01-02T03:04:05Z\nday = 2024-01-02\n",
+			object{"at": time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), "day": "2024-01-02"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expr, err := FromTOML([]byte(test.toml))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := evaluateDocument(t, expr); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFromTOMLErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		toml string
+		err  string
+	}{
+		{"duplicate key", "a = 1\na = 2\n", "defined more than once"},
+		{"duplicate table", "[a]\nx = 1\n[a]\ny = 2\n", "defined more than once"},
+		{"table over a value", "a = 1\n[a]\n", "already defined"},
+		{"extending an inline table", "a = { x = 1 }\n[a.b]\n", "cannot extend inline table"},
+		{"missing equals", "a 1\n", "expected `='"},
+		{"unterminated string", "a = \"open\n", "unterminated string"},
+		{"invalid escape", "a = \"\\q\"\n", "invalid escape sequence"},
+		{"trailing content", "a = 1 2\n", "at end of line"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := FromTOML([]byte(test.toml))
+
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("got error %v, want one containing `%s'", err, test.err)
+			}
+		})
+	}
+}
+
+// Documents written with ToTOML read back as the same value.
+func TestToTOMLRoundTrip(t *testing.T) {
+	for _, text := range []string{
+		`{ name "flim" port 8080 ratio 0.5 enabled true }`,
+		`{ server { host "a" limits { cpu 4 } } top 1 }`,
+		`{ items [ { name "a" } { name "b" limits { cpu 2 } } ] ports [ 1 2 ] }`,
+		"#base { port 80 }\n{ a &base b { *&base host \"b\" } }",
+		`{ "dotted.key" 1 text "line one\nline two" }`,
+	} {
+		t.Run(text, func(t *testing.T) {
+			expr, err := ParseString(text)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := ToTOML(expr)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			readBack, err := FromTOML(data)
+
+			if err != nil {
+				t.Fatalf("reading back %s: %s", data, err)
+			}
+
+			want := evaluateDocument(t, expr)
+
+			if got := evaluateDocument(t, readBack); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s read back as %#v, want %#v", data, got, want)
+			}
+		})
+	}
+}
+
+func TestToTOMLErrors(t *testing.T) {
+	for _, text := range []string{
+		`[ 1 2 ]`,
+		`{ a null }`,
+		`{ a upper "x" }`,
+	} {
+		t.Run(text, func(t *testing.T) {
+			expr, err := ParseString(text)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if data, err := ToTOML(expr); err == nil {
+				t.Errorf("written as %s without an error", data)
+			}
+		})
+	}
+}
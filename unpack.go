@@ -0,0 +1,184 @@
+package flim
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+)
+
+// Unpack stores an evaluated value, such as the result of common.Evaluate, in
+// the Go value out points to. Maps are stored in structs field by field, each
+// field taking the key named by its `flim` tag, or its own name if it has no
+// tag. Keys without a field are ignored, as are fields tagged "-", and fields
+// whose key is missing are left as they were. Numbers are converted to the
+// type of what they are stored in as long as they fit, and null stores the
+// zero value.
+func Unpack(val interface{}, out interface{}) error {
+	rv := reflect.ValueOf(out)
+
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("cannot unpack into %T, expected a non-nil pointer", out)
+	}
+
+	return unpack(val, rv.Elem(), Path{})
+}
+
+func unpack(val interface{}, rv reflect.Value, path Path) error {
+	if val == nil {
+		rv.Set(reflect.Zero(rv.Type()))
+		return nil
+	}
+
+	if rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+
+		return unpack(val, rv.Elem(), path)
+	}
+
+	// Values of the right type, like durations and custom literals, are
+	// stored as they are
+	if reflect.TypeOf(val).AssignableTo(rv.Type()) {
+		rv.Set(reflect.ValueOf(val))
+		return nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Struct:
+		m, ok := val.(map[string]interface{})
+
+		if !ok {
+			break
+		}
+
+		return unpackStruct(m, rv, path)
+	case reflect.Map:
+		m, ok := val.(map[string]interface{})
+
+		if !ok || rv.Type().Key().Kind() != reflect.String {
+			break
+		}
+
+		if rv.IsNil() {
+			rv.Set(reflect.MakeMapWithSize(rv.Type(), len(m)))
+		}
+
+		for key, mapVal := range m {
+			elem := reflect.New(rv.Type().Elem()).Elem()
+
+			if err := unpack(mapVal, elem, path.Child(key)); err != nil {
+				return err
+			}
+
+			rv.SetMapIndex(reflect.ValueOf(key).Convert(rv.Type().Key()), elem)
+		}
+
+		return nil
+	case reflect.Slice:
+		list, ok := val.([]interface{})
+
+		if !ok {
+			break
+		}
+
+		slice := reflect.MakeSlice(rv.Type(), len(list), len(list))
+
+		for i, listItem := range list {
+			if err := unpack(listItem, slice.Index(i), path.Child(i)); err != nil {
+				return err
+			}
+		}
+
+		rv.Set(slice)
+		return nil
+	case reflect.String:
+		if str, ok := val.(string); ok {
+			rv.SetString(str)
+			return nil
+		}
+	case reflect.Bool:
+		if b, ok := val.(bool); ok {
+			rv.SetBool(b)
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n, ok := unpackInteger(val); ok && n.IsInt64() && !rv.OverflowInt(n.Int64()) {
+			rv.SetInt(n.Int64())
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if n, ok := unpackInteger(val); ok && n.IsUint64() && !rv.OverflowUint(n.Uint64()) {
+			rv.SetUint(n.Uint64())
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		f64, ok := val.(float64)
+
+		// NaN has no big.Float, so floats are not converted through one
+		if !ok {
+			var f *big.Float
+
+			if f, ok = queryNumber(val); ok {
+				f64, _ = f.Float64()
+			}
+		}
+
+		if ok && (!rv.OverflowFloat(f64) || math.IsInf(f64, 0)) {
+			rv.SetFloat(f64)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s: cannot unpack %s into %s", path, schemaValueString(val), rv.Type())
+}
+
+func unpackStruct(m map[string]interface{}, rv reflect.Value, path Path) error {
+	for i := 0; i < rv.NumField(); i++ {
+		field := rv.Type().Field(i)
+
+		if !field.IsExported() {
+			continue
+		}
+
+		key := field.Name
+
+		if tag, tagged := field.Tag.Lookup("flim"); tagged {
+			name, _, _ := strings.Cut(tag, ",")
+
+			if name == "-" {
+				continue
+			}
+
+			if name != "" {
+				key = name
+			}
+		}
+
+		fieldVal, exists := m[key]
+
+		if !exists {
+			continue
+		}
+
+		if err := unpack(fieldVal, rv.Field(i), path.Child(key)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unpackInteger converts an integer value into a *big.Int.
+func unpackInteger(val interface{}) (*big.Int, bool) {
+	switch n := val.(type) {
+	case int64:
+		return big.NewInt(n), true
+	case *big.Int:
+		return n, true
+	default:
+		return nil, false
+	}
+}
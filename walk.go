@@ -0,0 +1,277 @@
+package flim
+
+import (
+	"github.com/l-donovan/flim/common"
+	flimexpr "github.com/l-donovan/flim/expressions"
+)
+
+// NodeKind is the kind of an expression in the tree.
+type NodeKind int
+
+const (
+	FileNode NodeKind = iota
+	MapNode
+	PairNode
+	ListNode
+	ExpandingNode
+	TaggedNode
+	ReferenceNode
+	TransformerNode
+	MappedTransformerNode
+
+	// LiteralNode covers every literal, including custom ones
+	LiteralNode
+)
+
+func (k NodeKind) String() string {
+	switch k {
+	case FileNode:
+		return "file"
+	case MapNode:
+		return "map"
+	case PairNode:
+		return "pair"
+	case ListNode:
+		return "list"
+	case ExpandingNode:
+		return "expansion"
+	case TaggedNode:
+		return "tagged"
+	case ReferenceNode:
+		return "reference"
+	case TransformerNode:
+		return "transformer"
+	case MappedTransformerNode:
+		return "mapped transformer"
+	default:
+		return "literal"
+	}
+}
+
+// KindOf returns the kind of expr.
+func KindOf(expr common.Expression) NodeKind {
+	switch expr.(type) {
+	case flimexpr.FileExpression:
+		return FileNode
+	case flimexpr.MapExpression:
+		return MapNode
+	case flimexpr.PairExpression:
+		return PairNode
+	case flimexpr.ListExpression:
+		return ListNode
+	case flimexpr.ExpandingExpression:
+		return ExpandingNode
+	case flimexpr.TaggedExpression:
+		return TaggedNode
+	case flimexpr.ReferenceExpression:
+		return ReferenceNode
+	case flimexpr.TransformerExpression:
+		return TransformerNode
+	case flimexpr.MappedTransformerExpression:
+		return MappedTransformerNode
+	default:
+		return LiteralNode
+	}
+}
+
+// Node is an expression met while walking a tree, along with where it was
+// found.
+type Node struct {
+	Expression common.Expression
+	Kind       NodeKind
+
+	// Parent is the node that contains this one, or nil at the root.
+	Parent *Node
+
+	// Path is the path of the value the expression belongs to within its
+	// top-level expression. A pair has the path of its value, and tags,
+	// transformers and expansions share the path of what they wrap.
+	Path Path
+}
+
+// A Visitor's Visit method is called for each node met by Walk. If it returns
+// a non-nil visitor w, Walk visits each child of the node with w and then
+// calls w.Visit(nil).
+type Visitor interface {
+	Visit(node *Node) (w Visitor)
+}
+
+// child is an expression inside another, with the path element that leads to
+// it, if any.
+type child struct {
+	expr    common.Expression
+	element interface{}
+}
+
+// children returns the expressions directly inside expr. References are
+// leaves, even once resolved, so that walking never follows them into a
+// cycle.
+func children(expr common.Expression) []child {
+	switch e := expr.(type) {
+	case flimexpr.FileExpression:
+		exprs := e.Expressions()
+		result := make([]child, len(exprs))
+
+		for i, fileExpr := range exprs {
+			result[i] = child{fileExpr, nil}
+		}
+
+		return result
+	case flimexpr.MapExpression:
+		pairs := e.Pairs()
+		result := make([]child, len(pairs))
+
+		for i, pairExpr := range pairs {
+			result[i] = child{pairExpr, nil}
+
+			if pair, ok := pairExpr.(flimexpr.PairExpression); ok {
+				result[i].element = pair.Key()
+			}
+		}
+
+		return result
+	case flimexpr.PairExpression:
+		return []child{{e.Value(), nil}}
+	case flimexpr.ListExpression:
+		listItems := e.Items()
+		result := make([]child, len(listItems))
+
+		for i, listItem := range listItems {
+			result[i] = child{listItem, i}
+		}
+
+		return result
+	case flimexpr.ExpandingExpression:
+		return []child{{e.Expression(), nil}}
+	case flimexpr.TaggedExpression:
+		return []child{{e.Expression(), nil}}
+	case flimexpr.TransformerExpression:
+		return []child{{e.Expression(), nil}}
+	case flimexpr.MappedTransformerExpression:
+		return []child{{e.Expression(), nil}}
+	default:
+		return nil
+	}
+}
+
+func (n *Node) child(c child) *Node {
+	path := n.Path
+
+	if c.element != nil {
+		path = path.Child(c.element)
+	} else if n.Kind == FileNode {
+		path = Path{}
+	}
+
+	return &Node{Expression: c.expr, Kind: KindOf(c.expr), Parent: n, Path: path}
+}
+
+// Walk traverses expr in depth-first order, as ast.Walk does: it calls
+// v.Visit for each node, and if that returns a non-nil visitor w, walks the
+// node's children with w before calling w.Visit(nil).
+func Walk(v Visitor, expr common.Expression) {
+	walk(v, &Node{Expression: expr, Kind: KindOf(expr), Path: Path{}})
+}
+
+func walk(v Visitor, node *Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	for _, c := range children(node.Expression) {
+		walk(v, node.child(c))
+	}
+
+	v.Visit(nil)
+}
+
+type inspector func(node *Node) bool
+
+func (f inspector) Visit(node *Node) Visitor {
+	if f(node) {
+		return f
+	}
+
+	return nil
+}
+
+// Inspect traverses expr in depth-first order, calling f for each node. If f
+// returns true, Inspect goes on to the node's children, and then calls
+// f(nil).
+func Inspect(expr common.Expression, f func(node *Node) bool) {
+	Walk(inspector(f), expr)
+}
+
+// Rewrite rebuilds expr from the bottom up, replacing each node with what f
+// returns for it. f sees each node after its children have been rewritten,
+// so node.Expression holds the new children, while node.Parent still holds
+// the parent as it was. Returning node.Expression keeps the node, and
+// returning nil removes a top-level expression, map pair, expansion or list
+// item. Later list items then keep their original index in node.Path.
+//
+// expr is not changed, and the nodes f does not replace are shared with it.
+func Rewrite(expr common.Expression, f func(node *Node) (common.Expression, error)) (common.Expression, error) {
+	return rewrite(&Node{Expression: expr, Kind: KindOf(expr), Path: Path{}}, f)
+}
+
+func rewrite(node *Node, f func(node *Node) (common.Expression, error)) (common.Expression, error) {
+	cs := children(node.Expression)
+
+	if len(cs) > 0 {
+		newChildren := make([]common.Expression, 0, len(cs))
+
+		for _, c := range cs {
+			newChild, err := rewrite(node.child(c), f)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if newChild != nil {
+				newChildren = append(newChildren, newChild)
+			}
+		}
+
+		rebuilt, err := rebuild(node.Expression, newChildren)
+
+		if err != nil || rebuilt == nil {
+			return rebuilt, err
+		}
+
+		node = &Node{Expression: rebuilt, Kind: KindOf(rebuilt), Parent: node.Parent, Path: node.Path}
+	}
+
+	return f(node)
+}
+
+// rebuild returns a copy of expr with its children replaced. An expression
+// that wraps a single child is removed along with it.
+func rebuild(expr common.Expression, newChildren []common.Expression) (common.Expression, error) {
+	switch expr.(type) {
+	case flimexpr.FileExpression:
+		return flimexpr.NewFileExpression(newChildren)
+	case flimexpr.MapExpression:
+		return flimexpr.NewMapExpression(newChildren)
+	case flimexpr.ListExpression:
+		return flimexpr.NewListExpression(newChildren)
+	}
+
+	if len(newChildren) == 0 {
+		return nil, nil
+	}
+
+	switch e := expr.(type) {
+	case flimexpr.PairExpression:
+		return e.WithValue(newChildren[0]), nil
+	case flimexpr.ExpandingExpression:
+		return e.WithExpression(newChildren[0]), nil
+	case flimexpr.TaggedExpression:
+		return e.WithExpression(newChildren[0]), nil
+	case flimexpr.TransformerExpression:
+		return e.WithExpression(newChildren[0]), nil
+	case flimexpr.MappedTransformerExpression:
+		return e.WithExpression(newChildren[0]), nil
+	default:
+		return expr, nil
+	}
+}
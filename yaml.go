@@ -0,0 +1,1481 @@
+package flim
+
+import (
+	"errors"
+	"fmt"
+	"github.com/l-donovan/flim/common"
+	flimexpr "github.com/l-donovan/flim/expressions"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// FromYAML builds an expression tree from a single YAML document. Anchors
+// become TaggedExpressions, aliases become ReferenceExpressions and merge keys
+// (<<) become ExpandingExpressions, so the result should be passed to
+// ResolveReferences before it is evaluated. Merged maps are expanded before
+// the mapping's own keys, which always win over them, and an anchor that is
+// defined again is given a new tag name such as a_2, so that aliases refer to
+// the latest definition before them. Local tags such as !name become
+// transformers, with !@name and !@@name for mapped transformers.
+//
+// Block and flow collections, plain, quoted and block scalars are supported.
+// Complex keys, multiple documents and tag handles other than !! are not.
+func FromYAML(data []byte) (common.Expression, error) {
+	parser, err := newYAMLParser(string(data))
+
+	if err != nil {
+		return nil, err
+	}
+
+	expr, err := parser.parseBlock(0)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if line, ok := parser.peekLine(); ok {
+		return nil, fmt.Errorf("yaml: line %d: unexpected content %q", line.number, strings.TrimSpace(line.text))
+	}
+
+	return flimexpr.NewFileExpression([]common.Expression{expr})
+}
+
+type yamlLine struct {
+	number int
+	indent int
+	text   string
+}
+
+type yamlParser struct {
+	lines   []yamlLine
+	pos     int
+	anchors *yamlAnchors
+}
+
+// yamlAnchors gives each anchor the name of the tag it becomes.
+type yamlAnchors struct {
+	// names holds the tag name of each anchor's latest definition, and used
+	// every tag name given so far
+	names map[string]string
+	used  map[string]bool
+}
+
+// define returns the tag name for a definition of anchor, which is the
+// anchor itself unless that name has already been given.
+func (a *yamlAnchors) define(anchor string) string {
+	if anchor == "" {
+		return ""
+	}
+
+	name := anchor
+
+	for n := 2; a.used[name]; n++ {
+		name = fmt.Sprintf("%s_%d", anchor, n)
+	}
+
+	a.used[name] = true
+	a.names[anchor] = name
+	return name
+}
+
+// alias returns a reference to the latest definition of anchor.
+func (a *yamlAnchors) alias(anchor string) (common.Expression, error) {
+	if !yamlNamePattern.MatchString(anchor) {
+		return nil, fmt.Errorf("yaml: alias `%s' is not a valid flim tag name", anchor)
+	}
+
+	name, defined := a.names[anchor]
+
+	if !defined {
+		return nil, fmt.Errorf("yaml: alias `%s' has no anchor before it", anchor)
+	}
+
+	return flimexpr.NewReferenceExpression(name)
+}
+
+func newYAMLParser(text string) (*yamlParser, error) {
+	parser := &yamlParser{anchors: &yamlAnchors{names: map[string]string{}, used: map[string]bool{}}}
+	rawLines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	started := false
+
+	for i, raw := range rawLines {
+		trimmed := strings.TrimLeft(raw, " ")
+
+		if strings.HasPrefix(trimmed, "\t") && strings.TrimSpace(trimmed) != "" {
+			return nil, fmt.Errorf("yaml: line %d: tabs cannot be used for indentation", i+1)
+		}
+
+		if len(trimmed) == len(raw) {
+			if strings.HasPrefix(raw, "%") && !started {
+				// Directives such as %YAML carry nothing we use
+				continue
+			}
+
+			if raw == "---" || strings.HasPrefix(raw, "--- ") {
+				if started || len(parser.lines) > 0 && parser.hasContent() {
+					return nil, fmt.Errorf("yaml: line %d: multiple documents are not supported", i+1)
+				}
+
+				started = true
+				raw = strings.TrimPrefix(strings.TrimPrefix(raw, "---"), " ")
+				trimmed = raw
+
+				if raw == "" {
+					continue
+				}
+			}
+
+			if raw == "..." {
+				break
+			}
+		}
+
+		parser.lines = append(parser.lines, yamlLine{
+			number: i + 1,
+			indent: len(raw) - len(trimmed),
+			text:   trimmed,
+		})
+	}
+
+	return parser, nil
+}
+
+func (p *yamlParser) hasContent() bool {
+	for _, line := range p.lines {
+		if stripYAMLComment(line.text) != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// peekLine returns the next line with content, skipping blank lines and
+// comments.
+func (p *yamlParser) peekLine() (yamlLine, bool) {
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+
+		if stripYAMLComment(line.text) != "" {
+			return line, true
+		}
+
+		p.pos++
+	}
+
+	return yamlLine{}, false
+}
+
+// stripYAMLComment removes a trailing comment and whitespace from a line,
+// ignoring # characters inside quoted scalars.
+func stripYAMLComment(text string) string {
+	var quote byte
+
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				if quote == '\'' && i+1 < len(text) && text[i+1] == '\'' {
+					i++
+				} else {
+					quote = 0
+				}
+			}
+		case (c == '"' || c == '\'') && (i == 0 || strings.ContainsRune(" \t[{,:", rune(text[i-1]))):
+			quote = c
+		case c == '#' && (i == 0 || text[i-1] == ' ' || text[i-1] == '\t'):
+			return strings.TrimRight(text[:i], " \t")
+		}
+	}
+
+	return strings.TrimRight(text, " \t")
+}
+
+func isYAMLSequenceEntry(content string) bool {
+	return content == "-" || strings.HasPrefix(content, "- ")
+}
+
+// splitYAMLMappingEntry splits a "key: value" line, returning false if the
+// line is not a mapping entry.
+func splitYAMLMappingEntry(content string) (string, string, bool, error) {
+	var quote byte
+	depth := 0
+
+	if strings.HasPrefix(content, "? ") {
+		return "", "", false, fmt.Errorf("complex mapping keys are not supported")
+	}
+
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				if quote == '\'' && i+1 < len(content) && content[i+1] == '\'' {
+					i++
+				} else {
+					quote = 0
+				}
+			}
+		case (c == '"' || c == '\'') && (i == 0 || strings.ContainsRune(" [{,", rune(content[i-1]))):
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		case c == ':' && depth == 0 && (i+1 == len(content) || content[i+1] == ' '):
+			key := strings.TrimSpace(content[:i])
+
+			if key == "" || strings.ContainsAny(key[:1], "&*!|>") {
+				return "", "", false, nil
+			}
+
+			if key[0] == '"' || key[0] == '\'' {
+				unquoted, err := unquoteYAML(key)
+
+				if err != nil {
+					return "", "", false, err
+				}
+
+				key = unquoted
+			}
+
+			return key, strings.TrimSpace(content[i+1:]), true, nil
+		}
+	}
+
+	return "", "", false, nil
+}
+
+// parseBlock parses the block node starting at the next line, which must be
+// indented by at least minIndent.
+func (p *yamlParser) parseBlock(minIndent int) (common.Expression, error) {
+	line, ok := p.peekLine()
+
+	if !ok || line.indent < minIndent {
+		return flimexpr.NewNullLiteralExpression()
+	}
+
+	content := stripYAMLComment(line.text)
+
+	if isYAMLSequenceEntry(content) {
+		return p.parseSequence(line.indent)
+	}
+
+	_, _, isEntry, err := splitYAMLMappingEntry(content)
+
+	if err != nil {
+		return nil, fmt.Errorf("yaml: line %d: %w", line.number, err)
+	}
+
+	if isEntry {
+		return p.parseMapping(line.indent)
+	}
+
+	p.pos++
+	return p.parseValue(content, line.indent-1, false)
+}
+
+func (p *yamlParser) parseMapping(indent int) (common.Expression, error) {
+	merges := []common.Expression{}
+	pairs := []common.Expression{}
+
+	for {
+		line, ok := p.peekLine()
+
+		if !ok || line.indent < indent {
+			break
+		}
+
+		if line.indent > indent {
+			return nil, fmt.Errorf("yaml: line %d: unexpected indentation", line.number)
+		}
+
+		content := stripYAMLComment(line.text)
+		key, rest, isEntry, err := splitYAMLMappingEntry(content)
+
+		if err != nil {
+			return nil, fmt.Errorf("yaml: line %d: %w", line.number, err)
+		}
+
+		if !isEntry {
+			if isYAMLSequenceEntry(content) {
+				break
+			}
+
+			return nil, fmt.Errorf("yaml: line %d: expected a mapping entry", line.number)
+		}
+
+		p.pos++
+		val, err := p.parseValue(rest, indent, true)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if key == "<<" {
+			expansions, err := yamlMergeExpansions(val)
+
+			if err != nil {
+				return nil, fmt.Errorf("yaml: line %d: %w", line.number, err)
+			}
+
+			merges = append(merges, expansions...)
+			continue
+		}
+
+		pair, err := flimexpr.NewPairExpression(key, val)
+
+		if err != nil {
+			return nil, err
+		}
+
+		pairs = append(pairs, pair)
+	}
+
+	// The mapping's own keys win over merged ones wherever they are written
+	return flimexpr.NewMapExpression(append(merges, pairs...))
+}
+
+// yamlMergeExpansions turns the value of a merge key into expansions. YAML
+// gives earlier maps in a merge list priority, whereas later expansions win
+// in flim, so the list is reversed.
+func yamlMergeExpansions(val common.Expression) ([]common.Expression, error) {
+	sources := []common.Expression{val}
+
+	if list, ok := val.(flimexpr.ListExpression); ok {
+		sources = list.Items()
+
+		for i, j := 0, len(sources)-1; i < j; i, j = i+1, j-1 {
+			sources[i], sources[j] = sources[j], sources[i]
+		}
+	}
+
+	expansions := make([]common.Expression, len(sources))
+
+	for i, source := range sources {
+		switch source.(type) {
+		case flimexpr.ReferenceExpression, flimexpr.MapExpression, flimexpr.TaggedExpression:
+		default:
+			return nil, fmt.Errorf("merge keys must refer to mappings")
+		}
+
+		expansion, err := flimexpr.NewExpandingExpression(source)
+
+		if err != nil {
+			return nil, err
+		}
+
+		expansions[i] = expansion
+	}
+
+	return expansions, nil
+}
+
+func (p *yamlParser) parseSequence(indent int) (common.Expression, error) {
+	listItems := []common.Expression{}
+
+	for {
+		line, ok := p.peekLine()
+
+		if !ok || line.indent != indent {
+			break
+		}
+
+		content := stripYAMLComment(line.text)
+
+		if !isYAMLSequenceEntry(content) {
+			break
+		}
+
+		rest := strings.TrimLeft(content[1:], " ")
+		_, _, isEntry, err := splitYAMLMappingEntry(rest)
+
+		if err != nil {
+			return nil, fmt.Errorf("yaml: line %d: %w", line.number, err)
+		}
+
+		var listItem common.Expression
+
+		if rest != "" && (isEntry || isYAMLSequenceEntry(rest)) {
+			// A collection that starts on the same line as its dash is parsed
+			// as though the dash were indentation
+			offset := len(line.text) - len(strings.TrimLeft(line.text[1:], " "))
+			p.lines[p.pos] = yamlLine{number: line.number, indent: indent + offset, text: line.text[offset:]}
+			listItem, err = p.parseBlock(indent + 1)
+		} else {
+			p.pos++
+			listItem, err = p.parseValue(rest, indent, false)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		listItems = append(listItems, listItem)
+	}
+
+	return flimexpr.NewListExpression(listItems)
+}
+
+// parseValue parses the value following a mapping key or sequence dash on a
+// line indented by indent. Values may continue on more indented lines.
+func (p *yamlParser) parseValue(rest string, indent int, inMapping bool) (common.Expression, error) {
+	anchor, tag, rest, err := splitYAMLProperties(rest)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var expr common.Expression
+
+	switch {
+	case rest == "":
+		line, ok := p.peekLine()
+
+		if ok && line.indent > indent {
+			expr, err = p.parseBlock(indent + 1)
+		} else if ok && inMapping && line.indent == indent && isYAMLSequenceEntry(stripYAMLComment(line.text)) {
+			// Sequences may be written at the same indentation as their key
+			expr, err = p.parseSequence(indent)
+		} else {
+			expr, err = flimexpr.NewNullLiteralExpression()
+		}
+	case rest[0] == '*':
+		if anchor != "" || tag != "" {
+			return nil, fmt.Errorf("yaml: aliases cannot have properties")
+		}
+
+		expr, err = p.anchors.alias(rest[1:])
+	case rest[0] == '|' || rest[0] == '>':
+		var text string
+		text, err = p.parseBlockScalar(rest, indent)
+
+		if err == nil {
+			expr, err = flimexpr.NewStringLiteralExpression(text)
+		}
+	case rest[0] == '[' || rest[0] == '{':
+		text := p.continueFlow(rest)
+		flow := &yamlFlowParser{text: text, anchors: p.anchors}
+		expr, err = flow.parseNode()
+
+		if err == nil {
+			flow.skipSpace()
+
+			if flow.pos < len(flow.text) {
+				err = fmt.Errorf("yaml: unexpected %q after flow collection", flow.text[flow.pos:])
+			}
+		}
+	case rest[0] == '"' || rest[0] == '\'':
+		var text string
+		text, err = unquoteYAML(p.continueQuoted(rest))
+
+		if err == nil {
+			expr, err = flimexpr.NewStringLiteralExpression(text)
+		}
+	default:
+		expr, err = resolveYAMLScalar(p.continuePlain(rest, indent), tag == "!!str")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return applyYAMLProperties(expr, p.anchors.define(anchor), tag)
+}
+
+// continuePlain joins the continuation lines of a multi-line plain scalar.
+func (p *yamlParser) continuePlain(text string, indent int) string {
+	for {
+		line, ok := p.peekLine()
+
+		if !ok || line.indent <= indent {
+			return text
+		}
+
+		content := stripYAMLComment(line.text)
+
+		if _, _, isEntry, _ := splitYAMLMappingEntry(content); isEntry || isYAMLSequenceEntry(content) {
+			return text
+		}
+
+		text += " " + content
+		p.pos++
+	}
+}
+
+// continueQuoted joins the lines of a quoted scalar until its closing quote.
+func (p *yamlParser) continueQuoted(text string) string {
+	for !yamlQuoteClosed(text) {
+		line, ok := p.peekLine()
+
+		if !ok {
+			return text
+		}
+
+		text += " " + strings.TrimSpace(line.text)
+		p.pos++
+	}
+
+	return stripYAMLComment(text)
+}
+
+func yamlQuoteClosed(text string) bool {
+	quote := text[0]
+
+	for i := 1; i < len(text); i++ {
+		switch {
+		case quote == '"' && text[i] == '\\':
+			i++
+		case text[i] == quote:
+			if quote == '\'' && i+1 < len(text) && text[i+1] == '\'' {
+				i++
+			} else {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// continueFlow joins the lines of a flow collection until its brackets are
+// balanced.
+func (p *yamlParser) continueFlow(text string) string {
+	for yamlFlowDepth(text) > 0 {
+		line, ok := p.peekLine()
+
+		if !ok {
+			return text
+		}
+
+		text += " " + stripYAMLComment(line.text)
+		p.pos++
+	}
+
+	return text
+}
+
+func yamlFlowDepth(text string) int {
+	var quote byte
+	depth := 0
+
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		}
+	}
+
+	return depth
+}
+
+func (p *yamlParser) parseBlockScalar(header string, indent int) (string, error) {
+	folded := header[0] == '>'
+	chomping := byte(0)
+	explicitIndent := 0
+
+	for _, c := range []byte(header[1:]) {
+		switch {
+		case c == '-' || c == '+':
+			chomping = c
+		case c >= '1' && c <= '9':
+			explicitIndent = int(c - '0')
+		case c == ' ':
+		default:
+			return "", fmt.Errorf("yaml: invalid block scalar header %q", header)
+		}
+	}
+
+	contentIndent := -1
+
+	if explicitIndent > 0 {
+		contentIndent = indent + explicitIndent
+
+		if indent < 0 {
+			contentIndent = explicitIndent
+		}
+	}
+
+	lines := []string{}
+
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		blank := strings.TrimSpace(line.text) == ""
+
+		if !blank && contentIndent < 0 {
+			if line.indent <= indent {
+				break
+			}
+
+			contentIndent = line.indent
+		}
+
+		if !blank && line.indent < contentIndent {
+			break
+		}
+
+		if blank {
+			lines = append(lines, "")
+		} else {
+			lines = append(lines, strings.Repeat(" ", line.indent-contentIndent)+line.text)
+		}
+
+		p.pos++
+	}
+
+	trailing := 0
+
+	for trailing < len(lines) && lines[len(lines)-1-trailing] == "" {
+		trailing++
+	}
+
+	body := lines[:len(lines)-trailing]
+	var text string
+
+	if folded {
+		var builder strings.Builder
+
+		for i, line := range body {
+			if i > 0 {
+				previous := body[i-1]
+
+				switch {
+				case line == "" || previous == "":
+					builder.WriteString("\n")
+				case strings.HasPrefix(line, " ") || strings.HasPrefix(previous, " "):
+					builder.WriteString("\n")
+				default:
+					builder.WriteString(" ")
+				}
+			}
+
+			builder.WriteString(line)
+		}
+
+		text = builder.String()
+	} else {
+		text = strings.Join(body, "\n")
+	}
+
+	switch {
+	case len(body) == 0:
+		if chomping == '+' {
+			return strings.Repeat("\n", trailing), nil
+		}
+
+		return "", nil
+	case chomping == '-':
+		return text, nil
+	case chomping == '+':
+		return text + "\n" + strings.Repeat("\n", trailing), nil
+	default:
+		return text + "\n", nil
+	}
+}
+
+var yamlNamePattern = regexp.MustCompile(`^\w+$`)
+
+// splitYAMLProperties removes any anchor and tag from the start of a value.
+func splitYAMLProperties(text string) (string, string, string, error) {
+	anchor, tag := "", ""
+
+	for len(text) > 0 && (text[0] == '&' || text[0] == '!') {
+		end := strings.IndexAny(text, " ,[]{}")
+
+		if end < 0 {
+			end = len(text)
+		}
+
+		property := text[:end]
+		text = strings.TrimLeft(text[end:], " ")
+
+		if property[0] == '&' {
+			anchor = property[1:]
+
+			if !yamlNamePattern.MatchString(anchor) {
+				return "", "", "", fmt.Errorf("yaml: anchor `%s' is not a valid flim tag name", anchor)
+			}
+		} else {
+			tag = property
+		}
+	}
+
+	return anchor, tag, text, nil
+}
+
+func applyYAMLProperties(expr common.Expression, anchor string, tag string) (common.Expression, error) {
+	var err error
+
+	switch {
+	case tag == "" || tag == "!!str" || tag == "!!map" || tag == "!!seq":
+	case strings.HasPrefix(tag, "!!"):
+		if _, supported := map[string]bool{"!!int": true, "!!float": true, "!!bool": true, "!!null": true, "!!timestamp": true}[tag]; !supported {
+			return nil, fmt.Errorf("yaml: unsupported tag %s", tag)
+		}
+	case strings.HasPrefix(tag, "!@@"):
+		expr, err = flimexpr.NewKeyedMappedTransformerExpression(tag[3:], expr)
+	case strings.HasPrefix(tag, "!@"):
+		expr, err = flimexpr.NewMappedTransformerExpression(tag[2:], expr)
+	default:
+		name := tag[1:]
+
+		if !yamlNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("yaml: tag %s is not a valid flim transformer name", tag)
+		}
+
+		expr, err = flimexpr.NewTransformerExpression(name, expr)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if anchor != "" {
+		return flimexpr.NewTaggedExpression(anchor, expr)
+	}
+
+	return expr, nil
+}
+
+var (
+	yamlIntPattern       = regexp.MustCompile(`^[-+]?[0-9]+$`)
+	yamlOctPattern       = regexp.MustCompile(`^0o[0-7]+$`)
+	yamlHexPattern       = regexp.MustCompile(`^0x[0-9a-fA-F]+$`)
+	yamlFloatPattern     = regexp.MustCompile(`^[-+]?(\.[0-9]+|[0-9]+(\.[0-9]*)?)([eE][-+]?[0-9]+)?$`)
+	yamlTimestampPattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:\d{2})$`)
+)
+
+// resolveYAMLScalar gives a plain scalar its type according to the YAML 1.2
+// core schema, plus RFC 3339 timestamps.
+func resolveYAMLScalar(text string, forceString bool) (common.Expression, error) {
+	if forceString {
+		return flimexpr.NewStringLiteralExpression(text)
+	}
+
+	switch text {
+	case "", "~", "null", "Null", "NULL":
+		return flimexpr.NewNullLiteralExpression()
+	case "true", "True", "TRUE":
+		return flimexpr.NewBooleanLiteralExpression(true)
+	case "false", "False", "FALSE":
+		return flimexpr.NewBooleanLiteralExpression(false)
+	case ".inf", ".Inf", ".INF", "+.inf", "+.Inf", "+.INF":
+		return flimexpr.NewFloatLiteralExpression(math.Inf(1))
+	case "-.inf", "-.Inf", "-.INF":
+		return flimexpr.NewFloatLiteralExpression(math.Inf(-1))
+	case ".nan", ".NaN", ".NAN":
+		return flimexpr.NewFloatLiteralExpression(math.NaN())
+	}
+
+	switch {
+	case yamlIntPattern.MatchString(text):
+		return yamlInteger(strings.TrimPrefix(text, "+"), 10, "")
+	case yamlOctPattern.MatchString(text):
+		return yamlInteger(text[2:], 8, text)
+	case yamlHexPattern.MatchString(text):
+		return yamlInteger(text[2:], 16, text)
+	case yamlFloatPattern.MatchString(text):
+		val, err := strconv.ParseFloat(text, 64)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return flimexpr.NewFloatLiteralExpression(val)
+	case yamlTimestampPattern.MatchString(text):
+		val, err := time.Parse(time.RFC3339Nano, text)
+
+		if err == nil {
+			return flimexpr.NewTimestampLiteralExpression(val)
+		}
+	}
+
+	return flimexpr.NewStringLiteralExpression(text)
+}
+
+func yamlInteger(digits string, base int, text string) (common.Expression, error) {
+	val, err := strconv.ParseInt(digits, base, 64)
+
+	if errors.Is(err, strconv.ErrRange) {
+		bigVal, ok := new(big.Int).SetString(digits, base)
+
+		if !ok {
+			return nil, err
+		}
+
+		return flimexpr.NewBigIntegerLiteralExpressionWithText(bigVal, text)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if text != "" {
+		return flimexpr.NewIntegerLiteralExpressionWithText(val, text)
+	}
+
+	return flimexpr.NewIntegerLiteralExpression(val)
+}
+
+// unquoteYAML resolves a single- or double-quoted scalar.
+func unquoteYAML(text string) (string, error) {
+	if len(text) < 2 || text[len(text)-1] != text[0] {
+		return "", fmt.Errorf("yaml: unterminated string %s", text)
+	}
+
+	body := text[1 : len(text)-1]
+
+	if text[0] == '\'' {
+		return strings.ReplaceAll(body, "''", "'"), nil
+	}
+
+	var builder strings.Builder
+
+	for i := 0; i < len(body); i++ {
+		if body[i] != '\\' {
+			builder.WriteByte(body[i])
+			continue
+		}
+
+		i++
+
+		if i == len(body) {
+			return "", fmt.Errorf("yaml: unterminated escape sequence in %s", text)
+		}
+
+		simple := map[byte]string{
+			'0': "\x00", 'a': "\a", 'b': "\b", 't': "\t", 'n': "\n", 'v': "\v", 'f': "\f",
+			'r': "\r", 'e': "\x1b", ' ': " ", '"': "\"", '/': "/", '\\': "\\",
+			'N': "\u0085", '_': " ", 'L': " ", 'P': " ",
+		}
+
+		if replacement, ok := simple[body[i]]; ok {
+			builder.WriteString(replacement)
+			continue
+		}
+
+		width := map[byte]int{'x': 2, 'u': 4, 'U': 8}[body[i]]
+
+		if width == 0 || i+width >= len(body)+1 {
+			return "", fmt.Errorf("yaml: invalid escape sequence in %s", text)
+		}
+
+		code, err := strconv.ParseUint(body[i+1:i+1+width], 16, 32)
+
+		if err != nil {
+			return "", fmt.Errorf("yaml: invalid escape sequence in %s", text)
+		}
+
+		builder.WriteRune(rune(code))
+		i += width
+	}
+
+	return builder.String(), nil
+}
+
+// yamlFlowParser parses flow collections, e.g. [a, b] and {a: 1}.
+type yamlFlowParser struct {
+	text    string
+	pos     int
+	anchors *yamlAnchors
+}
+
+func (f *yamlFlowParser) skipSpace() {
+	for f.pos < len(f.text) && (f.text[f.pos] == ' ' || f.text[f.pos] == '\t') {
+		f.pos++
+	}
+}
+
+func (f *yamlFlowParser) expect(c byte) error {
+	f.skipSpace()
+
+	if f.pos >= len(f.text) || f.text[f.pos] != c {
+		return fmt.Errorf("yaml: expected %q in flow collection %s", c, f.text)
+	}
+
+	f.pos++
+	return nil
+}
+
+// scalar reads a quoted or plain scalar, returning its raw text.
+func (f *yamlFlowParser) scalar() (string, bool, error) {
+	f.skipSpace()
+	start := f.pos
+
+	if f.pos < len(f.text) && (f.text[f.pos] == '"' || f.text[f.pos] == '\'') {
+		quote := f.text[f.pos]
+
+		for f.pos++; f.pos < len(f.text); f.pos++ {
+			if quote == '"' && f.text[f.pos] == '\\' {
+				f.pos++
+			} else if f.text[f.pos] == quote {
+				if quote == '\'' && f.pos+1 < len(f.text) && f.text[f.pos+1] == '\'' {
+					f.pos++
+					continue
+				}
+
+				f.pos++
+				unquoted, err := unquoteYAML(f.text[start:f.pos])
+				return unquoted, true, err
+			}
+		}
+
+		return "", true, fmt.Errorf("yaml: unterminated string in flow collection %s", f.text)
+	}
+
+	for f.pos < len(f.text) {
+		c := f.text[f.pos]
+
+		if c == ',' || c == '[' || c == ']' || c == '{' || c == '}' {
+			break
+		}
+
+		if c == ':' && (f.pos+1 == len(f.text) || strings.ContainsRune(" ,]}", rune(f.text[f.pos+1]))) {
+			break
+		}
+
+		f.pos++
+	}
+
+	return strings.TrimSpace(f.text[start:f.pos]), false, nil
+}
+
+func (f *yamlFlowParser) parseNode() (common.Expression, error) {
+	f.skipSpace()
+	before := f.text[f.pos:]
+	anchor, tag, after, err := splitYAMLProperties(before)
+
+	if err != nil {
+		return nil, err
+	}
+
+	f.pos += len(before) - len(after)
+	f.skipSpace()
+
+	if f.pos >= len(f.text) {
+		nullExpr, err := flimexpr.NewNullLiteralExpression()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return applyYAMLProperties(nullExpr, f.anchors.define(anchor), tag)
+	}
+
+	var expr common.Expression
+
+	switch f.text[f.pos] {
+	case '[':
+		f.pos++
+		listItems := []common.Expression{}
+
+		for {
+			f.skipSpace()
+
+			if f.pos < len(f.text) && f.text[f.pos] == ']' {
+				f.pos++
+				break
+			}
+
+			listItem, err := f.parseNode()
+
+			if err != nil {
+				return nil, err
+			}
+
+			listItems = append(listItems, listItem)
+			f.skipSpace()
+
+			if f.pos < len(f.text) && f.text[f.pos] == ',' {
+				f.pos++
+				continue
+			}
+
+			if err := f.expect(']'); err != nil {
+				return nil, err
+			}
+
+			break
+		}
+
+		expr, err = flimexpr.NewListExpression(listItems)
+	case '{':
+		f.pos++
+		merges := []common.Expression{}
+		pairs := []common.Expression{}
+
+		for {
+			f.skipSpace()
+
+			if f.pos < len(f.text) && f.text[f.pos] == '}' {
+				f.pos++
+				break
+			}
+
+			key, _, err := f.scalar()
+
+			if err != nil {
+				return nil, err
+			}
+
+			f.skipSpace()
+			var val common.Expression
+
+			if f.pos < len(f.text) && f.text[f.pos] == ':' {
+				f.pos++
+				val, err = f.parseNode()
+			} else {
+				val, err = flimexpr.NewNullLiteralExpression()
+			}
+
+			if err != nil {
+				return nil, err
+			}
+
+			if key == "<<" {
+				expansions, err := yamlMergeExpansions(val)
+
+				if err != nil {
+					return nil, err
+				}
+
+				merges = append(merges, expansions...)
+			} else {
+				pair, err := flimexpr.NewPairExpression(key, val)
+
+				if err != nil {
+					return nil, err
+				}
+
+				pairs = append(pairs, pair)
+			}
+
+			f.skipSpace()
+
+			if f.pos < len(f.text) && f.text[f.pos] == ',' {
+				f.pos++
+				continue
+			}
+
+			if err := f.expect('}'); err != nil {
+				return nil, err
+			}
+
+			break
+		}
+
+		expr, err = flimexpr.NewMapExpression(append(merges, pairs...))
+	case '*':
+		start := f.pos + 1
+		f.pos++
+
+		for f.pos < len(f.text) && !strings.ContainsRune(" ,]}", rune(f.text[f.pos])) {
+			f.pos++
+		}
+
+		expr, err = f.anchors.alias(f.text[start:f.pos])
+	default:
+		text, quoted, scalarErr := f.scalar()
+
+		if scalarErr != nil {
+			return nil, scalarErr
+		}
+
+		if quoted {
+			expr, err = flimexpr.NewStringLiteralExpression(text)
+		} else {
+			expr, err = resolveYAMLScalar(text, tag == "!!str")
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return applyYAMLProperties(expr, f.anchors.define(anchor), tag)
+}
+
+// ToYAML writes an expression tree as a YAML document. Tags become anchors
+// and references become aliases, with a top-level tagged expression anchored
+// where it is first used. Transformers become local tags, so a value can
+// carry at most one transformer. Pairs are written after any expansions,
+// which YAML merge keys always give lower priority.
+func ToYAML(expr common.Expression) ([]byte, error) {
+	emitter := &yamlEmitter{definitions: map[string]common.Expression{}, anchored: map[string]bool{}}
+
+	if fileExpr, ok := expr.(flimexpr.FileExpression); ok {
+		expressions := fileExpr.Expressions()
+
+		if len(expressions) == 0 {
+			return []byte("null\n"), nil
+		}
+
+		for _, definition := range expressions[:len(expressions)-1] {
+			taggedExpr, ok := definition.(flimexpr.TaggedExpression)
+
+			if !ok {
+				return nil, fmt.Errorf("yaml: only the last top-level expression can be untagged")
+			}
+
+			emitter.definitions[taggedExpr.Tag()] = taggedExpr.Expression()
+		}
+
+		expr = expressions[len(expressions)-1]
+	}
+
+	node, err := emitter.emit(expr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case node.block && node.properties != "":
+		return []byte(node.properties + "\n" + node.body + "\n"), nil
+	case node.properties != "":
+		return []byte(node.properties + " " + node.body + "\n"), nil
+	default:
+		return []byte(node.body + "\n"), nil
+	}
+}
+
+type yamlEmitter struct {
+	definitions map[string]common.Expression
+	anchored    map[string]bool
+	// flow is set while writing a merge key, whose maps must be written in
+	// flow style
+	flow bool
+}
+
+// yamlNode is an emitted value, with its anchor and tag kept separate so that
+// they can be placed before a block collection.
+type yamlNode struct {
+	properties string
+	body       string
+	block      bool
+	anchor     bool
+	tag        bool
+}
+
+func indentYAML(text string, indent string) string {
+	return indent + strings.ReplaceAll(text, "\n", "\n"+indent)
+}
+
+func (y *yamlEmitter) emit(expr common.Expression) (yamlNode, error) {
+	switch e := expr.(type) {
+	case flimexpr.TaggedExpression:
+		return y.emitAnchored(e.Tag(), e.Expression())
+	case flimexpr.ReferenceExpression:
+		if definition, exists := y.definitions[e.Name()]; exists && !y.anchored[e.Name()] {
+			return y.emitAnchored(e.Name(), definition)
+		}
+
+		return yamlNode{body: "*" + e.Name()}, nil
+	case flimexpr.TransformerExpression:
+		return y.emitTagged("!"+e.Name(), e.Expression())
+	case flimexpr.MappedTransformerExpression:
+		if e.WithKeys() {
+			return y.emitTagged("!@@"+e.Name(), e.Expression())
+		}
+
+		return y.emitTagged("!@"+e.Name(), e.Expression())
+	case flimexpr.MapExpression:
+		return y.emitMap(e)
+	case flimexpr.ListExpression:
+		return y.emitList(e)
+	case flimexpr.PairExpression, flimexpr.ExpandingExpression, flimexpr.FileExpression:
+		return yamlNode{}, fmt.Errorf("yaml: cannot write %s here", e.ToString())
+	default:
+		body, err := yamlScalar(expr)
+		return yamlNode{body: body}, err
+	}
+}
+
+func (y *yamlEmitter) emitAnchored(name string, expr common.Expression) (yamlNode, error) {
+	y.anchored[name] = true
+	node, err := y.emit(expr)
+
+	if err != nil {
+		return yamlNode{}, err
+	}
+
+	if node.anchor {
+		return yamlNode{}, fmt.Errorf("yaml: cannot write more than one tag on the same value (`%s')", name)
+	}
+
+	node.anchor = true
+	node.properties = strings.TrimSpace("&" + name + " " + node.properties)
+
+	return node, nil
+}
+
+func (y *yamlEmitter) emitTagged(tag string, expr common.Expression) (yamlNode, error) {
+	node, err := y.emit(expr)
+
+	if err != nil {
+		return yamlNode{}, err
+	}
+
+	if node.tag || node.anchor {
+		return yamlNode{}, fmt.Errorf("yaml: cannot write transformer `%s' on a value that has a tag or another transformer", strings.TrimLeft(tag, "!@"))
+	}
+
+	if strings.HasPrefix(node.body, "*") {
+		return yamlNode{}, fmt.Errorf("yaml: cannot write transformer `%s' on an alias", strings.TrimLeft(tag, "!@"))
+	}
+
+	node.tag = true
+	node.properties = tag
+
+	return node, nil
+}
+
+// entry writes a node after a mapping key or sequence dash.
+func (y *yamlEmitter) entry(prefix string, node yamlNode, dash bool) string {
+	switch {
+	case node.block && node.properties != "":
+		return prefix + " " + node.properties + "\n" + indentYAML(node.body, "  ")
+	case node.block && dash:
+		indented := indentYAML(node.body, "  ")
+		return prefix + " " + indented[2:]
+	case node.block:
+		return prefix + "\n" + indentYAML(node.body, "  ")
+	case node.properties != "":
+		return prefix + " " + node.properties + " " + node.body
+	default:
+		return prefix + " " + node.body
+	}
+}
+
+func (y *yamlEmitter) emitMap(e flimexpr.MapExpression) (yamlNode, error) {
+	pairs := e.Pairs()
+
+	if len(pairs) == 0 {
+		return yamlNode{body: "{}"}, nil
+	}
+
+	if y.flow {
+		return y.emitFlowMap(pairs)
+	}
+
+	merges := []string{}
+	lines := []string{}
+
+	for _, pairExpr := range pairs {
+		switch pair := pairExpr.(type) {
+		case flimexpr.PairExpression:
+			node, err := y.emit(pair.Value())
+
+			if err != nil {
+				return yamlNode{}, err
+			}
+
+			lines = append(lines, y.entry(yamlKey(pair.Key())+":", node, false))
+		case flimexpr.ExpandingExpression:
+			y.flow = true
+			node, err := y.emit(pair.Expression())
+			y.flow = false
+
+			if err != nil {
+				return yamlNode{}, err
+			}
+
+			if node.tag {
+				return yamlNode{}, fmt.Errorf("yaml: merge keys can only refer to anchored maps")
+			}
+
+			// Later expansions win in flim but earlier ones win in YAML
+			merges = append([]string{strings.TrimSpace(node.properties + " " + node.body)}, merges...)
+		default:
+			return yamlNode{}, fmt.Errorf("yaml: unexpected %s in map", pairExpr.ToString())
+		}
+	}
+
+	switch len(merges) {
+	case 0:
+	case 1:
+		lines = append([]string{"<<: " + merges[0]}, lines...)
+	default:
+		lines = append([]string{"<<: [" + strings.Join(merges, ", ") + "]"}, lines...)
+	}
+
+	return yamlNode{body: strings.Join(lines, "\n"), block: true}, nil
+}
+
+func (y *yamlEmitter) emitList(e flimexpr.ListExpression) (yamlNode, error) {
+	listItems := e.Items()
+
+	if len(listItems) == 0 {
+		return yamlNode{body: "[]"}, nil
+	}
+
+	lines := make([]string, len(listItems))
+
+	for i, listItem := range listItems {
+		if _, ok := listItem.(flimexpr.ExpandingExpression); ok {
+			return yamlNode{}, fmt.Errorf("yaml: cannot write list expansion %s", listItem.ToString())
+		}
+
+		node, err := y.emit(listItem)
+
+		if err != nil {
+			return yamlNode{}, err
+		}
+
+		if y.flow {
+			lines[i] = strings.TrimSpace(node.properties + " " + node.body)
+		} else {
+			lines[i] = y.entry("-", node, true)
+		}
+	}
+
+	if y.flow {
+		return yamlNode{body: "[" + strings.Join(lines, ", ") + "]"}, nil
+	}
+
+	return yamlNode{body: strings.Join(lines, "\n"), block: true}, nil
+}
+
+func (y *yamlEmitter) emitFlowMap(pairs []common.Expression) (yamlNode, error) {
+	merges := []string{}
+	fields := []string{}
+
+	for _, pairExpr := range pairs {
+		switch pair := pairExpr.(type) {
+		case flimexpr.PairExpression:
+			node, err := y.emit(pair.Value())
+
+			if err != nil {
+				return yamlNode{}, err
+			}
+
+			fields = append(fields, yamlKey(pair.Key())+": "+strings.TrimSpace(node.properties+" "+node.body))
+		case flimexpr.ExpandingExpression:
+			node, err := y.emit(pair.Expression())
+
+			if err != nil {
+				return yamlNode{}, err
+			}
+
+			merges = append([]string{strings.TrimSpace(node.properties + " " + node.body)}, merges...)
+		default:
+			return yamlNode{}, fmt.Errorf("yaml: unexpected %s in map", pairExpr.ToString())
+		}
+	}
+
+	if len(merges) > 0 {
+		fields = append([]string{"<<: [" + strings.Join(merges, ", ") + "]"}, fields...)
+	}
+
+	return yamlNode{body: "{" + strings.Join(fields, ", ") + "}"}, nil
+}
+
+func yamlKey(key string) string {
+	if yamlPlainSafe(key) {
+		return key
+	}
+
+	return strconv.Quote(key)
+}
+
+// yamlPlainSafe reports whether text can be written as a plain scalar and
+// read back as the same string.
+func yamlPlainSafe(text string) bool {
+	if text == "" || text != strings.TrimSpace(text) || !utf8.ValidString(text) {
+		return false
+	}
+
+	if strings.ContainsAny(text[:1], "-?:,[]{}#&*!|>'\"%@`") {
+		return false
+	}
+
+	// Flow indicators are avoided everywhere so that strings can also be
+	// written inside flow collections
+	if strings.ContainsAny(text, ",[]{}") || strings.Contains(text, ": ") || strings.Contains(text, " #") || strings.HasSuffix(text, ":") {
+		return false
+	}
+
+	for _, r := range text {
+		if r < ' ' || r == 0x7f {
+			return false
+		}
+	}
+
+	resolved, err := resolveYAMLScalar(text, false)
+
+	if err != nil {
+		return false
+	}
+
+	_, isString := resolved.(flimexpr.StringLiteralExpression)
+	return isString
+}
+
+func yamlScalar(expr common.Expression) (string, error) {
+	switch e := expr.(type) {
+	case flimexpr.NullLiteralExpression:
+		return "null", nil
+	case flimexpr.BooleanLiteralExpression:
+		return strconv.FormatBool(e.Value()), nil
+	case flimexpr.StringLiteralExpression:
+		if yamlPlainSafe(e.Value()) {
+			return e.Value(), nil
+		}
+
+		return strconv.Quote(e.Value()), nil
+	case flimexpr.IntegerLiteralExpression:
+		if text := e.Text(); yamlOctPattern.MatchString(text) || yamlHexPattern.MatchString(text) {
+			return text, nil
+		}
+
+		return strconv.FormatInt(e.Value(), 10), nil
+	case flimexpr.BigIntegerLiteralExpression:
+		return e.Value().String(), nil
+	case flimexpr.FloatLiteralExpression, flimexpr.BigFloatLiteralExpression:
+		// flim and YAML write floats, including .inf and .nan, the same way
+		return expr.Serialize(common.NewSerializerConfig(), 0)
+	case flimexpr.TimestampLiteralExpression:
+		return e.Value().Format(time.RFC3339Nano), nil
+	case flimexpr.DurationLiteralExpression, flimexpr.SizeLiteralExpression, flimexpr.CustomLiteralExpression:
+		// YAML has no equivalent type, so these become strings
+		text, err := expr.Serialize(common.NewSerializerConfig(), 0)
+
+		if err != nil {
+			return "", err
+		}
+
+		stringExpr, err := flimexpr.NewStringLiteralExpression(text)
+
+		if err != nil {
+			return "", err
+		}
+
+		return yamlScalar(stringExpr)
+	default:
+		return "", fmt.Errorf("yaml: cannot write %s", expr.ToString())
+	}
+}
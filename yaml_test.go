@@ -0,0 +1,205 @@
+package flim
+
+import (
+	"github.com/l-donovan/flim/common"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// evaluateDocument resolves the references in expr and evaluates it without
+// any handlers.
+func evaluateDocument(t *testing.T, expr common.Expression) interface{} {
+	t.Helper()
+	resolved, err := ResolveReferences(expr)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	val, err := common.Evaluate(resolved, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return val
+}
+
+type object = map[string]interface{}
+type array = []interface{}
+
+func TestFromYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want interface{}
+	}{
+		{
+			"block mapping",
+			"name: flim\nport: 8080\nratio: 0.5\nenabled: true\nnothing: null\nempty:\n",
+			object{"name": "flim", "port": int64(8080), "ratio": 0.5, "enabled": true, "nothing": nil, "empty": nil},
+		},
+		{
+			"nested block collections",
+			"server:\n  hosts:\n    - a\n    - b\n  limits:\n    cpu: 4\n",
+			object{"server": object{"hosts": array{"a", "b"}, "limits": object{"cpu": int64(4)}}},
+		},
+		{
+			"sequence at the indentation of its key",
+			"items:\n- 1\n- 2\n",
+			object{"items": array{int64(1), int64(2)}},
+		},
+		{
+			"sequence of mappings",
+			"- name: a\n  port: 1\n- name: b\n  port: 2\n",
+			array{object{"name": "a", "port": int64(1)}, object{"name": "b", "port": int64(2)}},
+		},
+		{
+			"flow collections",
+			"list: [1, two, \"three\"]\nmap: {a: 1, b: [x, y]}\nempty: {}\n",
+			object{"list": array{int64(1), "two", "three"}, "map": object{"a": int64(1), "b": array{"x", "y"}}, "empty": object{}},
+		},
+		{
+			"multi-line flow collection",
+			"list: [\n  1,\n  2\n]\n",
+			object{"list": array{int64(1), int64(2)}},
+		},
+		{
+			"literal block scalar",
+			"text: |\n  line one\n  line two\nafter: 1\n",
+			object{"text": "line one\nline two\n", "after": int64(1)},
+		},
+		{
+			"folded block scalar",
+			"text: >-\n  folded\n  onto one line\n",
+			object{"text": "folded onto one line"},
+		},
+		{
+			"multi-line plain scalar",
+			"text: a plain\n  scalar\n",
+			object{"text": "a plain scalar"},
+		},
+		{
+			"quoted scalars",
+			"double: \"tab\\there\"\nsingle: 'it''s'\nnumber: \"8080\"\n",
+			object{"double": "tab\there", "single": "it's", "number": "8080"},
+		},
+		{
+			"core schema scalars",
+			"hex: 0x1F\noctal: 0o17\nfloat: 1e3\nno: false\ntilde: ~\nstr: !!str 123\n",
+			object{"hex": int64(31), "octal": int64(15), "float": 1000.0, "no": false, "tilde": nil, "str": "123"},
+		},
+		{
+			"comments",
+			"# leading\na: 1 # trailing\n# between\nb: \"# kept\"\n",
+			object{"a": int64(1), "b": "# kept"},
+		},
+		{
+			"anchors and aliases",
+			"base: &b {port: 80}\ncopy: *b\nlist: [&x 1, *x]\n",
+			object{"base": object{"port": int64(80)}, "copy": object{"port": int64(80)}, "list": array{int64(1), int64(1)}},
+		},
+		{
+			"redefined anchor",
+			"x: &a 1\ny: *a\nz: &a 2\nw: *a\n",
+			object{"x": int64(1), "y": int64(1), "z": int64(2), "w": int64(2)},
+		},
+		{
+			"explicit keys win over merged ones",
+			"base: &base\n  port: 8080\n  host: a\nsvc:\n  port: 9090\n  <<: *base\n",
+			object{"base": object{"port": int64(8080), "host": "a"}, "svc": object{"port": int64(9090), "host": "a"}},
+		},
+		{
+			"earlier merged maps win",
+			"a: &a {x: 1}\nb: &b {x: 2, y: 2}\nc:\n  <<: [*a, *b]\n",
+			object{"a": object{"x": int64(1)}, "b": object{"x": int64(2), "y": int64(2)}, "c": object{"x": int64(1), "y": int64(2)}},
+		},
+		{
+			"merge in a flow mapping",
+			"a: &a {x: 1, y: 1}\nb: {y: 2, <<: *a}\n",
+			object{"a": object{"x": int64(1), "y": int64(1)}, "b": object{"x": int64(1), "y": int64(2)}},
+		},
+		{
+			"document markers",
+			"%YAML 1.2\n---\na: 1\n...\n",
+			object{"a": int64(1)},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expr, err := FromYAML([]byte(test.yaml))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := evaluateDocument(t, expr); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFromYAMLErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		err  string
+	}{
+		{"tab indentation", "a:\n\tb: 1\n", "tabs cannot be used"},
+		{"multiple documents", "a: 1\n---\nb: 2\n", "multiple documents"},
+		{"alias before its anchor", "a: *b\nb: &b 1\n", "no anchor before it"},
+		{"merge of a scalar", "a:\n  <<: 1\n", "merge keys must refer to mappings"},
+		{"unexpected indentation", "a: 1\n  b: 2\n", "unexpected"},
+		{"unclosed flow collection", "a: [1, 2\n", "expected"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := FromYAML([]byte(test.yaml))
+
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("got error %v, want one containing `%s'", err, test.err)
+			}
+		})
+	}
+}
+
+// Documents written with ToYAML read back as the same value, with tags and
+// references as anchors and aliases.
+func TestToYAMLRoundTrip(t *testing.T) {
+	for _, text := range []string{
+		`{ name "flim" port 8080 ratio 0.5 enabled true nothing null }`,
+		`{ list [ 1 "two" [ 3 ] ] map { a { b "c" } } empty {} none [] }`,
+		"#base { port 80 host \"a\" }\n{ base &base svc { *&base port 9090 } }",
+		`{ text "line one\nline two\n" quoted "8080" keyword "true" }`,
+	} {
+		t.Run(text, func(t *testing.T) {
+			expr, err := ParseString(text)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := ToYAML(expr)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			readBack, err := FromYAML(data)
+
+			if err != nil {
+				t.Fatalf("reading back %s: %s", data, err)
+			}
+
+			want := evaluateDocument(t, expr)
+
+			if got := evaluateDocument(t, readBack); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s read back as %#v, want %#v", data, got, want)
+			}
+		})
+	}
+}